@@ -12,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 )
 
 // aof用于记录数据库的操作日志，支持持久化和重放操作。当数据库重启时，可以通过aof文件重放操作来恢复数据。
@@ -25,12 +26,14 @@ type AofHandler struct {
 	aofFileName    string
 	currentDBIndex int        // 当前操作的数据库索引
 	mu             sync.Mutex // 保护同步写入的互斥锁
+	closeChan      chan struct{}
+	closeOnce      sync.Once
 }
 
 // NewAofHandler 创建一个新的AofHandler实例
 func NewAofHandler(database database.Database) (*AofHandler, error) {
 	handler := &AofHandler{}
-	handler.aofFileName = config.Properties.AppendFilename
+	handler.aofFileName = config.Properties().AppendFilename
 	handler.database = database
 	handler.LoadAof() // 从AOF文件加载数据到数据库
 	// WAL 需要写权限，使用 O_WRONLY
@@ -39,12 +42,35 @@ func NewAofHandler(database database.Database) (*AofHandler, error) {
 		return nil, err
 	}
 	handler.aofFile = aofFile
+	handler.closeChan = make(chan struct{})
+	go handler.fsyncEverySec()
 	return handler, nil
 }
 
-// AddAof WAL 方式：命令落盘（write + fsync）成功后才返回，保证日志先于内存状态持久化
+// fsyncEverySec appendfsync 为 everysec 时每秒刷一次盘
+func (handler *AofHandler) fsyncEverySec() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if config.Properties().AppendFsync != config.FsyncEverySec {
+				continue
+			}
+			handler.mu.Lock()
+			if err := handler.aofFile.Sync(); err != nil {
+				logger.Error("AOF fsync error:", err)
+			}
+			handler.mu.Unlock()
+		case <-handler.closeChan:
+			return
+		}
+	}
+}
+
+// AddAof WAL 方式：appendfsync 为 always 时命令落盘（write + fsync）成功后才返回，保证日志先于内存状态持久化
 func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
-	if !config.Properties.AppendOnly {
+	if !config.Properties().AppendOnly {
 		return
 	}
 
@@ -70,19 +96,27 @@ func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
 	}
 
 	// fsync：保证数据真正写入磁盘，WAL 的关键
-	if err := handler.aofFile.Sync(); err != nil {
-		logger.Error("AOF fsync error:", err)
+	// everysec 由后台协程刷盘，no 交给操作系统
+	if config.Properties().AppendFsync == config.FsyncAlways {
+		if err := handler.aofFile.Sync(); err != nil {
+			logger.Error("AOF fsync error:", err)
+		}
 	}
 }
 
-// Close 关闭前执行最后一次 fsync
+// Close 关闭前执行最后一次 fsync，重复调用是安全的
 func (handler *AofHandler) Close() error {
-	handler.mu.Lock()
-	defer handler.mu.Unlock()
-	if err := handler.aofFile.Sync(); err != nil {
-		logger.Error("AOF final fsync error:", err)
-	}
-	return handler.aofFile.Close()
+	var err error
+	handler.closeOnce.Do(func() {
+		close(handler.closeChan)
+		handler.mu.Lock()
+		defer handler.mu.Unlock()
+		if err = handler.aofFile.Sync(); err != nil {
+			logger.Error("AOF final fsync error:", err)
+		}
+		err = handler.aofFile.Close()
+	})
+	return err
 }
 
 // LoadAof 从AOF文件中加载数据到数据库
@@ -93,30 +127,23 @@ func (handler *AofHandler) LoadAof() {
 		return
 	}
 	defer file.Close()
-	ch := parser.ParseStream(file)
+	reader := parser.NewReader(file)
 	fackConn := &connection.Connection{}
-	for c := range ch {
-		if c == nil {
-			logger.Error("received nil payload")
-			break
-		}
-		if c.Err != nil {
-			if c.Err == io.EOF {
+	fackConn.SetPassword(config.Properties().RequirePass)
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			logger.Error(err)
+			if parser.IsFatal(err) {
+				// 文件损坏或被截断，后面的内容无法解析
 				break
 			}
-			logger.Error(c.Err)
-			continue
-		}
-		if c.Data == nil {
-			logger.Error("empty payload data")
-			continue
-		}
-		bulkReply, ok := c.Data.(*reply.MultiBulkReply)
-		if !ok {
-			logger.Error("require multi bulk reply")
 			continue
 		}
-		exec := handler.database.Exec(fackConn, bulkReply.Args)
+		exec := handler.database.Exec(fackConn, args)
 		if reply.IsErrReply(exec) {
 			logger.Error(exec)
 		}
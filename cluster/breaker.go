@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+)
+
+// 每个节点一个熔断器：连续 breakerThreshold 次转发失败（连接失败或超时，不包括对方返回的错误和认证失败）后打开，
+// 打开期间转发到该节点立即失败；breakerCooldown 之后进入半开状态，放行一个请求试探，
+// 成功则关闭，失败则重新打开
+
+const (
+	breakerThreshold = 3
+	breakerCooldown  = 5 * time.Second
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    int
+	failures int       // 连续失败次数
+	openedAt time.Time // 最近一次打开的时间
+}
+
+// allow 返回能否向该节点发送请求，半开状态下只放行一个试探请求
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < breakerCooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false // 试探请求还没有结果
+	}
+	return true
+}
+
+// done 记录一次请求的结果
+func (b *circuitBreaker) done(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= breakerThreshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// failing 最近的请求失败过，之后还没有成功的请求
+func (b *circuitBreaker) failing() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures > 0
+}
+
+// peerBreaker 返回 peer 的熔断器，第一次使用时创建
+func (cluster *ClusterDatabase) peerBreaker(peer string) *circuitBreaker {
+	cluster.peerMu.Lock()
+	defer cluster.peerMu.Unlock()
+	b, ok := cluster.breakers[peer]
+	if !ok {
+		b = &circuitBreaker{}
+		cluster.breakers[peer] = b
+	}
+	return b
+}
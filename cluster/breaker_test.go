@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"go_redis/lib/utils"
+	"go_redis/resp/connection"
+	"strings"
+	"testing"
+	"time"
+)
+
+// expireCooldown 让打开的熔断器立即可以进入半开状态
+func expireCooldown(b *circuitBreaker) {
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-breakerCooldown)
+	b.mu.Unlock()
+}
+
+func TestBreakerOpen(t *testing.T) {
+	b := &circuitBreaker{}
+	for i := 0; i < breakerThreshold-1; i++ {
+		b.done(false)
+		if !b.allow() {
+			t.Fatalf("breaker opened after %d failures", i+1)
+		}
+	}
+	// 成功后重新计数
+	b.done(true)
+	for i := 0; i < breakerThreshold-1; i++ {
+		b.done(false)
+	}
+	if !b.allow() {
+		t.Fatal("breaker opened without consecutive failures")
+	}
+	b.done(false)
+	if b.allow() {
+		t.Fatal("breaker is not open after consecutive failures")
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := &circuitBreaker{}
+	for i := 0; i < breakerThreshold; i++ {
+		b.done(false)
+	}
+	expireCooldown(b)
+	// 半开状态只放行一个试探请求
+	if !b.allow() {
+		t.Fatal("probe is not allowed after the cooldown")
+	}
+	if b.allow() {
+		t.Fatal("second request allowed while the probe is in flight")
+	}
+	// 试探失败立即重新打开
+	b.done(false)
+	if b.allow() {
+		t.Fatal("breaker is not reopened after the probe failed")
+	}
+	expireCooldown(b)
+	if !b.allow() {
+		t.Fatal("probe is not allowed after the cooldown")
+	}
+	// 试探成功后关闭
+	b.done(true)
+	for i := 0; i < 3; i++ {
+		if !b.allow() {
+			t.Fatal("breaker is not closed after the probe succeeded")
+		}
+	}
+}
+
+func relayTo(cluster *ClusterDatabase, peer string) string {
+	return string(cluster.relay(peer, &connection.Connection{}, utils.ToCmdLine("ping")).ToBytes())
+}
+
+func TestBreakerIgnoresAuthErrors(t *testing.T) {
+	nodes := makeTestNodes(t, 2)
+	peer := nodes[1].self
+	node := nodes[0].topology.nodeByID(nodes[1].topology.self.ID)
+	token := node.peerToken
+	for _, bad := range []string{"", "wrong"} {
+		node.peerToken = bad
+		for i := 0; i < 2*breakerThreshold; i++ {
+			if r := relayTo(nodes[0], peer); !strings.Contains(r, "peer") || strings.HasPrefix(r, "-CLUSTERDOWN") {
+				t.Fatalf("token %q: %q", bad, r)
+			}
+		}
+	}
+	// 令牌恢复后立即可以转发
+	node.peerToken = token
+	if r := relayTo(nodes[0], peer); r != "+PONG\r\n" {
+		t.Fatalf("relay after the token is known: %q", r)
+	}
+}
+
+func TestBreakerOpensOnTransportErrors(t *testing.T) {
+	nodes := makeTestNodes(t, 2)
+	// 对方没有监听，连接失败
+	unreachable := "127.0.0.1:1"
+	nodes[0].topology.mu.Lock()
+	node := newNode(nodeID(unreachable), unreachable, 0)
+	node.peerToken = "token"
+	nodes[0].topology.addNodeLocked(node)
+	nodes[0].topology.mu.Unlock()
+	for i := 0; i < breakerThreshold; i++ {
+		if r := relayTo(nodes[0], unreachable); !strings.HasPrefix(r, "-ERR relay to "+unreachable+" failed") {
+			t.Fatalf("relay %d: %q", i, r)
+		}
+	}
+	if r := relayTo(nodes[0], unreachable); !strings.HasPrefix(r, "-CLUSTERDOWN") {
+		t.Fatalf("breaker is not open: %q", r)
+	}
+	// 其他节点不受影响
+	if r := relayTo(nodes[0], nodes[1].self); r != "+PONG\r\n" {
+		t.Fatalf("relay to a healthy node: %q", r)
+	}
+}
@@ -0,0 +1,211 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"go_redis/config"
+	"go_redis/lib/logger"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 集群总线：节点之间通过服务端口 + 10000 的端口交换 MEET / PING / PONG / FAIL 消息。
+// 每条消息是一行 JSON。每个节点向其他每个节点主动建立一条连接发送 PING，
+// 对方在同一条连接上回复 PONG；对方发来的 PING 在它建立的连接上回复
+
+// 消息类型
+const (
+	msgMeet = "meet" // 与 PING 相同，接收方会把发送方加入集群
+	msgPing = "ping"
+	msgPong = "pong"
+	msgFail = "fail" // 通知所有节点某个节点已经下线
+
+	msgAuthRequest = "auth-request" // 从节点请求主节点投票
+	msgAuthAck     = "auth-ack"     // 主节点投票给从节点
+	msgMFStart     = "mfstart"      // 从节点请求主节点开始手动故障转移
+)
+
+// busMessage 总线上的一条消息
+type busMessage struct {
+	Type         string        `json:"type"`
+	Sender       string        `json:"sender"` // 发送方 ID
+	Addr         string        `json:"addr"`
+	BusPort      int           `json:"bus_port"`
+	Master       string        `json:"master,omitempty"` // 发送方是从节点时为主节点的 ID
+	ConfigEpoch  int64         `json:"config_epoch"`     // 从节点发送主节点的配置纪元
+	CurrentEpoch int64         `json:"current_epoch"`
+	ReplOffset   int64         `json:"repl_offset"`
+	Slots        []slotRange   `json:"slots,omitempty"`  // 发送方负责的槽位，从节点发送主节点的槽位
+	Gossip       []gossipEntry `json:"gossip,omitempty"` // 发送方所知的部分其他节点
+	Fail         string        `json:"fail,omitempty"`   // FAIL 消息中下线节点的 ID
+	Force        bool          `json:"force,omitempty"`  // 投票请求来自手动故障转移，主节点没有下线也可以投票
+	Paused       bool          `json:"paused,omitempty"` // 主节点为手动故障转移暂停了写命令
+	PeerToken    string        `json:"peer_token"`       // 发送方的令牌，连接它时出示
+}
+
+// gossipEntry 发送方眼中某个节点的状态
+type gossipEntry struct {
+	ID       string `json:"id"`
+	Addr     string `json:"addr"`
+	BusPort  int    `json:"bus_port"`
+	PingSent int64  `json:"ping_sent"`
+	PongRecv int64  `json:"pong_recv"`
+	Flags    int    `json:"flags"`
+}
+
+// busLink 一条总线连接
+type busLink struct {
+	conn   net.Conn
+	ctime  int64      // 建立连接的时间，毫秒
+	mu     sync.Mutex // 保护写入
+	enc    *json.Encoder
+	closed atomic.Bool
+}
+
+func newBusLink(conn net.Conn) *busLink {
+	return &busLink{conn: conn, ctime: nowMs(), enc: json.NewEncoder(conn)}
+}
+
+// send 发送一条消息，写超时与 cluster-node-timeout 相同
+func (link *busLink) send(msg *busMessage) error {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	_ = link.conn.SetWriteDeadline(time.Now().Add(nodeTimeout()))
+	err := link.enc.Encode(msg)
+	if err == nil {
+		busStats.sent.Add(1)
+	}
+	return err
+}
+
+func (link *busLink) close() {
+	if link.closed.CompareAndSwap(false, true) {
+		_ = link.conn.Close()
+	}
+}
+
+// busStats 收发的消息个数，显示在 CLUSTER INFO 中
+var busStats struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// nodeTimeout cluster-node-timeout
+func nodeTimeout() time.Duration {
+	return time.Duration(config.Properties().ClusterNodeTimeout) * time.Millisecond
+}
+
+// busAddr 节点的总线地址
+func busAddr(node *clusterNode) string {
+	host, _ := splitAddr(node.Addr)
+	return net.JoinHostPort(host, strconv.Itoa(node.BusPort))
+}
+
+// listenBus 监听本节点的总线端口，tls-cluster 时使用 TLS
+func (cluster *ClusterDatabase) listenBus() error {
+	addr := net.JoinHostPort(config.Properties().Bind, strconv.Itoa(cluster.topology.self.BusPort))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if config.Properties().TlsCluster {
+		tlsConfig, err := config.ServerTLSConfig()
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	cluster.busListener = listener
+	logger.Info("cluster bus listening on " + addr)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go cluster.serveInbound(newBusLink(conn))
+		}
+	}()
+	return nil
+}
+
+// serveInbound 处理其他节点建立的连接：收到 PING / MEET 时在这条连接上回复 PONG
+func (cluster *ClusterDatabase) serveInbound(link *busLink) {
+	defer link.close()
+	dec := json.NewDecoder(bufio.NewReader(link.conn))
+	for {
+		msg := &busMessage{}
+		if err := dec.Decode(msg); err != nil {
+			return
+		}
+		busStats.received.Add(1)
+		cluster.handleMessage(msg, link, nil)
+	}
+}
+
+// connectNode 建立到 node 的总线连接，发送第一条 PING（或 MEET）后开始读取回复
+func (cluster *ClusterDatabase) connectNode(node *clusterNode) {
+	addr := busAddr(node)
+	dialer := &net.Dialer{Timeout: nodeTimeout()}
+	var conn net.Conn
+	var err error
+	if config.Properties().TlsCluster {
+		var tlsConfig *tls.Config
+		tlsConfig, err = config.ClientTLSConfig(addr)
+		if err == nil {
+			conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+		}
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	t := cluster.topology
+	t.mu.Lock()
+	node.connecting = false
+	if err != nil || t.nodeByIDLocked(node.ID) != node {
+		if err != nil && node.pingSent == 0 {
+			// 连不上时也要能检测到节点下线，当作已经发出了 PING
+			node.pingSent = nowMs()
+		}
+		t.mu.Unlock()
+		if err == nil {
+			// 连接期间节点被删除了
+			_ = conn.Close()
+		}
+		return
+	}
+	link := newBusLink(conn)
+	node.link = link
+	msgType := msgPing
+	if node.flags&nodeMeet != 0 {
+		msgType = msgMeet
+	}
+	t.mu.Unlock()
+
+	cluster.sendPing(node, link, msgType)
+	defer cluster.dropLink(node, link)
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		msg := &busMessage{}
+		if err := dec.Decode(msg); err != nil {
+			return
+		}
+		busStats.received.Add(1)
+		cluster.handleMessage(msg, link, node)
+	}
+}
+
+// dropLink 关闭连接，下一次定时任务会重新连接
+func (cluster *ClusterDatabase) dropLink(node *clusterNode, link *busLink) {
+	link.close()
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if node.link == link {
+		node.link = nil
+	}
+}
@@ -2,35 +2,155 @@ package cluster
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	pool "github.com/jolestar/go-commons-pool/v2"
+	"go_redis/config"
+	"go_redis/lib/utils"
 	"go_redis/resp/client"
+	"go_redis/resp/reply"
+	"strconv"
+	"time"
 )
 
+const (
+	// peerEvictInterval 检查空闲连接的间隔：关闭空闲过久的连接，用 PING 检查其余的连接
+	peerEvictInterval = 30 * time.Second
+	// peerPingIdle 借出前空闲超过该时间的连接先 PING 一次，刚用过的连接只检查是否断开过（转发失败过时除外）
+	peerPingIdle = 10 * time.Second
+)
+
+// peerClient 连接池中到其他节点的连接，记录连接上当前的 DB，只在 DB 变化时发送 SELECT
+type peerClient struct {
+	*client.Client
+	dbIndex int // -1 表示不确定，下次使用时重新 SELECT
+}
+
+// selectDB 切换到 dbIndex，失败时连接上的 DB 不确定
+func (pc *peerClient) selectDB(ctx context.Context, dbIndex int) error {
+	if pc.dbIndex == dbIndex {
+		return nil
+	}
+	pc.dbIndex = -1
+	r, err := pc.SendContext(ctx, utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex)))
+	if err == nil {
+		err = replyErr(r)
+	}
+	if err != nil {
+		return err
+	}
+	pc.dbIndex = dbIndex
+	return nil
+}
+
+// peerPoolConfig 按 cluster-pool-* 配置创建连接池的参数
+func peerPoolConfig() *pool.ObjectPoolConfig {
+	cfg := pool.NewDefaultPoolConfig()
+	cfg.MaxTotal = config.Properties().ClusterPoolMaxTotal
+	cfg.MaxIdle = config.Properties().ClusterPoolMaxIdle
+	cfg.MinIdle = config.Properties().ClusterPoolMinIdle
+	if config.Properties().ClusterPoolIdleTimeout > 0 {
+		cfg.MinEvictableIdleTime = time.Duration(config.Properties().ClusterPoolIdleTimeout) * time.Second
+	} else {
+		cfg.MinEvictableIdleTime = time.Duration(1<<63 - 1)
+	}
+	cfg.TestOnBorrow = true
+	cfg.TestWhileIdle = true
+	cfg.NumTestsPerEvictionRun = -1 // 每次检查所有空闲连接
+	cfg.TimeBetweenEvictionRuns = peerEvictInterval
+	cfg.EvictionContext = context.Background()
+	return cfg
+}
+
+// peerPoolTimeout cluster-pool-timeout
+func peerPoolTimeout() time.Duration {
+	return time.Duration(config.Properties().ClusterPoolTimeout) * time.Millisecond
+}
+
+// peerAuthError 连接建立后认证失败或者还不知道对方的令牌，对方是可达的，不计入熔断器的失败次数
+type peerAuthError struct {
+	msg string
+}
+
+func (e *peerAuthError) Error() string {
+	return e.msg
+}
+
 type connectionFactory struct {
-	Peer string // 连接地址
+	Peer    string // 连接地址
+	Cluster *ClusterDatabase
 }
 
 func (f connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
-	c, err := client.MakeClient(f.Peer)
+	// 还没有收到对方的总线消息时不知道它的令牌，等下一次 PING / PONG
+	token := f.Cluster.topology.peerToken(f.Peer)
+	if token == "" {
+		return nil, &peerAuthError{msg: "peer token of " + f.Peer + " is unknown"}
+	}
+	var tlsConfig *tls.Config
+	if config.Properties().TlsCluster {
+		var err error
+		tlsConfig, err = config.ClientTLSConfig(f.Peer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c, err := client.MakeTLSClient(f.Peer, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 	c.Start()
-	return &pool.PooledObject{Object: c}, nil
+	if config.Properties().RequirePass != "" {
+		// 集群内各节点使用相同的密码
+		r, err := c.SendContext(ctx, utils.ToCmdLine("AUTH", config.Properties().RequirePass))
+		if err == nil {
+			err = replyErr(r)
+		}
+		if err != nil {
+			c.Close()
+			return nil, &peerAuthError{msg: "auth peer failed: " + err.Error()}
+		}
+	}
+	// 之后转发过去的 KEYS、FLUSHDB 等命令只在对方本地执行，不再分发，TCC 内部命令也只接受这样的连接
+	r, err := c.SendContext(ctx, utils.ToCmdLine("CLUSTER", "PEERAUTH", token))
+	if err == nil {
+		err = replyErr(r)
+	}
+	if err != nil {
+		c.Close()
+		return nil, &peerAuthError{msg: "peer auth failed: " + err.Error()}
+	}
+	return pool.NewPooledObject(&peerClient{Client: c}), nil
 }
 
 func (f connectionFactory) DestroyObject(ctx context.Context, object *pool.PooledObject) error {
-	c, ok := object.Object.(*client.Client)
+	pc, ok := object.Object.(*peerClient)
 	if !ok {
 		return errors.New("object is not a client")
 	}
-	c.Close()
+	pc.Close()
 	return nil
 }
 
+// ValidateObject 断开过的连接已经丢失了 AUTH 和 CLUSTER PEERAUTH，直接丢弃；空闲较久的连接 PING 一次，
+// 对方可能已经宕机而连接没有收到 FIN。刚用过的连接不 PING，但到该节点的转发最近失败过时
+// 其他连接可能同样已经失效，在下一次转发成功之前借出的连接都先 PING
 func (f connectionFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
-	return true
+	pc, ok := object.Object.(*peerClient)
+	if !ok || pc.Broken() {
+		return false
+	}
+	if time.Since(object.LastReturnTime) < peerPingIdle && !f.Cluster.peerBreaker(f.Peer).failing() {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, peerPoolTimeout())
+	defer cancel()
+	r, err := pc.SendContext(ctx, utils.ToCmdLine("PING"))
+	if err != nil || pc.Broken() {
+		return false
+	}
+	status, ok := r.(*reply.StatusReply)
+	return ok && status.Status == "PONG"
 }
 
 func (f connectionFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
@@ -0,0 +1,196 @@
+package cluster
+
+import (
+	"context"
+	pool "github.com/jolestar/go-commons-pool/v2"
+	"go_redis/config"
+	"go_redis/lib/utils"
+	"go_redis/resp/client"
+	"go_redis/resp/parser"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePeer 记录收到的命令，SELECT 9 返回错误，QUIT 断开连接，其他命令回复 PONG 或 OK；
+// silent 时不回复，模拟宕机而没有断开的节点
+type fakePeer struct {
+	mu       sync.Mutex
+	commands []string
+	silent   bool
+}
+
+func (p *fakePeer) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			reader := parser.NewRequestReader(conn)
+			for {
+				args, err := reader.ReadCommand()
+				if err != nil {
+					return
+				}
+				line := strings.ToUpper(string(args[0]))
+				for _, arg := range args[1:] {
+					line += " " + string(arg)
+				}
+				p.mu.Lock()
+				p.commands = append(p.commands, line)
+				silent := p.silent
+				p.mu.Unlock()
+				switch {
+				case silent:
+				case line == "QUIT":
+					return
+				case line == "PING":
+					_, _ = conn.Write([]byte("+PONG\r\n"))
+				case line == "SELECT 9":
+					_, _ = conn.Write([]byte("-ERR DB index is out of range\r\n"))
+				default:
+					_, _ = conn.Write([]byte("+OK\r\n"))
+				}
+			}
+		}()
+	}
+}
+
+// take 返回并清空收到的命令
+func (p *fakePeer) take() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	commands := p.commands
+	p.commands = nil
+	return commands
+}
+
+func (p *fakePeer) setSilent(silent bool) {
+	p.mu.Lock()
+	p.silent = silent
+	p.mu.Unlock()
+}
+
+// makeFakePeer 启动 fakePeer，返回连上它的 peerClient
+func makeFakePeer(t *testing.T) (*fakePeer, *peerClient) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &fakePeer{}
+	go peer.serve(listener)
+	c, err := client.MakeClient(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	t.Cleanup(func() {
+		c.Close()
+		_ = listener.Close()
+	})
+	return peer, &peerClient{Client: c}
+}
+
+func TestPeerClientSelectCache(t *testing.T) {
+	peer, pc := makeFakePeer(t)
+	ctx := context.Background()
+	// 新连接在 DB 0，不需要 SELECT
+	if err := pc.selectDB(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.selectDB(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.selectDB(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(peer.take(), ","); got != "SELECT 1" {
+		t.Fatalf("commands %q, want one SELECT 1", got)
+	}
+	// SELECT 失败后连接上的 DB 不确定，下次重新 SELECT
+	if err := pc.selectDB(ctx, 9); err == nil {
+		t.Fatal("SELECT 9 succeeded")
+	}
+	if pc.dbIndex != -1 {
+		t.Fatalf("dbIndex %d after a failed SELECT, want -1", pc.dbIndex)
+	}
+	if err := pc.selectDB(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(peer.take(), ","); got != "SELECT 9,SELECT 1" {
+		t.Fatalf("commands %q", got)
+	}
+}
+
+func TestValidateObject(t *testing.T) {
+	old := config.Properties()
+	props := *old
+	props.ClusterPoolTimeout = 100
+	config.SetProperties(&props)
+	t.Cleanup(func() { config.SetProperties(old) })
+
+	peer, pc := makeFakePeer(t)
+	cluster := makeTestCluster()
+	cluster.breakers = make(map[string]*circuitBreaker)
+	f := connectionFactory{Peer: "127.0.0.1:7001", Cluster: cluster}
+	ctx := context.Background()
+	object := pool.NewPooledObject(pc)
+
+	// 刚归还的连接不 PING
+	if !f.ValidateObject(ctx, object) {
+		t.Fatal("recently used connection is invalid")
+	}
+	if commands := peer.take(); len(commands) != 0 {
+		t.Fatalf("commands %q, want none", commands)
+	}
+	// 空闲较久的连接 PING 一次
+	object.LastReturnTime = time.Now().Add(-peerPingIdle)
+	if !f.ValidateObject(ctx, object) {
+		t.Fatal("idle connection is invalid")
+	}
+	if got := strings.Join(peer.take(), ","); got != "PING" {
+		t.Fatalf("commands %q, want PING", got)
+	}
+	// 对方不再回复时 PING 超时，连接失效
+	peer.setSilent(true)
+	if f.ValidateObject(ctx, object) {
+		t.Fatal("connection to a silent peer is valid")
+	}
+
+	// 转发失败过时刚用过的连接也要 PING
+	_, pc = makeFakePeer(t)
+	object = pool.NewPooledObject(pc)
+	cluster.peerBreaker(f.Peer).done(false)
+	if !f.ValidateObject(ctx, object) {
+		t.Fatal("healthy connection is invalid after a failure")
+	}
+	silentPeer, pc := makeFakePeer(t)
+	silentPeer.setSilent(true)
+	object = pool.NewPooledObject(pc)
+	if f.ValidateObject(ctx, object) {
+		t.Fatal("connection to a silent peer is valid after a failure")
+	}
+	// 转发成功后恢复
+	cluster.peerBreaker(f.Peer).done(true)
+	if !f.ValidateObject(ctx, object) {
+		t.Fatal("recently used connection is invalid after a success")
+	}
+
+	// 断开过的连接直接丢弃
+	_, pc = makeFakePeer(t)
+	object = pool.NewPooledObject(pc)
+	_, _ = pc.SendContext(ctx, utils.ToCmdLine("QUIT"))
+	for deadline := time.Now().Add(time.Second); !pc.Broken(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("connection is not broken after QUIT")
+		}
+	}
+	if f.ValidateObject(ctx, object) {
+		t.Fatal("closed connection is valid")
+	}
+}
@@ -0,0 +1,305 @@
+package cluster
+
+import (
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/slot"
+	"go_redis/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// clusterBusPortOffset 集群总线端口与服务端口的差，与 redis 的默认值相同
+const clusterBusPortOffset = 10000
+
+// CLUSTER SLOTS | SHARDS | NODES | MYID | INFO | SETSLOT | REBALANCE | MEET | FORGET | RESET | ADDSLOTS | DELSLOTS |
+// COUNT-FAILURE-REPORTS | REPLICATE | REPLICAS | SLAVES | FAILOVER 需要集群拓扑，PEERAUTH 认证其他节点的连接，在这里处理；
+// KEYSLOT、COUNTKEYSINSLOT、GETKEYSINSLOT 只涉及本节点的数据，交给本地数据库
+func execCluster(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "slots", "shards", "nodes", "myid", "info":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|" + subCmd)
+		}
+	case "forget", "count-failure-reports", "replicate", "replicas", "slaves", "peerauth":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|" + subCmd)
+		}
+	case "meet", "addslots", "delslots":
+		if len(args) < 3 {
+			return reply.MakeArgNumErrReply("cluster|" + subCmd)
+		}
+	}
+	switch subCmd {
+	case "slots":
+		return cluster.clusterSlots()
+	case "shards":
+		return cluster.clusterShards()
+	case "nodes":
+		return reply.MakeVerbatimReply("txt", []byte(cluster.clusterNodes()))
+	case "myid":
+		return reply.MakeBulkReply([]byte(cluster.topology.self.ID))
+	case "info":
+		return reply.MakeVerbatimReply("txt", []byte(cluster.clusterInfo()))
+	case "setslot":
+		return cluster.clusterSetSlot(args[2:])
+	case "rebalance":
+		return cluster.clusterRebalance(args[2:])
+	case "meet":
+		return cluster.clusterMeet(args[2:])
+	case "forget":
+		return cluster.clusterForget(string(args[2]))
+	case "reset":
+		return cluster.clusterReset(args[2:])
+	case "addslots", "delslots":
+		return cluster.clusterAddSlots(args[2:], subCmd == "addslots")
+	case "count-failure-reports":
+		return cluster.clusterCountFailureReports(string(args[2]))
+	case "replicate":
+		return cluster.clusterReplicate(string(args[2]))
+	case "replicas", "slaves":
+		return cluster.clusterReplicas(string(args[2]))
+	case "failover":
+		return cluster.clusterFailover(args[2:])
+	case "peerauth":
+		return cluster.clusterPeerAuth(c, args[2])
+	}
+	return cluster.db.Exec(c, args)
+}
+
+// clusterSetSlot CLUSTER SETSLOT <slot> IMPORTING <node-id> | MIGRATING <node-id> | NODE <node-id> | STABLE
+func (cluster *ClusterDatabase) clusterSetSlot(args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster|setslot")
+	}
+	s, err := strconv.Atoi(string(args[0]))
+	if err != nil || s < 0 || s >= slot.Count {
+		return reply.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		if len(args) != 2 {
+			return reply.MakeSyntaxErrReply()
+		}
+		cluster.topology.setStable(s)
+		return reply.MakeOkReply()
+	}
+	if len(args) != 3 {
+		return reply.MakeErrReply("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	t := cluster.topology
+	id := string(args[2])
+	node := t.nodeByID(id)
+	owner, _, _ := t.route(s)
+	switch action {
+	case "migrating":
+		if owner != t.self {
+			return reply.MakeErrReply("ERR I'm not the owner of hash slot " + strconv.Itoa(s))
+		}
+		if node == nil {
+			return reply.MakeErrReply("ERR I don't know about node " + id)
+		}
+		if node == t.self {
+			return reply.MakeErrReply("ERR Can't migrate a slot to myself")
+		}
+		t.setMigrating(s, node)
+	case "importing":
+		if owner == t.self {
+			return reply.MakeErrReply("ERR I'm already the owner of hash slot " + strconv.Itoa(s))
+		}
+		if node == nil {
+			return reply.MakeErrReply("ERR I don't know about node " + id)
+		}
+		if node == t.self {
+			return reply.MakeErrReply("ERR Can't import a slot from myself")
+		}
+		t.setImporting(s, node)
+	case "node":
+		if node == nil {
+			return reply.MakeErrReply("ERR Unknown node " + id)
+		}
+		if owner == t.self && node != t.self && cluster.db.CountKeysInSlot(s) > 0 {
+			return reply.MakeErrReply("ERR Can't assign hashslot " + strconv.Itoa(s) +
+				" to a different node while I still hold keys for this hash slot.")
+		}
+		t.setOwner(s, node)
+	default:
+		return reply.MakeErrReply("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	return reply.MakeOkReply()
+}
+
+// splitAddr 把 host:port 拆成主机和端口
+func splitAddr(addr string) (string, int) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// clusterSlots 每段槽位一项：起始槽位、结束槽位、负责的节点和它没有下线的从节点（ip、端口、ID、附加信息）
+func (cluster *ClusterDatabase) clusterSlots() resp.Reply {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ranges := t.slotRangesLocked()
+	result := make([]resp.Reply, 0)
+	for _, node := range t.nodes {
+		if len(ranges[node]) == 0 {
+			continue
+		}
+		members := []resp.Reply{slotNodeReply(node)}
+		for _, replica := range t.replicasLocked(node) {
+			if replica.flags&nodeFail == 0 {
+				members = append(members, slotNodeReply(replica))
+			}
+		}
+		for _, r := range ranges[node] {
+			item := []resp.Reply{
+				reply.MakeIntReply(int64(r.Start)),
+				reply.MakeIntReply(int64(r.End)),
+			}
+			result = append(result, reply.MakeMultiRawReply(append(item, members...)))
+		}
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// slotNodeReply CLUSTER SLOTS 中的一个节点
+func slotNodeReply(node *clusterNode) resp.Reply {
+	host, port := splitAddr(node.Addr)
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(host)),
+		reply.MakeIntReply(int64(port)),
+		reply.MakeBulkReply([]byte(node.ID)),
+		reply.MakeEmptyMutiBulkReply(),
+	})
+}
+
+// clusterShards 每个主节点一个分片，包含槽位区间和分片中的主从节点
+func (cluster *ClusterDatabase) clusterShards() resp.Reply {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ranges := t.slotRangesLocked()
+	portKey := "port"
+	if config.Properties().TlsCluster {
+		portKey = "tls-port"
+	}
+	bulk := func(s string) resp.Reply {
+		return reply.MakeBulkReply([]byte(s))
+	}
+	nodeInfo := func(node *clusterNode) resp.Reply {
+		host, port := splitAddr(node.Addr)
+		role, health := "master", "online"
+		if node.master != nil {
+			role = "replica"
+		}
+		if node.flags&nodeFail != 0 {
+			health = "fail"
+		}
+		offset := node.replOffset
+		if node == t.self {
+			offset = cluster.db.ReplOffset()
+		}
+		return reply.MakeMapReply().
+			Add(bulk("id"), bulk(node.ID)).
+			Add(bulk(portKey), reply.MakeIntReply(int64(port))).
+			Add(bulk("ip"), bulk(host)).
+			Add(bulk("endpoint"), bulk(host)).
+			Add(bulk("role"), bulk(role)).
+			Add(bulk("replication-offset"), reply.MakeIntReply(offset)).
+			Add(bulk("health"), bulk(health))
+	}
+	result := make([]resp.Reply, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		if node.master != nil {
+			continue
+		}
+		slots := make([]resp.Reply, 0, len(ranges[node])*2)
+		for _, r := range ranges[node] {
+			slots = append(slots, reply.MakeIntReply(int64(r.Start)), reply.MakeIntReply(int64(r.End)))
+		}
+		members := []resp.Reply{nodeInfo(node)}
+		for _, replica := range t.replicasLocked(node) {
+			members = append(members, nodeInfo(replica))
+		}
+		shard := reply.MakeMapReply().
+			Add(bulk("slots"), reply.MakeMultiRawReply(slots)).
+			Add(bulk("nodes"), reply.MakeMultiRawReply(members))
+		result = append(result, shard)
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// clusterNodes 与 redis 的 nodes.conf 格式相同，见 describeNodesLocked
+func (cluster *ClusterDatabase) clusterNodes() string {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.describeNodesLocked()
+}
+
+// clusterReplicas CLUSTER REPLICAS node-id 以 CLUSTER NODES 的格式列出主节点的从节点
+func (cluster *ClusterDatabase) clusterReplicas(id string) resp.Reply {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node := t.nodeByIDLocked(id)
+	if node == nil {
+		return reply.MakeErrReply("ERR Unknown node " + id)
+	}
+	if node.master != nil {
+		return reply.MakeErrReply("ERR The specified node is not a master")
+	}
+	lines := make([][]byte, 0)
+	for _, replica := range t.replicasLocked(node) {
+		var sb strings.Builder
+		t.describeNodeLocked(&sb, replica, nil)
+		lines = append(lines, []byte(strings.TrimSuffix(sb.String(), "\n")))
+	}
+	return reply.MakeMultiBulkReply(lines)
+}
+
+func (cluster *ClusterDatabase) clusterInfo() string {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	assigned, pfail, fail := 0, 0, 0
+	for _, node := range t.slots {
+		switch {
+		case node == nil:
+			continue
+		case node.flags&nodeFail != 0:
+			fail++
+		case node.flags&nodePFail != 0:
+			pfail++
+		}
+		assigned++
+	}
+	state := "ok"
+	if !cluster.stateOK.Load() {
+		state = "fail"
+	}
+	lines := []string{
+		"cluster_state:" + state,
+		"cluster_slots_assigned:" + strconv.Itoa(assigned),
+		"cluster_slots_ok:" + strconv.Itoa(assigned-pfail-fail),
+		"cluster_slots_pfail:" + strconv.Itoa(pfail),
+		"cluster_slots_fail:" + strconv.Itoa(fail),
+		"cluster_known_nodes:" + strconv.Itoa(len(t.nodes)),
+		"cluster_size:" + strconv.Itoa(t.sizeLocked()),
+		"cluster_current_epoch:" + strconv.FormatInt(t.currentEpoch, 10),
+		"cluster_my_epoch:" + strconv.FormatInt(t.self.Epoch, 10),
+		"cluster_stats_messages_sent:" + strconv.FormatInt(busStats.sent.Load(), 10),
+		"cluster_stats_messages_received:" + strconv.FormatInt(busStats.received.Load(), 10),
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
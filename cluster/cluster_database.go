@@ -2,46 +2,114 @@ package cluster
 
 import (
 	"context"
+	"errors"
 	pool "github.com/jolestar/go-commons-pool/v2"
 	"go_redis/config"
 	database2 "go_redis/database"
-	"go_redis/interface/database"
 	"go_redis/interface/resp"
-	"go_redis/lib/consistenthash"
 	"go_redis/lib/logger"
 	"go_redis/resp/reply"
+	"net"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type ClusterDatabase struct {
 	self           string
-	nodes          []string
-	peerPicker     *consistenthash.NodeMap
+	topology       *topology
+	peerMu         sync.Mutex // 保护 peerConnection 和 breakers，节点加入时才创建连接池
 	peerConnection map[string]*pool.ObjectPool
-	db             database.Database
+	breakers       map[string]*circuitBreaker // 每个节点的熔断器，见 breaker.go
+	db             *database2.StandaloneDatabase
+	// 本地执行迁移中槽位的命令时持有读锁，MIGRATE 持有写锁，
+	// 保证"key 是否还在本节点"的判断与命令的执行之间 key 不会被迁走
+	migrateMu sync.RWMutex
+
+	// 手动故障转移期间主节点暂停写命令：写命令执行期间持有读锁，pauseWrites 持有写锁
+	pauseMu     sync.RWMutex
+	pausedUntil int64
+
+	// 跨节点事务，见 tcc.go
+	participant txParticipant
+	txLocks     *keyLocks
+	txCounter   atomic.Int64 // 生成本节点发起的事务 ID
+
+	busListener net.Listener
+	stateOK     atomic.Bool // 集群状态是否为 ok，见 updateState
+	saveMu      sync.Mutex  // 保证 nodes.conf 按顺序写入
+	closeChan   chan struct{}
+	closeOnce   sync.Once
 }
 
 func MakeClusterDatabase() *ClusterDatabase {
 	cluster := ClusterDatabase{
-		self:           config.Properties.Self,
+		self:           config.ClusterSelf(),
 		db:             database2.NewStandaloneDatabase(),
-		peerPicker:     consistenthash.NewNodeMap(nil),
 		peerConnection: make(map[string]*pool.ObjectPool),
+		breakers:       make(map[string]*circuitBreaker),
+		closeChan:      make(chan struct{}),
+		participant: txParticipant{
+			transactions: make(map[string]*transaction),
+			owners:       make(map[string]*keyLockOwner),
+			finished:     make(map[string]int),
+		},
+		txLocks: makeKeyLocks(),
+	}
+	// 有 nodes.conf 时以它为准，否则按 self、peers 和权重计算初始的槽位分配
+	t, err := loadTopology(config.Properties().ClusterConfigFile, cluster.self)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error("load cluster config failed: " + err.Error())
+		}
+		nodes := []string{cluster.self}
+		for _, peer := range config.Properties().Peers {
+			if peer = strings.TrimSpace(peer); peer != "" && peer != cluster.self {
+				nodes = append(nodes, peer)
+			}
+		}
+		t = makeTopology(cluster.self, nodes, parseNodeWeights(config.Properties().ClusterNodeWeights))
+		t.dirty = true
 	}
-	nodes := make([]string, 0, len(config.Properties.Peers)+1)
-	for _, peer := range config.Properties.Peers {
-		nodes = append(nodes, peer)
+	t.self.peerToken = newPeerToken()
+	cluster.topology = t
+	if master := t.self.master; master != nil {
+		host, port := splitAddr(master.Addr)
+		cluster.db.ReplicaOf(host, port)
 	}
-	nodes = append(nodes, config.Properties.Self)
-	cluster.peerPicker.AddNodes(nodes...)
-	ctx := context.Background()
-	for _, node := range config.Properties.Peers {
-		cluster.peerConnection[node] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{Peer: node})
+	cluster.updateState()
+	if err := cluster.listenBus(); err != nil {
+		logger.Error("cluster bus listen failed: " + err.Error())
 	}
-	cluster.nodes = nodes
+	go cluster.clusterCron()
 	return &cluster
 }
 
+// parseNodeWeights 解析 cluster-node-weights，格式为 host:port=weight，权重为 0 的节点启动时不分配槽位
+func parseNodeWeights(items []string) map[string]int {
+	weights := make(map[string]int)
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		idx := strings.LastIndex(item, "=")
+		if idx < 0 {
+			logger.Warn("invalid cluster-node-weights item: " + item)
+			continue
+		}
+		weight, err := strconv.Atoi(item[idx+1:])
+		if err != nil || weight < 0 {
+			logger.Warn("invalid cluster-node-weights item: " + item)
+			continue
+		}
+		weights[item[:idx]] = weight
+	}
+	return weights
+}
+
 type CmdFunc func(clusterDatabase *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply
 
 var router = makeRouter()
@@ -55,14 +123,53 @@ func (cluster *ClusterDatabase) Exec(client resp.Connection, args [][]byte) (res
 	}()
 
 	CmdName := strings.ToLower(string(args[0]))
+	if CmdName == "auth" || CmdName == "hello" {
+		return cluster.db.Exec(client, args)
+	}
+	if !database2.IsAuthenticated(client) {
+		return reply.MakeErrReply("NOAUTH Authentication required.")
+	}
+	if CmdName == "replicaof" || CmdName == "slaveof" {
+		// 主从关系由 CLUSTER REPLICATE 和故障转移决定
+		return reply.MakeErrReply("ERR REPLICAOF not allowed in cluster mode.")
+	}
+	if CmdName != "asking" {
+		// ASKING 只对紧接着的一条命令有效
+		defer client.SetAsking(false)
+	}
+	if r, ok := cluster.execMultiCommand(client, CmdName, args); ok {
+		return r
+	}
+	if config.Properties().ClusterRouting == config.ClusterRoutingRedirect {
+		return cluster.execRedirect(client, CmdName, args)
+	}
 	if cmdFunc, ok := router[CmdName]; ok {
 		return cmdFunc(cluster, client, args)
-	} else {
-		return reply.MakeErrReply("ERR unknown command '" + CmdName + "'")
 	}
+	return defaultFunc(cluster, client, args)
 }
 
 func (cluster *ClusterDatabase) Close() {
+	cluster.closeOnce.Do(func() {
+		close(cluster.closeChan)
+	})
+	if cluster.busListener != nil {
+		_ = cluster.busListener.Close()
+	}
+	cluster.topology.mu.Lock()
+	for _, node := range cluster.topology.nodes {
+		if node.link != nil {
+			node.link.close()
+			node.link = nil
+		}
+	}
+	cluster.topology.mu.Unlock()
+	cluster.peerMu.Lock()
+	for _, p := range cluster.peerConnection {
+		p.Close(context.Background())
+	}
+	cluster.peerMu.Unlock()
+	cluster.saveConfig()
 	cluster.db.Close()
 }
 
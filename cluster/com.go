@@ -3,60 +3,158 @@ package cluster
 import (
 	"context"
 	"errors"
+	pool "github.com/jolestar/go-commons-pool/v2"
 	"go_redis/interface/resp"
 	"go_redis/lib/utils"
-	"go_redis/resp/client"
 	"go_redis/resp/reply"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // 通信文件
 
-func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
-	pool, ok := cluster.peerConnection[peer]
+// broadcastTimeout 广播等待所有节点回复的最长时间，小于单个请求的超时，某个节点没有响应时不必等满
+const broadcastTimeout = 2 * time.Second
+
+// peerPool 返回到 peer 的连接池，第一次使用时创建，参数见 peerPoolConfig
+func (cluster *ClusterDatabase) peerPool(peer string) *pool.ObjectPool {
+	cluster.peerMu.Lock()
+	defer cluster.peerMu.Unlock()
+	p, ok := cluster.peerConnection[peer]
 	if !ok {
-		return nil, errors.New("getPeerClient connection not found")
+		p = pool.NewObjectPool(context.Background(), &connectionFactory{Peer: peer, Cluster: cluster}, peerPoolConfig())
+		cluster.peerConnection[peer] = p
 	}
-	object, err := pool.BorrowObject(context.Background())
+	return p
+}
+
+// getPeerClient 借出一个连接，最多等待 cluster-pool-timeout
+func (cluster *ClusterDatabase) getPeerClient(ctx context.Context, peer string) (*peerClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, peerPoolTimeout())
+	defer cancel()
+	object, err := cluster.peerPool(peer).BorrowObject(ctx)
 	if err != nil {
 		return nil, err
 	}
-	c, ok := object.(*client.Client)
+	c, ok := object.(*peerClient)
 	if !ok {
 		return nil, errors.New("getPeerClient type assert failed")
 	}
 	return c, nil
 }
 
-func (cluster *ClusterDatabase) returnPeerClient(peer string, c *client.Client) error {
-	pool, ok := cluster.peerConnection[peer]
-	if !ok {
-		return errors.New("returnPeerClient connection not found")
+// returnPeerClient 归还连接，请求失败或连接断开过时关闭它，不再放回连接池
+func (cluster *ClusterDatabase) returnPeerClient(peer string, c *peerClient, err error) {
+	if err != nil || c.Broken() {
+		// 关闭连接时等待其上的心跳结束，不阻塞调用方
+		go func() {
+			_ = cluster.peerPool(peer).InvalidateObject(context.Background(), c)
+		}()
+		return
 	}
-	return pool.ReturnObject(context.Background(), c)
+	_ = cluster.peerPool(peer).ReturnObject(context.Background(), c)
 }
 
 // 转发请求
 func (cluster *ClusterDatabase) relay(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.relayContext(context.Background(), peer, c, args, false)
+}
+
+// relayAsking 转发到正在导入该槽位的节点，命令前先发送 ASKING
+func (cluster *ClusterDatabase) relayAsking(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.relayContext(context.Background(), peer, c, args, true)
+}
+
+// relayContext 最多等待到 ctx 的截止时间。peer 的熔断器打开时立即返回错误，见 breaker.go
+func (cluster *ClusterDatabase) relayContext(ctx context.Context, peer string, c resp.Connection, args [][]byte, asking bool) resp.Reply {
 	if peer == cluster.self {
-		return cluster.db.Exec(c, args)
+		return cluster.execLocal(c, args)
+	}
+	breaker := cluster.peerBreaker(peer)
+	if !breaker.allow() {
+		return reply.MakeErrReply("CLUSTERDOWN Node " + peer + " is unreachable")
+	}
+	r, err := cluster.sendToPeer(ctx, peer, c, args, asking)
+	var exhausted *pool.NoSuchElementErr
+	var authErr *peerAuthError
+	if errors.As(err, &exhausted) || errors.As(err, &authErr) {
+		// 连接池中没有空闲的连接，或者对方可达但认证失败，不是连接的问题
+		breaker.done(true)
+	} else {
+		breaker.done(err == nil)
+	}
+	if err != nil {
+		return reply.MakeErrReply("ERR relay to " + peer + " failed: " + err.Error())
 	}
-	peerClient, err := cluster.getPeerClient(peer)
+	return r
+}
+
+func (cluster *ClusterDatabase) sendToPeer(ctx context.Context, peer string, c resp.Connection, args [][]byte, asking bool) (r resp.Reply, err error) {
+	peerClient, err := cluster.getPeerClient(ctx, peer)
 	if err != nil {
-		return reply.MakeErrReply("relay failed" + err.Error())
+		return nil, err
 	}
 	defer func() {
-		_ = cluster.returnPeerClient(peer, peerClient)
+		cluster.returnPeerClient(peer, peerClient, err)
 	}()
-	// 需要手动将select db发给peers
-	peerClient.Send(utils.ToCmdLine2("SELECT", strconv.Itoa(c.GetDBIndex())))
-	return peerClient.Send(args)
+	// 连接上的 DB 与客户端的不同时才发送 SELECT
+	if err := peerClient.selectDB(ctx, c.GetDBIndex()); err != nil {
+		return nil, err
+	}
+	if asking {
+		if _, err := peerClient.SendContext(ctx, utils.ToCmdLine("ASKING")); err != nil {
+			return nil, err
+		}
+	}
+	return peerClient.SendContext(ctx, args)
 }
 
+// boardcast 并发地在每个负责槽位的主节点上执行 args，所有节点共用 broadcastTimeout 的截止时间，
+// 返回每个节点的结果
 func (cluster *ClusterDatabase) boardcast(c resp.Connection, args [][]byte) map[string]resp.Reply {
-	results := make(map[string]resp.Reply)
-	for _, peer := range cluster.nodes {
-		results[peer] = cluster.relay(peer, c, args)
+	ctx, cancel := context.WithTimeout(context.Background(), broadcastTimeout)
+	defer cancel()
+	owners := cluster.topology.slotOwners()
+	replies := make([]resp.Reply, len(owners))
+	var wg sync.WaitGroup
+	for i, node := range owners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replies[i] = cluster.relayContext(ctx, node.Addr, c, args, false)
+		}()
+	}
+	wg.Wait()
+	results := make(map[string]resp.Reply, len(owners))
+	for i, node := range owners {
+		results[node.Addr] = replies[i]
 	}
 	return results
 }
+
+// broadcastError 把各节点返回的错误合并为一个错误回复，没有错误时返回 nil。
+// 只有一个节点出错时原样返回，客户端仍能按 TRYAGAIN、CLUSTERDOWN 等前缀处理
+func broadcastError(results map[string]resp.Reply) resp.Reply {
+	var peers []string
+	for peer, r := range results {
+		if reply.IsErrReply(r) {
+			peers = append(peers, peer)
+		}
+	}
+	switch len(peers) {
+	case 0:
+		return nil
+	case 1:
+		return results[peers[0]]
+	}
+	sort.Strings(peers)
+	msgs := make([]string, len(peers))
+	for i, peer := range peers {
+		msgs[i] = peer + ": " + strings.TrimSpace(strings.TrimPrefix(string(results[peer].ToBytes()), "-"))
+	}
+	return reply.MakeErrReply("ERR " + strconv.Itoa(len(peers)) + " of " + strconv.Itoa(len(results)) +
+		" nodes failed: " + strings.Join(msgs, "; "))
+}
@@ -0,0 +1,393 @@
+package cluster
+
+import (
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/logger"
+	"go_redis/resp/reply"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 故障转移：主节点被标记为 FAIL 后，它的从节点按复制偏移量排名依次推迟后发起选举，
+// 取 currentEpoch + 1 作为本轮纪元向所有负责槽位的主节点请求投票，每个主节点在每个纪元只投一票。
+// 得到多数票的从节点晋升为主节点，以本轮纪元作为配置纪元接管原主节点的槽位，其他节点通过 gossip 接受新的归属，
+// 原主节点和其他从节点发现自己（的主节点）的槽位全部被接管后成为新主节点的从节点
+
+// manualFailoverTimeout 手动故障转移的超时时间，毫秒，与 redis 相同
+const manualFailoverTimeout = 5000
+
+// failoverState 选举和手动故障转移的状态，由 topology.mu 保护
+type failoverState struct {
+	authTime  int64           // 本轮选举开始（或计划开始）的时间，毫秒
+	authSent  bool            // 本轮已经发出投票请求
+	authRank  int             // 本节点在同一主节点的从节点中按复制偏移量的排名，0 为最新
+	authEpoch int64           // 本轮选举的纪元
+	authVotes map[string]bool // 投票给本节点的主节点 ID
+
+	mfEnd      int64        // 手动故障转移的截止时间，0 表示没有进行中的手动故障转移
+	mfReplica  *clusterNode // 主节点上：发起手动故障转移的从节点
+	mfOffset   int64        // 从节点上：主节点暂停写命令后的复制偏移量，-1 表示还不知道
+	mfCanStart bool         // 从节点上：已经追上主节点，可以发起选举
+}
+
+// resetManual 结束手动故障转移
+func (f *failoverState) resetManual() {
+	f.mfEnd = 0
+	f.mfReplica = nil
+	f.mfOffset = -1
+	f.mfCanStart = false
+}
+
+// authTimeout 一轮选举等待投票的时间，两倍的 authTimeout 之后才会发起下一轮
+func authTimeout() int64 {
+	return max(2*int64(config.Properties().ClusterNodeTimeout), 2000)
+}
+
+// replicaRankLocked 同一主节点的正常从节点中复制偏移量比本节点大的个数
+func (t *topology) replicaRankLocked() int {
+	rank := 0
+	for _, node := range t.replicasLocked(t.self.master) {
+		if node != t.self && node.flags&(nodePFail|nodeFail) == 0 && node.replOffset > t.self.replOffset {
+			rank++
+		}
+	}
+	return rank
+}
+
+// replicaFailoverCron 本节点是从节点，主节点下线或者手动故障转移可以开始时发起选举，得到多数票后晋升为主节点
+func (cluster *ClusterDatabase) replicaFailoverCron(now int64) {
+	t := cluster.topology
+	f := &t.failover
+	t.mu.Lock()
+	master := t.self.master
+	manual := f.mfEnd != 0 && f.mfCanStart
+	if master == nil || (master.flags&nodeFail == 0 && !manual) || t.slotCountLocked(master) == 0 {
+		t.mu.Unlock()
+		return
+	}
+	timeout := authTimeout()
+	if now-f.authTime > 2*timeout {
+		// 安排新一轮选举：等待 500 毫秒加上随机时间让 FAIL 传播到所有节点，数据较旧的从节点按排名每名再推迟 1 秒，
+		// 手动故障转移时主节点已经暂停写入，立即开始
+		f.authRank = t.replicaRankLocked()
+		f.authTime = now + 500 + rand.Int63n(500) + int64(f.authRank)*1000
+		if manual {
+			f.authTime, f.authRank = now, 0
+		}
+		f.authSent = false
+		f.authVotes = make(map[string]bool)
+		delay, rank := f.authTime-now, f.authRank
+		t.mu.Unlock()
+		logger.Info("cluster: start of election delayed for " + strconv.FormatInt(delay, 10) +
+			" milliseconds (rank #" + strconv.Itoa(rank) + ")")
+		return
+	}
+	if !f.authSent && !manual {
+		// 等待期间得知其他从节点的偏移量更大时相应推迟
+		if rank := t.replicaRankLocked(); rank > f.authRank {
+			f.authTime += int64(rank-f.authRank) * 1000
+			f.authRank = rank
+		}
+	}
+	if now < f.authTime || now-f.authTime > timeout {
+		t.mu.Unlock()
+		return
+	}
+	if !f.authSent {
+		t.currentEpoch++
+		f.authEpoch = t.currentEpoch
+		f.authSent = true
+		t.dirty = true
+		msg := t.buildMessageLocked(msgAuthRequest, nil)
+		msg.Gossip = nil
+		msg.Force = manual
+		links := make(map[*clusterNode]*busLink)
+		for _, node := range t.nodes {
+			if node != t.self && node.master == nil && node.link != nil && t.slotCountLocked(node) > 0 {
+				links[node] = node.link
+			}
+		}
+		t.mu.Unlock()
+		logger.Info("cluster: starting a failover election for epoch " + strconv.FormatInt(msg.CurrentEpoch, 10))
+		for node, link := range links {
+			if err := link.send(msg); err != nil {
+				cluster.dropLink(node, link)
+			}
+		}
+		return
+	}
+	if len(f.authVotes) < t.sizeLocked()/2+1 {
+		t.mu.Unlock()
+		return
+	}
+	old := t.replaceMasterLocked(f.authEpoch)
+	t.mu.Unlock()
+	logger.Info("cluster: failover election won, taking over slots of " + old.Addr)
+	cluster.afterPromoted()
+}
+
+// grantVoteLocked 收到投票请求时决定是否投票，与 redis 的条件相同：本节点是负责槽位的主节点，本纪元还没有投过票，
+// 对方的主节点已经下线（手动故障转移除外），两倍 cluster-node-timeout 内没有投票给同一主节点的从节点，
+// 并且对方声明的槽位没有被配置纪元更大的节点接管，否则对方的配置已经过时
+func (t *topology) grantVoteLocked(sender *clusterNode, msg *busMessage, now int64) bool {
+	if t.self.master != nil || t.slotCountLocked(t.self) == 0 {
+		return false
+	}
+	master := sender.master
+	reason := ""
+	switch {
+	case msg.CurrentEpoch < t.currentEpoch:
+		reason = "request epoch " + strconv.FormatInt(msg.CurrentEpoch, 10) + " is older than current epoch"
+	case t.lastVoteEpoch == t.currentEpoch:
+		reason = "already voted for epoch " + strconv.FormatInt(t.currentEpoch, 10)
+	case master == nil:
+		reason = "it is a master"
+	case master.flags&nodeFail == 0 && !msg.Force:
+		reason = "its master is up"
+	case now-master.votedTime < 2*int64(config.Properties().ClusterNodeTimeout):
+		reason = "voted for a replica of " + master.Addr + " recently"
+	}
+	for _, r := range msg.Slots {
+		if reason != "" {
+			break
+		}
+		for s := max(r.Start, 0); s <= min(r.End, len(t.slots)-1); s++ {
+			if owner := t.slots[s]; owner != nil && owner.Epoch > msg.ConfigEpoch {
+				reason = "slot " + strconv.Itoa(s) + " has a newer config epoch"
+				break
+			}
+		}
+	}
+	if reason != "" {
+		logger.Info("cluster: failover auth denied to " + sender.Addr + ": " + reason)
+		return false
+	}
+	t.lastVoteEpoch = t.currentEpoch
+	master.votedTime = now
+	t.dirty = true
+	logger.Info("cluster: failover auth granted to " + sender.Addr + " for epoch " + strconv.FormatInt(t.currentEpoch, 10))
+	return true
+}
+
+// countVoteLocked 记录负责槽位的主节点对本轮选举的投票
+func (t *topology) countVoteLocked(sender *clusterNode, msg *busMessage) {
+	f := &t.failover
+	if t.self.master == nil || !f.authSent || msg.CurrentEpoch < f.authEpoch ||
+		sender.master != nil || t.slotCountLocked(sender) == 0 {
+		return
+	}
+	f.authVotes[sender.ID] = true
+}
+
+// replaceMasterLocked 晋升为主节点，以 epoch 为配置纪元接管原主节点的槽位，返回原主节点
+func (t *topology) replaceMasterLocked(epoch int64) *clusterNode {
+	old := t.self.master
+	t.self.master = nil
+	for s, node := range t.slots {
+		if node == old {
+			t.slots[s] = t.self
+			t.migrating[s] = nil
+			t.importing[s] = nil
+		}
+	}
+	if epoch > t.self.Epoch {
+		t.self.Epoch = epoch
+	}
+	t.failover = failoverState{mfOffset: -1}
+	t.dirty = true
+	return old
+}
+
+// afterPromoted 停止复制，保存配置，并立即向所有节点发送 PONG 宣布新的槽位归属
+func (cluster *ClusterDatabase) afterPromoted() {
+	cluster.db.ReplicaOfNoOne()
+	cluster.saveConfig()
+	cluster.updateState()
+	t := cluster.topology
+	t.mu.RLock()
+	links := make(map[*clusterNode]*busLink)
+	for _, node := range t.nodes {
+		if node != t.self && node.link != nil {
+			links[node] = node.link
+		}
+	}
+	t.mu.RUnlock()
+	for node, link := range links {
+		cluster.sendPing(node, link, msgPong)
+	}
+}
+
+// setMaster 成为 master 的从节点：放弃自己的槽位，结束手动故障转移，恢复暂停的写命令，从 master 同步数据
+func (cluster *ClusterDatabase) setMaster(master *clusterNode) {
+	t := cluster.topology
+	t.mu.Lock()
+	if t.self.master == master {
+		t.mu.Unlock()
+		return
+	}
+	t.self.master = master
+	for s, node := range t.slots {
+		if node == t.self {
+			t.slots[s] = nil
+		}
+		t.migrating[s] = nil
+		t.importing[s] = nil
+	}
+	t.failover = failoverState{mfOffset: -1}
+	t.dirty = true
+	addr := master.Addr
+	t.mu.Unlock()
+	logger.Info("cluster: configured as replica of " + addr)
+	cluster.pauseWrites(0)
+	host, port := splitAddr(addr)
+	cluster.db.ReplicaOf(host, port)
+	cluster.saveConfig()
+	cluster.updateState()
+}
+
+// clusterReplicate CLUSTER REPLICATE node-id 成为指定主节点的从节点，本节点需要没有槽位和 key
+func (cluster *ClusterDatabase) clusterReplicate(id string) resp.Reply {
+	t := cluster.topology
+	t.mu.RLock()
+	node := t.nodeByIDLocked(id)
+	var errMsg string
+	switch {
+	case node == nil:
+		errMsg = "ERR Unknown node " + id
+	case node == t.self:
+		errMsg = "ERR Can't replicate myself"
+	case node.master != nil:
+		errMsg = "ERR I can only replicate a master, not a replica."
+	case t.self.master == nil && (t.slotCountLocked(t.self) > 0 || cluster.db.KeyCount() > 0):
+		errMsg = "ERR To set a master the node must be empty and without assigned slots."
+	}
+	t.mu.RUnlock()
+	if errMsg != "" {
+		return reply.MakeErrReply(errMsg)
+	}
+	cluster.setMaster(node)
+	return reply.MakeOkReply()
+}
+
+// clusterFailover CLUSTER FAILOVER [FORCE|TAKEOVER] 在从节点上执行，让它取代自己的主节点。
+// 默认先让主节点暂停写命令，等本节点的复制偏移量追上主节点后再发起选举，不会丢失写入；
+// FORCE 不与主节点协调直接发起选举，用于主节点已经下线的情况；TAKEOVER 不经过选举，直接提升配置纪元接管槽位
+func (cluster *ClusterDatabase) clusterFailover(args [][]byte) resp.Reply {
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("cluster|failover")
+	}
+	force, takeover := false, false
+	if len(args) == 1 {
+		switch strings.ToLower(string(args[0])) {
+		case "force":
+			force = true
+		case "takeover":
+			takeover = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	t := cluster.topology
+	t.mu.Lock()
+	master := t.self.master
+	if master == nil {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR You should send CLUSTER FAILOVER to a replica")
+	}
+	if !force && !takeover && (master.flags&nodeFail != 0 || master.link == nil) {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR Master is down or failed, please use CLUSTER FAILOVER FORCE")
+	}
+	f := &t.failover
+	f.resetManual()
+	f.mfEnd = nowMs() + manualFailoverTimeout
+	f.authTime = 0
+	if takeover {
+		t.currentEpoch++
+		t.replaceMasterLocked(t.currentEpoch)
+		t.mu.Unlock()
+		logger.Info("cluster: taking over the master (user request)")
+		cluster.afterPromoted()
+		return reply.MakeOkReply()
+	}
+	if force {
+		f.mfCanStart = true
+		t.mu.Unlock()
+		logger.Info("cluster: forced failover user request accepted")
+		return reply.MakeOkReply()
+	}
+	link := master.link
+	t.mu.Unlock()
+	logger.Info("cluster: manual failover user request accepted")
+	cluster.sendPing(master, link, msgMFStart)
+	return reply.MakeOkReply()
+}
+
+// startManualFailoverLocked 主节点收到自己的从节点发来的 MFSTART 时开始手动故障转移，返回暂停写命令的截止时间，
+// 之后发出的消息带有 Paused 标志和暂停后的复制偏移量
+func (t *topology) startManualFailoverLocked(sender *clusterNode, now int64) int64 {
+	if t.self.master != nil || sender.master != t.self {
+		return 0
+	}
+	f := &t.failover
+	f.resetManual()
+	f.mfEnd = now + manualFailoverTimeout
+	f.mfReplica = sender
+	logger.Info("cluster: manual failover requested by replica " + sender.Addr)
+	return f.mfEnd
+}
+
+// manualFailoverCron 手动故障转移超时后放弃；从节点的复制偏移量追上主节点暂停时的偏移量后允许发起选举
+func (cluster *ClusterDatabase) manualFailoverCron(now int64) {
+	t := cluster.topology
+	f := &t.failover
+	t.mu.Lock()
+	if f.mfEnd == 0 {
+		t.mu.Unlock()
+		return
+	}
+	if now > f.mfEnd {
+		f.resetManual()
+		isMaster := t.self.master == nil
+		t.mu.Unlock()
+		logger.Warn("cluster: manual failover timed out")
+		if isMaster {
+			cluster.pauseWrites(0)
+		}
+		return
+	}
+	if t.self.master != nil && !f.mfCanStart && f.mfOffset >= 0 && t.self.replOffset >= f.mfOffset {
+		f.mfCanStart = true
+		logger.Info("cluster: all master replication stream processed, manual failover can start")
+	}
+	t.mu.Unlock()
+}
+
+// pauseWrites 暂停本节点负责的槽位上的写命令直到 until（毫秒），0 表示恢复。
+// 返回时已经开始执行的写命令都已完成，之后读取的复制偏移量不会再因为它们变化
+func (cluster *ClusterDatabase) pauseWrites(until int64) {
+	cluster.pauseMu.Lock()
+	defer cluster.pauseMu.Unlock()
+	cluster.pausedUntil = until
+}
+
+// writesPaused 调用方持有 pauseMu
+func (cluster *ClusterDatabase) writesPaused() bool {
+	return cluster.pausedUntil != 0 && nowMs() < cluster.pausedUntil
+}
+
+// waitWritesResumed 等待写命令恢复
+func (cluster *ClusterDatabase) waitWritesResumed() {
+	for {
+		cluster.pauseMu.RLock()
+		paused := cluster.writesPaused()
+		cluster.pauseMu.RUnlock()
+		if !paused {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
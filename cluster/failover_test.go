@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"go_redis/config"
+	"testing"
+)
+
+// addReplica 为 master 添加一个从节点
+func addReplica(t *topology, addr string, master *clusterNode) *clusterNode {
+	node := newNode(nodeID(addr), addr, 0)
+	node.master = master
+	t.addNodeLocked(node)
+	return node
+}
+
+// authRequest 从节点 sender 发起的投票请求
+func authRequest(t *topology, sender *clusterNode, epoch int64) *busMessage {
+	master := sender.master
+	ranges := t.slotRangesLocked()[master]
+	return &busMessage{
+		Type:         msgAuthRequest,
+		Sender:       sender.ID,
+		Master:       master.ID,
+		ConfigEpoch:  master.Epoch,
+		CurrentEpoch: epoch,
+		Slots:        ranges,
+	}
+}
+
+func TestGrantVote(t *testing.T) {
+	topo := makeTestTopology(3)
+	master := topo.nodes[1]
+	replica := addReplica(topo, "127.0.0.1:7100", master)
+	now := nowMs()
+
+	// 主节点正常时不投票
+	topo.currentEpoch++
+	if topo.grantVoteLocked(replica, authRequest(topo, replica, topo.currentEpoch), now) {
+		t.Fatal("voted while the master is up")
+	}
+	// 手动故障转移时可以投票
+	msg := authRequest(topo, replica, topo.currentEpoch)
+	msg.Force = true
+	if !topo.grantVoteLocked(replica, msg, now) {
+		t.Fatal("vote denied for a manual failover")
+	}
+	// 同一纪元只投一票
+	master.flags |= nodeFail
+	if topo.grantVoteLocked(replica, authRequest(topo, replica, topo.currentEpoch), now) {
+		t.Fatal("voted twice in one epoch")
+	}
+
+	// 两倍 cluster-node-timeout 内不再投给同一主节点的从节点
+	topo.currentEpoch++
+	if topo.grantVoteLocked(replica, authRequest(topo, replica, topo.currentEpoch), now+1) {
+		t.Fatal("voted for a replica of the same master again")
+	}
+	later := now + 2*int64(config.Properties().ClusterNodeTimeout)
+	// 请求的纪元过时
+	if topo.grantVoteLocked(replica, authRequest(topo, replica, topo.currentEpoch-1), later) {
+		t.Fatal("voted for an old epoch")
+	}
+	if !topo.grantVoteLocked(replica, authRequest(topo, replica, topo.currentEpoch), later) {
+		t.Fatal("vote denied after the vote timeout")
+	}
+	if topo.lastVoteEpoch != topo.currentEpoch {
+		t.Fatalf("last vote epoch %d, want %d", topo.lastVoteEpoch, topo.currentEpoch)
+	}
+}
+
+func TestGrantVoteStaleConfig(t *testing.T) {
+	topo := makeTestTopology(3)
+	master := topo.nodes[1]
+	master.flags |= nodeFail
+	replica := addReplica(topo, "127.0.0.1:7100", master)
+	topo.currentEpoch++
+	msg := authRequest(topo, replica, topo.currentEpoch)
+	// 对方声明的槽位已经被配置纪元更大的节点接管
+	topo.nodes[2].Epoch = master.Epoch + 10
+	topo.slots[msg.Slots[0].Start] = topo.nodes[2]
+	if topo.grantVoteLocked(replica, msg, nowMs()) {
+		t.Fatal("voted for a replica with a stale config")
+	}
+
+	// 从节点和没有槽位的主节点不投票
+	other := makeTestTopology(3)
+	otherReplica := addReplica(other, "127.0.0.1:7100", other.nodes[1])
+	other.nodes[1].flags |= nodeFail
+	other.currentEpoch++
+	other.self.master = other.nodes[2]
+	if other.grantVoteLocked(otherReplica, authRequest(other, otherReplica, other.currentEpoch), nowMs()) {
+		t.Fatal("replica voted")
+	}
+}
+
+func TestCountVoteAndPromote(t *testing.T) {
+	topo := makeTestTopology(3)
+	master := topo.nodes[1]
+	topo.self.master = master
+	for s, n := range topo.slots {
+		if n == topo.self {
+			topo.slots[s] = nil
+		}
+	}
+	f := &topo.failover
+	f.authSent = true
+	f.authEpoch = 10
+	f.authVotes = make(map[string]bool)
+
+	// 过时的投票和从节点的投票不计入
+	topo.countVoteLocked(topo.nodes[2], &busMessage{CurrentEpoch: 9})
+	replica := addReplica(topo, "127.0.0.1:7100", master)
+	topo.countVoteLocked(replica, &busMessage{CurrentEpoch: 10})
+	if len(f.authVotes) != 0 {
+		t.Fatalf("votes %v, want none", f.authVotes)
+	}
+	topo.countVoteLocked(topo.nodes[2], &busMessage{CurrentEpoch: 10})
+	topo.countVoteLocked(master, &busMessage{CurrentEpoch: 10})
+	// 两个负责槽位的主节点中需要 2 票
+	if len(f.authVotes) < topo.sizeLocked()/2+1 {
+		t.Fatalf("%d votes, need %d", len(f.authVotes), topo.sizeLocked()/2+1)
+	}
+
+	owned := topo.slotCountLocked(master)
+	if old := topo.replaceMasterLocked(f.authEpoch); old != master {
+		t.Fatal("replaced the wrong master")
+	}
+	if topo.self.master != nil || topo.self.Epoch != 10 || topo.slotCountLocked(topo.self) != owned {
+		t.Fatalf("master %v, epoch %d, slots %d", topo.self.master, topo.self.Epoch, topo.slotCountLocked(topo.self))
+	}
+	if topo.failover.authSent {
+		t.Fatal("election state is not reset")
+	}
+}
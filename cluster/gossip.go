@@ -0,0 +1,488 @@
+package cluster
+
+import (
+	"go_redis/config"
+	"go_redis/lib/logger"
+	"go_redis/lib/slot"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// forgetTTL CLUSTER FORGET 之后忽略关于该节点的 gossip 的时间，毫秒，与 redis 相同
+const forgetTTL = 60 * 1000
+
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// handleMessage 处理收到的一条总线消息。outNode 不为 nil 时消息来自本节点向 outNode 建立的连接（回复的 PONG），
+// 否则来自对方建立的连接，收到 PING / MEET 时在同一条连接上回复 PONG，投票和 MFSTART 也在同一条连接上回复
+func (cluster *ClusterDatabase) handleMessage(msg *busMessage, link *busLink, outNode *clusterNode) {
+	t := cluster.topology
+	now := nowMs()
+	var failed []*clusterNode
+	var newMaster *clusterNode
+	vote := false
+	var pauseUntil int64
+	t.mu.Lock()
+	sender := t.nodeByIDLocked(msg.Sender)
+	if outNode != nil && msg.Type == msgPong && outNode.flags&nodeHandshake != 0 && sender != outNode {
+		// 握手节点的 ID 是按地址算出来的，以对方回复的为准
+		if sender != nil {
+			// 已经以另一个地址认识了这个节点
+			t.removeNodeLocked(outNode)
+			t.mu.Unlock()
+			return
+		}
+		outNode.ID = msg.Sender
+		sender = outNode
+	}
+	if sender == nil && msg.Type == msgMeet {
+		sender = newNode(msg.Sender, msg.Addr, now)
+		sender.BusPort = msg.BusPort
+		t.addNodeLocked(sender)
+		logger.Info("cluster: node " + msg.Addr + " met us")
+	}
+	if sender != nil && sender != t.self {
+		if msg.CurrentEpoch > t.currentEpoch {
+			t.currentEpoch = msg.CurrentEpoch
+			t.dirty = true
+		}
+		if msg.ConfigEpoch > sender.Epoch {
+			sender.Epoch = msg.ConfigEpoch
+			t.dirty = true
+		}
+		t.updateRoleLocked(sender, msg.Master)
+		sender.replOffset = msg.ReplOffset
+		sender.peerToken = msg.PeerToken
+		if msg.Type == msgPong {
+			sender.pongRecv = now
+			sender.pingSent = 0
+			if sender.flags&(nodeHandshake|nodeMeet) != 0 {
+				sender.flags &^= nodeHandshake | nodeMeet
+				t.dirty = true
+			}
+			sender.flags &^= nodePFail
+			t.clearFailIfNeededLocked(sender, now)
+		}
+		switch msg.Type {
+		case msgFail:
+			if node := t.nodeByIDLocked(msg.Fail); node != nil && node != t.self && node.flags&nodeFail == 0 {
+				node.flags = node.flags&^nodePFail | nodeFail
+				node.failTime = now
+				t.dirty = true
+				logger.Info("cluster: FAIL message received from " + sender.Addr + " about " + node.Addr)
+			}
+		case msgAuthRequest:
+			vote = t.grantVoteLocked(sender, msg, now)
+		case msgAuthAck:
+			t.countVoteLocked(sender, msg)
+		case msgMFStart:
+			pauseUntil = t.startManualFailoverLocked(sender, now)
+		default:
+			if sender.master == nil {
+				// 只有主节点声明的槽位有效，从节点消息中的槽位是它的主节点的
+				newMaster = t.updateSlotsLocked(sender, msg.Slots, msg.ConfigEpoch)
+				t.handleEpochCollisionLocked(sender)
+			}
+			failed = t.processGossipLocked(sender, msg.Gossip, now)
+			if f := &t.failover; msg.Paused && sender == t.self.master && f.mfEnd != 0 && f.mfOffset < 0 {
+				f.mfOffset = msg.ReplOffset
+				logger.Info("cluster: received replication offset " + strconv.FormatInt(msg.ReplOffset, 10) +
+					" from paused master for manual failover")
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	if outNode == nil && (msg.Type == msgPing || msg.Type == msgMeet) {
+		cluster.sendPing(nil, link, msgPong)
+	}
+	if vote {
+		// 投票前先持久化 lastVoteEpoch，重启后不会在同一纪元再投一次
+		cluster.saveConfig()
+		cluster.sendPing(nil, link, msgAuthAck)
+	}
+	if pauseUntil != 0 {
+		cluster.pauseWrites(pauseUntil)
+		cluster.sendPing(nil, link, msgPong)
+	}
+	if newMaster != nil {
+		cluster.setMaster(newMaster)
+	}
+	for _, node := range failed {
+		cluster.broadcastFail(node)
+	}
+}
+
+// updateRoleLocked 按消息更新发送方的主从关系。主节点变成从节点时它的槽位改为未分配，由新的负责节点声明
+func (t *topology) updateRoleLocked(sender *clusterNode, masterID string) {
+	if masterID == "" {
+		if sender.master != nil {
+			sender.master = nil
+			t.dirty = true
+			logger.Info("cluster: node " + sender.Addr + " is now a master")
+		}
+		return
+	}
+	master := t.nodeByIDLocked(masterID)
+	if master == nil || master == sender || sender.master == master {
+		return
+	}
+	if sender.master == nil {
+		for s, node := range t.slots {
+			if node == sender {
+				t.slots[s] = nil
+			}
+		}
+	}
+	sender.master = master
+	t.dirty = true
+	logger.Info("cluster: node " + sender.Addr + " is now a replica of " + master.Addr)
+}
+
+// updateSlotsLocked 发送方声明的槽位当前没有负责节点，或者负责节点的配置纪元比发送方小时，改为由发送方负责。
+// 正在迁入本节点的槽位由 CLUSTER SETSLOT 决定，不受 gossip 影响。
+// 本节点（是从节点时为它的主节点）的槽位因此全部被接管时返回发送方，本节点应当成为它的从节点
+func (t *topology) updateSlotsLocked(sender *clusterNode, claimed []slotRange, senderEpoch int64) *clusterNode {
+	curMaster := t.self
+	if t.self.master != nil {
+		curMaster = t.self.master
+	}
+	lost, taken := 0, 0
+	for _, r := range claimed {
+		for s := max(r.Start, 0); s <= min(r.End, slot.Count-1); s++ {
+			cur := t.slots[s]
+			if cur == sender || t.importing[s] != nil {
+				continue
+			}
+			if cur == nil || cur.Epoch < senderEpoch {
+				if cur == t.self {
+					lost++
+				}
+				if cur == curMaster {
+					taken++
+				}
+				t.slots[s] = sender
+				t.migrating[s] = nil
+				t.dirty = true
+			}
+		}
+	}
+	if lost > 0 {
+		logger.Warn("cluster: " + strconv.Itoa(lost) + " slots taken over by " + sender.Addr +
+			" with config epoch " + strconv.FormatInt(senderEpoch, 10))
+	}
+	if taken > 0 && t.slotCountLocked(curMaster) == 0 && t.self.master != sender {
+		return sender
+	}
+	return nil
+}
+
+// handleEpochCollisionLocked 两个主节点的配置纪元相同时，ID 较小的一方取一个新的纪元，
+// 否则它们声明同一个槽位时无法决定归属
+func (t *topology) handleEpochCollisionLocked(sender *clusterNode) {
+	if sender.Epoch != t.self.Epoch || sender.ID <= t.self.ID || t.self.master != nil {
+		return
+	}
+	t.currentEpoch++
+	t.self.Epoch = t.currentEpoch
+	t.dirty = true
+	logger.Info("cluster: config epoch collision with " + sender.Addr + ", config epoch set to " +
+		strconv.FormatInt(t.self.Epoch, 10))
+}
+
+// processGossipLocked 处理消息中的 gossip：记录主节点发送方对各节点的下线报告，认识新的节点。
+// 返回因此被标记为 FAIL 的节点
+func (t *topology) processGossipLocked(sender *clusterNode, entries []gossipEntry, now int64) []*clusterNode {
+	var failed []*clusterNode
+	for _, entry := range entries {
+		node := t.nodeByIDLocked(entry.ID)
+		if node == nil {
+			if entry.ID == "" || entry.Addr == "" || t.forgotten[entry.ID] > now || t.nodeByAddrLocked(entry.Addr) != nil {
+				continue
+			}
+			// 通过已知节点认识的新节点，先握手，收到 PONG 后才算正式加入
+			node = newNode(entry.ID, entry.Addr, now)
+			node.BusPort = entry.BusPort
+			node.flags = nodeHandshake
+			t.addNodeLocked(node)
+			continue
+		}
+		if node == t.self || sender.master != nil {
+			continue
+		}
+		if entry.Flags&(nodePFail|nodeFail) != 0 {
+			node.failReports[sender.ID] = now
+			if t.markFailingLocked(node, now) {
+				failed = append(failed, node)
+			}
+		} else {
+			delete(node.failReports, sender.ID)
+		}
+	}
+	return failed
+}
+
+// markFailingLocked 本节点认为 node 可能下线，并且有多数主节点报告它下线时（本节点是主节点时也算一个），把它标记为 FAIL
+func (t *topology) markFailingLocked(node *clusterNode, now int64) bool {
+	if node.flags&nodePFail == 0 || node.flags&nodeFail != 0 {
+		return false
+	}
+	needed := t.sizeLocked()/2 + 1
+	failures := 0
+	if t.self.master == nil {
+		failures = 1
+	}
+	validity := 2 * int64(config.Properties().ClusterNodeTimeout)
+	for id, at := range node.failReports {
+		if now-at > validity {
+			delete(node.failReports, id)
+			continue
+		}
+		failures++
+	}
+	if failures < needed {
+		return false
+	}
+	node.flags = node.flags&^nodePFail | nodeFail
+	node.failTime = now
+	t.dirty = true
+	logger.Info("cluster: marking node " + node.Addr + " as failing (quorum reached)")
+	return true
+}
+
+// clearFailIfNeededLocked 收到 FAIL 节点的 PONG 后清除 FAIL：没有槽位的节点立即清除；
+// 负责槽位的节点要等到标记 FAIL 两倍 cluster-node-timeout 之后，在这之前可能已经有从节点接替了它
+func (t *topology) clearFailIfNeededLocked(node *clusterNode, now int64) {
+	if node.flags&nodeFail == 0 {
+		return
+	}
+	if t.slotCountLocked(node) == 0 || now-node.failTime > 2*int64(config.Properties().ClusterNodeTimeout) {
+		node.flags &^= nodeFail
+		t.dirty = true
+		logger.Info("cluster: clear FAIL state for node " + node.Addr)
+	}
+}
+
+// buildMessageLocked 生成一条消息，gossip 部分包含随机挑选的十分之一（至少 3 个）节点和所有可能下线的节点。
+// 从节点发送主节点的配置纪元和槽位，投票时用来判断它的配置是否过时
+func (t *topology) buildMessageLocked(msgType string, target *clusterNode) *busMessage {
+	master := t.self
+	if t.self.master != nil {
+		master = t.self.master
+	}
+	msg := &busMessage{
+		Type:         msgType,
+		Sender:       t.self.ID,
+		Addr:         t.self.Addr,
+		BusPort:      t.self.BusPort,
+		ConfigEpoch:  master.Epoch,
+		CurrentEpoch: t.currentEpoch,
+		ReplOffset:   t.self.replOffset,
+		Paused:       t.self.master == nil && t.failover.mfEnd != 0,
+		PeerToken:    t.self.peerToken,
+	}
+	if master != t.self {
+		msg.Master = master.ID
+	}
+	for s := 0; s < slot.Count; s++ {
+		if t.slots[s] != master {
+			continue
+		}
+		if n := len(msg.Slots); n > 0 && msg.Slots[n-1].End == s-1 {
+			msg.Slots[n-1].End = s
+		} else {
+			msg.Slots = append(msg.Slots, slotRange{Start: s, End: s})
+		}
+	}
+	candidates := make([]*clusterNode, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		if node != t.self && node != target && node.flags&nodeHandshake == 0 {
+			candidates = append(candidates, node)
+		}
+	}
+	wanted := min(max(3, len(t.nodes)/10), len(candidates))
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	for i, node := range candidates {
+		if i < wanted || node.flags&(nodePFail|nodeFail) != 0 {
+			msg.Gossip = append(msg.Gossip, gossipEntry{
+				ID:       node.ID,
+				Addr:     node.Addr,
+				BusPort:  node.BusPort,
+				PingSent: node.pingSent,
+				PongRecv: node.pongRecv,
+				Flags:    node.flags,
+			})
+		}
+	}
+	return msg
+}
+
+// sendPing 在 link 上发送 PING / MEET / PONG 等消息，发送 PING 时记录发送时间
+func (cluster *ClusterDatabase) sendPing(node *clusterNode, link *busLink, msgType string) {
+	t := cluster.topology
+	offset := cluster.db.ReplOffset()
+	t.mu.Lock()
+	t.self.replOffset = offset
+	msg := t.buildMessageLocked(msgType, node)
+	if node != nil && msgType != msgPong && node.pingSent == 0 {
+		node.pingSent = nowMs()
+	}
+	t.mu.Unlock()
+	if err := link.send(msg); err != nil {
+		if node != nil {
+			cluster.dropLink(node, link)
+		} else {
+			link.close()
+		}
+	}
+}
+
+// broadcastFail 通知所有已连接的节点 node 已经下线
+func (cluster *ClusterDatabase) broadcastFail(failing *clusterNode) {
+	t := cluster.topology
+	t.mu.RLock()
+	msg := t.buildMessageLocked(msgFail, nil)
+	msg.Gossip = nil
+	msg.Fail = failing.ID
+	links := make(map[*clusterNode]*busLink)
+	for _, node := range t.nodes {
+		if node != t.self && node.link != nil {
+			links[node] = node.link
+		}
+	}
+	t.mu.RUnlock()
+	for node, link := range links {
+		if err := link.send(msg); err != nil {
+			cluster.dropLink(node, link)
+		}
+	}
+}
+
+// clusterCron 每 100 毫秒执行一次：建立缺失的总线连接，发送 PING，检测超时的节点，保存 nodes.conf
+func (cluster *ClusterDatabase) clusterCron() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for iteration := 0; ; iteration++ {
+		select {
+		case <-ticker.C:
+			cluster.clusterTick(iteration)
+		case <-cluster.closeChan:
+			return
+		}
+	}
+}
+
+func (cluster *ClusterDatabase) clusterTick(iteration int) {
+	t := cluster.topology
+	now := nowMs()
+	timeout := int64(config.Properties().ClusterNodeTimeout)
+	var connect []*clusterNode
+	pings := make(map[*clusterNode]*busLink)
+	offset := cluster.db.ReplOffset()
+
+	t.mu.Lock()
+	t.self.replOffset = offset
+	for id, expire := range t.forgotten {
+		if expire <= now {
+			delete(t.forgotten, id)
+		}
+	}
+	for _, node := range append([]*clusterNode(nil), t.nodes...) {
+		if node == t.self {
+			continue
+		}
+		if node.flags&nodeHandshake != 0 && now-node.ctime > max(timeout, 1000) {
+			// 握手超时，对方可能不存在
+			logger.Info("cluster: handshake with " + node.Addr + " timed out")
+			t.removeNodeLocked(node)
+			continue
+		}
+		if node.link == nil {
+			if !node.connecting {
+				node.connecting = true
+				connect = append(connect, node)
+			}
+		} else if node.pingSent != 0 && now-node.pingSent > timeout/2 && now-node.link.ctime > timeout {
+			// 连接正常但一直收不到 PONG，断开重连，排除连接本身的问题
+			node.link.close()
+			node.link = nil
+		} else if node.pingSent == 0 && now-node.pongRecv > timeout/2 {
+			pings[node] = node.link
+		}
+		if node.pingSent != 0 && now-node.pingSent > timeout && node.flags&(nodePFail|nodeFail) == 0 {
+			node.flags |= nodePFail
+			logger.Info("cluster: node " + node.Addr + " possibly failing")
+		}
+	}
+	if iteration%10 == 0 {
+		// 每秒随机挑 5 个节点，向其中最久没有收到 PONG 的节点发送 PING
+		var oldest *clusterNode
+		for i := 0; i < 5 && len(t.nodes) > 1; i++ {
+			node := t.nodes[rand.Intn(len(t.nodes))]
+			if node == t.self || node.link == nil || node.pingSent != 0 || node.flags&nodeHandshake != 0 {
+				continue
+			}
+			if oldest == nil || node.pongRecv < oldest.pongRecv {
+				oldest = node
+			}
+		}
+		if oldest != nil {
+			pings[oldest] = oldest.link
+		}
+	}
+	t.mu.Unlock()
+
+	for _, node := range connect {
+		go cluster.connectNode(node)
+	}
+	for node, link := range pings {
+		cluster.sendPing(node, link, msgPing)
+	}
+	cluster.manualFailoverCron(now)
+	cluster.replicaFailoverCron(now)
+	cluster.updateState()
+	t.mu.RLock()
+	dirty := t.dirty
+	t.mu.RUnlock()
+	if dirty {
+		cluster.saveConfig()
+	}
+}
+
+// updateState 所有槽位都有正常的负责节点，并且本节点能连通多数主节点时集群状态为 ok，否则为 fail，
+// 此时带 key 的命令返回 CLUSTERDOWN
+func (cluster *ClusterDatabase) updateState() {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ok := true
+	owners := make(map[*clusterNode]struct{})
+	for _, node := range t.slots {
+		if node == nil || node.flags&nodeFail != 0 {
+			ok = false
+			break
+		}
+		owners[node] = struct{}{}
+	}
+	if ok {
+		reachable := 0
+		for node := range owners {
+			if node.flags&(nodePFail|nodeFail) == 0 {
+				reachable++
+			}
+		}
+		ok = reachable >= len(owners)/2+1
+	}
+	if cluster.stateOK.Swap(ok) != ok {
+		state := "fail"
+		if ok {
+			state = "ok"
+		}
+		logger.Info("cluster state changed: " + state)
+	}
+}
@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"go_redis/config"
+	"go_redis/lib/slot"
+	"strings"
+	"testing"
+)
+
+// makeTestTopology n 个主节点平分槽位，本节点为第一个
+func makeTestTopology(n int) *topology {
+	addrs := testAddrs(n)
+	return makeTopology(addrs[0], addrs, nil)
+}
+
+// reportFail sender 在 gossip 中报告 node 可能下线，返回因此被标记为 FAIL 的节点
+func reportFail(t *topology, sender, node *clusterNode, now int64) []*clusterNode {
+	entries := []gossipEntry{{ID: node.ID, Addr: node.Addr, Flags: nodePFail}}
+	return t.processGossipLocked(sender, entries, now)
+}
+
+func TestFailureReportQuorum(t *testing.T) {
+	topo := makeTestTopology(5)
+	target := topo.nodes[4]
+	now := nowMs()
+	// 本节点还没有认为它可能下线时，只记录报告
+	if failed := reportFail(topo, topo.nodes[1], target, now); len(failed) != 0 {
+		t.Fatal("node marked FAIL without PFAIL")
+	}
+	target.flags |= nodePFail
+	// 5 个主节点需要 3 票：本节点和 nodes[1]、nodes[2]
+	if failed := reportFail(topo, topo.nodes[1], target, now); len(failed) != 0 || target.flags&nodeFail != 0 {
+		t.Fatal("node marked FAIL with 2 of 5 reports")
+	}
+	failed := reportFail(topo, topo.nodes[2], target, now)
+	if len(failed) != 1 || failed[0] != target {
+		t.Fatalf("failed %v, want the target", failed)
+	}
+	if target.flags&nodeFail == 0 || target.flags&nodePFail != 0 {
+		t.Fatalf("flags %b, want FAIL", target.flags)
+	}
+	// 已经是 FAIL 时不再重复广播
+	if failed := reportFail(topo, topo.nodes[3], target, now); len(failed) != 0 {
+		t.Fatal("FAIL broadcast twice")
+	}
+}
+
+func TestFailureReportIgnored(t *testing.T) {
+	topo := makeTestTopology(5)
+	target := topo.nodes[4]
+	target.flags |= nodePFail
+	now := nowMs()
+	validity := 2 * int64(config.Properties().ClusterNodeTimeout)
+
+	// 过期的报告不计入
+	target.failReports[topo.nodes[1].ID] = now - validity - 1
+	if failed := reportFail(topo, topo.nodes[2], target, now); len(failed) != 0 {
+		t.Fatal("expired report counted")
+	}
+	if _, ok := target.failReports[topo.nodes[1].ID]; ok {
+		t.Fatal("expired report is not removed")
+	}
+
+	// 从节点的报告不计入
+	replica := newNode(nodeID("127.0.0.1:7100"), "127.0.0.1:7100", now)
+	replica.master = topo.nodes[1]
+	topo.addNodeLocked(replica)
+	if failed := reportFail(topo, replica, target, now); len(failed) != 0 {
+		t.Fatal("replica report counted")
+	}
+
+	// 报告者改口后撤回报告
+	entries := []gossipEntry{{ID: target.ID, Addr: target.Addr}}
+	topo.processGossipLocked(topo.nodes[2], entries, now)
+	if len(target.failReports) != 0 {
+		t.Fatalf("reports %v, want none", target.failReports)
+	}
+
+	// 本节点是从节点时自己不算一票
+	topo.self.master = topo.nodes[3]
+	reportFail(topo, topo.nodes[1], target, now)
+	if failed := reportFail(topo, topo.nodes[2], target, now); len(failed) != 0 {
+		t.Fatal("replica counted itself")
+	}
+	if failed := reportFail(topo, topo.nodes[3], target, now); len(failed) != 1 {
+		t.Fatal("node not marked FAIL with 3 of 5 reports")
+	}
+}
+
+func TestConfigEpochCollision(t *testing.T) {
+	topo := makeTestTopology(3)
+	smaller, larger := topo.nodes[1], topo.nodes[2]
+	smaller.ID = strings.Repeat("0", 40)
+	larger.ID = strings.Repeat("f", 40)
+	topo.currentEpoch = 10
+	smaller.Epoch = topo.self.Epoch
+	larger.Epoch = topo.self.Epoch
+
+	// 对方 ID 较小时由对方取新纪元
+	topo.handleEpochCollisionLocked(smaller)
+	if topo.self.Epoch != larger.Epoch || topo.currentEpoch != 10 {
+		t.Fatal("node with the larger ID changed its epoch")
+	}
+	topo.handleEpochCollisionLocked(larger)
+	if topo.self.Epoch != 11 || topo.currentEpoch != 11 {
+		t.Fatalf("config epoch %d, current epoch %d, want 11", topo.self.Epoch, topo.currentEpoch)
+	}
+	// 不再冲突时不变
+	topo.handleEpochCollisionLocked(larger)
+	if topo.self.Epoch != 11 {
+		t.Fatal("epoch bumped again")
+	}
+	// 从节点没有自己的配置纪元
+	topo.self.master = smaller
+	larger.Epoch = topo.self.Epoch
+	topo.handleEpochCollisionLocked(larger)
+	if topo.self.Epoch != 11 {
+		t.Fatal("replica bumped its epoch")
+	}
+}
+
+func TestConfigEpochCollisionResolved(t *testing.T) {
+	// 两个节点各自处理冲突，只有 ID 较小的一方取新纪元
+	addrs := testAddrs(2)
+	a := makeTopology(addrs[0], addrs, nil)
+	b := makeTopology(addrs[1], addrs, nil)
+	for _, topo := range []*topology{a, b} {
+		for _, n := range topo.nodes {
+			n.Epoch = 5
+		}
+		topo.currentEpoch = 5
+	}
+	a.handleEpochCollisionLocked(a.nodeByIDLocked(b.self.ID))
+	b.handleEpochCollisionLocked(b.nodeByIDLocked(a.self.ID))
+	if a.self.Epoch == b.self.Epoch {
+		t.Fatalf("both nodes have config epoch %d", a.self.Epoch)
+	}
+	bumped := a
+	if a.self.ID > b.self.ID {
+		bumped = b
+	}
+	if bumped.self.Epoch != 6 {
+		t.Fatalf("config epoch %d, want 6", bumped.self.Epoch)
+	}
+}
+
+func TestUpdateSlotsByConfigEpoch(t *testing.T) {
+	topo := makeTestTopology(3)
+	self, other := topo.self, topo.nodes[1]
+	selfSlots := []slotRange{{Start: 0, End: topo.slotCountLocked(self) - 1}}
+	if topo.slots[0] != self {
+		t.Fatal("slot 0 is not owned by self")
+	}
+
+	// 配置纪元较小的声明被忽略
+	other.Epoch = self.Epoch - 1
+	if topo.updateSlotsLocked(other, []slotRange{{Start: 0, End: 99}}, other.Epoch) != nil || topo.slots[0] != self {
+		t.Fatal("claim with an older config epoch accepted")
+	}
+
+	// 正在迁入的槽位不受 gossip 影响
+	topo.importing[1] = other
+	other.Epoch = self.Epoch + 1
+	topo.updateSlotsLocked(other, []slotRange{{Start: 0, End: 99}}, other.Epoch)
+	if topo.slots[0] != other || topo.slots[1] != self || topo.slots[100] != self {
+		t.Fatal("claim with a newer config epoch not applied correctly")
+	}
+	topo.importing[1] = nil
+
+	// 全部槽位被接管后成为对方的从节点
+	if newMaster := topo.updateSlotsLocked(other, selfSlots, other.Epoch); newMaster != other {
+		t.Fatalf("new master %v, want %s", newMaster, other.Addr)
+	}
+	if topo.slotCountLocked(self) != 0 || topo.slotCountLocked(other) != 2*slot.Count/3 {
+		t.Fatalf("self has %d slots, other has %d", topo.slotCountLocked(self), topo.slotCountLocked(other))
+	}
+}
@@ -0,0 +1,183 @@
+package cluster
+
+import (
+	"go_redis/interface/resp"
+	"go_redis/lib/slot"
+	"go_redis/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// clusterMeet CLUSTER MEET ip port [cluster-bus-port]
+// 把节点加入握手列表，下一次定时任务会向它发送 MEET，对方收到后也会认识本节点，其他节点通过 gossip 互相认识
+func (cluster *ClusterDatabase) clusterMeet(args [][]byte) resp.Reply {
+	if len(args) > 3 {
+		return reply.MakeArgNumErrReply("cluster|meet")
+	}
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid base port specified: " + string(args[1]))
+	}
+	busPort := port + clusterBusPortOffset
+	if len(args) == 3 {
+		busPort, err = strconv.Atoi(string(args[2]))
+		if err != nil || busPort <= 0 || busPort > 65535 {
+			return reply.MakeErrReply("ERR Invalid bus port specified: " + string(args[2]))
+		}
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if net.ParseIP(host) == nil {
+		return reply.MakeErrReply("ERR Invalid node address specified: " + addr)
+	}
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.nodeByAddrLocked(addr) != nil {
+		return reply.MakeOkReply()
+	}
+	node := newNode(nodeID(addr), addr, nowMs())
+	node.BusPort = busPort
+	node.flags = nodeHandshake | nodeMeet
+	delete(t.forgotten, node.ID)
+	t.addNodeLocked(node)
+	return reply.MakeOkReply()
+}
+
+// clusterForget CLUSTER FORGET node-id
+// 删除节点，之后 60 秒内忽略其他节点 gossip 中的该节点，需要在所有节点上执行
+func (cluster *ClusterDatabase) clusterForget(id string) resp.Reply {
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node := t.nodeByIDLocked(id)
+	if node == nil {
+		return reply.MakeErrReply("ERR Unknown node " + id)
+	}
+	if node == t.self {
+		return reply.MakeErrReply("ERR I tried hard but I can't forget myself...")
+	}
+	if node == t.self.master {
+		return reply.MakeErrReply("ERR Can't forget my master!")
+	}
+	t.removeNodeLocked(node)
+	t.forgotten[id] = nowMs() + forgetTTL
+	return reply.MakeOkReply()
+}
+
+// clusterReset CLUSTER RESET [HARD|SOFT]
+// 忘记所有其他节点，清空槽位分配；HARD 时把纪元也清零。节点 ID 由地址决定，不会改变。
+// 从节点先变为主节点并清空数据
+func (cluster *ClusterDatabase) clusterReset(args [][]byte) resp.Reply {
+	hard := false
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("cluster|reset")
+	}
+	if len(args) == 1 {
+		switch strings.ToLower(string(args[0])) {
+		case "hard":
+			hard = true
+		case "soft":
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	t := cluster.topology
+	t.mu.Lock()
+	replica := t.self.master != nil
+	if !replica && cluster.db.KeyCount() > 0 {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR CLUSTER RESET can't be called with master nodes containing keys")
+	}
+	t.self.master = nil
+	t.failover = failoverState{mfOffset: -1}
+	for _, node := range append([]*clusterNode(nil), t.nodes...) {
+		if node != t.self {
+			t.removeNodeLocked(node)
+		}
+	}
+	for s := 0; s < slot.Count; s++ {
+		t.slots[s] = nil
+		t.migrating[s] = nil
+		t.importing[s] = nil
+	}
+	if hard {
+		t.currentEpoch = 0
+		t.lastVoteEpoch = 0
+		t.self.Epoch = 0
+	}
+	t.dirty = true
+	t.mu.Unlock()
+	if replica {
+		cluster.db.ReplicaOfNoOne()
+		cluster.db.FlushAll()
+	}
+	cluster.pauseWrites(0)
+	cluster.saveConfig()
+	cluster.updateState()
+	return reply.MakeOkReply()
+}
+
+// clusterAddSlots CLUSTER ADDSLOTS slot [slot ...] / CLUSTER DELSLOTS slot [slot ...]
+func (cluster *ClusterDatabase) clusterAddSlots(args [][]byte, add bool) resp.Reply {
+	t := cluster.topology
+	slots := make([]int, 0, len(args))
+	seen := make(map[int]bool, len(args))
+	for _, arg := range args {
+		s, err := strconv.Atoi(string(arg))
+		if err != nil || s < 0 || s >= slot.Count {
+			return reply.MakeErrReply("ERR Invalid or out of range slot")
+		}
+		if seen[s] {
+			return reply.MakeErrReply("ERR Slot " + strconv.Itoa(s) + " specified multiple times")
+		}
+		seen[s] = true
+		slots = append(slots, s)
+	}
+	t.mu.Lock()
+	for _, s := range slots {
+		if add && t.slots[s] != nil {
+			t.mu.Unlock()
+			return reply.MakeErrReply("ERR Slot " + strconv.Itoa(s) + " is already busy")
+		}
+		if !add && t.slots[s] == nil {
+			t.mu.Unlock()
+			return reply.MakeErrReply("ERR Slot " + strconv.Itoa(s) + " is already unassigned")
+		}
+	}
+	for _, s := range slots {
+		if add {
+			t.slots[s] = t.self
+			t.importing[s] = nil
+		} else {
+			t.slots[s] = nil
+		}
+	}
+	t.dirty = true
+	t.mu.Unlock()
+	cluster.updateState()
+	return reply.MakeOkReply()
+}
+
+// clusterCountFailureReports CLUSTER COUNT-FAILURE-REPORTS node-id 其他主节点对该节点的有效下线报告个数
+func (cluster *ClusterDatabase) clusterCountFailureReports(id string) resp.Reply {
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node := t.nodeByIDLocked(id)
+	if node == nil {
+		return reply.MakeErrReply("ERR Unknown node " + id)
+	}
+	now := nowMs()
+	validity := 2 * nodeTimeout().Milliseconds()
+	count := 0
+	for reporter, at := range node.failReports {
+		if now-at > validity {
+			delete(node.failReports, reporter)
+			continue
+		}
+		count++
+	}
+	return reply.MakeIntReply(int64(count))
+}
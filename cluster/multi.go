@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"errors"
+	"go_redis/config"
+	database2 "go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/lib/slot"
+	"go_redis/resp/reply"
+	"strings"
+)
+
+// 集群模式下的 MULTI / EXEC：命令入队时检查 key，proxy 模式下 EXEC 以跨节点事务执行，
+// key 可以由不同的节点负责，但每条命令自己的 key 必须在同一个节点上；
+// redirect 模式下事务中的 key 都必须由本节点负责，EXEC 在本地执行
+
+// execMultiCommand 处理 MULTI / EXEC / DISCARD 以及事务中的命令入队，返回 false 表示不是事务相关的命令
+func (cluster *ClusterDatabase) execMultiCommand(c resp.Connection, cmdName string, args [][]byte) (resp.Reply, bool) {
+	switch cmdName {
+	case "multi", "discard":
+		return cluster.db.Exec(c, args), true
+	case "exec":
+		return cluster.execExec(c, args), true
+	}
+	if !c.InMultiState() {
+		return nil, false
+	}
+	if errReply := cluster.checkQueuedKeys(args); errReply != nil {
+		c.AddTxError(errors.New(strings.TrimSpace(string(errReply.ToBytes()))))
+		return errReply, true
+	}
+	return database2.EnqueueCmd(c, args), true
+}
+
+// checkQueuedKeys proxy 模式下事务中的命令必须带 key；redirect 模式下 key 必须由本节点负责
+func (cluster *ClusterDatabase) checkQueuedKeys(args [][]byte) resp.Reply {
+	if !database2.ValidateArity(args) {
+		// 由 EnqueueCmd 返回错误
+		return nil
+	}
+	keys := database2.CommandKeys(args)
+	if config.Properties().ClusterRouting != config.ClusterRoutingRedirect {
+		if len(keys) == 0 {
+			return reply.MakeErrReply("ERR Command without key is not allowed in cluster transaction")
+		}
+		return nil
+	}
+	for _, key := range keys {
+		s := slot.KeySlot(string(key))
+		owner, _, _ := cluster.topology.route(s)
+		if owner == nil {
+			return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+		}
+		if owner != cluster.topology.self {
+			return movedReply(s, owner.Addr)
+		}
+	}
+	return nil
+}
+
+// execExec 没有 MULTI、入队时出错以及 redirect 模式由本地处理
+func (cluster *ClusterDatabase) execExec(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 || !c.InMultiState() || len(c.GetTxErrors()) > 0 ||
+		config.Properties().ClusterRouting == config.ClusterRoutingRedirect {
+		// redirect 模式的事务在本地执行，执行期间持有所有写命令的 key 锁
+		return cluster.execLocal(c, args)
+	}
+	cmdLines := c.GetQueuedCmdLine()
+	c.SetMultiState(false)
+	if len(cmdLines) == 0 {
+		return reply.MakeEmptyMutiBulkReply()
+	}
+	return cluster.execTransaction(c, cmdLines)
+}
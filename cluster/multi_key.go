@@ -0,0 +1,178 @@
+package cluster
+
+import (
+	"context"
+	"go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/lib/slot"
+	"go_redis/resp/reply"
+	"strings"
+	"sync"
+)
+
+// MGET MSET DEL EXISTS TOUCH 的 key 可以分布在不同的槽位：本节点负责的 key 按槽位拆开经 dispatch 执行，
+// 迁移和手动故障转移的处理与单 key 命令相同；其他节点的 key 每个节点转发一条子命令，最后按原顺序合并。
+// 拆分后只在单个槽位内保证原子性；MSET 涉及多个槽位时以跨节点事务执行，见 tcc_coordinator.go
+
+// keyGroup 拆分出的一条子命令
+type keyGroup struct {
+	peer    string // 为空表示在本节点执行
+	indexes []int  // 子命令中的 key 在原命令中的序号
+}
+
+//type CmdFunc func(clusterDatabase *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply
+
+func execMultiKey(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	keys := database.CommandKeys(args)
+	if len(keys) == 0 || (cmdName == "mset" && len(args)%2 == 0) {
+		// 参数个数不对，由本地返回错误
+		return cluster.db.Exec(c, args)
+	}
+	if !cluster.stateOK.Load() {
+		return reply.MakeErrReply("CLUSTERDOWN The cluster is down")
+	}
+	groups, errReply := cluster.groupKeys(c, cmdName, keys)
+	if errReply != nil {
+		return errReply
+	}
+	step := 1
+	if cmdName == "mset" {
+		step = 2
+		if len(groups) > 1 {
+			return cluster.msetAcrossSlots(c, args, groups)
+		}
+	}
+	replies := cluster.execGroups(c, cmdName, args, groups, step)
+	// 本地的错误（如 TRYAGAIN）原样返回，其他节点的错误合并后返回
+	remote := make(map[string]resp.Reply)
+	for g, group := range groups {
+		if group.peer != "" {
+			remote[group.peer] = replies[g]
+		} else if reply.IsErrReply(replies[g]) {
+			return replies[g]
+		}
+	}
+	if errReply := broadcastError(remote); errReply != nil {
+		return errReply
+	}
+	values := make([][]byte, len(keys)) // MGET 的结果
+	var count int64                     // DEL EXISTS TOUCH 的结果
+	for g, group := range groups {
+		r := replies[g]
+		switch cmdName {
+		case "mget":
+			results, ok := multiBulkArgs(r)
+			if !ok || len(results) != len(group.indexes) {
+				return reply.MakeErrReply("ERR " + cmdName + " command failed")
+			}
+			for j, i := range group.indexes {
+				values[i] = results[j]
+			}
+		case "mset":
+		default:
+			intReply, ok := r.(*reply.IntReply)
+			if !ok {
+				return reply.MakeErrReply("ERR " + cmdName + " command failed")
+			}
+			count += intReply.Code
+		}
+	}
+	switch cmdName {
+	case "mget":
+		return reply.MakeMultiBulkReply(values)
+	case "mset":
+		return reply.MakeOkReply()
+	}
+	return reply.MakeIntReply(count)
+}
+
+// execGroups 执行每个分组的子命令：发给其他节点的子命令并发转发，共用 broadcastTimeout 的截止时间，
+// 同时在本节点逐个执行本地的分组
+func (cluster *ClusterDatabase) execGroups(c resp.Connection, cmdName string, args [][]byte, groups []*keyGroup, step int) []resp.Reply {
+	ctx, cancel := context.WithTimeout(context.Background(), broadcastTimeout)
+	defer cancel()
+	replies := make([]resp.Reply, len(groups))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		if group.peer == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replies[i] = cluster.relayContext(ctx, group.peer, c, subCommand(args, group.indexes, step), false)
+		}()
+	}
+	for i, group := range groups {
+		if group.peer == "" {
+			sub := subCommand(args, group.indexes, step)
+			replies[i] = cluster.dispatch(c, cmdName, sub, database.CommandKeys(sub), false)
+		}
+	}
+	wg.Wait()
+	return replies
+}
+
+// msetAcrossSlots key 分布在多个槽位的 MSET 以跨节点事务执行，保证原子性
+func (cluster *ClusterDatabase) msetAcrossSlots(c resp.Connection, args [][]byte, groups []*keyGroup) resp.Reply {
+	cmdLines := make([][][]byte, len(groups))
+	for i, group := range groups {
+		cmdLines[i] = subCommand(args, group.indexes, 2)
+	}
+	r := cluster.execTransaction(c, cmdLines)
+	results, ok := replyElements(r)
+	if !ok {
+		return r
+	}
+	for _, result := range results {
+		if reply.IsErrReply(result) {
+			return result
+		}
+	}
+	return reply.MakeOkReply()
+}
+
+// subCommand 取出原命令中序号为 indexes 的 key（以及 MSET 的 value），组成一条子命令
+func subCommand(args [][]byte, indexes []int, step int) [][]byte {
+	sub := make([][]byte, 1, 1+len(indexes)*step)
+	sub[0] = args[0]
+	for _, i := range indexes {
+		sub = append(sub, args[1+i*step:1+(i+1)*step]...)
+	}
+	return sub
+}
+
+// groupKeys 本节点执行的 key 按槽位分组，其他节点的 key 按节点分组，保持 key 第一次出现的顺序
+func (cluster *ClusterDatabase) groupKeys(c resp.Connection, cmdName string, keys [][]byte) ([]*keyGroup, resp.Reply) {
+	self := cluster.topology.self
+	asking := c.IsAsking() || database.IsAskingCommand(cmdName)
+	bySlot := make(map[int]*keyGroup)
+	byPeer := make(map[string]*keyGroup)
+	var groups []*keyGroup
+	for i, key := range keys {
+		s := slot.KeySlot(string(key))
+		owner, _, importing := cluster.topology.route(s)
+		switch {
+		case owner == self, importing != nil && asking, cluster.readLocally(c, cmdName, owner):
+			group, ok := bySlot[s]
+			if !ok {
+				group = &keyGroup{}
+				bySlot[s] = group
+				groups = append(groups, group)
+			}
+			group.indexes = append(group.indexes, i)
+		case owner == nil:
+			return nil, reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+		default:
+			group, ok := byPeer[owner.Addr]
+			if !ok {
+				group = &keyGroup{peer: owner.Addr}
+				byPeer[owner.Addr] = group
+				groups = append(groups, group)
+			}
+			group.indexes = append(group.indexes, i)
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,229 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"go_redis/config"
+	"go_redis/lib/logger"
+	"go_redis/lib/slot"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// nodes.conf 与 redis 的格式相同：每行一个节点，与 CLUSTER NODES 的输出一致，
+// 迁移中的槽位写作 [slot->-目标 ID] 和 [slot-<-来源 ID]，最后一行为 vars currentEpoch <n> lastVoteEpoch <n>
+
+// flagsString CLUSTER NODES 中的 flags 字段
+func (t *topology) flagsString(node *clusterNode) string {
+	flags := make([]string, 0, 3)
+	if node == t.self {
+		flags = append(flags, "myself")
+	}
+	if node.master != nil {
+		flags = append(flags, "slave")
+	} else {
+		flags = append(flags, "master")
+	}
+	if node.flags&nodePFail != 0 {
+		flags = append(flags, "fail?")
+	}
+	if node.flags&nodeFail != 0 {
+		flags = append(flags, "fail")
+	}
+	if node.flags&nodeHandshake != 0 {
+		flags = append(flags, "handshake")
+	}
+	return strings.Join(flags, ",")
+}
+
+// describeNodesLocked 生成 CLUSTER NODES 的内容，本节点迁移中的槽位附在自己那一行的最后
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func (t *topology) describeNodesLocked() string {
+	ranges := t.slotRangesLocked()
+	var sb strings.Builder
+	for _, node := range t.nodes {
+		t.describeNodeLocked(&sb, node, ranges[node])
+	}
+	return sb.String()
+}
+
+// describeNodeLocked 生成一个节点的一行，从节点的 master 字段为主节点的 ID，主节点为 -
+func (t *topology) describeNodeLocked(sb *strings.Builder, node *clusterNode, ranges []slotRange) {
+	linkState := "disconnected"
+	if node == t.self || node.link != nil {
+		linkState = "connected"
+	}
+	masterID := "-"
+	if node.master != nil {
+		masterID = node.master.ID
+	}
+	sb.WriteString(node.ID + " " + node.Addr + "@" + strconv.Itoa(node.BusPort) + " " + t.flagsString(node) + " " +
+		masterID + " " + strconv.FormatInt(node.pingSent, 10) + " " + strconv.FormatInt(node.pongRecv, 10) + " " +
+		strconv.FormatInt(node.Epoch, 10) + " " + linkState)
+	for _, r := range ranges {
+		if r.Start == r.End {
+			sb.WriteString(" " + strconv.Itoa(r.Start))
+		} else {
+			sb.WriteString(" " + strconv.Itoa(r.Start) + "-" + strconv.Itoa(r.End))
+		}
+	}
+	if node == t.self {
+		for s := 0; s < slot.Count; s++ {
+			if target := t.migrating[s]; target != nil {
+				sb.WriteString(" [" + strconv.Itoa(s) + "->-" + target.ID + "]")
+			}
+			if source := t.importing[s]; source != nil {
+				sb.WriteString(" [" + strconv.Itoa(s) + "-<-" + source.ID + "]")
+			}
+		}
+	}
+	sb.WriteString("\n")
+}
+
+// saveConfig 把节点和槽位分配写入 cluster-config-file，先写临时文件再改名，写到一半时崩溃不会损坏原文件
+func (cluster *ClusterDatabase) saveConfig() {
+	cluster.saveMu.Lock()
+	defer cluster.saveMu.Unlock()
+	t := cluster.topology
+	t.mu.Lock()
+	content := t.describeNodesLocked() +
+		"vars currentEpoch " + strconv.FormatInt(t.currentEpoch, 10) +
+		" lastVoteEpoch " + strconv.FormatInt(t.lastVoteEpoch, 10) + "\n"
+	t.dirty = false
+	t.mu.Unlock()
+
+	path := config.Properties().ClusterConfigFile
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		logger.Error("save cluster config failed: " + err.Error())
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logger.Error("save cluster config failed: " + err.Error())
+	}
+}
+
+// loadTopology 从 cluster-config-file 加载集群配置，文件不存在时返回 os.ErrNotExist。
+// 文件中 myself 的地址与 self 不同时以 self 为准
+func loadTopology(path, self string) (*topology, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	t := &topology{forgotten: make(map[string]int64), failover: failoverState{mfOffset: -1}}
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				switch fields[i] {
+				case "currentEpoch":
+					t.currentEpoch, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				case "lastVoteEpoch":
+					t.lastVoteEpoch, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return nil, errors.New("invalid line in " + path + ": " + scanner.Text())
+		}
+		if strings.Contains(fields[2], "handshake") {
+			continue
+		}
+		addr, cport, _ := strings.Cut(fields[1], "@")
+		node := newNode(fields[0], addr, nowMs())
+		if port, err := strconv.Atoi(cport); err == nil {
+			node.BusPort = port
+		}
+		node.Epoch, _ = strconv.ParseInt(fields[6], 10, 64)
+		for _, flag := range strings.Split(fields[2], ",") {
+			switch flag {
+			case "myself":
+				t.self = node
+			case "fail":
+				node.flags |= nodeFail
+			}
+		}
+		t.nodes = append(t.nodes, node)
+		lines = append(lines, fields)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if t.self == nil {
+		return nil, errors.New("myself not found in " + path)
+	}
+	if t.self.Addr != self {
+		t.self.Addr = self
+		_, port := splitAddr(self)
+		t.self.BusPort = port + clusterBusPortOffset
+	}
+	// 所有节点都加载之后才能解析主节点和迁移中的槽位引用的节点
+	for i, fields := range lines {
+		node := t.nodes[i]
+		if fields[3] != "-" {
+			if node.master = t.nodeByIDLocked(fields[3]); node.master == nil {
+				return nil, errors.New("unknown master " + fields[3] + " in " + path)
+			}
+		}
+		for _, item := range fields[8:] {
+			if err := t.loadSlotItem(node, item); err != nil {
+				return nil, errors.New("invalid slot " + item + " in " + path)
+			}
+		}
+	}
+	t.sortNodes()
+	return t, nil
+}
+
+// loadSlotItem 解析 nodes.conf 中的一个槽位字段：N、N-M、[N->-id]、[N-<-id]
+func (t *topology) loadSlotItem(node *clusterNode, item string) error {
+	if strings.HasPrefix(item, "[") && strings.HasSuffix(item, "]") {
+		item = item[1 : len(item)-1]
+		migrating := true
+		sep := "->-"
+		if strings.Contains(item, "-<-") {
+			migrating, sep = false, "-<-"
+		}
+		slotStr, id, ok := strings.Cut(item, sep)
+		s, err := strconv.Atoi(slotStr)
+		other := t.nodeByIDLocked(id)
+		if !ok || err != nil || s < 0 || s >= slot.Count || other == nil {
+			return errors.New("invalid slot")
+		}
+		if migrating {
+			t.migrating[s] = other
+		} else {
+			t.importing[s] = other
+		}
+		return nil
+	}
+	startStr, endStr, isRange := strings.Cut(item, "-")
+	start, err := strconv.Atoi(startStr)
+	if err != nil {
+		return err
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(endStr); err != nil {
+			return err
+		}
+	}
+	if start < 0 || end >= slot.Count || start > end {
+		return errors.New("invalid slot")
+	}
+	for s := start; s <= end; s++ {
+		t.slots[s] = node
+	}
+	return nil
+}
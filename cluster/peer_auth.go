@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+)
+
+// 节点之间连接的认证。转发过来的 KEYS、FLUSHDB 等命令只在本节点执行，TCC 的 PREPARE、COMMIT 等内部命令
+// 只接受其他节点的连接，这需要连接证明自己来自集群内的节点：每个节点启动时随机生成一个令牌，通过总线消息告诉
+// 其他节点，其他节点连接它时用 CLUSTER PEERAUTH 出示这个令牌。与 redis 一样，总线端口只应对集群内的节点开放
+
+// newPeerToken 随机生成 40 个十六进制字符的令牌，不写入 nodes.conf，重启后重新生成
+func newPeerToken() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// peerToken 返回地址为 addr 的节点的令牌，还没有收到它发来的总线消息时为空
+func (t *topology) peerToken(addr string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if node := t.nodeByAddrLocked(addr); node != nil {
+		return node.peerToken
+	}
+	return ""
+}
+
+// clusterPeerAuth CLUSTER PEERAUTH <token>，令牌正确时把连接标记为来自其他节点
+func (cluster *ClusterDatabase) clusterPeerAuth(c resp.Connection, token []byte) resp.Reply {
+	t := cluster.topology
+	t.mu.RLock()
+	own := t.self.peerToken
+	t.mu.RUnlock()
+	if subtle.ConstantTimeCompare(token, []byte(own)) != 1 {
+		return reply.MakeErrReply("ERR invalid peer token")
+	}
+	c.SetPeer(true)
+	return reply.MakeOkReply()
+}
@@ -0,0 +1,261 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/resp/connection"
+	"go_redis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	rebalanceDefaultPipeline = 10   // 每次 MIGRATE 的 key 个数
+	rebalanceDefaultTimeout  = 2000 // MIGRATE 的超时时间，毫秒
+)
+
+// rebalanceMove 把 slots 从 from 迁到 to
+type rebalanceMove struct {
+	from  *clusterNode
+	to    *clusterNode
+	slots []int
+}
+
+// clusterRebalance CLUSTER REBALANCE [SIMULATE] [PIPELINE <keys>] [TIMEOUT <ms>]
+// 让每个节点负责的槽位个数相同，逐个槽位用 SETSLOT + MIGRATE 迁移，迁移过程中槽位一直可以读写。
+// 返回执行（SIMULATE 时为计划执行）的迁移步骤
+func (cluster *ClusterDatabase) clusterRebalance(args [][]byte) resp.Reply {
+	simulate := false
+	pipeline, timeout := rebalanceDefaultPipeline, rebalanceDefaultTimeout
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "simulate":
+			simulate = true
+		case (option == "pipeline" || option == "timeout") && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(string(args[i]))
+			if err != nil || n <= 0 {
+				return reply.MakeErrReply("ERR value is out of range, must be positive")
+			}
+			if option == "pipeline" {
+				pipeline = n
+			} else {
+				timeout = n
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	if addr := cluster.topology.unstableNode(); addr != "" {
+		return reply.MakeErrReply("ERR Node " + addr + " is in handshake or failing, rebalance aborted")
+	}
+	moves := cluster.planRebalance()
+	lines := make([][]byte, 0, len(moves))
+	for _, mv := range moves {
+		line := "Moving " + strconv.Itoa(len(mv.slots)) + " slots from " + mv.from.Addr + " to " + mv.to.Addr
+		if !simulate {
+			for _, s := range mv.slots {
+				if err := cluster.moveSlot(s, mv.from, mv.to, pipeline, timeout); err != nil {
+					return reply.MakeErrReply("ERR rebalance failed at slot " + strconv.Itoa(s) + ": " + err.Error())
+				}
+			}
+		}
+		lines = append(lines, []byte(line))
+	}
+	return reply.MakeMultiBulkReply(lines)
+}
+
+// planRebalance 计算每个节点应有的槽位个数，槽位多的节点从自己槽位的末尾取出多余部分分给槽位少的节点
+func (cluster *ClusterDatabase) planRebalance() []*rebalanceMove {
+	t := cluster.topology
+	owned := t.ownedSlots()
+	nodes := t.masterList()
+	n := len(nodes)
+	balance := make(map[*clusterNode]int, n)
+	for i, node := range nodes {
+		expected := cluster.expectedSlots(i, n)
+		balance[node] = len(owned[node]) - expected
+	}
+	sources := make([]*clusterNode, 0, n)
+	targets := make([]*clusterNode, 0, n)
+	for _, node := range nodes {
+		if balance[node] > 0 {
+			sources = append(sources, node)
+		} else if balance[node] < 0 {
+			targets = append(targets, node)
+		}
+	}
+	sort.SliceStable(sources, func(i, j int) bool { return balance[sources[i]] > balance[sources[j]] })
+	sort.SliceStable(targets, func(i, j int) bool { return balance[targets[i]] < balance[targets[j]] })
+
+	var moves []*rebalanceMove
+	for _, to := range targets {
+		for _, from := range sources {
+			need, surplus := -balance[to], balance[from]
+			if need == 0 {
+				break
+			}
+			if surplus == 0 {
+				continue
+			}
+			k := min(need, surplus)
+			slots := owned[from]
+			moves = append(moves, &rebalanceMove{from: from, to: to, slots: slots[len(slots)-k:]})
+			owned[from] = slots[:len(slots)-k]
+			balance[from] -= k
+			balance[to] += k
+		}
+	}
+	return moves
+}
+
+// expectedSlots 第 i 个节点应当负责的槽位个数，除不尽时前面的节点多分一个
+func (cluster *ClusterDatabase) expectedSlots(i, n int) int {
+	expected := cluster.topology.assignedSlots() / n
+	if i < cluster.topology.assignedSlots()%n {
+		expected++
+	}
+	return expected
+}
+
+// moveSlot 迁移一个槽位：目标节点 IMPORTING，来源节点 MIGRATING，逐批 MIGRATE 槽位中的 key，
+// 最后依次通知目标节点、来源节点和其他节点槽位的新归属
+func (cluster *ClusterDatabase) moveSlot(s int, from, to *clusterNode, pipeline, timeout int) error {
+	slotArg := strconv.Itoa(s)
+	if err := replyErr(cluster.execOnNode(to.Addr, -1, utils.ToCmdLine("cluster", "setslot", slotArg, "importing", from.ID))); err != nil {
+		return err
+	}
+	if err := replyErr(cluster.execOnNode(from.Addr, -1, utils.ToCmdLine("cluster", "setslot", slotArg, "migrating", to.ID))); err != nil {
+		return err
+	}
+	dbs, err := cluster.nonEmptyDBs(from.Addr)
+	if err != nil {
+		return err
+	}
+	host, port := splitAddr(to.Addr)
+	for _, dbIndex := range dbs {
+		for {
+			r := cluster.execOnNode(from.Addr, dbIndex, utils.ToCmdLine("cluster", "getkeysinslot", slotArg, strconv.Itoa(pipeline)))
+			if err := replyErr(r); err != nil {
+				return err
+			}
+			keys, _ := multiBulkArgs(r)
+			if len(keys) == 0 {
+				break
+			}
+			migrate := utils.ToCmdLine("migrate", host, strconv.Itoa(port), "", strconv.Itoa(dbIndex), strconv.Itoa(timeout), "replace")
+			if config.Properties().RequirePass != "" {
+				migrate = append(migrate, []byte("auth"), []byte(config.Properties().RequirePass))
+			}
+			migrate = append(migrate, []byte("keys"))
+			migrate = append(migrate, keys...)
+			if err := replyErr(cluster.execOnNode(from.Addr, dbIndex, migrate)); err != nil {
+				return err
+			}
+		}
+	}
+	setNode := utils.ToCmdLine("cluster", "setslot", slotArg, "node", to.ID)
+	if err := replyErr(cluster.execOnNode(to.Addr, -1, setNode)); err != nil {
+		return err
+	}
+	if err := replyErr(cluster.execOnNode(from.Addr, -1, setNode)); err != nil {
+		return err
+	}
+	for _, node := range cluster.topology.nodeList() {
+		if node != from && node != to {
+			// 其他节点没有收到通知时仍会把请求转发或重定向到来源节点，来源节点会再次重定向，不影响正确性
+			_ = replyErr(cluster.execOnNode(node.Addr, -1, setNode))
+		}
+	}
+	return nil
+}
+
+// nonEmptyDBs 从 INFO keyspace 中取出节点上有 key 的 DB，重定向模式下只使用 DB 0
+func (cluster *ClusterDatabase) nonEmptyDBs(addr string) ([]int, error) {
+	if config.Properties().ClusterRouting == config.ClusterRoutingRedirect {
+		return []int{0}, nil
+	}
+	r := cluster.execOnNode(addr, -1, utils.ToCmdLine("info", "keyspace"))
+	if err := replyErr(r); err != nil {
+		return nil, err
+	}
+	var text string
+	switch info := r.(type) {
+	case *reply.BulkReply:
+		text = string(info.Arg)
+	case *reply.VerbatimReply:
+		text = string(info.Text)
+	}
+	var dbs []int
+	for _, line := range strings.Split(text, "\r\n") {
+		name, _, ok := strings.Cut(line, ":keys=")
+		if !ok || !strings.HasPrefix(name, "db") {
+			continue
+		}
+		if dbIndex, err := strconv.Atoi(name[2:]); err == nil {
+			dbs = append(dbs, dbIndex)
+		}
+	}
+	return dbs, nil
+}
+
+// execOnNode 在指定节点上执行命令，dbIndex 不小于 0 时先切换到该 DB。
+// 这里只会执行 CLUSTER、MIGRATE 和 INFO，本节点上直接调用对应的处理函数
+func (cluster *ClusterDatabase) execOnNode(addr string, dbIndex int, args [][]byte) resp.Reply {
+	if addr == cluster.self {
+		conn := &connection.Connection{}
+		conn.SetPassword(config.Properties().RequirePass)
+		if dbIndex >= 0 {
+			conn.SelectDB(dbIndex)
+		}
+		switch strings.ToLower(string(args[0])) {
+		case "cluster":
+			return execCluster(cluster, conn, args)
+		case "migrate":
+			return execMigrate(cluster, conn, args)
+		}
+		return cluster.db.Exec(conn, args)
+	}
+	peerClient, err := cluster.getPeerClient(context.Background(), addr)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	if dbIndex >= 0 {
+		err = peerClient.selectDB(context.Background(), dbIndex)
+	}
+	var r resp.Reply
+	if err == nil {
+		r, err = peerClient.SendContext(context.Background(), args)
+	}
+	cluster.returnPeerClient(addr, peerClient, err)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return r
+}
+
+// replyErr 把错误回复转换为 error
+func replyErr(r resp.Reply) error {
+	if !reply.IsErrReply(r) {
+		return nil
+	}
+	msg := strings.TrimSpace(string(r.ToBytes()))
+	return errors.New(strings.TrimPrefix(msg, "-"))
+}
+
+// multiBulkArgs 取出本地或者转发得到的数组回复中的元素，不是数组时 ok 为 false
+func multiBulkArgs(r resp.Reply) ([][]byte, bool) {
+	switch r := r.(type) {
+	case *reply.MultiBulkReply:
+		return r.Args, true
+	case *reply.EmptyMutiBulkReply:
+		return nil, true
+	}
+	return nil, false
+}
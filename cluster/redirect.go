@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	database2 "go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/lib/slot"
+	"go_redis/resp/reply"
+	"strconv"
+)
+
+// redirect 模式：key 所在的槽位由本节点负责时在本地执行，否则返回 MOVED 或 ASK，
+// 由集群客户端直接访问负责的节点，省去 proxy 模式下节点之间的一次转发
+
+// execRedirect redirect 模式下执行一条命令
+func (cluster *ClusterDatabase) execRedirect(c resp.Connection, cmdName string, args [][]byte) resp.Reply {
+	switch cmdName {
+	case "cluster":
+		return execCluster(cluster, c, args)
+	case "migrate":
+		return execMigrate(cluster, c, args)
+	case "select":
+		if len(args) == 2 && string(args[1]) != "0" {
+			return reply.MakeErrReply("ERR SELECT is not allowed in cluster mode")
+		}
+	}
+	keys := database2.CommandKeys(args)
+	if len(keys) == 0 {
+		// 没有 key 的命令只作用于本节点
+		return cluster.execLocal(c, args)
+	}
+	return cluster.dispatch(c, cmdName, args, keys, true)
+}
+
+// dispatch 执行带 key 的命令：槽位由本节点负责时在本地执行；否则 redirect 为 true 时返回 MOVED / ASK，
+// 为 false（proxy 模式）时转发给负责的节点
+func (cluster *ClusterDatabase) dispatch(c resp.Connection, cmdName string, args [][]byte, keys [][]byte, redirect bool) resp.Reply {
+	s := slot.KeySlot(string(keys[0]))
+	for _, key := range keys[1:] {
+		if slot.KeySlot(string(key)) != s {
+			return reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	if !cluster.stateOK.Load() {
+		return reply.MakeErrReply("CLUSTERDOWN The cluster is down")
+	}
+	owner, migrating, importing := cluster.topology.route(s)
+	self := cluster.topology.self
+	asking := c.IsAsking() || database2.IsAskingCommand(cmdName)
+	unlock := func() {}
+	if (owner == self || importing != nil && asking) && !database2.IsReadOnlyCommand(cmdName) {
+		// 持有 key 锁直到命令执行结束，与跨节点事务互斥，见 tcc.go
+		if unlock = cluster.lockWrites(c, args); unlock == nil {
+			return reply.MakeErrReply(errWaitLockTimeout)
+		}
+	}
+	if owner == self && !database2.IsReadOnlyCommand(cmdName) {
+		// 手动故障转移期间等待，恢复后槽位可能已经交给了新的主节点，重新路由
+		cluster.pauseMu.RLock()
+		if cluster.writesPaused() {
+			cluster.pauseMu.RUnlock()
+			unlock()
+			cluster.waitWritesResumed()
+			return cluster.dispatch(c, cmdName, args, keys, redirect)
+		}
+		defer cluster.pauseMu.RUnlock()
+	}
+	defer unlock()
+	switch {
+	case owner == self && migrating != nil:
+		return cluster.execMigrating(c, args, keys, s, migrating, redirect)
+	case owner == self, importing != nil && asking:
+		return cluster.db.Exec(c, args)
+	case owner == nil:
+		return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+	case cluster.readLocally(c, cmdName, owner):
+		return cluster.db.Exec(c, args)
+	case redirect:
+		return movedReply(s, owner.Addr)
+	}
+	return cluster.relay(owner.Addr, c, args)
+}
+
+// readLocally 本节点是负责节点的从节点，客户端执行过 READONLY 时只读命令可以在这里读
+func (cluster *ClusterDatabase) readLocally(c resp.Connection, cmdName string, owner *clusterNode) bool {
+	return owner != nil && c.IsReadOnly() && database2.IsReadOnlyCommand(cmdName) && cluster.db.MasterAddr() == owner.Addr
+}
+
+// execMigrating 槽位正在从本节点迁出：key 都还在本节点时直接执行，全部迁走时交给目标节点，
+// 部分迁走时返回 TRYAGAIN。判断和执行期间持有 migrateMu 读锁，MIGRATE 不会在中间把 key 迁走
+func (cluster *ClusterDatabase) execMigrating(c resp.Connection, args [][]byte, keys [][]byte, s int, target *clusterNode, redirect bool) resp.Reply {
+	cluster.migrateMu.RLock()
+	missing := 0
+	for _, key := range keys {
+		if !cluster.db.KeyExists(c, string(key)) {
+			missing++
+		}
+	}
+	if missing == 0 {
+		defer cluster.migrateMu.RUnlock()
+		return cluster.db.Exec(c, args)
+	}
+	cluster.migrateMu.RUnlock()
+	if missing < len(keys) {
+		return reply.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+	}
+	if redirect {
+		return askReply(s, target.Addr)
+	}
+	return cluster.relayAsking(target.Addr, c, args)
+}
+
+func movedReply(s int, addr string) resp.Reply {
+	return reply.MakeErrReply("MOVED " + strconv.Itoa(s) + " " + addr)
+}
+
+func askReply(s int, addr string) resp.Reply {
+	return reply.MakeErrReply("ASK " + strconv.Itoa(s) + " " + addr)
+}
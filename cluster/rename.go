@@ -2,10 +2,12 @@ package cluster
 
 import (
 	"go_redis/interface/resp"
+	"go_redis/lib/slot"
 	"go_redis/resp/reply"
+	"strings"
 )
 
-// 暂时不考虑节点迁移问题
+// 两个 key 在同一个槽位时与普通命令一样路由；不同槽位但在同一个节点时直接转发；在不同节点时以跨节点事务执行
 
 //type CmdFunc func(clusterDatabase *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply
 
@@ -15,10 +17,17 @@ func Rename(clusterDatabase *ClusterDatabase, c resp.Connection, cmdArgs [][]byt
 	}
 	old := string(cmdArgs[1])
 	cur := string(cmdArgs[2])
-	oldPeer := clusterDatabase.peerPicker.PickNode(old)
-	curPeer := clusterDatabase.peerPicker.PickNode(cur)
+	if slot.KeySlot(old) == slot.KeySlot(cur) {
+		// 同一个槽位，迁移期间也能正确路由
+		return clusterDatabase.dispatch(c, strings.ToLower(string(cmdArgs[0])), cmdArgs, cmdArgs[1:], false)
+	}
+	oldPeer := clusterDatabase.topology.pickNode(old)
+	curPeer := clusterDatabase.topology.pickNode(cur)
+	if oldPeer == "" || curPeer == "" {
+		return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+	}
 	if oldPeer != curPeer {
-		return reply.MakeErrReply("ERR cross slot rename is not allowed")
+		return clusterDatabase.renameAcrossNodes(c, cmdArgs, oldPeer, curPeer)
 	}
-	return clusterDatabase.relay(cur, c, cmdArgs)
+	return clusterDatabase.relay(curPeer, c, cmdArgs)
 }
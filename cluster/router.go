@@ -1,29 +1,60 @@
 package cluster
 
-import "go_redis/interface/resp"
+import (
+	"go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"strings"
+)
 
 //type CmdFunc func(clusterDatabase *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply
 
+// makeRouter 需要特殊处理的命令，其余命令都由 defaultFunc 按 key 的位置路由
 func makeRouter() map[string]CmdFunc {
 	router := make(map[string]CmdFunc)
-	router["exists"] = defaultFunc
-	router["del"] = defaultFunc
-	router["get"] = defaultFunc
-	router["type"] = defaultFunc
-	router["set"] = defaultFunc
-	router["setnx"] = defaultFunc
-	router["getset"] = defaultFunc
-	router["ping"] = ping
 	router["rename"] = Rename
 	router["renamenx"] = Rename
-	router["flushdb"] = flushdb
-	router["del"] = Del
-	router["select"] = execSelect
+	router["cluster"] = execCluster
+	router["migrate"] = execMigrate
+	// 可以按节点拆分的多 key 命令
+	for _, name := range []string{"mget", "mset", "del", "exists", "touch"} {
+		router[name] = execMultiKey
+	}
+	// 需要汇总所有节点结果的命令
+	router["keys"] = execKeys
+	router["dbsize"] = execDBSize
+	router["scan"] = execScan
+	router["randomkey"] = execRandomKey
+	router["flushdb"] = execFlush
+	router["flushall"] = execFlush
+	router["info"] = execInfo
+	// 跨节点事务，见 tcc.go
+	router["prepare"] = execTCC
+	router["commit"] = execTCC
+	router["rollback"] = execTCC
+	router["forget"] = execTCC
 	return router
 }
 
+// defaultFunc 按 key 所在的槽位转发，没有 key 的命令在本地执行
 func defaultFunc(clusterDatabase *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
-	key := string(cmdArgs[1])
-	peer := clusterDatabase.peerPicker.PickNode(key)
-	return clusterDatabase.relay(peer, c, cmdArgs)
+	keys := database.CommandKeys(cmdArgs)
+	if len(keys) == 0 {
+		// 服务器命令，或者参数个数不对由本地返回错误
+		return clusterDatabase.execLocal(c, cmdArgs)
+	}
+	return clusterDatabase.dispatch(c, strings.ToLower(string(cmdArgs[0])), cmdArgs, keys, false)
+}
+
+// execMigrate MIGRATE 期间持有 migrateMu 写锁，见 execMigrating。
+// 迁走的 key 在本节点被删除，与写命令一样先锁住 key，加锁顺序也与 dispatch 相同
+func execMigrate(clusterDatabase *ClusterDatabase, c resp.Connection, cmdArgs [][]byte) resp.Reply {
+	unlock := clusterDatabase.lockWrites(c, cmdArgs)
+	if unlock == nil {
+		return reply.MakeErrReply(errWaitLockTimeout)
+	}
+	defer unlock()
+	clusterDatabase.migrateMu.Lock()
+	defer clusterDatabase.migrateMu.Unlock()
+	return clusterDatabase.db.Exec(c, cmdArgs)
 }
@@ -0,0 +1,224 @@
+package cluster
+
+import (
+	"go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KEYS DBSIZE SCAN RANDOMKEY FLUSHDB FLUSHALL INFO 没有 key，proxy 模式下发给每个负责槽位的主节点再汇总结果。
+// 节点之间转发用的连接执行过 CLUSTER PEERAUTH，这些命令在对方节点只在本地执行，不会再次分发。
+// 除 SCAN 和 RANDOMKEY 外经 boardcast 并发发送，有节点出错时返回合并后的错误
+
+// scanNodeShift SCAN 游标的高位是节点序号，低位是该节点上的游标
+const scanNodeShift = 32
+
+// gatherLocally 连接来自其他节点，或者参数有误由本地返回错误
+func gatherLocally(c resp.Connection, args [][]byte) bool {
+	return c.IsPeer() || !database.ValidateArity(args)
+}
+
+// KEYS pattern
+func execKeys(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if gatherLocally(c, args) {
+		return cluster.db.Exec(c, args)
+	}
+	results := cluster.boardcast(c, args)
+	if errReply := broadcastError(results); errReply != nil {
+		return errReply
+	}
+	result := make([][]byte, 0)
+	for _, r := range results {
+		keys, ok := multiBulkArgs(r)
+		if !ok {
+			return reply.MakeErrReply("ERR keys command failed")
+		}
+		result = append(result, keys...)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// DBSIZE
+func execDBSize(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if gatherLocally(c, args) {
+		return cluster.db.Exec(c, args)
+	}
+	results := cluster.boardcast(c, args)
+	if errReply := broadcastError(results); errReply != nil {
+		return errReply
+	}
+	var size int64
+	for _, r := range results {
+		intReply, ok := r.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR dbsize command failed")
+		}
+		size += intReply.Code
+	}
+	return reply.MakeIntReply(size)
+}
+
+// FLUSHDB / FLUSHALL，从节点通过复制清空。本节点清空前等待事务释放 key，见 lockWrites
+func execFlush(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if gatherLocally(c, args) {
+		return cluster.execLocal(c, args)
+	}
+	if errReply := broadcastError(cluster.boardcast(c, args)); errReply != nil {
+		return errReply
+	}
+	return reply.MakeOkReply()
+}
+
+// RANDOMKEY 随机选择节点，节点为空时换下一个
+func execRandomKey(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if gatherLocally(c, args) {
+		return cluster.db.Exec(c, args)
+	}
+	owners := cluster.topology.slotOwners()
+	for _, i := range rand.Perm(len(owners)) {
+		r := cluster.relay(owners[i].Addr, c, args)
+		if _, ok := r.(*reply.NullBulkReply); !ok {
+			return r
+		}
+	}
+	return reply.MakeNullBulkReply()
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]，按 slotOwners 的顺序逐个节点遍历。
+// 遍历期间槽位的负责节点发生变化时，迁移的 key 可能被漏掉或重复返回
+func execScan(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if gatherLocally(c, args) {
+		return cluster.db.Exec(c, args)
+	}
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	owners := cluster.topology.slotOwners()
+	nodeIndex := int(cursor >> scanNodeShift)
+	if nodeIndex >= len(owners) {
+		return scanReply(0, nil)
+	}
+	sub := make([][]byte, len(args))
+	copy(sub, args)
+	sub[1] = []byte(strconv.FormatUint(cursor&(1<<scanNodeShift-1), 10))
+	r := cluster.relay(owners[nodeIndex].Addr, c, sub)
+	if reply.IsErrReply(r) {
+		return r
+	}
+	raw, ok := r.(*reply.MultiRawReply)
+	if !ok || len(raw.Replies) != 2 {
+		return reply.MakeErrReply("ERR scan command failed")
+	}
+	nextReply, ok1 := raw.Replies[0].(*reply.BulkReply)
+	keys, ok2 := multiBulkArgs(raw.Replies[1])
+	if !ok1 || !ok2 {
+		return reply.MakeErrReply("ERR scan command failed")
+	}
+	next, err := strconv.ParseUint(string(nextReply.Arg), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR scan command failed")
+	}
+	if next == 0 {
+		// 该节点遍历结束，转到下一个节点
+		nodeIndex++
+		if nodeIndex >= len(owners) {
+			return scanReply(0, keys)
+		}
+	}
+	return scanReply(uint64(nodeIndex)<<scanNodeShift|next, keys)
+}
+
+func scanReply(cursor uint64, keys [][]byte) resp.Reply {
+	if keys == nil {
+		keys = [][]byte{}
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(cursor, 10))),
+		reply.MakeMultiBulkReply(keys),
+	})
+}
+
+// infoKeyspaceHeader INFO 中 keyspace section 的标题，keyspace 是最后一个 section
+const infoKeyspaceHeader = "# Keyspace\r\n"
+
+// INFO [section ...]，其他 section 来自本节点，keyspace 汇总所有负责槽位的主节点
+func execInfo(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	local := cluster.db.Exec(c, args)
+	if c.IsPeer() {
+		return local
+	}
+	text, ok := infoText(local)
+	if !ok {
+		return local
+	}
+	head, _, found := strings.Cut(string(text), infoKeyspaceHeader)
+	if !found {
+		return local
+	}
+	type keyspace struct{ keys, expires int64 }
+	results := cluster.boardcast(c, [][]byte{[]byte("INFO"), []byte("keyspace")})
+	if errReply := broadcastError(results); errReply != nil {
+		return errReply
+	}
+	dbs := make(map[int]*keyspace)
+	for _, r := range results {
+		nodeText, ok := infoText(r)
+		if !ok {
+			return reply.MakeErrReply("ERR info command failed")
+		}
+		// db0:keys=1,expires=0,avg_ttl=0
+		for _, line := range strings.Split(string(nodeText), "\r\n") {
+			name, fields, ok := strings.Cut(line, ":")
+			if !ok || !strings.HasPrefix(name, "db") {
+				continue
+			}
+			index, err := strconv.Atoi(name[2:])
+			if err != nil {
+				continue
+			}
+			ks := dbs[index]
+			if ks == nil {
+				ks = &keyspace{}
+				dbs[index] = ks
+			}
+			for _, field := range strings.Split(fields, ",") {
+				key, value, _ := strings.Cut(field, "=")
+				n, _ := strconv.ParseInt(value, 10, 64)
+				switch key {
+				case "keys":
+					ks.keys += n
+				case "expires":
+					ks.expires += n
+				}
+			}
+		}
+	}
+	indexes := make([]int, 0, len(dbs))
+	for index := range dbs {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	var sb strings.Builder
+	sb.WriteString(head + infoKeyspaceHeader)
+	for _, index := range indexes {
+		sb.WriteString("db" + strconv.Itoa(index) + ":keys=" + strconv.FormatInt(dbs[index].keys, 10) +
+			",expires=" + strconv.FormatInt(dbs[index].expires, 10) + ",avg_ttl=0\r\n")
+	}
+	return reply.MakeVerbatimReply("txt", []byte(sb.String()))
+}
+
+// infoText 取出 INFO 回复的文本，本地为 verbatim，转发得到的是 bulk 字符串
+func infoText(r resp.Reply) ([]byte, bool) {
+	switch r := r.(type) {
+	case *reply.VerbatimReply:
+		return r.Text, true
+	case *reply.BulkReply:
+		return r.Arg, true
+	}
+	return nil, false
+}
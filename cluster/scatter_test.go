@@ -0,0 +1,291 @@
+package cluster
+
+import (
+	pool "github.com/jolestar/go-commons-pool/v2"
+	"go_redis/config"
+	database2 "go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/resp/connection"
+	"go_redis/resp/parser"
+	"go_redis/resp/reply"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveCluster 用 cluster 处理 listener 上的连接，作为其他节点转发请求的对端
+func serveCluster(cluster *ClusterDatabase, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			client := connection.NewConnection(conn)
+			defer func() { _ = client.Close() }()
+			reader := parser.NewRequestReader(conn)
+			for {
+				args, err := reader.ReadCommand()
+				if err != nil {
+					return
+				}
+				if err := client.Write(reply.ToBytes(cluster.Exec(client, args), client.GetProtocol())); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// makeTestNodes n 个主节点平分槽位的集群，节点之间通过本地 TCP 连接转发请求，没有集群总线
+func makeTestNodes(t *testing.T, n int) []*ClusterDatabase {
+	t.Helper()
+	old := config.Properties()
+	props := *old
+	props.ClusterConfigFile = filepath.Join(t.TempDir(), "nodes.conf")
+	config.SetProperties(&props)
+	t.Cleanup(func() { config.SetProperties(old) })
+
+	listeners := make([]net.Listener, n)
+	addrs := make([]string, n)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = listener
+		addrs[i] = listener.Addr().String()
+	}
+	nodes := make([]*ClusterDatabase, n)
+	for i, addr := range addrs {
+		cluster := &ClusterDatabase{
+			self:           addr,
+			db:             database2.NewStandaloneDatabase(),
+			peerConnection: make(map[string]*pool.ObjectPool),
+			breakers:       make(map[string]*circuitBreaker),
+			closeChan:      make(chan struct{}),
+			participant: txParticipant{
+				transactions: make(map[string]*transaction),
+				owners:       make(map[string]*keyLockOwner),
+				finished:     make(map[string]int),
+			},
+			txLocks: makeKeyLocks(),
+		}
+		cluster.topology = makeTopology(addr, addrs, nil)
+		cluster.topology.self.peerToken = newPeerToken()
+		cluster.updateState()
+		nodes[i] = cluster
+	}
+	// 代替总线消息交换令牌
+	for _, cluster := range nodes {
+		for _, other := range nodes {
+			if other != cluster {
+				cluster.topology.nodeByID(other.topology.self.ID).peerToken = other.topology.self.peerToken
+			}
+		}
+	}
+	for i, cluster := range nodes {
+		go serveCluster(cluster, listeners[i])
+	}
+	t.Cleanup(func() {
+		for i, cluster := range nodes {
+			_ = listeners[i].Close()
+			cluster.Close()
+		}
+	})
+	return nodes
+}
+
+func execOn(cluster *ClusterDatabase, args ...string) resp.Reply {
+	return cluster.Exec(&connection.Connection{}, utils.ToCmdLine(args...))
+}
+
+func testKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	return keys
+}
+
+// fillNodes 写入 n 个 key，确认它们分布在所有节点上
+func fillNodes(t *testing.T, nodes []*ClusterDatabase, n int) []string {
+	t.Helper()
+	keys := testKeys(n)
+	owners := make(map[string]bool)
+	for _, key := range keys {
+		mustOK(t, execOn(nodes[0], "set", key, "v"))
+		owners[nodes[0].topology.pickNode(key)] = true
+	}
+	if len(owners) != len(nodes) {
+		t.Fatalf("keys are stored on %d of %d nodes", len(owners), len(nodes))
+	}
+	return keys
+}
+
+func sortedArgs(t *testing.T, r resp.Reply) []string {
+	t.Helper()
+	args, ok := multiBulkArgs(r)
+	if !ok {
+		t.Fatalf("unexpected reply %q", r.ToBytes())
+	}
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestScatterKeysAndDBSize(t *testing.T) {
+	nodes := makeTestNodes(t, 3)
+	keys := fillNodes(t, nodes, 30)
+	sort.Strings(keys)
+	for _, node := range nodes {
+		if got := sortedArgs(t, execOn(node, "keys", "key:*")); strings.Join(got, ",") != strings.Join(keys, ",") {
+			t.Fatalf("KEYS on %s: %v", node.self, got)
+		}
+		if r := execOn(node, "dbsize"); string(r.ToBytes()) != ":30\r\n" {
+			t.Fatalf("DBSIZE on %s: %q", node.self, r.ToBytes())
+		}
+	}
+	// 来自其他节点的连接只返回本地的 key
+	peer := &connection.Connection{}
+	peer.SetPeer(true)
+	local := nodes[0].Exec(peer, utils.ToCmdLine("dbsize")).(*reply.IntReply).Code
+	if local == 0 || local == 30 {
+		t.Fatalf("local DBSIZE %d", local)
+	}
+}
+
+func TestScatterScanCursor(t *testing.T) {
+	nodes := makeTestNodes(t, 3)
+	keys := fillNodes(t, nodes, 30)
+	seen := make(map[string]bool)
+	cursor := "0"
+	for i := 0; ; i++ {
+		if i > 100 {
+			t.Fatal("SCAN does not finish")
+		}
+		raw, ok := execOn(nodes[1], "scan", cursor, "count", "4").(*reply.MultiRawReply)
+		if !ok || len(raw.Replies) != 2 {
+			t.Fatalf("unexpected SCAN reply")
+		}
+		cursor = string(raw.Replies[0].(*reply.BulkReply).Arg)
+		for _, key := range sortedArgs(t, raw.Replies[1]) {
+			if seen[key] {
+				t.Fatalf("key %s returned twice", key)
+			}
+			seen[key] = true
+		}
+		next, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 高位是节点序号，低位是节点上的游标
+		if int(next>>scanNodeShift) >= len(nodes) {
+			t.Fatalf("cursor %d has node index %d", next, next>>scanNodeShift)
+		}
+		if next == 0 {
+			break
+		}
+	}
+	if len(seen) != len(keys) {
+		t.Fatalf("SCAN returned %d keys, want %d", len(seen), len(keys))
+	}
+	// 超出节点个数的游标直接结束
+	raw := execOn(nodes[0], "scan", strconv.FormatUint(uint64(len(nodes))<<scanNodeShift, 10)).(*reply.MultiRawReply)
+	if got := string(raw.ToBytes()); got != "*2\r\n$1\r\n0\r\n*0\r\n" {
+		t.Fatalf("SCAN with an out of range cursor: %q", got)
+	}
+	mustErr(t, execOn(nodes[0], "scan", "abc"))
+}
+
+func TestScatterRandomKeyAndFlush(t *testing.T) {
+	nodes := makeTestNodes(t, 3)
+	fillNodes(t, nodes, 30)
+	for i := 0; i < 10; i++ {
+		r, ok := execOn(nodes[0], "randomkey").(*reply.BulkReply)
+		if !ok || !strings.HasPrefix(string(r.Arg), "key:") {
+			t.Fatal("RANDOMKEY did not return a key")
+		}
+	}
+	// 只剩一个节点有数据时也能找到
+	last := "key:0"
+	mustOK(t, execOn(nodes[2], "flushall"))
+	mustOK(t, execOn(nodes[2], "set", last, "v"))
+	if r := execOn(nodes[0], "randomkey"); string(r.ToBytes()) != "$5\r\nkey:0\r\n" {
+		t.Fatalf("RANDOMKEY %q", r.ToBytes())
+	}
+	mustOK(t, execOn(nodes[1], "flushdb"))
+	for _, node := range nodes {
+		if r := execOn(node, "dbsize"); string(r.ToBytes()) != ":0\r\n" {
+			t.Fatalf("DBSIZE after FLUSHDB on %s: %q", node.self, r.ToBytes())
+		}
+	}
+	if _, ok := execOn(nodes[0], "randomkey").(*reply.NullBulkReply); !ok {
+		t.Fatal("RANDOMKEY on an empty cluster")
+	}
+}
+
+func TestScatterInfoKeyspace(t *testing.T) {
+	nodes := makeTestNodes(t, 3)
+	keys := fillNodes(t, nodes, 30)
+	mustOK(t, execOn(nodes[0], "expire", keys[0], "100"))
+	c := &connection.Connection{}
+	c.SelectDB(1)
+	mustOK(t, nodes[0].Exec(c, utils.ToCmdLine("set", "k", "v")))
+	text, ok := infoText(execOn(nodes[1], "info", "keyspace"))
+	if !ok {
+		t.Fatal("unexpected INFO reply")
+	}
+	want := "# Keyspace\r\ndb0:keys=30,expires=1,avg_ttl=0\r\ndb1:keys=1,expires=0,avg_ttl=0\r\n"
+	if !strings.HasSuffix(string(text), want) {
+		t.Fatalf("INFO keyspace %q, want %q", text, want)
+	}
+}
+
+func TestCrossSlotMSet(t *testing.T) {
+	nodes := makeTestNodes(t, 3)
+	keys := testKeys(6)
+	args := []string{"mset"}
+	owners := make(map[string]bool)
+	for _, key := range keys {
+		args = append(args, key, "value-"+key)
+		owners[nodes[0].topology.pickNode(key)] = true
+	}
+	// key 分布在不同节点上，以跨节点事务执行
+	if len(owners) < 2 {
+		t.Fatal("keys are stored on a single node")
+	}
+	mustOK(t, execOn(nodes[0], args...))
+	r := execOn(nodes[2], append([]string{"mget"}, keys...)...)
+	values, ok := multiBulkArgs(r)
+	if !ok || len(values) != len(keys) {
+		t.Fatalf("MGET %q", r.ToBytes())
+	}
+	for i, key := range keys {
+		if string(values[i]) != "value-"+key {
+			t.Fatalf("%s = %q", key, values[i])
+		}
+		// key 写在负责它的节点上
+		owner := nodes[0].topology.pickNode(key)
+		for _, node := range nodes {
+			if _, stored := getValue(node, key); stored != (node.self == owner) {
+				t.Fatalf("%s stored on %s: %v", key, node.self, stored)
+			}
+		}
+	}
+	// 事务结束后 key 没有被锁住
+	for _, node := range nodes {
+		for _, key := range keys {
+			if locked(node, key) {
+				t.Fatalf("%s is still locked on %s", key, node.self)
+			}
+		}
+	}
+}
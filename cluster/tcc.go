@@ -0,0 +1,423 @@
+package cluster
+
+import (
+	"go_redis/config"
+	database2 "go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/lib/logger"
+	"go_redis/lib/slot"
+	"go_redis/resp/connection"
+	"go_redis/resp/reply"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 跨节点事务（TCC）的参与者一侧。
+// PREPARE txid cmd args... 检查命令并锁住涉及的 key，同一个事务可以 PREPARE 多条命令；
+// COMMIT txid 记录 undo log 后依次执行这些命令，返回每条命令的结果，key 锁和 undo log 继续保留；
+// ROLLBACK txid 对未提交的事务只释放 key 锁，对已经提交的事务执行 undo log 恢复原来的值；
+// FORGET txid 所有节点都提交成功后由协调者发送，释放 key 锁并丢弃 undo log，事务之后不能再回滚。
+// 本节点的写命令执行期间持有 key 锁，见 lockWrites。
+// 协调者在 txTimeout 内没有提交时自动回滚；提交后 txConfirmTimeout 内没有收到 FORGET 或 ROLLBACK 时
+// 保留提交的结果，之后迟到的 ROLLBACK 返回错误
+
+// txTimeout 事务从第一次 PREPARE 到提交的最长时间，也是普通写命令等待 key 锁的最长时间，
+// 小于节点之间转发的超时，转发过来的写命令等待结束前对方还在等待回复
+const txTimeout = 2 * time.Second
+
+// txConfirmTimeout 提交后等待 FORGET 或 ROLLBACK 的最长时间，比协调者逐个节点提交再回滚所需的时间长
+const txConfirmTimeout = 30 * time.Second
+
+// txFinishedTTL 事务结束后保留其结果的时间，迟到的 ROLLBACK、FORGET 据此返回正确的结果
+const txFinishedTTL = time.Minute
+
+// errKeysLocked PREPARE 时 key 被其他事务或正在执行的写命令锁住，协调者会重试
+const errKeysLocked = "TRYAGAIN Keys are locked by another transaction"
+
+// errWaitLockTimeout 写命令等待事务释放 key 超时
+const errWaitLockTimeout = "TRYAGAIN Keys are locked by a transaction"
+
+const (
+	txPrepared = iota
+	txCommitted
+	txRolledBack
+)
+
+type transaction struct {
+	id       string
+	dbIndex  int
+	cmdLines [][][]byte
+	keys     []string // 已加锁的 key
+	undoLogs [][][]byte
+	status   int
+	timer    *time.Timer
+}
+
+// lockKey 加锁的单位是某个 DB 中的 key
+type lockKey struct {
+	db  int
+	key string
+}
+
+// allDBs FLUSHALL 锁住所有 DB
+const allDBs = -1
+
+// keyLocks 事务和本节点的写命令持有的 key 锁。PREPARE 加锁失败时直接返回错误，不等待，避免事务之间死锁；
+// 写命令在 key 被锁住时等待，锁住后执行，执行结束才释放，检查和执行之间事务不能 PREPARE 这些 key
+type keyLocks struct {
+	mu     sync.Mutex
+	owners map[lockKey]*keyLockOwner
+	dbs    map[int]*keyLockOwner // FLUSHDB / FLUSHALL 锁住的整个 DB
+}
+
+type keyLockOwner struct {
+	released chan struct{} // 释放全部 key 时关闭
+}
+
+func makeKeyLocks() *keyLocks {
+	return &keyLocks{
+		owners: make(map[lockKey]*keyLockOwner),
+		dbs:    make(map[int]*keyLockOwner),
+	}
+}
+
+// conflictLocked 返回锁住了 keys 中某个 key 的其他 owner，whole 为 true 时检查整个 DB，调用方持有 mu
+func (l *keyLocks) conflictLocked(owner *keyLockOwner, db int, keys []string, whole bool) *keyLockOwner {
+	for d, cur := range l.dbs {
+		if cur != owner && (d == db || d == allDBs || db == allDBs) {
+			return cur
+		}
+	}
+	if whole {
+		for k, cur := range l.owners {
+			if cur != owner && (db == allDBs || k.db == db) {
+				return cur
+			}
+		}
+		return nil
+	}
+	for _, key := range keys {
+		if cur, ok := l.owners[lockKey{db, key}]; ok && cur != owner {
+			return cur
+		}
+	}
+	return nil
+}
+
+// tryLock 锁住 keys，有 key 被其他事务或写命令锁住时一个都不锁，返回 false
+func (l *keyLocks) tryLock(owner *keyLockOwner, db int, keys []string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conflictLocked(owner, db, keys, false) != nil {
+		return false
+	}
+	for _, key := range keys {
+		l.owners[lockKey{db, key}] = owner
+	}
+	return true
+}
+
+func (l *keyLocks) unlock(owner *keyLockOwner, db int, keys []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if l.owners[lockKey{db, key}] == owner {
+			delete(l.owners, lockKey{db, key})
+		}
+	}
+}
+
+// lock 写命令执行前锁住 keys，whole 为 true 时锁住整个 DB（db 为 allDBs 时为所有 DB）。
+// 被锁住时等待，超过 timeout 返回 nil，否则返回解锁函数，命令执行结束后调用
+func (l *keyLocks) lock(db int, keys []string, whole bool, timeout time.Duration) func() {
+	owner := &keyLockOwner{released: make(chan struct{})}
+	var timer <-chan time.Time
+	for {
+		l.mu.Lock()
+		cur := l.conflictLocked(owner, db, keys, whole)
+		if cur == nil {
+			if whole {
+				l.dbs[db] = owner
+			} else {
+				for _, key := range keys {
+					l.owners[lockKey{db, key}] = owner
+				}
+			}
+			l.mu.Unlock()
+			return func() {
+				if whole {
+					l.mu.Lock()
+					delete(l.dbs, db)
+					l.mu.Unlock()
+				} else {
+					l.unlock(owner, db, keys)
+				}
+				close(owner.released)
+			}
+		}
+		l.mu.Unlock()
+		if timer == nil {
+			timer = time.After(timeout)
+		}
+		select {
+		case <-cur.released:
+		case <-timer:
+			return nil
+		}
+	}
+}
+
+// lockWrites 本节点执行写命令前锁住它的 key，直到执行结束：事务持有的 key 等事务结束，
+// 命令执行期间事务也不能 PREPARE 这些 key。FLUSHDB / FLUSHALL 锁住整个 DB，EXEC 锁住事务中所有写命令的 key。
+// 等待超过 txTimeout 返回 nil
+func (cluster *ClusterDatabase) lockWrites(c resp.Connection, args [][]byte) func() {
+	cmdLines := [][][]byte{args}
+	if strings.EqualFold(string(args[0]), "exec") {
+		cmdLines = c.GetQueuedCmdLine()
+	}
+	dbIndex := c.GetDBIndex()
+	var keys []string
+	for _, line := range cmdLines {
+		name := strings.ToLower(string(line[0]))
+		switch {
+		case name == "flushall":
+			return cluster.txLocks.lock(allDBs, nil, true, txTimeout)
+		case name == "flushdb":
+			return cluster.txLocks.lock(dbIndex, nil, true, txTimeout)
+		case database2.IsReadOnlyCommand(name) || !database2.ValidateArity(line):
+			continue
+		}
+		for _, key := range database2.CommandKeys(line) {
+			keys = append(keys, string(key))
+		}
+	}
+	if len(keys) == 0 {
+		return func() {}
+	}
+	return cluster.txLocks.lock(dbIndex, keys, false, txTimeout)
+}
+
+// execLocal 在本节点执行命令，写命令持有 key 锁直到执行结束，见 lockWrites
+func (cluster *ClusterDatabase) execLocal(c resp.Connection, args [][]byte) resp.Reply {
+	unlock := cluster.lockWrites(c, args)
+	if unlock == nil {
+		return reply.MakeErrReply(errWaitLockTimeout)
+	}
+	defer unlock()
+	return cluster.db.Exec(c, args)
+}
+
+// txParticipant 本节点参与的事务
+type txParticipant struct {
+	mu           sync.Mutex
+	transactions map[string]*transaction
+	owners       map[string]*keyLockOwner
+	finished     map[string]int // 已结束的事务最终是提交还是回滚，保留 txFinishedTTL
+}
+
+// execTCC PREPARE / COMMIT / ROLLBACK / FORGET 只接受节点之间转发用的连接
+func execTCC(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	name := strings.ToLower(string(args[0]))
+	if !c.IsPeer() {
+		return reply.MakeErrReply("ERR unknown command '" + name + "'")
+	}
+	return cluster.handleTCC(c.GetDBIndex(), args)
+}
+
+func (cluster *ClusterDatabase) handleTCC(dbIndex int, args [][]byte) resp.Reply {
+	name := strings.ToLower(string(args[0]))
+	switch {
+	case name == "prepare" && len(args) >= 3:
+		return cluster.prepareTx(string(args[1]), dbIndex, args[2:])
+	case name == "commit" && len(args) == 2:
+		return cluster.commitTx(string(args[1]))
+	case name == "rollback" && len(args) == 2:
+		return cluster.rollbackTx(string(args[1]))
+	case name == "forget" && len(args) == 2:
+		return cluster.forgetTx(string(args[1]))
+	}
+	return reply.MakeArgNumErrReply(name)
+}
+
+// prepareTx 检查命令，锁住命令涉及的 key 并记录命令
+func (cluster *ClusterDatabase) prepareTx(txID string, dbIndex int, cmdLine [][]byte) resp.Reply {
+	if !database2.ValidateArity(cmdLine) {
+		return reply.MakeErrReply("ERR invalid command in transaction: " + strings.ToLower(string(cmdLine[0])))
+	}
+	keys := database2.CommandKeys(cmdLine)
+	self := cluster.topology.self
+	for _, key := range keys {
+		owner, migrating, importing := cluster.topology.route(slot.KeySlot(string(key)))
+		if owner != self || migrating != nil || importing != nil {
+			return reply.MakeErrReply("TRYAGAIN Slot of key " + string(key) + " is moving")
+		}
+	}
+	cluster.pauseMu.RLock()
+	paused := cluster.writesPaused()
+	cluster.pauseMu.RUnlock()
+	if paused {
+		return reply.MakeErrReply("TRYAGAIN Writes are paused for failover")
+	}
+	p := &cluster.participant
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.finished[txID]; ok {
+		return reply.MakeErrReply("ERR transaction " + txID + " is already finished")
+	}
+	tx, ok := p.transactions[txID]
+	if !ok {
+		tx = &transaction{id: txID, dbIndex: dbIndex}
+		p.transactions[txID] = tx
+		p.owners[txID] = &keyLockOwner{released: make(chan struct{})}
+		tx.timer = time.AfterFunc(txTimeout, func() {
+			cluster.expireTx(txID)
+		})
+	}
+	if tx.status != txPrepared || tx.dbIndex != dbIndex {
+		return reply.MakeErrReply("ERR transaction " + txID + " is not preparing")
+	}
+	strKeys := make([]string, len(keys))
+	for i, key := range keys {
+		strKeys[i] = string(key)
+	}
+	if !cluster.txLocks.tryLock(p.owners[txID], dbIndex, strKeys) {
+		return reply.MakeErrReply(errKeysLocked)
+	}
+	tx.keys = append(tx.keys, strKeys...)
+	tx.cmdLines = append(tx.cmdLines, cmdLine)
+	return reply.MakeOkReply()
+}
+
+// commitTx 记录写命令涉及的 key 的 undo log 后执行事务中的命令。
+// key 锁保留到 FORGET 或 ROLLBACK，回滚时不会覆盖其他客户端在这期间的写入
+func (cluster *ClusterDatabase) commitTx(txID string) resp.Reply {
+	p := &cluster.participant
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx, ok := p.transactions[txID]
+	if !ok || tx.status != txPrepared {
+		return reply.MakeErrReply("ERR transaction " + txID + " not found or expired")
+	}
+	// 与普通写命令一样，执行期间手动故障转移不会开始暂停
+	cluster.pauseMu.RLock()
+	defer cluster.pauseMu.RUnlock()
+	if cluster.writesPaused() {
+		return reply.MakeErrReply("TRYAGAIN Writes are paused for failover")
+	}
+	var writeKeys []string
+	for _, line := range tx.cmdLines {
+		if database2.IsReadOnlyCommand(string(line[0])) {
+			continue
+		}
+		for _, key := range database2.CommandKeys(line) {
+			writeKeys = append(writeKeys, string(key))
+		}
+	}
+	if !tx.timer.Stop() {
+		// 已经超时，expireTx 正在等待回滚这个事务
+		return reply.MakeErrReply("ERR transaction " + txID + " not found or expired")
+	}
+	tx.undoLogs = cluster.db.UndoLogs(tx.dbIndex, writeKeys)
+	result := cluster.db.ExecMulti(txConnection(tx.dbIndex), tx.cmdLines)
+	tx.status = txCommitted
+	tx.timer = time.AfterFunc(txConfirmTimeout, func() {
+		cluster.expireTx(txID)
+	})
+	return result
+}
+
+// rollbackTx 事务不存在时视为已经回滚，已经提交并且结束的事务不能再回滚
+func (cluster *ClusterDatabase) rollbackTx(txID string) resp.Reply {
+	p := &cluster.participant
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx, ok := p.transactions[txID]
+	if !ok {
+		if p.finished[txID] == txCommitted {
+			return reply.MakeErrReply("ERR transaction " + txID + " is already committed")
+		}
+		return reply.MakeOkReply()
+	}
+	if tx.status == txCommitted && len(tx.undoLogs) > 0 {
+		if r := cluster.db.ExecMulti(txConnection(tx.dbIndex), tx.undoLogs); reply.IsErrReply(r) {
+			logger.Error("rollback transaction " + txID + " failed: " + strings.TrimSpace(string(r.ToBytes())))
+		}
+	}
+	cluster.finishTxLocked(tx, txRolledBack)
+	return reply.MakeOkReply()
+}
+
+// forgetTx 确认已提交的事务，释放 key 锁并丢弃 undo log
+func (cluster *ClusterDatabase) forgetTx(txID string) resp.Reply {
+	p := &cluster.participant
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx, ok := p.transactions[txID]
+	if !ok {
+		if status, ok := p.finished[txID]; ok && status == txRolledBack {
+			return reply.MakeErrReply("ERR transaction " + txID + " is already rolled back")
+		}
+		return reply.MakeOkReply()
+	}
+	if tx.status != txCommitted {
+		return reply.MakeErrReply("ERR transaction " + txID + " is not committed")
+	}
+	cluster.finishTxLocked(tx, txCommitted)
+	return reply.MakeOkReply()
+}
+
+// expireTx 协调者超时没有结束事务：未提交的事务回滚，已提交的事务保留提交的结果
+func (cluster *ClusterDatabase) expireTx(txID string) {
+	p := &cluster.participant
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx, ok := p.transactions[txID]
+	if !ok {
+		return
+	}
+	if tx.status == txPrepared {
+		logger.Warn("transaction " + txID + " timed out, rolled back")
+		cluster.finishTxLocked(tx, txRolledBack)
+		return
+	}
+	logger.Warn("transaction " + txID + " was not confirmed in time, keep it committed")
+	cluster.finishTxLocked(tx, txCommitted)
+}
+
+// finishTxLocked 结束事务：释放 key 锁，记录事务的结果，txFinishedTTL 后删除记录
+func (cluster *ClusterDatabase) finishTxLocked(tx *transaction, status int) {
+	p := &cluster.participant
+	cluster.releaseTxLocked(tx)
+	cluster.removeTxLocked(tx)
+	p.finished[tx.id] = status
+	time.AfterFunc(txFinishedTTL, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.finished, tx.id)
+	})
+}
+
+// releaseTxLocked 释放事务持有的 key 锁，唤醒等待这些 key 的命令
+func (cluster *ClusterDatabase) releaseTxLocked(tx *transaction) {
+	owner, ok := cluster.participant.owners[tx.id]
+	if !ok {
+		return
+	}
+	cluster.txLocks.unlock(owner, tx.dbIndex, tx.keys)
+	delete(cluster.participant.owners, tx.id)
+	close(owner.released)
+}
+
+func (cluster *ClusterDatabase) removeTxLocked(tx *transaction) {
+	tx.timer.Stop()
+	delete(cluster.participant.transactions, tx.id)
+}
+
+// txConnection 执行事务中命令使用的内部连接
+func txConnection(dbIndex int) resp.Connection {
+	conn := &connection.Connection{}
+	conn.SetPassword(config.Properties().RequirePass)
+	conn.SelectDB(dbIndex)
+	return conn
+}
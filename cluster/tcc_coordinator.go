@@ -0,0 +1,241 @@
+package cluster
+
+import (
+	database2 "go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/lib/logger"
+	"go_redis/lib/slot"
+	"go_redis/lib/utils"
+	"go_redis/resp/reply"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 跨节点事务的协调者一侧，由接收客户端请求的节点担任，参与者见 tcc.go。
+// 逐个节点 PREPARE，任何一条失败时向所有节点发送 ROLLBACK；全部成功后逐个节点 COMMIT，
+// 某个节点 COMMIT 失败时同样向所有节点发送 ROLLBACK，已经提交的节点执行 undo log；
+// 所有节点都提交成功后发送 FORGET，参与者这时才释放 key 锁
+
+const (
+	txRetries      = 5                     // PREPARE 遇到 key 被锁住时整个事务重试的次数
+	txRetryBackoff = 20 * time.Millisecond // 第 n 次重试前随机等待 [0, n*txRetryBackoff)
+)
+
+type txCoordinator struct {
+	cluster *ClusterDatabase
+	c       resp.Connection
+	id      string
+	peers   []string       // 按第一次 PREPARE 的顺序
+	counts  map[string]int // 每个节点 PREPARE 的命令条数
+	results map[string][]resp.Reply
+}
+
+func (cluster *ClusterDatabase) newTxCoordinator(c resp.Connection) *txCoordinator {
+	id := cluster.topology.self.ID + "-" + strconv.FormatInt(cluster.txCounter.Add(1), 10)
+	return &txCoordinator{cluster: cluster, c: c, id: id, counts: make(map[string]int)}
+}
+
+// call 向 peer 发送 PREPARE / COMMIT / ROLLBACK / FORGET，本节点直接处理
+func (tx *txCoordinator) call(peer string, args [][]byte) resp.Reply {
+	if peer == tx.cluster.self {
+		return tx.cluster.handleTCC(tx.c.GetDBIndex(), args)
+	}
+	return tx.cluster.relay(peer, tx.c, args)
+}
+
+// prepare 在 peer 上 PREPARE 一条命令，失败时回滚整个事务并返回错误
+func (tx *txCoordinator) prepare(peer string, cmdLine [][]byte) resp.Reply {
+	if _, ok := tx.counts[peer]; !ok {
+		tx.peers = append(tx.peers, peer)
+	}
+	tx.counts[peer]++
+	args := make([][]byte, 0, len(cmdLine)+2)
+	args = append(args, []byte("prepare"), []byte(tx.id))
+	args = append(args, cmdLine...)
+	r := tx.call(peer, args)
+	if reply.IsErrReply(r) {
+		tx.rollback()
+	}
+	return r
+}
+
+// commit 逐个节点提交，返回值为 nil 表示成功，各节点的结果在 tx.results 中
+func (tx *txCoordinator) commit() resp.Reply {
+	tx.results = make(map[string][]resp.Reply, len(tx.peers))
+	for _, peer := range tx.peers {
+		r := tx.call(peer, utils.ToCmdLine("commit", tx.id))
+		results, ok := replyElements(r)
+		if !ok || len(results) != tx.counts[peer] {
+			tx.rollback()
+			if reply.IsErrReply(r) {
+				return r
+			}
+			return reply.MakeErrReply("ERR transaction commit failed on " + peer)
+		}
+		tx.results[peer] = results
+	}
+	return nil
+}
+
+// commitAll 提交后任何一条命令出错时回滚整个事务
+func (tx *txCoordinator) commitAll() resp.Reply {
+	if errReply := tx.commit(); errReply != nil {
+		return errReply
+	}
+	for _, peer := range tx.peers {
+		for _, r := range tx.results[peer] {
+			if reply.IsErrReply(r) {
+				tx.rollback()
+				return r
+			}
+		}
+	}
+	tx.forget()
+	return nil
+}
+
+func (tx *txCoordinator) rollback() {
+	for _, peer := range tx.peers {
+		r := tx.call(peer, utils.ToCmdLine("rollback", tx.id))
+		if reply.IsErrReply(r) {
+			// 对方已经按超时保留了提交的结果，各节点的数据不再一致
+			logger.Error("rollback transaction " + tx.id + " on " + peer + " failed: " + replyErr(r).Error())
+		}
+	}
+}
+
+// forget 事务在所有节点上都已提交，通知参与者释放 key 锁并丢弃 undo log。
+// 失败时参与者在 txConfirmTimeout 后自行结束事务，同样保留提交的结果
+func (tx *txCoordinator) forget() {
+	for _, peer := range tx.peers {
+		r := tx.call(peer, utils.ToCmdLine("forget", tx.id))
+		if reply.IsErrReply(r) {
+			logger.Warn("forget transaction " + tx.id + " on " + peer + " failed: " + replyErr(r).Error())
+		}
+	}
+}
+
+// replyElements 取出 COMMIT 返回的数组，转发得到的数组元素都是 bulk 字符串时解析为 MultiBulkReply
+func replyElements(r resp.Reply) ([]resp.Reply, bool) {
+	switch r := r.(type) {
+	case *reply.MultiRawReply:
+		return r.Replies, true
+	case *reply.MultiBulkReply:
+		results := make([]resp.Reply, len(r.Args))
+		for i, arg := range r.Args {
+			if arg == nil {
+				results[i] = reply.MakeNullBulkReply()
+			} else {
+				results[i] = reply.MakeBulkReply(arg)
+			}
+		}
+		return results, true
+	case *reply.EmptyMutiBulkReply:
+		return nil, true
+	}
+	return nil, false
+}
+
+// retryOnConflict 事务之间争用 key 时随机等待后重试，PREPARE 失败时还没有提交任何命令
+func retryOnConflict(run func() resp.Reply) resp.Reply {
+	r := run()
+	for i := 1; i <= txRetries; i++ {
+		errReply, ok := r.(reply.ErrorReply)
+		if !ok || errReply.Error() != errKeysLocked {
+			return r
+		}
+		time.Sleep(time.Duration(rand.Int63n(int64(i * int(txRetryBackoff)))))
+		r = run()
+	}
+	return r
+}
+
+// execTransaction 以一个跨节点事务执行 cmdLines，返回每条命令的结果。
+// 每条命令的 key 必须由同一个节点负责，各节点上的命令按原来的顺序执行
+func (cluster *ClusterDatabase) execTransaction(c resp.Connection, cmdLines [][][]byte) resp.Reply {
+	if !cluster.stateOK.Load() {
+		return reply.MakeErrReply("CLUSTERDOWN The cluster is down")
+	}
+	owners := make([]string, len(cmdLines))
+	for i, line := range cmdLines {
+		var owner *clusterNode
+		for j, key := range database2.CommandKeys(line) {
+			node, _, _ := cluster.topology.route(slot.KeySlot(string(key)))
+			if node == nil {
+				return reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+			}
+			if j > 0 && node != owner {
+				return reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+			}
+			owner = node
+		}
+		if owner == nil {
+			return reply.MakeErrReply("ERR Command without key is not allowed in cluster transaction")
+		}
+		owners[i] = owner.Addr
+	}
+	return retryOnConflict(func() resp.Reply {
+		tx := cluster.newTxCoordinator(c)
+		for i, line := range cmdLines {
+			if r := tx.prepare(owners[i], line); reply.IsErrReply(r) {
+				return r
+			}
+		}
+		if errReply := tx.commit(); errReply != nil {
+			return errReply
+		}
+		// 命令出错不回滚其他命令，与 redis 的 EXEC 相同
+		tx.forget()
+		results := make([]resp.Reply, len(cmdLines))
+		next := make(map[string]int)
+		for i, peer := range owners {
+			results[i] = tx.results[peer][next[peer]]
+			next[peer]++
+		}
+		return reply.MakeMultiRawReply(results)
+	})
+}
+
+// renameAcrossNodes 两个 key 由不同的节点负责：在源节点 PREPARE DEL 锁住源 key 后读出它的值，
+// 再在目标节点 PREPARE RESTORE 锁住目标 key，两边一起提交
+func (cluster *ClusterDatabase) renameAcrossNodes(c resp.Connection, cmdArgs [][]byte, srcPeer, dstPeer string) resp.Reply {
+	return retryOnConflict(func() resp.Reply {
+		return cluster.renameTx(c, cmdArgs, srcPeer, dstPeer)
+	})
+}
+
+func (cluster *ClusterDatabase) renameTx(c resp.Connection, cmdArgs [][]byte, srcPeer, dstPeer string) resp.Reply {
+	nx := strings.EqualFold(string(cmdArgs[0]), "renamenx")
+	src, dst := cmdArgs[1], cmdArgs[2]
+	tx := cluster.newTxCoordinator(c)
+	if r := tx.prepare(srcPeer, utils.ToCmdLine3("del", src)); reply.IsErrReply(r) {
+		return r
+	}
+	// 源 key 已经锁住，读出的值在提交前不会变化
+	dump, ok := cluster.relay(srcPeer, c, utils.ToCmdLine3("dump", src)).(*reply.BulkReply)
+	ttl, ok2 := cluster.relay(srcPeer, c, utils.ToCmdLine3("pttl", src)).(*reply.IntReply)
+	if !ok || !ok2 || ttl.Code == -2 {
+		tx.rollback()
+		return reply.MakeErrReply("no such key") // 与单机的 RENAME 相同
+	}
+	ttlArg := []byte(strconv.FormatInt(max(ttl.Code, 0), 10))
+	if r := tx.prepare(dstPeer, utils.ToCmdLine3("restore", dst, ttlArg, dump.Arg, []byte("REPLACE"))); reply.IsErrReply(r) {
+		return r
+	}
+	if nx {
+		exists, ok := cluster.relay(dstPeer, c, utils.ToCmdLine3("exists", dst)).(*reply.IntReply)
+		if !ok || exists.Code != 0 {
+			tx.rollback()
+			return reply.MakeIntReply(0)
+		}
+	}
+	if errReply := tx.commitAll(); errReply != nil {
+		return errReply
+	}
+	if nx {
+		return reply.MakeIntReply(1)
+	}
+	return reply.MakeOkReply()
+}
@@ -0,0 +1,301 @@
+package cluster
+
+import (
+	database2 "go_redis/database"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/resp/connection"
+	"go_redis/resp/reply"
+	"testing"
+	"time"
+)
+
+// makeTestCluster 只有本节点、负责全部槽位的集群，用于测试参与者一侧的事务处理
+func makeTestCluster() *ClusterDatabase {
+	self := "127.0.0.1:7000"
+	cluster := &ClusterDatabase{
+		self: self,
+		db:   database2.NewStandaloneDatabase(),
+		participant: txParticipant{
+			transactions: make(map[string]*transaction),
+			owners:       make(map[string]*keyLockOwner),
+			finished:     make(map[string]int),
+		},
+		txLocks: makeKeyLocks(),
+	}
+	cluster.topology = makeTopology(self, []string{self}, nil)
+	for s := range cluster.topology.slots {
+		cluster.topology.slots[s] = cluster.topology.self
+	}
+	cluster.topology.self.peerToken = newPeerToken()
+	return cluster
+}
+
+func tcc(cluster *ClusterDatabase, args ...string) resp.Reply {
+	return cluster.handleTCC(0, utils.ToCmdLine(args...))
+}
+
+func getValue(cluster *ClusterDatabase, key string) (string, bool) {
+	conn := &connection.Connection{}
+	r, ok := cluster.db.Exec(conn, utils.ToCmdLine("get", key)).(*reply.BulkReply)
+	if !ok {
+		return "", false
+	}
+	return string(r.Arg), true
+}
+
+func setValue(cluster *ClusterDatabase, key, value string) {
+	cluster.db.Exec(&connection.Connection{}, utils.ToCmdLine("set", key, value))
+}
+
+// locked key 是否被锁住
+func locked(cluster *ClusterDatabase, key string) bool {
+	unlock := cluster.txLocks.lock(0, []string{key}, false, 10*time.Millisecond)
+	if unlock == nil {
+		return true
+	}
+	unlock()
+	return false
+}
+
+// execAsync 在后台执行本节点的命令，返回接收结果的 channel
+func execAsync(cluster *ClusterDatabase, args ...string) <-chan resp.Reply {
+	done := make(chan resp.Reply, 1)
+	go func() {
+		done <- cluster.execLocal(&connection.Connection{}, utils.ToCmdLine(args...))
+	}()
+	return done
+}
+
+func mustBlock(t *testing.T, done <-chan resp.Reply) {
+	t.Helper()
+	select {
+	case r := <-done:
+		t.Fatalf("command is not blocked by the transaction: %s", r.ToBytes())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func mustFinish(t *testing.T, done <-chan resp.Reply) resp.Reply {
+	t.Helper()
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("command is still blocked")
+		return nil
+	}
+}
+
+func mustOK(t *testing.T, r resp.Reply) {
+	t.Helper()
+	if reply.IsErrReply(r) {
+		t.Fatalf("unexpected error %s", r.ToBytes())
+	}
+}
+
+func mustErr(t *testing.T, r resp.Reply) {
+	t.Helper()
+	if !reply.IsErrReply(r) {
+		t.Fatalf("expected error, got %s", r.ToBytes())
+	}
+}
+
+func TestTCCCommitForget(t *testing.T) {
+	cluster := makeTestCluster()
+	setValue(cluster, "b", "old")
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "a", "1"))
+	mustOK(t, tcc(cluster, "prepare", "tx1", "del", "b"))
+	if !locked(cluster, "a") || !locked(cluster, "b") {
+		t.Fatal("keys are not locked after prepare")
+	}
+	results, ok := replyElements(tcc(cluster, "commit", "tx1"))
+	if !ok || len(results) != 2 {
+		t.Fatalf("commit results %v", results)
+	}
+	if v, _ := getValue(cluster, "a"); v != "1" {
+		t.Fatalf("a = %q after commit", v)
+	}
+	// 提交后 key 锁保留到 FORGET
+	if !locked(cluster, "a") || !locked(cluster, "b") {
+		t.Fatal("keys are unlocked before forget")
+	}
+	mustOK(t, tcc(cluster, "forget", "tx1"))
+	if locked(cluster, "a") || locked(cluster, "b") {
+		t.Fatal("keys are still locked after forget")
+	}
+	mustOK(t, tcc(cluster, "forget", "tx1"))
+	mustErr(t, tcc(cluster, "rollback", "tx1"))
+	mustErr(t, tcc(cluster, "prepare", "tx1", "set", "c", "1"))
+	if v, _ := getValue(cluster, "a"); v != "1" {
+		t.Fatalf("a = %q after late rollback", v)
+	}
+}
+
+func TestTCCRollbackAfterCommit(t *testing.T) {
+	cluster := makeTestCluster()
+	setValue(cluster, "a", "old")
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "a", "new"))
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "b", "new"))
+	tcc(cluster, "commit", "tx1")
+	mustOK(t, tcc(cluster, "rollback", "tx1"))
+	if v, _ := getValue(cluster, "a"); v != "old" {
+		t.Fatalf("a = %q after rollback, want old", v)
+	}
+	if _, ok := getValue(cluster, "b"); ok {
+		t.Fatal("b exists after rollback")
+	}
+	if locked(cluster, "a") || locked(cluster, "b") {
+		t.Fatal("keys are still locked after rollback")
+	}
+	mustOK(t, tcc(cluster, "rollback", "tx1"))
+	mustErr(t, tcc(cluster, "forget", "tx1"))
+}
+
+func TestTCCRollbackPrepared(t *testing.T) {
+	cluster := makeTestCluster()
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "a", "1"))
+	mustOK(t, tcc(cluster, "rollback", "tx1"))
+	if locked(cluster, "a") {
+		t.Fatal("key is still locked after rollback")
+	}
+	if _, ok := getValue(cluster, "a"); ok {
+		t.Fatal("rolled back command was executed")
+	}
+	mustErr(t, tcc(cluster, "commit", "tx1"))
+	// 没有在本节点 PREPARE 过的事务视为已经回滚
+	mustOK(t, tcc(cluster, "rollback", "unknown"))
+	mustErr(t, tcc(cluster, "commit", "unknown"))
+}
+
+func TestTCCLockConflict(t *testing.T) {
+	cluster := makeTestCluster()
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "a", "1"))
+	r := tcc(cluster, "prepare", "tx2", "mset", "b", "2", "a", "2")
+	if errReply, ok := r.(reply.ErrorReply); !ok || errReply.Error() != errKeysLocked {
+		t.Fatalf("prepare locked key: %s", r.ToBytes())
+	}
+	// 加锁失败时一个 key 都不锁
+	if locked(cluster, "b") {
+		t.Fatal("b is locked by a failed prepare")
+	}
+	// PREPARE 失败后由协调者回滚
+	mustOK(t, tcc(cluster, "rollback", "tx2"))
+	tcc(cluster, "commit", "tx1")
+	mustOK(t, tcc(cluster, "forget", "tx1"))
+	mustOK(t, tcc(cluster, "prepare", "tx3", "set", "a", "3"))
+}
+
+func TestTCCExpire(t *testing.T) {
+	cluster := makeTestCluster()
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "a", "1"))
+	cluster.expireTx("tx1")
+	if locked(cluster, "a") {
+		t.Fatal("key is still locked after prepared transaction expired")
+	}
+	mustErr(t, tcc(cluster, "commit", "tx1"))
+	mustOK(t, tcc(cluster, "rollback", "tx1"))
+
+	setValue(cluster, "b", "old")
+	mustOK(t, tcc(cluster, "prepare", "tx2", "set", "b", "new"))
+	tcc(cluster, "commit", "tx2")
+	cluster.expireTx("tx2")
+	if locked(cluster, "b") {
+		t.Fatal("key is still locked after committed transaction expired")
+	}
+	// 超时后保留提交的结果，迟到的 ROLLBACK 返回错误
+	mustErr(t, tcc(cluster, "rollback", "tx2"))
+	if v, _ := getValue(cluster, "b"); v != "new" {
+		t.Fatalf("b = %q after late rollback, want new", v)
+	}
+}
+
+func TestTCCRequiresPeer(t *testing.T) {
+	cluster := makeTestCluster()
+	c := &connection.Connection{}
+	prepare := utils.ToCmdLine("prepare", "tx1", "set", "a", "1")
+	mustErr(t, execTCC(cluster, c, prepare))
+	mustErr(t, execCluster(cluster, c, utils.ToCmdLine("cluster", "local")))
+	mustErr(t, execCluster(cluster, c, utils.ToCmdLine("cluster", "peerauth", "wrong")))
+	mustErr(t, execTCC(cluster, c, prepare))
+	if locked(cluster, "a") {
+		t.Fatal("client connection prepared a transaction")
+	}
+	mustOK(t, execCluster(cluster, c, utils.ToCmdLine("cluster", "peerauth", cluster.topology.self.peerToken)))
+	mustOK(t, execTCC(cluster, c, prepare))
+	mustOK(t, execTCC(cluster, c, utils.ToCmdLine("rollback", "tx1")))
+}
+
+func TestWriteHoldsKeyLock(t *testing.T) {
+	cluster := makeTestCluster()
+	// 写命令从检查到执行结束一直持有 key 锁，期间事务不能 PREPARE 这些 key
+	c := &connection.Connection{}
+	unlock := cluster.lockWrites(c, utils.ToCmdLine("mset", "a", "1", "b", "2"))
+	r := tcc(cluster, "prepare", "tx1", "set", "b", "3")
+	if errReply, ok := r.(reply.ErrorReply); !ok || errReply.Error() != errKeysLocked {
+		t.Fatalf("prepare key locked by a write: %s", r.ToBytes())
+	}
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "c", "3"))
+	unlock()
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "b", "3"))
+	mustOK(t, tcc(cluster, "rollback", "tx1"))
+	// 读命令不加锁
+	unlock = cluster.lockWrites(c, utils.ToCmdLine("get", "a"))
+	if locked(cluster, "a") {
+		t.Fatal("read command locked the key")
+	}
+	unlock()
+}
+
+func TestWriteWaitsForRollback(t *testing.T) {
+	cluster := makeTestCluster()
+	setValue(cluster, "a", "old")
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "a", "tx"))
+	tcc(cluster, "commit", "tx1")
+	done := execAsync(cluster, "set", "a", "client")
+	mustBlock(t, done)
+	// 回滚恢复事务之前的值，之后才执行等待中的写命令，回滚不会覆盖它
+	mustOK(t, tcc(cluster, "rollback", "tx1"))
+	mustOK(t, mustFinish(t, done))
+	if v, _ := getValue(cluster, "a"); v != "client" {
+		t.Fatalf("a = %q, want client", v)
+	}
+}
+
+func TestFlushWaitsForTransaction(t *testing.T) {
+	for _, cmd := range []string{"flushdb", "flushall"} {
+		cluster := makeTestCluster()
+		setValue(cluster, "a", "old")
+		mustOK(t, tcc(cluster, "prepare", "tx1", "set", "a", "tx"))
+		tcc(cluster, "commit", "tx1")
+		done := execAsync(cluster, cmd)
+		mustBlock(t, done)
+		mustOK(t, tcc(cluster, "rollback", "tx1"))
+		mustOK(t, mustFinish(t, done))
+		if _, ok := getValue(cluster, "a"); ok {
+			t.Fatalf("%s ran before rollback", cmd)
+		}
+		// 清空期间不能 PREPARE
+		unlock := cluster.lockWrites(&connection.Connection{}, utils.ToCmdLine(cmd))
+		mustErr(t, tcc(cluster, "prepare", "tx2", "set", "a", "1"))
+		unlock()
+		mustOK(t, tcc(cluster, "prepare", "tx2", "set", "a", "1"))
+	}
+}
+
+func TestWriteLockTimeout(t *testing.T) {
+	cluster := makeTestCluster()
+	mustOK(t, tcc(cluster, "prepare", "tx1", "set", "a", "1"))
+	if unlock := cluster.txLocks.lock(0, []string{"a"}, false, 10*time.Millisecond); unlock != nil {
+		t.Fatal("locked a key held by a transaction")
+	}
+	if unlock := cluster.txLocks.lock(allDBs, nil, true, 10*time.Millisecond); unlock != nil {
+		t.Fatal("locked all DBs while a transaction holds a key")
+	}
+	// 其他 DB 不受影响
+	unlock := cluster.txLocks.lock(1, nil, true, 10*time.Millisecond)
+	if unlock == nil {
+		t.Fatal("transaction in DB 0 blocks FLUSHDB in DB 1")
+	}
+	unlock()
+}
@@ -0,0 +1,409 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"go_redis/lib/slot"
+	"sort"
+	"sync"
+)
+
+// 节点状态标志
+const (
+	nodePFail     = 1 << iota // 本节点超过 cluster-node-timeout 没有收到该节点的 PONG，认为它可能下线
+	nodeFail                  // 多数主节点都认为该节点下线
+	nodeHandshake             // 刚加入，还没有收到过该节点的 PONG
+	nodeMeet                  // 下次连接时发送 MEET，让对方把本节点加入集群
+)
+
+// clusterNode 集群中的一个节点
+type clusterNode struct {
+	ID      string // 40 位十六进制，默认由地址计算得到
+	Addr    string // host:port
+	BusPort int    // 集群总线端口
+	Epoch   int64  // 配置纪元
+
+	// 以下字段由 topology.mu 保护
+	master      *clusterNode // 从节点的主节点，主节点为 nil
+	replOffset  int64        // 消息中带来的复制偏移量
+	votedTime   int64        // 本节点最近一次投票给该节点的从节点的时间，毫秒
+	flags       int
+	ctime       int64            // 加入的时间，毫秒
+	pingSent    int64            // 还没有收到 PONG 的 PING 的发送时间，毫秒，0 表示没有
+	pongRecv    int64            // 最近一次收到 PONG 的时间，毫秒
+	failTime    int64            // 被标记为 FAIL 的时间，毫秒
+	failReports map[string]int64 // 其他主节点报告该节点下线的时间，key 为报告者 ID
+	link        *busLink         // 本节点向该节点发起的总线连接
+	connecting  bool             // 正在建立总线连接
+	peerToken   string           // 连接该节点时用 CLUSTER PEERAUTH 出示的令牌，见 peer_auth.go
+}
+
+// slotRange 一段连续的槽位，包含 Start 和 End
+type slotRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// topology 集群的节点和槽位分配
+type topology struct {
+	mu    sync.RWMutex
+	self  *clusterNode
+	nodes []*clusterNode // 按地址排序
+	slots [slot.Count]*clusterNode
+
+	migrating [slot.Count]*clusterNode // 正在从本节点迁出的槽位及其目标节点
+	importing [slot.Count]*clusterNode // 正在迁入本节点的槽位及其来源节点
+
+	currentEpoch  int64
+	lastVoteEpoch int64            // 本节点最近一次投票的纪元，每个纪元只投一票
+	failover      failoverState    // 见 failover.go
+	forgotten     map[string]int64 // CLUSTER FORGET 的节点 ID 及到期时间，到期前忽略关于它的 gossip
+	dirty         bool             // 有变化还没有写入 nodes.conf
+}
+
+// nodeID 由地址生成节点 ID
+func nodeID(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+// newNode 创建节点，总线端口为服务端口加上固定偏移
+func newNode(id, addr string, now int64) *clusterNode {
+	_, port := splitAddr(addr)
+	return &clusterNode{
+		ID:          id,
+		Addr:        addr,
+		BusPort:     port + clusterBusPortOffset,
+		ctime:       now,
+		failReports: make(map[string]int64),
+	}
+}
+
+// makeTopology 按地址排序后把 16384 个槽位分成连续的几段依次分给各节点，每段的大小与节点权重成正比。
+// 所有节点使用相同的 peers、self 和权重配置时，算出的分配结果相同；只有自己一个节点时不分配槽位
+func makeTopology(self string, addrs []string, weights map[string]int) *topology {
+	sorted := make([]string, len(addrs))
+	copy(sorted, addrs)
+	sort.Strings(sorted)
+	t := &topology{
+		forgotten: make(map[string]int64),
+		failover:  failoverState{mfOffset: -1},
+	}
+	total := 0
+	for i, addr := range sorted {
+		node := newNode(nodeID(addr), addr, 0)
+		if len(sorted) > 1 {
+			node.Epoch = int64(i + 1)
+		}
+		if addr == self {
+			t.self = node
+		}
+		t.nodes = append(t.nodes, node)
+		total += weightOf(weights, addr)
+	}
+	if len(sorted) == 1 || total == 0 {
+		return t
+	}
+	t.currentEpoch = int64(len(sorted))
+	acc := 0
+	for _, node := range t.nodes {
+		start := slot.Count * acc / total
+		acc += weightOf(weights, node.Addr)
+		end := slot.Count * acc / total
+		for s := start; s < end; s++ {
+			t.slots[s] = node
+		}
+	}
+	return t
+}
+
+func weightOf(weights map[string]int, addr string) int {
+	if weight, ok := weights[addr]; ok {
+		return weight
+	}
+	return 1
+}
+
+// pickNode 返回 key 所在槽位的节点地址
+func (t *topology) pickNode(key string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node := t.slots[slot.KeySlot(key)]
+	if node == nil {
+		return ""
+	}
+	return node.Addr
+}
+
+// route 返回槽位的负责节点，以及槽位正在迁出时的目标节点、正在迁入时的来源节点
+func (t *topology) route(s int) (owner, migrating, importing *clusterNode) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slots[s], t.migrating[s], t.importing[s]
+}
+
+// slotRanges 返回每个节点负责的槽位区间，没有槽位的节点不出现在结果中
+func (t *topology) slotRanges() map[*clusterNode][]slotRange {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slotRangesLocked()
+}
+
+func (t *topology) slotRangesLocked() map[*clusterNode][]slotRange {
+	result := make(map[*clusterNode][]slotRange)
+	for s := 0; s < slot.Count; s++ {
+		node := t.slots[s]
+		if node == nil {
+			continue
+		}
+		ranges := result[node]
+		if len(ranges) > 0 && ranges[len(ranges)-1].End == s-1 {
+			ranges[len(ranges)-1].End = s
+		} else {
+			ranges = append(ranges, slotRange{Start: s, End: s})
+		}
+		result[node] = ranges
+	}
+	return result
+}
+
+// assignedSlots 已分配给节点的槽位个数
+func (t *topology) assignedSlots() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	count := 0
+	for _, node := range t.slots {
+		if node != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// nodeList 返回所有节点，包括自己
+func (t *topology) nodeList() []*clusterNode {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	nodes := make([]*clusterNode, len(t.nodes))
+	copy(nodes, t.nodes)
+	return nodes
+}
+
+// masterList 返回所有主节点，包括没有槽位的主节点
+func (t *topology) masterList() []*clusterNode {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	nodes := make([]*clusterNode, 0, len(t.nodes))
+	for _, node := range t.nodes {
+		if node.master == nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// slotOwners 返回负责至少一个槽位的节点
+func (t *topology) slotOwners() []*clusterNode {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	owned := make(map[*clusterNode]bool)
+	for _, node := range t.slots {
+		if node != nil {
+			owned[node] = true
+		}
+	}
+	owners := make([]*clusterNode, 0, len(owned))
+	for _, node := range t.nodes {
+		if owned[node] {
+			owners = append(owners, node)
+		}
+	}
+	return owners
+}
+
+// replicasLocked 返回 master 的从节点
+func (t *topology) replicasLocked(master *clusterNode) []*clusterNode {
+	var replicas []*clusterNode
+	for _, node := range t.nodes {
+		if node.master == master {
+			replicas = append(replicas, node)
+		}
+	}
+	return replicas
+}
+
+// unstableNode 返回一个正在握手或者可能下线的节点的地址，没有时返回空字符串
+func (t *topology) unstableNode() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, node := range t.nodes {
+		if node.flags&(nodeHandshake|nodePFail|nodeFail) != 0 {
+			return node.Addr
+		}
+	}
+	return ""
+}
+
+// nodeByID 按 ID 查找节点，不存在时返回 nil
+func (t *topology) nodeByID(id string) *clusterNode {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nodeByIDLocked(id)
+}
+
+func (t *topology) nodeByIDLocked(id string) *clusterNode {
+	for _, node := range t.nodes {
+		if node.ID == id {
+			return node
+		}
+	}
+	return nil
+}
+
+func (t *topology) nodeByAddrLocked(addr string) *clusterNode {
+	for _, node := range t.nodes {
+		if node.Addr == addr {
+			return node
+		}
+	}
+	return nil
+}
+
+// addNodeLocked 加入一个节点，保持按地址排序
+func (t *topology) addNodeLocked(node *clusterNode) {
+	idx := sort.Search(len(t.nodes), func(i int) bool { return t.nodes[i].Addr >= node.Addr })
+	t.nodes = append(t.nodes, nil)
+	copy(t.nodes[idx+1:], t.nodes[idx:])
+	t.nodes[idx] = node
+	t.dirty = true
+}
+
+// sortNodes 按地址排序
+func (t *topology) sortNodes() {
+	sort.Slice(t.nodes, func(i, j int) bool { return t.nodes[i].Addr < t.nodes[j].Addr })
+}
+
+// removeNodeLocked 删除一个节点，它负责的槽位变为未分配，它提交的下线报告作废
+func (t *topology) removeNodeLocked(node *clusterNode) {
+	for i, n := range t.nodes {
+		if n == node {
+			t.nodes = append(t.nodes[:i], t.nodes[i+1:]...)
+			break
+		}
+	}
+	for s := 0; s < slot.Count; s++ {
+		if t.slots[s] == node {
+			t.slots[s] = nil
+		}
+		if t.migrating[s] == node {
+			t.migrating[s] = nil
+		}
+		if t.importing[s] == node {
+			t.importing[s] = nil
+		}
+	}
+	for _, n := range t.nodes {
+		delete(n.failReports, node.ID)
+		if n.master == node {
+			n.master = nil
+		}
+	}
+	if node.link != nil {
+		node.link.close()
+		node.link = nil
+	}
+	t.dirty = true
+}
+
+// slotCountLocked 节点负责的槽位个数
+func (t *topology) slotCountLocked(node *clusterNode) int {
+	count := 0
+	for _, n := range t.slots {
+		if n == node {
+			count++
+		}
+	}
+	return count
+}
+
+// sizeLocked 负责至少一个槽位的主节点个数，判断 FAIL 和集群状态时以它计算多数
+func (t *topology) sizeLocked() int {
+	owners := make(map[*clusterNode]struct{})
+	for _, n := range t.slots {
+		if n != nil {
+			owners[n] = struct{}{}
+		}
+	}
+	return len(owners)
+}
+
+// setMigrating 标记槽位正在迁出到 target
+func (t *topology) setMigrating(s int, target *clusterNode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.migrating[s] = target
+	t.dirty = true
+}
+
+// setImporting 标记槽位正在从 source 迁入
+func (t *topology) setImporting(s int, source *clusterNode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.importing[s] = source
+	t.dirty = true
+}
+
+// setStable 清除槽位的迁入、迁出状态
+func (t *topology) setStable(s int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.migrating[s] = nil
+	t.importing[s] = nil
+	t.dirty = true
+}
+
+// setOwner 把槽位分配给 node。分配给其他节点时结束迁出；
+// 迁入的槽位分配给自己时结束迁入，并提升自己的配置纪元，让其他节点通过 gossip 接受新的归属
+func (t *topology) setOwner(s int, node *clusterNode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.slots[s] = node
+	t.dirty = true
+	if node != t.self {
+		t.migrating[s] = nil
+		return
+	}
+	if t.importing[s] != nil {
+		t.importing[s] = nil
+		t.bumpEpochLocked()
+	}
+}
+
+// bumpEpochLocked 自己的配置纪元不是主节点中唯一最大时，取 currentEpoch + 1，与 redis 相同不需要其他节点同意
+func (t *topology) bumpEpochLocked() {
+	unique := t.self.Epoch > 0
+	for _, n := range t.nodes {
+		if n != t.self && n.master == nil && n.Epoch >= t.self.Epoch {
+			unique = false
+		}
+	}
+	if unique {
+		return
+	}
+	t.currentEpoch++
+	t.self.Epoch = t.currentEpoch
+	t.dirty = true
+}
+
+// ownedSlots 返回每个节点负责的槽位，按槽位从小到大排列
+func (t *topology) ownedSlots() map[*clusterNode][]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	result := make(map[*clusterNode][]int)
+	for s, node := range t.slots {
+		if node != nil {
+			result[node] = append(result[node], s)
+		}
+	}
+	return result
+}
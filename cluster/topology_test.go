@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"go_redis/lib/slot"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func testAddrs(n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = "127.0.0.1:" + strconv.Itoa(7000+i)
+	}
+	return addrs
+}
+
+// keysPerNode 把 n 个 key 按槽位分配到节点上，返回每个节点的 key 数
+func keysPerNode(t *topology, n int) map[string]int {
+	counts := make(map[string]int)
+	for _, node := range t.nodeList() {
+		counts[node.Addr] = 0
+	}
+	for i := 0; i < n; i++ {
+		counts[t.pickNode("key:"+strconv.Itoa(i))]++
+	}
+	return counts
+}
+
+func TestTopologySlots(t *testing.T) {
+	addrs := testAddrs(3)
+	topo := makeTopology(addrs[0], []string{addrs[2], addrs[0], addrs[1]}, nil)
+	if topo.self == nil || topo.self.Addr != addrs[0] {
+		t.Fatal("self node not found")
+	}
+	if topo.assignedSlots() != slot.Count {
+		t.Fatalf("assigned %d slots, want %d", topo.assignedSlots(), slot.Count)
+	}
+	// 节点按地址排序后依次分到一段连续的槽位
+	if topo.slots[0].Addr != addrs[0] || topo.slots[slot.Count-1].Addr != addrs[2] {
+		t.Fatalf("slot 0 on %s, slot %d on %s", topo.slots[0].Addr, slot.Count-1, topo.slots[slot.Count-1].Addr)
+	}
+	other := makeTopology(addrs[1], addrs, nil)
+	for s := 0; s < slot.Count; s++ {
+		if topo.slots[s].Addr != other.slots[s].Addr {
+			t.Fatalf("slot %d: nodes computed different owners", s)
+		}
+	}
+	if topo.pickNode("{user1000}.following") != topo.pickNode("{user1000}.followers") {
+		t.Error("keys with the same hash tag are on different nodes")
+	}
+}
+
+func TestTopologyDistribution(t *testing.T) {
+	const keys = 200000
+	for _, n := range []int{2, 3, 4, 8} {
+		counts := keysPerNode(makeTopology("", testAddrs(n), nil), keys)
+		mean := float64(keys) / float64(n)
+		var variance float64
+		for _, c := range counts {
+			variance += (float64(c) - mean) * (float64(c) - mean)
+		}
+		stddev := math.Sqrt(variance/float64(n)) / mean
+		t.Logf("nodes=%d stddev=%.2f%%", n, stddev*100)
+		if stddev > 0.02 {
+			t.Errorf("%d nodes: stddev of keys per node %.2f%% is too high", n, stddev*100)
+		}
+	}
+}
+
+func TestTopologyWeights(t *testing.T) {
+	addrs := testAddrs(3)
+	weights := map[string]int{addrs[2]: 2, addrs[1]: 0}
+	topo := makeTopology(addrs[0], addrs, weights)
+	counts := make(map[string]int)
+	for _, node := range topo.slots {
+		counts[node.Addr]++
+	}
+	if counts[addrs[1]] != 0 {
+		t.Errorf("weight 0 node got %d slots", counts[addrs[1]])
+	}
+	if counts[addrs[0]]+counts[addrs[2]] != slot.Count || counts[addrs[2]] < 2*counts[addrs[0]]-1 {
+		t.Errorf("slots per node: %v", counts)
+	}
+}
@@ -2,12 +2,15 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"go_redis/lib/logger"
 	"io"
+	"net"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // ServerProperties defines global config properties
@@ -16,42 +19,200 @@ type ServerProperties struct {
 	Port           int    `cfg:"port"`
 	AppendOnly     bool   `cfg:"appendOnly"`
 	AppendFilename string `cfg:"appendFilename"`
+	AppendFsync    string `cfg:"appendfsync"`
 	MaxClients     int    `cfg:"maxclients"`
 	RequirePass    string `cfg:"requirepass"`
 	Databases      int    `cfg:"databases"`
+	Timeout        int    `cfg:"timeout"`   // 客户端空闲超时秒数，0 表示不超时
+	MaxMemory      int    `cfg:"maxmemory"` // 内存上限字节数，0 表示不限制
 
-	Peers []string `cfg:"peers"`
-	Self  string   `cfg:"self"`
+	MaxMemoryPolicy  string `cfg:"maxmemory-policy"`
+	MaxMemorySamples int    `cfg:"maxmemory-samples"` // 每次淘汰时每个 DB 采样的 key 数量
+	LfuLogFactor     int    `cfg:"lfu-log-factor"`
+	LfuDecayTime     int    `cfg:"lfu-decay-time"` // 分钟
+
+	SlowlogLogSlowerThan int `cfg:"slowlog-log-slower-than"` // 微秒，负数表示关闭慢日志
+	SlowlogMaxLen        int `cfg:"slowlog-max-len"`
+
+	ProtoMaxBulkLen         int    `cfg:"proto-max-bulk-len"`         // 请求中单个 bulk 字符串的最大字节数
+	ProtoMaxMultiBulkLen    int    `cfg:"proto-max-multibulk-len"`    // 请求中数组的最大元素个数
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"` // <class> <hard> <soft> <soft seconds> ...
+
+	UnixSocket     string `cfg:"unixsocket"`     // unix socket 路径，为空表示不监听
+	UnixSocketPerm string `cfg:"unixsocketperm"` // socket 文件权限，八进制，如 700
+
+	TlsPort        int    `cfg:"tls-port"` // 0 表示不启用 TLS 监听
+	TlsCertFile    string `cfg:"tls-cert-file"`
+	TlsKeyFile     string `cfg:"tls-key-file"`
+	TlsCaCertFile  string `cfg:"tls-ca-cert-file"`
+	TlsAuthClients string `cfg:"tls-auth-clients"` // yes / no / optional，是否要求客户端证书
+	TlsCluster     bool   `cfg:"tls-cluster"`      // 集群节点之间是否使用 TLS 连接
+	TlsReplication bool   `cfg:"tls-replication"`  // 从节点是否使用 TLS 连接主节点
+
+	ReplicaOf             string `cfg:"replicaof"` // <masterip> <masterport>，启动时成为该主节点的从节点
+	MasterAuth            string `cfg:"masterauth"`
+	ReplicaReadOnly       bool   `cfg:"replica-read-only"`
+	ReplBacklogSize       int    `cfg:"repl-backlog-size"`
+	ReplTimeout           int    `cfg:"repl-timeout"`             // 秒，主从连接超过该时间没有数据时断开
+	ReplPingReplicaPeriod int    `cfg:"repl-ping-replica-period"` // 秒，主节点向从节点发送 PING 的间隔
+
+	Peers              []string `cfg:"peers"`
+	Self               string   `cfg:"self"`
+	ClusterNodeWeights []string `cfg:"cluster-node-weights"` // 分配槽位时的节点权重，如 127.0.0.1:8888=2，未列出的节点权重为 1，权重为 0 的节点不分配槽位
+	ClusterRouting     string   `cfg:"cluster-routing"`      // proxy：转发到负责的节点；redirect：返回 MOVED / ASK
+	ClusterEnabled     bool     `cfg:"cluster-enabled"`      // 没有配置 peers 时也以集群模式启动，之后用 CLUSTER MEET 加入集群
+	ClusterConfigFile  string   `cfg:"cluster-config-file"`  // 保存集群节点和槽位分配的文件
+	ClusterNodeTimeout int      `cfg:"cluster-node-timeout"` // 毫秒，节点超过该时间没有回复 PONG 时认为可能下线
+
+	// 节点之间转发命令的连接池，每个节点一个
+	ClusterPoolMaxTotal    int `cfg:"cluster-pool-max-total"`    // 每个节点最多的连接数，负数表示不限制
+	ClusterPoolMaxIdle     int `cfg:"cluster-pool-max-idle"`     // 每个节点最多保留的空闲连接数
+	ClusterPoolMinIdle     int `cfg:"cluster-pool-min-idle"`     // 每个节点至少保留的空闲连接数
+	ClusterPoolIdleTimeout int `cfg:"cluster-pool-idle-timeout"` // 秒，空闲超过该时间的连接被关闭，0 表示不关闭
+	ClusterPoolTimeout     int `cfg:"cluster-pool-timeout"`      // 毫秒，等待空闲连接、建立连接以及 PING 检查连接的最长时间
+}
+
+// properties 当前生效的配置。发布后不再修改，CONFIG SET 和重新加载时修改一份副本后整体替换，
+// 读取配置不需要加锁
+var properties atomic.Pointer[ServerProperties]
+
+// Properties returns the current config snapshot, callers must not modify it
+func Properties() *ServerProperties {
+	return properties.Load()
+}
+
+// SetProperties replaces the current config
+func SetProperties(props *ServerProperties) {
+	properties.Store(props)
+}
+
+// ClusterEnabled 配置了 cluster-enabled，或者配置了 self 和 peers 时以集群模式启动
+func ClusterEnabled() bool {
+	return Properties().ClusterEnabled || (Properties().Self != "" && len(Properties().Peers) > 0)
+}
+
+// ClusterSelf 本节点在集群中的地址，没有配置 self 时使用监听地址和端口（tls-cluster 时为 TLS 端口）
+func ClusterSelf() string {
+	if Properties().Self != "" {
+		return Properties().Self
+	}
+	host := Properties().Bind
+	if host == "" || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+	port := Properties().Port
+	if Properties().TlsCluster {
+		port = Properties().TlsPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
 
-// Properties holds global config properties
-var Properties *ServerProperties
+// ConfigFile is the path of the loaded config file, empty if started without one
+var ConfigFile string
 
 func init() {
 	// default config
-	Properties = &ServerProperties{
-		Bind:       "127.0.0.1",
-		Port:       6379,
-		AppendOnly: false,
+	SetProperties(NewServerProperties())
+}
+
+// NewServerProperties returns properties filled with default values
+func NewServerProperties() *ServerProperties {
+	return &ServerProperties{
+		Bind:                 "127.0.0.1",
+		Port:                 6379,
+		AppendOnly:           false,
+		AppendFsync:          FsyncAlways,
+		Databases:            16,
+		MaxMemoryPolicy:      PolicyNoEviction,
+		MaxMemorySamples:     5,
+		LfuLogFactor:         10,
+		LfuDecayTime:         1,
+		SlowlogLogSlowerThan: 10000,
+		SlowlogMaxLen:        128,
+
+		ProtoMaxBulkLen:         512 * 1024 * 1024,
+		ProtoMaxMultiBulkLen:    1024 * 1024,
+		ClientOutputBufferLimit: defaultClientOutputBufferLimit,
+
+		TlsAuthClients: TlsAuthClientsYes,
+
+		ReplicaReadOnly:       true,
+		ReplBacklogSize:       1024 * 1024,
+		ReplTimeout:           60,
+		ReplPingReplicaPeriod: 10,
+
+		ClusterRouting:     ClusterRoutingProxy,
+		ClusterConfigFile:  "nodes.conf",
+		ClusterNodeTimeout: 15000,
+
+		ClusterPoolMaxTotal:    16,
+		ClusterPoolMaxIdle:     8,
+		ClusterPoolIdleTimeout: 300,
+		ClusterPoolTimeout:     1000,
+	}
+}
+
+// appendfsync 可选的刷盘策略
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+// maxmemory-policy 可选的淘汰策略
+const (
+	PolicyNoEviction     = "noeviction"
+	PolicyAllKeysLRU     = "allkeys-lru"
+	PolicyAllKeysLFU     = "allkeys-lfu"
+	PolicyAllKeysRandom  = "allkeys-random"
+	PolicyVolatileLRU    = "volatile-lru"
+	PolicyVolatileLFU    = "volatile-lfu"
+	PolicyVolatileRandom = "volatile-random"
+	PolicyVolatileTTL    = "volatile-ttl"
+)
+
+// cluster-routing 可选的集群路由方式
+const (
+	ClusterRoutingProxy    = "proxy"
+	ClusterRoutingRedirect = "redirect"
+)
+
+// multiLineKeys 可以在配置文件中出现多次的配置项，多行的值按空格拼接，值为每一行的参数个数
+var multiLineKeys = map[string]int{
+	"client-output-buffer-limit": 4,
+}
+
+// keyAliases 兼容 redis 的旧配置名
+var keyAliases = map[string]string{
+	"slaveof":                "replicaof",
+	"slave-read-only":        "replica-read-only",
+	"repl-ping-slave-period": "repl-ping-replica-period",
+}
+
+// canonicalKey 把旧配置名转换为当前的名称
+func canonicalKey(key string) string {
+	if alias, ok := keyAliases[key]; ok {
+		return alias
 	}
+	return key
 }
 
-func parse(src io.Reader) *ServerProperties {
-	config := &ServerProperties{}
+// parse 解析配置文件，同时返回文件中出现的配置项及其原始值
+func parse(src io.Reader) (*ServerProperties, map[string]string) {
+	config := NewServerProperties()
 
 	// read config file
 	rawMap := make(map[string]string)
 	scanner := bufio.NewScanner(src)
 	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) > 0 && line[0] == '#' {
+		key, value, ok := parseLine(scanner.Text())
+		if !ok {
 			continue
 		}
-		pivot := strings.IndexAny(line, " ")
-		if pivot > 0 && pivot < len(line)-1 { // separator found
-			key := line[0:pivot]
-			value := strings.Trim(line[pivot+1:], " ")
-			rawMap[strings.ToLower(key)] = value
+		if old, exists := rawMap[key]; exists && multiLineKeys[key] > 0 {
+			rawMap[key] = old + " " + value
+		} else {
+			rawMap[key] = value
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -65,33 +226,111 @@ func parse(src io.Reader) *ServerProperties {
 	for i := 0; i < n; i++ {
 		field := t.Elem().Field(i)
 		fieldVal := v.Elem().Field(i)
-		key, ok := field.Tag.Lookup("cfg")
-		if !ok {
-			key = field.Name
-		}
-		value, ok := rawMap[strings.ToLower(key)]
+		value, ok := rawMap[fieldKey(field)]
 		if ok {
-			// fill config
-			switch field.Type.Kind() {
-			case reflect.String:
-				fieldVal.SetString(value)
-			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					fieldVal.SetInt(intValue)
-				}
-			case reflect.Bool:
-				boolValue := "yes" == value
-				fieldVal.SetBool(boolValue)
-			case reflect.Slice:
-				if field.Type.Elem().Kind() == reflect.String {
-					slice := strings.Split(value, ",")
-					fieldVal.Set(reflect.ValueOf(slice))
-				}
+			// fill config, 格式错误的值保持零值
+			_ = setField(fieldVal, value)
+		}
+	}
+	if config.Databases <= 0 {
+		config.Databases = 16 // 默认16个数据库
+	}
+	return config, rawMap
+}
+
+// parseLine 解析配置文件中的一行，返回小写的 key 和 value
+func parseLine(line string) (string, string, bool) {
+	if len(line) > 0 && line[0] == '#' {
+		return "", "", false
+	}
+	pivot := strings.IndexAny(line, " ")
+	if pivot > 0 && pivot < len(line)-1 { // separator found
+		key := line[0:pivot]
+		value := strings.Trim(line[pivot+1:], " ")
+		return canonicalKey(strings.ToLower(key)), value, true
+	}
+	return "", "", false
+}
+
+// fieldKey 返回字段在配置文件中的 key
+func fieldKey(field reflect.StructField) string {
+	key, ok := field.Tag.Lookup("cfg")
+	if !ok {
+		key = field.Name
+	}
+	return strings.ToLower(key)
+}
+
+// setField 按字段类型把字符串写入字段
+func setField(fieldVal reflect.Value, value string) error {
+	switch fieldVal.Kind() {
+	case reflect.String:
+		fieldVal.SetString(value)
+	case reflect.Int:
+		intValue, err := parseInt(value)
+		if err != nil {
+			return err
+		}
+		fieldVal.SetInt(intValue)
+	case reflect.Bool:
+		value = strings.ToLower(value)
+		if value != "yes" && value != "no" {
+			return errors.New("argument must be 'yes' or 'no'")
+		}
+		fieldVal.SetBool("yes" == value)
+	case reflect.Slice:
+		if fieldVal.Type().Elem().Kind() == reflect.String {
+			slice := strings.Split(value, ",")
+			fieldVal.Set(reflect.ValueOf(slice))
+		}
+	}
+	return nil
+}
+
+// formatField 把字段值格式化为配置文件中的写法
+func formatField(fieldVal reflect.Value) string {
+	switch fieldVal.Kind() {
+	case reflect.String:
+		return fieldVal.String()
+	case reflect.Int:
+		return strconv.FormatInt(fieldVal.Int(), 10)
+	case reflect.Bool:
+		if fieldVal.Bool() {
+			return "yes"
+		}
+		return "no"
+	case reflect.Slice:
+		if fieldVal.Type().Elem().Kind() == reflect.String {
+			return strings.Join(fieldVal.Interface().([]string), ",")
+		}
+	}
+	return ""
+}
+
+// parseInt 解析整数，支持 kb/mb/gb 这样的内存单位
+func parseInt(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			num, err := strconv.ParseInt(lower[:len(lower)-len(unit.suffix)], 10, 64)
+			if err != nil {
+				return 0, errors.New("argument couldn't be parsed into an integer")
 			}
+			return num * unit.mul, nil
 		}
 	}
-	return config
+	num, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	return num, nil
 }
 
 // SetupConfig read config file and store properties into Properties
@@ -101,5 +340,8 @@ func SetupConfig(configFilename string) {
 		panic(err)
 	}
 	defer file.Close()
-	Properties = parse(file)
+	props, values := parse(file)
+	SetProperties(props)
+	ConfigFile = configFilename
+	fileValues = values
 }
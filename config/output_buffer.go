@@ -0,0 +1,89 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// client-output-buffer-limit <class> <hard limit> <soft limit> <soft seconds> [<class> ...]
+// 超过 hard limit，或者持续 soft seconds 秒超过 soft limit 的客户端会被断开，0 表示不限制
+
+// 客户端类别
+const (
+	ClientClassNormal  = "normal"
+	ClientClassReplica = "replica"
+	ClientClassPubSub  = "pubsub"
+)
+
+const defaultClientOutputBufferLimit = "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60"
+
+// OutputBufferLimit 一类客户端的输出缓冲区限制
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int64
+}
+
+type parsedLimits struct {
+	raw    string
+	limits map[string]OutputBufferLimit
+}
+
+// limitsCache 缓存解析结果，配置变化后第一次查询时重新解析
+var limitsCache atomic.Pointer[parsedLimits]
+
+// parseOutputBufferLimits 解析配置值，未出现的类别使用默认值
+func parseOutputBufferLimits(value string) (map[string]OutputBufferLimit, error) {
+	limits := make(map[string]OutputBufferLimit)
+	if value != defaultClientOutputBufferLimit {
+		defaults, _ := parseOutputBufferLimits(defaultClientOutputBufferLimit)
+		for class, limit := range defaults {
+			limits[class] = limit
+		}
+	}
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = ClientClassReplica
+		}
+		if class != ClientClassNormal && class != ClientClassReplica && class != ClientClassPubSub {
+			return nil, errors.New("invalid client class '" + fields[i] + "'")
+		}
+		hard, err := parseInt(fields[i+1])
+		if err != nil || hard < 0 {
+			return nil, errors.New("invalid hard limit '" + fields[i+1] + "'")
+		}
+		soft, err := parseInt(fields[i+2])
+		if err != nil || soft < 0 {
+			return nil, errors.New("invalid soft limit '" + fields[i+2] + "'")
+		}
+		seconds, err := strconv.ParseInt(fields[i+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return nil, errors.New("invalid soft seconds '" + fields[i+3] + "'")
+		}
+		limits[class] = OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}
+	}
+	return limits, nil
+}
+
+// GetOutputBufferLimit 返回某一类客户端当前的输出缓冲区限制
+func GetOutputBufferLimit(class string) OutputBufferLimit {
+	raw := Properties().ClientOutputBufferLimit
+	cached := limitsCache.Load()
+	if cached == nil || cached.raw != raw {
+		limits, err := parseOutputBufferLimits(raw)
+		if err != nil {
+			// 配置文件中的非法值按默认值处理
+			limits, _ = parseOutputBufferLimits(defaultClientOutputBufferLimit)
+		}
+		cached = &parsedLimits{raw: raw, limits: limits}
+		limitsCache.Store(cached)
+	}
+	return cached.limits[class]
+}
@@ -0,0 +1,331 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"go_redis/lib/wildcard"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 运行时配置管理：CONFIG GET / SET / REWRITE 以及 SIGHUP 时的重新加载
+
+var mu sync.Mutex // 串行化运行时对配置的修改和 REWRITE，读取配置不需要加锁
+
+// mutableKeys 可以通过 CONFIG SET 在运行时修改的配置项
+var mutableKeys = map[string]bool{
+	"requirepass": true,
+	"maxclients":  true,
+	"appendfsync": true,
+	"timeout":     true,
+	"maxmemory":   true,
+
+	"slowlog-log-slower-than": true,
+	"slowlog-max-len":         true,
+	"maxmemory-policy":        true,
+	"maxmemory-samples":       true,
+	"lfu-log-factor":          true,
+	"lfu-decay-time":          true,
+
+	"proto-max-bulk-len":         true,
+	"proto-max-multibulk-len":    true,
+	"client-output-buffer-limit": true,
+
+	"masterauth":               true,
+	"replica-read-only":        true,
+	"repl-timeout":             true,
+	"repl-ping-replica-period": true,
+
+	"cluster-node-timeout": true,
+}
+
+// validators 对部分配置项的取值做额外校验
+var validators = map[string]func(value string) error{
+	"appendfsync": func(value string) error {
+		switch value {
+		case FsyncAlways, FsyncEverySec, FsyncNo:
+			return nil
+		}
+		return errors.New("argument must be one of always, everysec, no")
+	},
+	"slowlog-max-len": nonNegative,
+	"maxclients":      nonNegative,
+	"timeout":         nonNegative,
+	"maxmemory":       nonNegative,
+	"maxmemory-policy": func(value string) error {
+		switch value {
+		case PolicyNoEviction, PolicyAllKeysLRU, PolicyAllKeysLFU, PolicyAllKeysRandom,
+			PolicyVolatileLRU, PolicyVolatileLFU, PolicyVolatileRandom, PolicyVolatileTTL:
+			return nil
+		}
+		return errors.New("argument must be one of noeviction, allkeys-lru, allkeys-lfu, allkeys-random, " +
+			"volatile-lru, volatile-lfu, volatile-random, volatile-ttl")
+	},
+	"maxmemory-samples": positive,
+	"lfu-log-factor":    nonNegative,
+	"lfu-decay-time":    nonNegative,
+
+	"proto-max-bulk-len": func(value string) error {
+		// 过小的值会让 CONFIG SET 本身也无法发送
+		num, err := parseInt(value)
+		if err != nil {
+			return err
+		}
+		if num < 1024*1024 {
+			return errors.New("proto-max-bulk-len must be 1mb or greater")
+		}
+		return nil
+	},
+	"proto-max-multibulk-len": positive,
+	"client-output-buffer-limit": func(value string) error {
+		_, err := parseOutputBufferLimits(value)
+		return err
+	},
+	"repl-timeout":             positive,
+	"repl-ping-replica-period": positive,
+	"cluster-node-timeout":     positive,
+}
+
+func positive(value string) error {
+	num, err := parseInt(value)
+	if err != nil {
+		return err
+	}
+	if num <= 0 {
+		return errors.New("argument must be greater than 0")
+	}
+	return nil
+}
+
+func nonNegative(value string) error {
+	num, err := parseInt(value)
+	if err != nil {
+		return err
+	}
+	if num < 0 {
+		return errors.New("argument must be greater or equal to 0")
+	}
+	return nil
+}
+
+// modifiedKeys 记录运行时被修改过的配置项，REWRITE 时需要写回
+var modifiedKeys = make(map[string]bool)
+
+// fileValues 最近一次加载时配置文件中各配置项的原始值，用于判断重新加载时文件是否修改了某一项
+var fileValues = make(map[string]string)
+
+// fieldByKey 根据配置项名称找到 props 中对应的字段
+func fieldByKey(props *ServerProperties, key string) (reflect.Value, bool) {
+	t := reflect.TypeOf(props).Elem()
+	v := reflect.ValueOf(props).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if fieldKey(t.Field(i)) == key {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// Get 返回名称匹配 pattern 的配置项，结果为按 key 排序的 key, value 交替列表
+func Get(pattern string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	p := wildcard.CompilePattern(strings.ToLower(pattern))
+	props := Properties()
+	t := reflect.TypeOf(props).Elem()
+	v := reflect.ValueOf(props).Elem()
+	keys := make([]string, 0)
+	values := make(map[string]string)
+	for i := 0; i < t.NumField(); i++ {
+		key := fieldKey(t.Field(i))
+		if p.IsMatch(key) {
+			keys = append(keys, key)
+			values[key] = formatField(v.Field(i))
+		}
+	}
+	sort.Strings(keys)
+	result := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		result = append(result, key, values[key])
+	}
+	return result
+}
+
+// Set 修改一组配置项，任何一项非法时不做任何修改
+func Set(pairs map[string]string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	// 在副本上修改，全部成功后整体替换
+	staged := *Properties()
+	canonical := make(map[string]string, len(pairs))
+	for key, value := range pairs {
+		canonical[canonicalKey(key)] = value
+	}
+	pairs = canonical
+	for key, value := range pairs {
+		fieldVal, ok := fieldByKey(&staged, key)
+		if !ok {
+			return errors.New("Unknown option or number of arguments for CONFIG SET - '" + key + "'")
+		}
+		if !mutableKeys[key] {
+			return errors.New("can't set immutable config '" + key + "'")
+		}
+		if validate, ok := validators[key]; ok {
+			if err := validate(value); err != nil {
+				return errors.New("Invalid argument '" + value + "' for CONFIG SET '" + key + "' - " + err.Error())
+			}
+		}
+		if err := setField(fieldVal, value); err != nil {
+			return errors.New("Invalid argument '" + value + "' for CONFIG SET '" + key + "' - " + err.Error())
+		}
+	}
+	SetProperties(&staged)
+	for key := range pairs {
+		modifiedKeys[key] = true
+	}
+	return nil
+}
+
+// Rewrite 把当前配置写回配置文件，保留原有注释和顺序，新增的配置项追加在文件末尾
+func Rewrite() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if ConfigFile == "" {
+		return errors.New("The server is running without a config file")
+	}
+	file, err := os.Open(ConfigFile)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	props := Properties()
+	lines := make([]string, 0)
+	written := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		key, _, ok := parseLine(line)
+		if ok {
+			if fieldVal, found := fieldByKey(props, key); found {
+				// 同一项出现多次时在第一次出现的位置写入当前值，之后的行删除
+				if !written[key] {
+					lines = append(lines, configLines(key, formatField(fieldVal))...)
+					written[key] = true
+				}
+				continue
+			}
+		}
+		lines = append(lines, line)
+	}
+	_ = file.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	appended := make([]string, 0)
+	for key := range modifiedKeys {
+		if !written[key] {
+			appended = append(appended, key)
+		}
+	}
+	if len(appended) > 0 {
+		sort.Strings(appended)
+		lines = append(lines, "", "# Generated by CONFIG REWRITE")
+		for _, key := range appended {
+			fieldVal, _ := fieldByKey(props, key)
+			lines = append(lines, configLines(key, formatField(fieldVal))...)
+		}
+	}
+
+	// 先写临时文件再替换，避免写到一半时配置文件损坏；临时文件沿用原文件的权限
+	tmpFile := ConfigFile + ".tmp"
+	content := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(tmpFile, []byte(content), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile, info.Mode().Perm()); err != nil {
+		// 文件已存在时 WriteFile 不修改权限
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, ConfigFile)
+}
+
+// configLines 生成一项配置在配置文件中的行，多行配置项每行写一组参数
+func configLines(key, value string) []string {
+	n := multiLineKeys[key]
+	fields := strings.Fields(value)
+	if n == 0 || len(fields) <= n {
+		return []string{key + " " + value}
+	}
+	lines := make([]string, 0, len(fields)/n+1)
+	for i := 0; i < len(fields); i += n {
+		lines = append(lines, key+" "+strings.Join(fields[i:min(i+n, len(fields))], " "))
+	}
+	return lines
+}
+
+// Reload 重新读取配置文件，应用可在运行时修改的配置项。
+// 只应用文件中出现的配置项；运行时用 CONFIG SET 修改过、而文件中的值与上次加载时相同的配置项保留运行时的值。
+// changed 为已生效的配置项，needRestart 为发生变化但需要重启才能生效的配置项。
+func Reload() (changed []string, needRestart []string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if ConfigFile == "" {
+		return nil, nil, errors.New("the server is running without a config file")
+	}
+	file, err := os.Open(ConfigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	loaded, values := parse(file)
+
+	props := Properties()
+	t := reflect.TypeOf(props).Elem()
+	cur := reflect.ValueOf(props).Elem()
+	next := reflect.ValueOf(loaded).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		key := fieldKey(t.Field(i))
+		value, inFile := values[key]
+		if !inFile {
+			// 文件中没有的配置项保持当前值
+			continue
+		}
+		if last, ok := fileValues[key]; ok && last == value && modifiedKeys[key] {
+			// 文件中的值没有变化，保留 CONFIG SET 设置的值
+			continue
+		}
+		if formatField(cur.Field(i)) == formatField(next.Field(i)) {
+			continue
+		}
+		if !mutableKeys[key] {
+			needRestart = append(needRestart, key)
+			continue
+		}
+		if validate, ok := validators[key]; ok {
+			if err := validate(formatField(next.Field(i))); err != nil {
+				return nil, nil, errors.New("invalid value for '" + key + "': " + err.Error())
+			}
+		}
+		changed = append(changed, key)
+	}
+	staged := *props
+	for _, key := range changed {
+		src, _ := fieldByKey(loaded, key)
+		dst, _ := fieldByKey(&staged, key)
+		dst.Set(src)
+		// 已经与配置文件一致，REWRITE 时无需追加
+		delete(modifiedKeys, key)
+	}
+	SetProperties(&staged)
+	fileValues = values
+	return changed, needRestart, nil
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setupTestConfig 从临时配置文件加载配置，测试结束后恢复默认配置
+func setupTestConfig(t *testing.T, content string, perm os.FileMode) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(file, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, perm); err != nil {
+		t.Fatal(err)
+	}
+	modifiedKeys = make(map[string]bool)
+	SetupConfig(file)
+	t.Cleanup(func() {
+		SetProperties(NewServerProperties())
+		ConfigFile = ""
+		modifiedKeys = make(map[string]bool)
+		fileValues = make(map[string]string)
+	})
+	return file
+}
+
+func readFile(t *testing.T, file string) string {
+	t.Helper()
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestGet(t *testing.T) {
+	setupTestConfig(t, "port 7000\nmaxmemory 1mb\n", 0644)
+	got := Get("maxmemory*")
+	want := []string{"maxmemory", "1048576", "maxmemory-policy", "noeviction", "maxmemory-samples", "5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Get(maxmemory*) = %v, want %v", got, want)
+	}
+	if got := Get("PORT"); !reflect.DeepEqual(got, []string{"port", "7000"}) {
+		t.Fatalf("Get(PORT) = %v", got)
+	}
+	if got := Get("nosuch"); len(got) != 0 {
+		t.Fatalf("Get(nosuch) = %v", got)
+	}
+}
+
+func TestSet(t *testing.T) {
+	setupTestConfig(t, "port 7000\n", 0644)
+	before := Properties()
+	if err := Set(map[string]string{"maxmemory": "2mb", "timeout": "30"}); err != nil {
+		t.Fatal(err)
+	}
+	if Properties().MaxMemory != 2*1024*1024 || Properties().Timeout != 30 {
+		t.Fatalf("maxmemory = %d, timeout = %d", Properties().MaxMemory, Properties().Timeout)
+	}
+	// 发布的是新的快照，旧快照不变
+	if before.MaxMemory != 0 {
+		t.Fatal("Set modified the published snapshot")
+	}
+	errCases := []map[string]string{
+		{"port": "7001"},
+		{"nosuch": "1"},
+		{"appendfsync": "sometimes"},
+		{"maxmemory": "-1"},
+		{"client-output-buffer-limit": "normal 0 0"},
+		// 一项非法时其他项也不修改
+		{"timeout": "60", "maxmemory-policy": "lru"},
+	}
+	for _, pairs := range errCases {
+		if err := Set(pairs); err == nil {
+			t.Errorf("Set(%v) succeeded", pairs)
+		}
+	}
+	if Properties().Timeout != 30 {
+		t.Fatalf("timeout = %d after a failed Set", Properties().Timeout)
+	}
+	// 旧配置名
+	if err := Set(map[string]string{"slave-read-only": "no"}); err != nil || Properties().ReplicaReadOnly {
+		t.Fatalf("alias: err %v, replica-read-only %v", err, Properties().ReplicaReadOnly)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	file := setupTestConfig(t, `# comment
+port 7000
+client-output-buffer-limit normal 0 0 0
+maxmemory 1mb
+client-output-buffer-limit replica 256mb 64mb 60
+timeout 10
+timeout 20
+`, 0600)
+	err := Set(map[string]string{
+		"client-output-buffer-limit": "normal 1mb 0 0 replica 128mb 32mb 30 pubsub 32mb 8mb 60",
+		"maxmemory":                  "2mb",
+		"requirepass":                "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	want := `# comment
+port 7000
+client-output-buffer-limit normal 1mb 0 0
+client-output-buffer-limit replica 128mb 32mb 30
+client-output-buffer-limit pubsub 32mb 8mb 60
+maxmemory 2097152
+timeout 20
+
+# Generated by CONFIG REWRITE
+requirepass secret
+`
+	got := readFile(t, file)
+	if got != want {
+		t.Fatalf("rewritten file:\n%s\nwant:\n%s", got, want)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("mode %v, want 0600", info.Mode().Perm())
+	}
+	// 重新加载后每一类只出现一次
+	SetupConfig(file)
+	if got := Properties().ClientOutputBufferLimit; strings.Count(got, "replica") != 1 {
+		t.Fatalf("client-output-buffer-limit after reload = %q", got)
+	}
+	if GetOutputBufferLimit(ClientClassReplica).SoftSeconds != 30 {
+		t.Fatalf("replica limit after reload = %+v", GetOutputBufferLimit(ClientClassReplica))
+	}
+	// 再次 REWRITE 结果不变
+	if err := Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, file); got != want {
+		t.Fatalf("second rewrite:\n%s", got)
+	}
+}
+
+func TestRewriteWithoutFile(t *testing.T) {
+	ConfigFile = ""
+	if err := Rewrite(); err == nil {
+		t.Fatal("Rewrite without a config file succeeded")
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := setupTestConfig(t, "port 7000\ntimeout 10\nmaxmemory 1mb\n", 0644)
+	if err := Set(map[string]string{"timeout": "30"}); err != nil {
+		t.Fatal(err)
+	}
+	content := "port 7001\ntimeout 10\nmaxmemory 2mb\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, needRestart, err := Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"maxmemory"}) || !reflect.DeepEqual(needRestart, []string{"port"}) {
+		t.Fatalf("changed %v, needRestart %v", changed, needRestart)
+	}
+	// 文件中的 timeout 没有变化，保留 CONFIG SET 的值
+	if Properties().Timeout != 30 || Properties().MaxMemory != 2*1024*1024 || Properties().Port != 7000 {
+		t.Fatalf("timeout %d, maxmemory %d, port %d", Properties().Timeout, Properties().MaxMemory, Properties().Port)
+	}
+	// 文件修改了 timeout 时以文件为准
+	if err := os.WriteFile(file, []byte("port 7001\ntimeout 40\nmaxmemory 2mb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if Properties().Timeout != 40 {
+		t.Fatalf("timeout %d, want 40", Properties().Timeout)
+	}
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+)
+
+// tls-auth-clients 可选值
+const (
+	TlsAuthClientsYes      = "yes"      // 客户端必须提供由 tls-ca-cert-file 签发的证书
+	TlsAuthClientsNo       = "no"       // 不要求客户端证书
+	TlsAuthClientsOptional = "optional" // 客户端提供了证书时才校验
+)
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in " + file)
+	}
+	return pool, nil
+}
+
+// ServerTLSConfig 根据 tls-* 配置生成 TLS 监听使用的配置
+func ServerTLSConfig() (*tls.Config, error) {
+	props := Properties()
+	if props.TlsCertFile == "" || props.TlsKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file are required when tls-port is set")
+	}
+	cert, err := tls.LoadX509KeyPair(props.TlsCertFile, props.TlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch props.TlsAuthClients {
+	case TlsAuthClientsNo:
+		tlsConfig.ClientAuth = tls.NoClientCert
+		return tlsConfig, nil
+	case TlsAuthClientsOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case TlsAuthClientsYes, "":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, errors.New("tls-auth-clients must be one of yes, no, optional")
+	}
+	if props.TlsCaCertFile == "" {
+		return nil, errors.New("tls-ca-cert-file is required to authenticate clients, or set tls-auth-clients no")
+	}
+	tlsConfig.ClientCAs, err = loadCertPool(props.TlsCaCertFile)
+	if err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+// ClientTLSConfig 连接其他节点时使用的 TLS 配置：
+// 用 tls-ca-cert-file 校验对方证书，并以 tls-cert-file 作为自己的客户端证书
+func ClientTLSConfig(addr string) (*tls.Config, error) {
+	props := Properties()
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		tlsConfig.ServerName = host
+	}
+	if props.TlsCaCertFile != "" {
+		pool, err := loadCertPool(props.TlsCaCertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if props.TlsCertFile != "" && props.TlsKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(props.TlsCertFile, props.TlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
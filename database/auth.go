@@ -0,0 +1,43 @@
+package database
+
+import (
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+)
+
+// AUTH password
+// AUTH default password
+func execAuth(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return reply.MakeArgNumErrReply("auth")
+	}
+	if config.Properties().RequirePass == "" {
+		return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	password := string(args[len(args)-1])
+	if len(args) == 2 && string(args[0]) != "default" {
+		// 只有 default 一个用户
+		return reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	if config.Properties().RequirePass != password {
+		// 密码错误时不改变连接已有的认证状态
+		return reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	c.SetPassword(password)
+	return reply.MakeOkReply()
+}
+
+// IsAuthenticated 未设置 requirepass 时所有连接都视为已认证
+func IsAuthenticated(c resp.Connection) bool {
+	if config.Properties().RequirePass == "" {
+		return true
+	}
+	return c.GetPassword() == config.Properties().RequirePass
+}
+
+func init() {
+	registerServerCommand("auth", -2).
+		attachCommandExtra([]string{"noscript", "loading", "stale", "fast", "no_auth"}, 0, 0, 0).
+		attachDocs("connection", "Authenticates the connection.")
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"strings"
+)
+
+// CLIENT SETNAME name
+// CLIENT GETNAME
+func execClient(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("client")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "setname":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|setname")
+		}
+		name := string(args[1])
+		if strings.ContainsAny(name, " \n") {
+			return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.SetName(name)
+		return reply.MakeOkReply()
+	case "getname":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("client|getname")
+		}
+		name := c.GetName()
+		if name == "" {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply([]byte(name))
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try CLIENT HELP.")
+}
+
+func init() {
+	registerServerCommand("client", -2).
+		attachCommandExtra([]string{"noscript", "loading", "stale"}, 0, 0, 0).
+		attachDocs("connection", "A container for client connection commands.")
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/slot"
+	"go_redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// CLUSTER KEYSLOT key
+// CLUSTER COUNTKEYSINSLOT slot
+// CLUSTER GETKEYSINSLOT slot count
+// 这几个子命令只依赖本节点的数据，需要集群拓扑的子命令由 cluster 包处理
+func (d *StandaloneDatabase) execCluster(c resp.Connection, args [][]byte) resp.Reply {
+	if !config.ClusterEnabled() {
+		return reply.MakeErrReply("ERR This instance has cluster support disabled")
+	}
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("cluster")
+	}
+	db := d.dbSet[c.GetDBIndex()]
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "keyslot":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return reply.MakeIntReply(int64(slot.KeySlot(string(args[1]))))
+	case "countkeysinslot":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("cluster|countkeysinslot")
+		}
+		s, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if s < 0 || s >= slot.Count {
+			return reply.MakeErrReply("ERR Invalid slot")
+		}
+		return reply.MakeIntReply(int64(db.slots.count(int(s))))
+	case "getkeysinslot":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|getkeysinslot")
+		}
+		s, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		count, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if s < 0 || s >= slot.Count || count < 0 {
+			return reply.MakeErrReply("ERR Invalid slot or number of keys")
+		}
+		keys := db.slots.keysIn(int(s), int(count))
+		result := make([][]byte, len(keys))
+		for i, key := range keys {
+			result[i] = []byte(key)
+		}
+		return reply.MakeMultiBulkReply(result)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try CLUSTER HELP.")
+}
+
+// ASKING
+// 下一条命令访问正在导入本节点的槽位时，不返回 MOVED
+func execAsking(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("asking")
+	}
+	if !config.ClusterEnabled() {
+		return reply.MakeErrReply("ERR This instance has cluster support disabled")
+	}
+	c.SetAsking(true)
+	return reply.MakeOkReply()
+}
+
+// READONLY / READWRITE
+// 开启后，只读命令可以在从节点上执行，不再重定向到主节点
+func execReadOnly(c resp.Connection, args [][]byte, readOnly bool) resp.Reply {
+	if len(args) != 0 {
+		if readOnly {
+			return reply.MakeArgNumErrReply("readonly")
+		}
+		return reply.MakeArgNumErrReply("readwrite")
+	}
+	if !config.ClusterEnabled() {
+		return reply.MakeErrReply("ERR This instance has cluster support disabled")
+	}
+	c.SetReadOnly(readOnly)
+	return reply.MakeOkReply()
+}
+
+// KeyExists 判断 key 在连接当前的 DB 中是否存在，槽位迁移时判断返回 ASK 还是在本地执行
+func (d *StandaloneDatabase) KeyExists(c resp.Connection, key string) bool {
+	_, exists := d.dbSet[c.GetDBIndex()].GetEntity(key)
+	return exists
+}
+
+func init() {
+	registerServerCommand("cluster", -2).
+		attachCommandExtra([]string{"loading", "stale"}, 0, 0, 0).
+		attachDocs("cluster", "A container for Redis Cluster commands.")
+	registerServerCommand("asking", 1).
+		attachCommandExtra([]string{"fast"}, 0, 0, 0).
+		attachDocs("cluster", "Signals that a cluster client is following an -ASK redirect.")
+	registerServerCommand("readonly", 1).
+		attachCommandExtra([]string{"loading", "stale", "fast"}, 0, 0, 0).
+		attachDocs("cluster", "Enables read-only queries for a connection to a Redis Cluster replica node.")
+	registerServerCommand("readwrite", 1).
+		attachCommandExtra([]string{"loading", "stale", "fast"}, 0, 0, 0).
+		attachDocs("cluster", "Enables read-write queries for a connection to a Redis Cluster replica node.")
+}
+
+// KeyCount 所有 DB 的 key 总数，CLUSTER RESET 只能在没有 key 的节点上执行
+func (d *StandaloneDatabase) KeyCount() int {
+	count := 0
+	for _, db := range d.dbSet {
+		count += db.data.Len()
+	}
+	return count
+}
+
+// 集群模式下由 CLUSTER REPLICATE、CLUSTER RESET 和故障转移切换主从关系
+
+// ReplicaOf 成为 host:port 的从节点，已经在复制该节点时不做任何事
+func (d *StandaloneDatabase) ReplicaOf(host string, port int) {
+	if link := d.masterLinkOf(); link != nil && link.host == host && link.port == port {
+		return
+	}
+	d.replicaOf(host, port)
+}
+
+// ReplicaOfNoOne 晋升为主节点，保留数据
+func (d *StandaloneDatabase) ReplicaOfNoOne() {
+	d.replicaOfNoOne()
+}
+
+// ReplOffset 复制流当前的偏移量，从节点上是已经执行到的主节点偏移量，选举时用于比较各从节点的数据新旧
+func (d *StandaloneDatabase) ReplOffset() int64 {
+	return d.repl.currentOffset()
+}
+
+// FlushAll 清空所有 DB
+func (d *StandaloneDatabase) FlushAll() {
+	for _, db := range d.dbSet {
+		db.Flush()
+	}
+}
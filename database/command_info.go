@@ -0,0 +1,154 @@
+package database
+
+import (
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"sort"
+	"strings"
+)
+
+// COMMAND 命令：供客户端查询命令的参数个数、标志位和 key 位置
+
+// COMMAND
+// COMMAND COUNT
+// COMMAND INFO [command-name ...]
+// COMMAND DOCS [command-name ...]
+// COMMAND GETKEYS command [arg ...]
+// COMMAND LIST
+func execCommand(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return commandInfoReplies(allCommandNames())
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "count":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("command|count")
+		}
+		return reply.MakeIntReply(int64(len(cmdTable)))
+	case "info":
+		if len(args) == 1 {
+			return commandInfoReplies(allCommandNames())
+		}
+		names := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			names = append(names, string(arg))
+		}
+		return commandInfoReplies(names)
+	case "docs":
+		names := allCommandNames()
+		if len(args) > 1 {
+			names = names[:0]
+			for _, arg := range args[1:] {
+				names = append(names, string(arg))
+			}
+		}
+		return commandDocsReply(names)
+	case "getkeys":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply("command|getkeys")
+		}
+		return execCommandGetKeys(args[1:])
+	case "list":
+		if len(args) != 1 {
+			return reply.MakeErrReply("ERR COMMAND LIST FILTERBY is not supported")
+		}
+		names := allCommandNames()
+		result := make([][]byte, len(names))
+		for i, name := range names {
+			result[i] = []byte(name)
+		}
+		return reply.MakeMultiBulkReply(result)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try COMMAND HELP.")
+}
+
+func allCommandNames() []string {
+	names := make([]string, 0, len(cmdTable))
+	for name := range cmdTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandInfoReplies 未知命令对应位置返回 nil
+func commandInfoReplies(names []string) resp.Reply {
+	result := make([]resp.Reply, 0, len(names))
+	for _, name := range names {
+		cmd := lookupCommand(name)
+		if cmd == nil {
+			result = append(result, reply.MakeNullBulkReply())
+			continue
+		}
+		result = append(result, commandInfoReply(cmd))
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+// commandInfoReply 格式与 redis 7 相同：
+// name, arity, flags, first key, last key, step, acl categories, tips, key specs, subcommands
+func commandInfoReply(cmd *command) resp.Reply {
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(cmd.name)),
+		reply.MakeIntReply(int64(cmd.arity)),
+		statusArray(cmd.flagList()),
+		reply.MakeIntReply(int64(cmd.firstKey)),
+		reply.MakeIntReply(int64(cmd.lastKey)),
+		reply.MakeIntReply(int64(cmd.keyStep)),
+		statusArray(cmd.categories()),
+		reply.MakeEmptyMutiBulkReply(),
+		reply.MakeEmptyMutiBulkReply(),
+		reply.MakeEmptyMutiBulkReply(),
+	})
+}
+
+// commandDocsReply name -> {summary, group}，未知命令直接跳过
+func commandDocsReply(names []string) resp.Reply {
+	result := reply.MakeMapReply()
+	for _, name := range names {
+		cmd := lookupCommand(name)
+		if cmd == nil {
+			continue
+		}
+		docs := reply.MakeBulkMapReply([][]byte{
+			[]byte("summary"), []byte(cmd.summary),
+			[]byte("group"), []byte(cmd.group),
+		})
+		result.Add(reply.MakeBulkReply([]byte(cmd.name)), docs)
+	}
+	return result
+}
+
+func execCommandGetKeys(args [][]byte) resp.Reply {
+	cmd := lookupCommand(string(args[0]))
+	if cmd == nil {
+		return reply.MakeErrReply("ERR Invalid command specified")
+	}
+	if !validateArity(cmd.arity, args) {
+		return reply.MakeErrReply("ERR Invalid number of arguments specified for command")
+	}
+	keys := cmd.extractKeys(args)
+	if len(keys) == 0 {
+		return reply.MakeErrReply("ERR The command has no key arguments")
+	}
+	return reply.MakeMultiBulkReply(keys)
+}
+
+func statusArray(items []string) resp.Reply {
+	result := make([]resp.Reply, len(items))
+	for i, item := range items {
+		result[i] = reply.MakeStatusReply(item)
+	}
+	return reply.MakeMultiRawReply(result)
+}
+
+func init() {
+	registerServerCommand("command", -1).
+		attachCommandExtra([]string{"loading", "stale"}, 0, 0, 0).
+		attachDocs("server", "Returns detailed information about all commands.")
+	// MONITOR 由 handler 处理，这里只登记命令信息
+	registerServerCommand("monitor", 1).
+		attachCommandExtra([]string{"admin", "noscript", "loading", "stale"}, 0, 0, 0).
+		attachDocs("server", "Listens for all requests received by the server in real-time.")
+}
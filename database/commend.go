@@ -8,11 +8,169 @@ import (
 
 var cmdTable = make(map[string]*command) // 命令表，键为命令名称，值为对应的command结构体
 type command struct {
-	exector ExecFunc // 执行命令的函数
-	arity   int      // 命令参数个数
+	name     string
+	exector  ExecFunc // 执行命令的函数，由 StandaloneDatabase 直接处理的服务器命令为 nil
+	arity    int      // 命令参数个数
+	flags    int      // 命令标志位，见 flagXxx
+	firstKey int      // 第一个 key 的位置，0 表示没有 key
+	lastKey  int      // 最后一个 key 的位置，负数表示从末尾倒数
+	keyStep  int      // 相邻 key 的间隔
+	group    string   // 命令分组，如 string、generic
+	summary  string   // 命令说明，COMMAND DOCS 使用
 }
 
-func RegisterCommand(name string, exector ExecFunc, arity int) {
+// 命令标志位，与 redis COMMAND 返回的 flags 对应
+const (
+	flagWrite = 1 << iota
+	flagReadOnly
+	flagDenyOOM
+	flagAdmin
+	flagNoScript
+	flagLoading
+	flagStale
+	flagFast
+	flagNoAuth
+	flagSortForScript
+	flagAsking
+)
+
+var flagNames = []string{
+	"write", "readonly", "denyoom", "admin", "noscript",
+	"loading", "stale", "fast", "no_auth", "sort_for_script",
+	"asking",
+}
+
+func RegisterCommand(name string, exector ExecFunc, arity int) *command {
 	name = strings.ToLower(name)
-	cmdTable[name] = &command{exector, arity}
+	cmd := &command{
+		name:    name,
+		exector: exector,
+		arity:   arity,
+	}
+	cmdTable[name] = cmd
+	return cmd
+}
+
+// registerServerCommand 登记由 StandaloneDatabase 或 handler 直接处理的命令，只用于 COMMAND 查询
+func registerServerCommand(name string, arity int) *command {
+	return RegisterCommand(name, nil, arity)
+}
+
+// attachCommandExtra 补充 COMMAND 需要的标志位和 key 位置
+func (cmd *command) attachCommandExtra(flags []string, firstKey, lastKey, keyStep int) *command {
+	for _, flag := range flags {
+		for i, name := range flagNames {
+			if name == flag {
+				cmd.flags |= 1 << i
+			}
+		}
+	}
+	cmd.firstKey = firstKey
+	cmd.lastKey = lastKey
+	cmd.keyStep = keyStep
+	return cmd
+}
+
+// attachDocs 补充 COMMAND DOCS 需要的分组和说明
+func (cmd *command) attachDocs(group string, summary string) *command {
+	cmd.group = group
+	cmd.summary = summary
+	return cmd
+}
+
+func (cmd *command) hasFlag(flag int) bool {
+	return cmd.flags&flag != 0
+}
+
+// flagList 返回标志位名称
+func (cmd *command) flagList() []string {
+	result := make([]string, 0)
+	for i, name := range flagNames {
+		if cmd.flags&(1<<i) != 0 {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// categories 根据分组和标志位推导 ACL 分类
+func (cmd *command) categories() []string {
+	result := make([]string, 0)
+	switch cmd.group {
+	case "generic":
+		result = append(result, "@keyspace")
+	case "":
+	default:
+		result = append(result, "@"+cmd.group)
+	}
+	if cmd.hasFlag(flagWrite) {
+		result = append(result, "@write")
+	}
+	if cmd.hasFlag(flagReadOnly) {
+		result = append(result, "@read")
+	}
+	if cmd.hasFlag(flagAdmin) {
+		result = append(result, "@admin", "@dangerous")
+	}
+	if cmd.hasFlag(flagFast) {
+		result = append(result, "@fast")
+	} else {
+		result = append(result, "@slow")
+	}
+	return result
+}
+
+// extractKeys 按 firstKey、lastKey、keyStep 从完整命令行中取出 key
+func (cmd *command) extractKeys(args [][]byte) [][]byte {
+	if cmd.firstKey <= 0 || cmd.firstKey >= len(args) {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	step := cmd.keyStep
+	if step <= 0 {
+		step = 1
+	}
+	keys := make([][]byte, 0)
+	for i := cmd.firstKey; i <= last; i += step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// CommandKeys 按命令登记的 key 位置取出命令行中的 key，未知命令或没有 key 的命令返回 nil
+func CommandKeys(args [][]byte) [][]byte {
+	cmd := lookupCommand(string(args[0]))
+	if cmd == nil {
+		return nil
+	}
+	return cmd.extractKeys(args)
+}
+
+// ValidateArity 参数个数是否符合命令的 arity，未知命令返回 false
+func ValidateArity(args [][]byte) bool {
+	cmd := lookupCommand(string(args[0]))
+	return cmd != nil && validateArity(cmd.arity, args)
+}
+
+// IsAskingCommand 命令是否带 asking 标志，相当于先执行了 ASKING
+func IsAskingCommand(name string) bool {
+	cmd := lookupCommand(name)
+	return cmd != nil && cmd.hasFlag(flagAsking)
+}
+
+// IsReadOnlyCommand 命令是否只读
+func IsReadOnlyCommand(name string) bool {
+	cmd := lookupCommand(name)
+	return cmd != nil && cmd.hasFlag(flagReadOnly)
+}
+
+// lookupCommand 根据命令名查找命令，找不到时返回 nil
+func lookupCommand(name string) *command {
+	return cmdTable[strings.ToLower(name)]
 }
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"strings"
+)
+
+// CONFIG GET pattern [pattern ...]
+// CONFIG SET parameter value [parameter value ...]
+// CONFIG REWRITE
+// CONFIG RESETSTAT
+func execConfig(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("config")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "get":
+		return execConfigGet(args[1:])
+	case "set":
+		return execConfigSet(args[1:])
+	case "rewrite":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("config|rewrite")
+		}
+		if err := config.Rewrite(); err != nil {
+			return reply.MakeErrReply("ERR Rewriting config file: " + err.Error())
+		}
+		return reply.MakeOkReply()
+	case "resetstat":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("config|resetstat")
+		}
+		stats.reset()
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try CONFIG HELP.")
+}
+
+func execConfigGet(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("config|get")
+	}
+	result := make([][]byte, 0)
+	seen := make(map[string]bool)
+	for _, pattern := range args {
+		pairs := config.Get(string(pattern))
+		for i := 0; i < len(pairs); i += 2 {
+			if seen[pairs[i]] {
+				continue
+			}
+			seen[pairs[i]] = true
+			result = append(result, []byte(pairs[i]), []byte(pairs[i+1]))
+		}
+	}
+	return reply.MakeBulkMapReply(result)
+}
+
+func execConfigSet(args [][]byte) resp.Reply {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("config|set")
+	}
+	pairs := make(map[string]string, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key := strings.ToLower(string(args[i]))
+		if _, ok := pairs[key]; ok {
+			return reply.MakeErrReply("ERR CONFIG SET failed (possibly related to argument '" + key + "') - duplicate parameter")
+		}
+		pairs[key] = string(args[i+1])
+	}
+	if err := config.Set(pairs); err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+func init() {
+	registerServerCommand("config", -2).
+		attachCommandExtra([]string{"admin", "noscript", "loading", "stale"}, 0, 0, 0).
+		attachDocs("server", "A container for server configuration commands.")
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"go_redis/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigCommand(t *testing.T) {
+	d := makeTestDatabase(t)
+	setConfig(t, func(p *config.ServerProperties) {})
+	assertReply(t, execLine(d, "config", "get", "maxmemory", "maxmemory"), "*2\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n")
+	assertReply(t, execLine(d, "config", "set", "maxmemory", "1mb", "timeout", "30"), "+OK\r\n")
+	assertReply(t, execLine(d, "config", "get", "maxmemory"), "*2\r\n$9\r\nmaxmemory\r\n$7\r\n1048576\r\n")
+	assertReply(t, execLine(d, "config", "set", "timeout", "10", "TIMEOUT", "20"),
+		"-ERR CONFIG SET failed (possibly related to argument 'timeout') - duplicate parameter\r\n")
+	for _, args := range [][]string{
+		{"config", "set", "timeout"},
+		{"config", "set", "port", "7000"},
+		{"config", "set", "timeout", "60", "maxmemory-policy", "lru"},
+	} {
+		if r := execLine(d, args...); !isErr(r) {
+			t.Fatalf("%v: %q", args, r.ToBytes())
+		}
+	}
+	if config.Properties().Timeout != 30 {
+		t.Fatalf("timeout = %d", config.Properties().Timeout)
+	}
+	config.ConfigFile = ""
+	if r := execLine(d, "config", "rewrite"); !isErr(r) {
+		t.Fatalf("CONFIG REWRITE without a config file: %q", r.ToBytes())
+	}
+	assertReply(t, execLine(d, "config", "nosuch"), "-ERR unknown subcommand 'nosuch'. Try CONFIG HELP.\r\n")
+}
+
+func isErr(r interface{ ToBytes() []byte }) bool {
+	b := r.ToBytes()
+	return len(b) > 0 && b[0] == '-'
+}
+
+func TestConfigResetStat(t *testing.T) {
+	d := makeTestDatabase(t)
+	execLine(d, "set", "k", "v")
+	if stats.totalCommands.Load() == 0 {
+		t.Fatal("command is not counted")
+	}
+	assertReply(t, execLine(d, "config", "resetstat"), "+OK\r\n")
+	// RESETSTAT 本身在清零之后计入
+	if n := stats.totalCommands.Load(); n != 1 {
+		t.Fatalf("total_commands_processed = %d after RESETSTAT", n)
+	}
+}
+
+func TestStatsConcurrentRecord(t *testing.T) {
+	s := &serverStats{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.record("get", time.Microsecond)
+				s.record("set", 2*time.Microsecond)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.totalCommands.Load(); n != 16000 {
+		t.Fatalf("totalCommands = %d", n)
+	}
+	raw, _ := s.cmdStats.Load("set")
+	stat := raw.(*cmdStat)
+	if stat.calls.Load() != 8000 || stat.usec.Load() != 16000 {
+		t.Fatalf("set: calls %d, usec %d", stat.calls.Load(), stat.usec.Load())
+	}
+	s.reset()
+	if s.totalCommands.Load() != 0 || stat.calls.Load() != 0 {
+		t.Fatal("reset does not clear the counters")
+	}
+}
@@ -1,17 +1,24 @@
 package database
 
 import (
+	"go_redis/config"
 	"go_redis/datastruct/dict"
 	"go_redis/interface/database"
 	"go_redis/interface/resp"
 	"go_redis/resp/reply"
 	"strings"
+	"sync/atomic"
 )
 
 type DB struct {
 	index  int
 	data   dict.Dict
+	ttlMap dict.Dict    // key -> 过期时间 time.Time
+	used   atomic.Int64 // 估算的内存占用，字节
+	slots  *slotIndex   // 槽位索引，只在集群模式下创建
 	addAof func(CmdLine)
+
+	onExpired func(db *DB, key string) // 访问到过期的 key，见 StandaloneDatabase.queueExpired
 }
 
 // SET k v
@@ -19,19 +26,31 @@ type ExecFunc func(db *DB, args [][]byte) resp.Reply
 
 type CmdLine = [][]byte
 
+const (
+	dataDictShards = 1024
+	ttlDictShards  = 256
+)
+
 func makeDB() *DB {
-	return &DB{
+	db := &DB{
 		index:  0,
-		data:   dict.MakeSyncDict(),
+		data:   dict.MakeConcurrent(dataDictShards),
+		ttlMap: dict.MakeConcurrent(ttlDictShards),
 		addAof: func(CmdLine) {},
+
+		onExpired: func(*DB, string) {},
+	}
+	if config.ClusterEnabled() {
+		db.slots = makeSlotIndex()
 	}
+	return db
 }
 
 func (db *DB) Exec(c resp.Connection, line CmdLine) resp.Reply {
 	// PING SET SETNX GET DEL
 	cmdName := strings.ToLower(string(line[0]))
 	cmd, ok := cmdTable[cmdName]
-	if !ok {
+	if !ok || cmd.exector == nil {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
 	}
 	if !validateArity(cmd.arity, line) {
@@ -53,37 +72,93 @@ func validateArity(arity int, cmdArgs [][]byte) bool {
 }
 
 func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
+	if db.expired(key) {
+		return nil, false
+	}
 	raw, exists := db.data.Get(key)
 	if !exists {
 		return nil, false
 	}
 	entity, _ := raw.(*database.DataEntity)
+	touchEntity(entity)
 	return entity, true
 }
 
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
 	// 如果key已存在，更新操作，返回存入几个
-	return db.data.Put(key, entity)
+	initEntity(entity)
+	if db.IsExpired(key) {
+		// 覆盖已过期还没删除的 key，原来的过期时间不再有效
+		db.ttlMap.Remove(key)
+	}
+	old, existed := db.data.Get(key)
+	result := db.withSlot(key, true, func() int { return db.data.Put(key, entity) })
+	if existed {
+		db.used.Add(-estimateSize(key, old))
+	}
+	db.used.Add(estimateSize(key, entity))
+	return result
 }
 
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
 	// 如果key已存在，更新操作，返回存入几个
-	return db.data.PutIfExists(key, entity)
+	if db.expired(key) {
+		return 0
+	}
+	initEntity(entity)
+	old, existed := db.data.Get(key)
+	result := db.data.PutIfExists(key, entity)
+	if result > 0 && existed {
+		db.used.Add(estimateSize(key, entity) - estimateSize(key, old))
+	}
+	return result
 }
 
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
 	// 如果key已存在，更新操作，返回存入几个
-	return db.data.PutIfAbsent(key, entity)
+	if db.expired(key) {
+		// 已过期还没删除的 key 当作不存在，直接覆盖
+		db.PutEntity(key, entity)
+		return 1
+	}
+	initEntity(entity)
+	result := db.withSlot(key, true, func() int { return db.data.PutIfAbsent(key, entity) })
+	if result > 0 {
+		db.used.Add(estimateSize(key, entity))
+	}
+	return result
 }
 
 func (db *DB) Remove(key string) {
-	db.data.Remove(key)
+	db.remove(key)
+}
+
+// remove 删除 key 及其过期时间，返回删除的个数
+func (db *DB) remove(key string) int {
+	old, existed := db.data.Get(key)
+	result := db.withSlot(key, false, func() int { return db.data.Remove(key) })
+	if result > 0 && existed {
+		db.used.Add(-estimateSize(key, old))
+	}
+	db.ttlMap.Remove(key)
+	return result
+}
+
+// withSlot 执行对 key 的插入或删除，集群模式下同时维护槽位索引
+func (db *DB) withSlot(key string, added bool, op func() int) int {
+	if db.slots == nil {
+		return op()
+	}
+	return db.slots.update(key, added, op)
 }
 
 func (db *DB) Removes(key ...string) (deleted int) {
 	deleted = 0
 	for _, key := range key {
-		if db.data.Remove(key) > 0 {
+		if db.expired(key) {
+			continue
+		}
+		if db.remove(key) > 0 {
 			deleted++
 		}
 	}
@@ -92,4 +167,14 @@ func (db *DB) Removes(key ...string) (deleted int) {
 
 func (db *DB) Flush() {
 	db.data.Clear()
+	db.ttlMap.Clear()
+	db.used.Store(0)
+	if db.slots != nil {
+		db.slots.clear()
+	}
+}
+
+// UsedMemory 返回该 DB 中数据占用内存的估算值
+func (db *DB) UsedMemory() int64 {
+	return db.used.Load()
 }
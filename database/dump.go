@@ -0,0 +1,127 @@
+package database
+
+import (
+	"go_redis/interface/database"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/rdb"
+	"go_redis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DUMP key
+// 返回与 redis 兼容的序列化结果，可以用 RESTORE 在 redis 或本服务器上还原
+func execDump(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	value, ok := entity.Data.([]byte)
+	if !ok {
+		return reply.MakeWrongTypeErrReply()
+	}
+	return reply.MakeBulkReply(rdb.DumpString(value))
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+// ttl 为 0 表示没有过期时间，ABSTTL 时 ttl 为 unix 毫秒时间戳
+func execRestore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	replace, absTTL := false, false
+	idleTime, freq := int64(-1), int64(-1)
+	for i := 3; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "replace":
+			replace = true
+		case option == "absttl":
+			absTTL = true
+		case option == "idletime" && i+1 < len(args) && freq < 0:
+			i++
+			idleTime, err = strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if idleTime < 0 {
+				return reply.MakeErrReply("ERR Invalid IDLETIME value, must be >= 0")
+			}
+		case option == "freq" && i+1 < len(args) && idleTime < 0:
+			i++
+			freq, err = strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if freq < 0 || freq > 255 {
+				return reply.MakeErrReply("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	if !replace {
+		if _, exists := db.GetEntity(key); exists {
+			return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+		}
+	}
+	valueType, value, err := rdb.LoadDump(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	if valueType != rdb.TypeString {
+		return reply.MakeErrReply("ERR Bad data format")
+	}
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = ttl
+		if !absTTL {
+			expireAt = time.Now().UnixMilli() + ttl
+		}
+		if expireAt <= time.Now().UnixMilli() {
+			// 已经过期，相当于还原后立即删除
+			if replace && db.remove(key) > 0 {
+				db.addAof(utils.ToCmdLine("del", key))
+			}
+			return reply.MakeOkReply()
+		}
+	}
+	entity := &database.DataEntity{Data: value}
+	if idleTime >= 0 {
+		entity.LRU.Store(lruClock() - uint32(min(idleTime, int64(lruClock()-1))))
+		entity.LFU.Store(lfuClock()<<8 | lfuInitVal)
+	} else if freq >= 0 {
+		entity.LRU.Store(lruClock())
+		entity.LFU.Store(lfuClock()<<8 | uint32(freq))
+	}
+	db.PutEntity(key, entity)
+	db.addAof(utils.ToCmdLine3("set", args[0], value))
+	if expireAt > 0 {
+		db.Expire(key, time.UnixMilli(expireAt))
+		db.addAof(utils.ToCmdLine("pexpireat", key, strconv.FormatInt(expireAt, 10)))
+	} else {
+		db.Persist(key)
+	}
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("dump", execDump, 2).
+		attachCommandExtra([]string{"readonly"}, 1, 1, 1).
+		attachDocs("generic", "Returns a serialized representation of the value stored at a key.")
+	RegisterCommand("restore", execRestore, -4).
+		attachCommandExtra([]string{"write", "denyoom"}, 1, 1, 1).
+		attachDocs("generic", "Creates a key from the serialized representation of a value.")
+	// MIGRATE 在集群模式下发送 RESTORE-ASKING，目标节点正在导入该槽位时不需要先发送 ASKING
+	RegisterCommand("restore-asking", execRestore, -4).
+		attachCommandExtra([]string{"write", "denyoom", "asking"}, 1, 1, 1).
+		attachDocs("server", "An internal command for migrating keys in a cluster.")
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"go_redis/config"
+	"go_redis/interface/database"
+	"go_redis/lib/utils"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// 内存淘汰：估算内存占用，超过 maxmemory 时按 maxmemory-policy 抽样淘汰 key
+
+const (
+	entryOverhead = 64 // 字典节点、DataEntity 等固定开销的估算值
+	lfuInitVal    = 5  // 新 key 的初始访问计数，避免刚写入就被淘汰
+)
+
+// estimateSize 估算一个 key 占用的内存
+func estimateSize(key string, raw interface{}) int64 {
+	size := int64(entryOverhead + len(key))
+	entity, ok := raw.(*database.DataEntity)
+	if !ok {
+		return size
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		size += int64(len(val))
+	}
+	return size
+}
+
+func lruClock() uint32 {
+	return uint32(time.Now().Unix())
+}
+
+// lfuClock 分钟级时钟，只保留 24 位
+func lfuClock() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xFFFFFF
+}
+
+// initEntity 新写入的 entity 初始化访问信息，RENAME 等搬运已有 entity 时保留原值
+func initEntity(entity *database.DataEntity) {
+	if entity.LRU.Load() != 0 {
+		return
+	}
+	entity.LRU.Store(lruClock())
+	entity.LFU.Store(lfuClock()<<8 | lfuInitVal)
+}
+
+// touchEntity 访问 key 时更新 LRU 时钟和 LFU 计数
+func touchEntity(entity *database.DataEntity) {
+	entity.LRU.Store(lruClock())
+	counter := lfuLogIncr(lfuDecr(entity))
+	entity.LFU.Store(lfuClock()<<8 | counter)
+}
+
+// lfuDecr 按 lfu-decay-time 对计数做衰减
+func lfuDecr(entity *database.DataEntity) uint32 {
+	lfu := entity.LFU.Load()
+	lastDecr := lfu >> 8
+	counter := lfu & 0xFF
+	decayTime := uint32(config.Properties().LfuDecayTime)
+	if decayTime == 0 {
+		return counter
+	}
+	now := lfuClock()
+	var elapsed uint32
+	if now >= lastDecr {
+		elapsed = now - lastDecr
+	} else {
+		elapsed = 0xFFFFFF - lastDecr + now
+	}
+	periods := elapsed / decayTime
+	if periods > counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// lfuLogIncr 对数递增，计数越大递增概率越低
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == 255 {
+		return counter
+	}
+	base := float64(counter) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	p := 1.0 / (base*float64(config.Properties().LfuLogFactor) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// usedMemory 所有 DB 的估算内存占用之和
+func (d *StandaloneDatabase) usedMemory() int64 {
+	var used int64
+	for _, db := range d.dbSet {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// freeMemoryIfNeeded 超出 maxmemory 时按策略淘汰 key，返回是否已回到上限以内
+func (d *StandaloneDatabase) freeMemoryIfNeeded() bool {
+	maxMemory := int64(config.Properties().MaxMemory)
+	if maxMemory <= 0 || d.usedMemory() <= maxMemory {
+		return true
+	}
+	d.evictMu.Lock()
+	defer d.evictMu.Unlock()
+	for d.usedMemory() > maxMemory {
+		if !d.evictOne() {
+			return false
+		}
+	}
+	return true
+}
+
+// evictOne 从每个 DB 抽样，淘汰得分最高的一个 key，没有可淘汰的 key 时返回 false
+func (d *StandaloneDatabase) evictOne() bool {
+	policy := config.Properties().MaxMemoryPolicy
+	switch policy {
+	case config.PolicyAllKeysLRU, config.PolicyAllKeysLFU, config.PolicyAllKeysRandom,
+		config.PolicyVolatileLRU, config.PolicyVolatileLFU, config.PolicyVolatileRandom, config.PolicyVolatileTTL:
+	default:
+		return false
+	}
+	samples := config.Properties().MaxMemorySamples
+	if samples <= 0 {
+		samples = 5
+	}
+	volatile := strings.HasPrefix(policy, "volatile-")
+
+	var bestDB *DB
+	var bestKey string
+	var bestScore int64
+	for _, db := range d.dbSet {
+		var keys []string
+		if volatile {
+			keys = db.ttlMap.RandomKeys(samples)
+		} else {
+			keys = db.data.RandomKeys(samples)
+		}
+		for _, key := range keys {
+			score, ok := db.evictionScore(key, policy)
+			if ok && (bestDB == nil || score > bestScore) {
+				bestDB, bestKey, bestScore = db, key, score
+			}
+		}
+	}
+	if bestDB == nil {
+		return false
+	}
+	// 与写命令一样加锁，保证 del 在 AOF 和复制流中的顺序；
+	// 抽样时没有加锁，key 可能已被其他连接删除，此时不再写入 del
+	unlock := d.lockWrite()
+	defer unlock()
+	if _, exists := bestDB.data.Get(bestKey); !exists {
+		// 已被删除，由调用方重新计算内存
+		return true
+	}
+	bestDB.Remove(bestKey)
+	bestDB.addAof(utils.ToCmdLine("del", bestKey))
+	return true
+}
+
+// evictionScore 得分越高越应该被淘汰
+func (db *DB) evictionScore(key string, policy string) (int64, bool) {
+	raw, exists := db.data.Get(key)
+	if !exists {
+		return 0, false
+	}
+	if db.IsExpired(key) {
+		// 已过期的 key 优先淘汰
+		return math.MaxInt64, true
+	}
+	entity, ok := raw.(*database.DataEntity)
+	if !ok {
+		return 0, false
+	}
+	switch policy {
+	case config.PolicyAllKeysLRU, config.PolicyVolatileLRU:
+		return int64(lruClock()) - int64(entity.LRU.Load()), true
+	case config.PolicyAllKeysLFU, config.PolicyVolatileLFU:
+		return 255 - int64(lfuDecr(entity)), true
+	case config.PolicyVolatileTTL:
+		expireTime, ok := db.ttlOf(key)
+		if !ok {
+			return 0, false
+		}
+		return -expireTime.UnixMilli(), true
+	}
+	return rand.Int63(), true
+}
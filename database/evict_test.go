@@ -0,0 +1,164 @@
+package database
+
+import (
+	"go_redis/config"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testValue = "0123456789"
+
+// keySize 测试中每个 key 的估算占用
+var keySize = estimateSize("key:00", nil) + int64(len(testValue))
+
+func setMaxMemory(t *testing.T, policy string, maxMemory int) {
+	setConfig(t, func(p *config.ServerProperties) {
+		p.MaxMemory = maxMemory
+		p.MaxMemoryPolicy = policy
+	})
+}
+
+func testKey(i int) string {
+	return "key:" + strconv.Itoa(10+i)
+}
+
+func TestEvictAllKeys(t *testing.T) {
+	for _, policy := range []string{config.PolicyAllKeysLRU, config.PolicyAllKeysLFU, config.PolicyAllKeysRandom} {
+		t.Run(policy, func(t *testing.T) {
+			d := makeTestDatabase(t)
+			setMaxMemory(t, policy, int(20*keySize))
+			for i := 0; i < 80; i++ {
+				assertReply(t, execLine(d, "set", testKey(i), testValue), "+OK\r\n")
+			}
+			// 每条命令执行前淘汰，执行后最多超出一个 key
+			if used := d.usedMemory(); used > 21*keySize {
+				t.Fatalf("used memory %d, max %d", used, 20*keySize)
+			}
+			// 直接查看数据，GET 执行前的淘汰可能随机删掉这个 key
+			if _, ok := d.dbSet[0].data.Get(testKey(79)); !ok {
+				t.Fatal("the last written key is evicted")
+			}
+		})
+	}
+}
+
+func TestEvictLRUKeepsRecentKeys(t *testing.T) {
+	d := makeTestDatabase(t)
+	setMaxMemory(t, config.PolicyAllKeysLRU, 0)
+	for i := 0; i < 10; i++ {
+		execLine(d, "set", testKey(i), testValue)
+	}
+	// LRU 时钟精度为秒，让前五个 key 看起来更久没有访问
+	for i := 0; i < 5; i++ {
+		entity, _ := d.dbSet[0].GetEntity(testKey(i))
+		entity.LRU.Store(lruClock() - 100)
+	}
+	setMaxMemory(t, config.PolicyAllKeysLRU, int(8*keySize))
+	setConfig(t, func(p *config.ServerProperties) { p.MaxMemorySamples = 10 })
+	if !d.freeMemoryIfNeeded() {
+		t.Fatal("freeMemoryIfNeeded failed")
+	}
+	for i := 5; i < 10; i++ {
+		if _, ok := d.dbSet[0].data.Get(testKey(i)); !ok {
+			t.Fatalf("recent key %s is evicted", testKey(i))
+		}
+	}
+}
+
+func TestEvictNoEviction(t *testing.T) {
+	d := makeTestDatabase(t)
+	setMaxMemory(t, config.PolicyNoEviction, int(5*keySize))
+	var oom bool
+	for i := 0; i < 10; i++ {
+		r := execLine(d, "set", testKey(i), testValue)
+		if strings.HasPrefix(string(r.ToBytes()), "-OOM") {
+			oom = true
+			break
+		}
+	}
+	if !oom {
+		t.Fatal("write is not rejected under noeviction")
+	}
+	// 读命令和 DEL 不受影响
+	assertReply(t, execLine(d, "get", testKey(0)), "$10\r\n"+testValue+"\r\n")
+	assertReply(t, execLine(d, "del", testKey(0)), ":1\r\n")
+}
+
+func TestEvictVolatile(t *testing.T) {
+	for _, policy := range []string{config.PolicyVolatileLRU, config.PolicyVolatileLFU,
+		config.PolicyVolatileRandom, config.PolicyVolatileTTL} {
+		t.Run(policy, func(t *testing.T) {
+			d := makeTestDatabase(t)
+			setMaxMemory(t, policy, int(10*keySize))
+			for i := 0; i < 5; i++ {
+				execLine(d, "set", testKey(i), testValue)
+			}
+			for i := 5; i < 30; i++ {
+				execLine(d, "set", testKey(i), testValue)
+				execLine(d, "expire", testKey(i), "100")
+			}
+			// 没有过期时间的 key 不会被淘汰
+			for i := 0; i < 5; i++ {
+				if _, ok := d.dbSet[0].data.Get(testKey(i)); !ok {
+					t.Fatalf("persistent key %s is evicted", testKey(i))
+				}
+			}
+			setMaxMemory(t, policy, int(3*keySize))
+			r := execLine(d, "set", "key:99", testValue)
+			if !strings.HasPrefix(string(r.ToBytes()), "-OOM") {
+				t.Fatalf("got %q after volatile keys ran out, want OOM", r.ToBytes())
+			}
+		})
+	}
+}
+
+func TestEvictionScore(t *testing.T) {
+	d := makeTestDatabase(t)
+	db := d.dbSet[0]
+	execLine(d, "set", "old", testValue)
+	execLine(d, "set", "new", testValue)
+	execLine(d, "set", "expired", testValue)
+	old, _ := db.GetEntity("old")
+	old.LRU.Store(lruClock() - 100)
+	db.Expire("old", time.Now().Add(time.Hour))
+	db.Expire("new", time.Now().Add(time.Minute))
+	db.Expire("expired", time.Now().Add(-time.Second))
+
+	score := func(key, policy string) int64 {
+		s, ok := db.evictionScore(key, policy)
+		if !ok {
+			t.Fatalf("no score for %s", key)
+		}
+		return s
+	}
+	if score("old", config.PolicyAllKeysLRU) <= score("new", config.PolicyAllKeysLRU) {
+		t.Error("LRU: key accessed earlier should be evicted first")
+	}
+	if score("new", config.PolicyVolatileTTL) <= score("old", config.PolicyVolatileTTL) {
+		t.Error("TTL: key expiring sooner should be evicted first")
+	}
+	if score("expired", config.PolicyAllKeysLFU) != math.MaxInt64 {
+		t.Error("expired key should be evicted first")
+	}
+	if _, ok := db.evictionScore("missing", config.PolicyAllKeysLRU); ok {
+		t.Error("missing key has a score")
+	}
+}
+
+func TestLFUCounter(t *testing.T) {
+	setConfig(t, func(p *config.ServerProperties) { p.LfuLogFactor = 10 })
+	counter := uint32(lfuInitVal)
+	for i := 0; i < 1000; i++ {
+		counter = lfuLogIncr(counter)
+	}
+	// 对数递增，1000 次访问远达不到上限
+	if counter <= lfuInitVal || counter >= 255 {
+		t.Fatalf("counter after 1000 increments = %d", counter)
+	}
+	if lfuLogIncr(255) != 255 {
+		t.Fatal("counter overflows")
+	}
+}
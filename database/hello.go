@@ -0,0 +1,87 @@
+package database
+
+import (
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// serverVersion HELLO 中返回的版本号，与实现的命令语义所对应的 redis 版本一致
+const serverVersion = "7.0.0"
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+// role 为本节点当前的角色，master 或 replica
+func execHello(c resp.Connection, args [][]byte, role string) resp.Reply {
+	protocol := c.GetProtocol()
+	if len(args) > 0 {
+		ver, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return reply.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != reply.Protocol2 && ver != reply.Protocol3 {
+			return reply.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		protocol = int(ver)
+	}
+
+	var authArgs [][]byte
+	var name []byte
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		remaining := len(args) - i - 1
+		if option == "auth" && remaining >= 2 {
+			authArgs = args[i+1 : i+3]
+			i += 2
+		} else if option == "setname" && remaining >= 1 {
+			name = args[i+1]
+			i++
+		} else {
+			return reply.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+
+	if authArgs != nil {
+		if result := execAuth(c, authArgs); reply.IsErrReply(result) {
+			return result
+		}
+	}
+	if !IsAuthenticated(c) {
+		return reply.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+	if name != nil {
+		if result := execClient(c, [][]byte{[]byte("setname"), name}); reply.IsErrReply(result) {
+			return result
+		}
+	}
+	c.SetProtocol(protocol)
+	return helloReply(c, role)
+}
+
+// helloReply 服务器和连接信息，RESP3 下编码为 map
+func helloReply(c resp.Connection, role string) resp.Reply {
+	mode := "standalone"
+	if config.ClusterEnabled() {
+		mode = "cluster"
+	}
+	bulk := func(s string) resp.Reply {
+		return reply.MakeBulkReply([]byte(s))
+	}
+	return reply.MakeMapReply().
+		Add(bulk("server"), bulk("redis")).
+		Add(bulk("version"), bulk(serverVersion)).
+		Add(bulk("proto"), reply.MakeIntReply(int64(c.GetProtocol()))).
+		Add(bulk("id"), reply.MakeIntReply(c.GetID())).
+		Add(bulk("mode"), bulk(mode)).
+		Add(bulk("role"), bulk(role)).
+		Add(bulk("modules"), reply.MakeEmptyMutiBulkReply())
+}
+
+func init() {
+	registerServerCommand("hello", -1).
+		attachCommandExtra([]string{"noscript", "loading", "stale", "fast", "no_auth"}, 0, 0, 0).
+		attachDocs("connection", "Handshakes with the Redis server.")
+}
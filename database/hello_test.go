@@ -0,0 +1,77 @@
+package database
+
+import (
+	"go_redis/config"
+	"go_redis/resp/connection"
+	"go_redis/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHelloProtocolSwitch(t *testing.T) {
+	d := makeTestDatabase(t)
+	c := &connection.Connection{}
+	id := strconv.FormatInt(c.GetID(), 10)
+	// 不带参数时不改变协议，回复按 RESP2 编码为数组
+	r := execWith(d, c, "hello")
+	if c.GetProtocol() != reply.Protocol2 {
+		t.Fatalf("protocol %d after HELLO", c.GetProtocol())
+	}
+	if got := string(reply.ToBytes(r, c.GetProtocol())); !strings.HasPrefix(got, "*14\r\n$6\r\nserver\r\n") {
+		t.Fatalf("HELLO reply %q", got)
+	}
+
+	r = execWith(d, c, "hello", "3", "setname", "conn")
+	if c.GetProtocol() != reply.Protocol3 || c.GetName() != "conn" {
+		t.Fatalf("protocol %d, name %q", c.GetProtocol(), c.GetName())
+	}
+	want := "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n7.0.0\r\n$5\r\nproto\r\n:3\r\n" +
+		"$2\r\nid\r\n:" + id + "\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n" +
+		"$7\r\nmodules\r\n*0\r\n"
+	if got := string(reply.ToBytes(r, c.GetProtocol())); got != want {
+		t.Fatalf("HELLO 3 reply %q, want %q", got, want)
+	}
+	// 切换到 RESP3 后 null 的编码随之改变
+	if got := string(reply.ToBytes(execWith(d, c, "get", "nosuch"), c.GetProtocol())); got != "_\r\n" {
+		t.Fatalf("GET under RESP3: %q", got)
+	}
+
+	execWith(d, c, "hello", "2")
+	if c.GetProtocol() != reply.Protocol2 {
+		t.Fatalf("protocol %d after HELLO 2", c.GetProtocol())
+	}
+	if got := string(reply.ToBytes(execWith(d, c, "get", "nosuch"), c.GetProtocol())); got != "$-1\r\n" {
+		t.Fatalf("GET under RESP2: %q", got)
+	}
+}
+
+func TestHelloErrors(t *testing.T) {
+	d := makeTestDatabase(t)
+	c := &connection.Connection{}
+	assertReply(t, execWith(d, c, "hello", "4"), "-NOPROTO unsupported protocol version\r\n")
+	assertReply(t, execWith(d, c, "hello", "x"), "-ERR Protocol version is not an integer or out of range\r\n")
+	assertReply(t, execWith(d, c, "hello", "3", "setname"), "-ERR Syntax error in HELLO option 'setname'\r\n")
+	if c.GetProtocol() != reply.Protocol2 {
+		t.Fatalf("protocol changed to %d by a failed HELLO", c.GetProtocol())
+	}
+}
+
+func TestHelloAuth(t *testing.T) {
+	d := makeTestDatabase(t)
+	setConfig(t, func(p *config.ServerProperties) { p.RequirePass = "pw" })
+	c := &connection.Connection{}
+	if r := execWith(d, c, "hello", "3"); !strings.HasPrefix(string(r.ToBytes()), "-NOAUTH") {
+		t.Fatalf("HELLO without AUTH: %q", r.ToBytes())
+	}
+	if r := execWith(d, c, "hello", "3", "auth", "default", "wrong"); !strings.HasPrefix(string(r.ToBytes()), "-WRONGPASS") {
+		t.Fatalf("HELLO with a wrong password: %q", r.ToBytes())
+	}
+	if c.GetProtocol() != reply.Protocol2 {
+		t.Fatal("protocol changed by a failed HELLO")
+	}
+	execWith(d, c, "hello", "3", "auth", "default", "pw")
+	if c.GetProtocol() != reply.Protocol3 || !IsAuthenticated(c) {
+		t.Fatalf("protocol %d, authenticated %v", c.GetProtocol(), IsAuthenticated(c))
+	}
+}
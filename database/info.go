@@ -0,0 +1,108 @@
+package database
+
+import (
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// INFO 按 redis 的格式返回服务器状态，每个 section 以 # Name 开头
+
+var (
+	startTime = time.Now()
+	runID     = newReplID() // 每次启动随机生成
+)
+
+// infoSections 按输出顺序排列的 section
+var infoSections = []string{"server", "memory", "stats", "replication", "keyspace"}
+
+// INFO [section ...]
+func (d *StandaloneDatabase) execInfo(args [][]byte) resp.Reply {
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		section := strings.ToLower(string(arg))
+		switch section {
+		case "all", "default", "everything":
+			for _, name := range infoSections {
+				wanted[name] = true
+			}
+		default:
+			wanted[section] = true
+		}
+	}
+	if len(args) == 0 {
+		for _, name := range infoSections {
+			wanted[name] = true
+		}
+	}
+	var sb strings.Builder
+	for _, name := range infoSections {
+		if !wanted[name] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# " + strings.ToUpper(name[:1]) + name[1:] + "\r\n")
+		for _, line := range d.infoSection(name) {
+			sb.WriteString(line + "\r\n")
+		}
+	}
+	return reply.MakeVerbatimReply("txt", []byte(sb.String()))
+}
+
+func (d *StandaloneDatabase) infoSection(name string) []string {
+	switch name {
+	case "server":
+		mode := "standalone"
+		if config.ClusterEnabled() {
+			mode = "cluster"
+		}
+		uptime := int64(time.Since(startTime).Seconds())
+		return []string{
+			"redis_version:" + serverVersion,
+			"redis_mode:" + mode,
+			"arch_bits:64",
+			"process_id:" + strconv.Itoa(os.Getpid()),
+			"run_id:" + runID,
+			"tcp_port:" + strconv.Itoa(config.Properties().Port),
+			"uptime_in_seconds:" + strconv.FormatInt(uptime, 10),
+			"uptime_in_days:" + strconv.FormatInt(uptime/86400, 10),
+			"config_file:" + config.ConfigFile,
+		}
+	case "memory":
+		return []string{
+			"used_memory:" + strconv.FormatInt(d.usedMemory(), 10),
+			"maxmemory:" + strconv.Itoa(config.Properties().MaxMemory),
+			"maxmemory_policy:" + config.Properties().MaxMemoryPolicy,
+		}
+	case "stats":
+		return []string{
+			"total_commands_processed:" + strconv.FormatInt(stats.totalCommands.Load(), 10),
+		}
+	case "replication":
+		return d.replicationInfo()
+	case "keyspace":
+		lines := make([]string, 0)
+		for i, db := range d.dbSet {
+			keys := db.data.Len()
+			if keys == 0 {
+				continue
+			}
+			lines = append(lines, "db"+strconv.Itoa(i)+":keys="+strconv.Itoa(keys)+
+				",expires="+strconv.Itoa(db.ttlMap.Len())+",avg_ttl=0")
+		}
+		return lines
+	}
+	return nil
+}
+
+func init() {
+	registerServerCommand("info", -1).
+		attachCommandExtra([]string{"loading", "stale"}, 0, 0, 0).
+		attachDocs("server", "Returns information and statistics about the server.")
+}
@@ -1,14 +1,17 @@
 package database
 
 import (
+	"go_redis/interface/database"
 	"go_redis/interface/resp"
 	"go_redis/lib/utils"
 	"go_redis/lib/wildcard"
 	"go_redis/resp/reply"
+	"strconv"
+	"strings"
 )
 
 // 处理键相关的命令
-// DEL EXISTS KEYS FLUSH TYPE RENAME RENAMENX
+// DEL EXISTS KEYS FLUSH TYPE RENAME RENAMENX TOUCH DBSIZE RANDOMKEY SCAN
 
 // DEl
 func execDel(db *DB, args [][]byte) resp.Reply {
@@ -53,12 +56,20 @@ func execType(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeStatusReply("none") // none\r\n
 	}
+	if typ := entityType(entity); typ != "" {
+		return reply.MakeStatusReply(typ) // string\r\n
+	}
+	return &reply.UnknowErrReply{}
+}
+
+// entityType 返回 TYPE 命令使用的类型名，未知类型返回空串
+func entityType(entity *database.DataEntity) string {
 	switch entity.Data.(type) {
 	case []byte:
-		return reply.MakeStatusReply("string") // string\r\n
+		return "string"
 	}
 	//TODO:实现其他数据结构
-	return &reply.UnknowErrReply{}
+	return ""
 }
 
 // RENAME
@@ -69,8 +80,7 @@ func execRename(db *DB, args [][]byte) resp.Reply {
 	if !exists {
 		return reply.MakeErrReply("no such key")
 	}
-	db.data.Put(newKey, entity)
-	db.data.Remove(oldKey)
+	moveEntity(db, oldKey, newKey, entity)
 	db.addAof(utils.ToCmdLine3("rename", args...))
 	return reply.MakeOkReply()
 }
@@ -87,18 +97,28 @@ func execRenamenx(db *DB, args [][]byte) resp.Reply {
 	if !exists2 {
 		return reply.MakeErrReply("no such key")
 	}
-	db.data.Put(newKey, entity)
-	db.data.Remove(oldKey)
+	moveEntity(db, oldKey, newKey, entity)
 	db.addAof(utils.ToCmdLine3("renamenx", args...))
 	return reply.MakeIntReply(1) // 成功
 }
 
+// moveEntity 把 entity 连同过期时间从 oldKey 移到 newKey
+func moveEntity(db *DB, oldKey, newKey string, entity *database.DataEntity) {
+	expireTime, hasTTL := db.ttlOf(oldKey)
+	db.Remove(oldKey)
+	db.PutEntity(newKey, entity)
+	db.Persist(newKey)
+	if hasTTL {
+		db.Expire(newKey, expireTime)
+	}
+}
+
 // KEYS，KEYS *
 func execKeys(db *DB, args [][]byte) resp.Reply {
 	pattern := wildcard.CompilePattern(string(args[0]))
 	result := make([][]byte, 0)
 	db.data.ForEach(func(key string, value interface{}) bool {
-		if pattern.IsMatch(key) {
+		if pattern.IsMatch(key) && !db.IsExpired(key) {
 			result = append(result, []byte(key))
 		}
 		return true
@@ -106,12 +126,122 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeMultiBulkReply(result)
 }
 
+// TOUCH，返回存在的 key 个数，读取时会更新访问时间
+func execTouch(db *DB, args [][]byte) resp.Reply {
+	return execExists(db, args)
+}
+
+// DBSIZE
+func execDBSize(db *DB, args [][]byte) resp.Reply {
+	return reply.MakeIntReply(int64(db.data.Len()))
+}
+
+// randomKeyAttempts RANDOMKEY 跳过已过期 key 的最大重试次数
+const randomKeyAttempts = 100
+
+// RANDOMKEY
+func execRandomKey(db *DB, args [][]byte) resp.Reply {
+	for i := 0; i < randomKeyAttempts; i++ {
+		keys := db.data.RandomKeys(1)
+		if len(keys) == 0 {
+			return reply.MakeNullBulkReply()
+		}
+		if !db.IsExpired(keys[0]) {
+			return reply.MakeBulkReply([]byte(keys[0]))
+		}
+	}
+	return reply.MakeNullBulkReply()
+}
+
+const defaultScanCount = 10
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db *DB, args [][]byte) resp.Reply {
+	cursor, err := strconv.Atoi(string(args[0]))
+	if err != nil || cursor < 0 {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	var pattern *wildcard.Pattern
+	count := defaultScanCount
+	typ := ""
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return reply.MakeSyntaxErrReply()
+		}
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = wildcard.CompilePattern(value)
+		case "count":
+			count, err = strconv.Atoi(value)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return reply.MakeSyntaxErrReply()
+			}
+		case "type":
+			typ = strings.ToLower(value)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	result := make([][]byte, 0, count)
+	next := db.data.Scan(cursor, count, func(key string, value interface{}) bool {
+		if pattern != nil && !pattern.IsMatch(key) {
+			return true
+		}
+		if typ != "" {
+			entity, ok := value.(*database.DataEntity)
+			if !ok || entityType(entity) != typ {
+				return true
+			}
+		}
+		if !db.IsExpired(key) {
+			result = append(result, []byte(key))
+		}
+		return true
+	})
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.Itoa(next))),
+		reply.MakeMultiBulkReply(result),
+	})
+}
+
 func init() {
-	RegisterCommand("del", execDel, -2) // 一个参数是命令，一个参数是键名
-	RegisterCommand("exists", execExists, -2)
-	RegisterCommand("flushdb", execFlushDB, -1) // 变长，后面的参数直接丢弃
-	RegisterCommand("type", execType, 2)
-	RegisterCommand("rename", execRename, 3)
-	RegisterCommand("renamenx", execRenamenx, 3)
-	RegisterCommand("keys", execKeys, 2)
+	// 一个参数是命令，一个参数是键名
+	RegisterCommand("del", execDel, -2).
+		attachCommandExtra([]string{"write"}, 1, -1, 1).
+		attachDocs("generic", "Deletes one or more keys.")
+	RegisterCommand("exists", execExists, -2).
+		attachCommandExtra([]string{"readonly", "fast"}, 1, -1, 1).
+		attachDocs("generic", "Determines whether one or more keys exist.")
+	// 变长，后面的参数直接丢弃
+	RegisterCommand("flushdb", execFlushDB, -1).
+		attachCommandExtra([]string{"write"}, 0, 0, 0).
+		attachDocs("server", "Removes all keys from the current database.")
+	RegisterCommand("type", execType, 2).
+		attachCommandExtra([]string{"readonly", "fast"}, 1, 1, 1).
+		attachDocs("generic", "Determines the type of value stored at a key.")
+	RegisterCommand("rename", execRename, 3).
+		attachCommandExtra([]string{"write"}, 1, 2, 1).
+		attachDocs("generic", "Renames a key and overwrites the destination.")
+	RegisterCommand("renamenx", execRenamenx, 3).
+		attachCommandExtra([]string{"write", "fast"}, 1, 2, 1).
+		attachDocs("generic", "Renames a key only when the target key name doesn't exist.")
+	RegisterCommand("keys", execKeys, 2).
+		attachCommandExtra([]string{"readonly", "sort_for_script"}, 0, 0, 0).
+		attachDocs("generic", "Returns all key names that match a pattern.")
+	RegisterCommand("touch", execTouch, -2).
+		attachCommandExtra([]string{"readonly", "fast"}, 1, -1, 1).
+		attachDocs("generic", "Returns the number of existing keys out of those specified after updating the time they were last accessed.")
+	RegisterCommand("dbsize", execDBSize, 1).
+		attachCommandExtra([]string{"readonly", "fast"}, 0, 0, 0).
+		attachDocs("server", "Returns the number of keys in the database.")
+	RegisterCommand("randomkey", execRandomKey, 1).
+		attachCommandExtra([]string{"readonly"}, 0, 0, 0).
+		attachDocs("generic", "Returns a random key name from the database.")
+	RegisterCommand("scan", execScan, -2).
+		attachCommandExtra([]string{"readonly"}, 0, 0, 0).
+		attachDocs("generic", "Iterates over the key names in the database.")
 }
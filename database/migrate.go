@@ -0,0 +1,241 @@
+package database
+
+import (
+	"bufio"
+	"crypto/tls"
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/rdb"
+	"go_redis/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrateDefaultTimeout timeout 参数不大于 0 时使用的超时时间，与 redis 相同
+const migrateDefaultTimeout = time.Second
+
+// migrateKey 一个待迁移的 key
+type migrateKey struct {
+	key   string
+	value []byte
+	ttl   int64 // 剩余的毫秒数，0 表示没有过期时间
+}
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+// 把 key 以 RESTORE 的方式发送到目标实例，全部成功后删除本地的 key（COPY 时保留）。
+// 整个过程持有 writeMu 写锁，DUMP 到删除之间 key 不会被其他命令修改
+func (d *StandaloneDatabase) execMigrate(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 5 {
+		return reply.MakeArgNumErrReply("migrate")
+	}
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	destDB, err := strconv.Atoi(string(args[3]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = migrateDefaultTimeout
+	}
+	copyKeys, replace := false, false
+	var auth [][]byte
+	keys := [][]byte{args[2]}
+	for i := 5; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "copy":
+			copyKeys = true
+		case option == "replace":
+			replace = true
+		case option == "auth" && i+1 < len(args):
+			auth = utils.ToCmdLine3("auth", args[i+1])
+			i++
+		case option == "auth2" && i+2 < len(args):
+			auth = utils.ToCmdLine3("auth", args[i+1], args[i+2])
+			i += 2
+		case option == "keys":
+			if len(args[2]) != 0 {
+				return reply.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	db := d.dbSet[c.GetDBIndex()]
+	items := db.collectMigrateKeys(keys)
+	if len(items) == 0 {
+		return reply.MakeStatusReply("NOKEY")
+	}
+	failed, errReply := migrateTo(net.JoinHostPort(host, strconv.Itoa(port)), auth, destDB, items, replace, timeout)
+	if !copyKeys {
+		// 已经成功还原的 key 即使有其他 key 失败也要删除，否则两边各有一份
+		for _, item := range items {
+			if failed[item.key] {
+				continue
+			}
+			if db.remove(item.key) > 0 {
+				db.addAof(utils.ToCmdLine("del", item.key))
+			}
+		}
+	}
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeOkReply()
+}
+
+// collectMigrateKeys 取出存在且未过期的 key 及其剩余过期时间
+func (db *DB) collectMigrateKeys(keys [][]byte) []migrateKey {
+	items := make([]migrateKey, 0, len(keys))
+	now := time.Now()
+	for _, raw := range keys {
+		key := string(raw)
+		entity, exists := db.GetEntity(key)
+		if !exists {
+			continue
+		}
+		value, ok := entity.Data.([]byte)
+		if !ok {
+			continue
+		}
+		var ttl int64
+		if expireTime, ok := db.ttlOf(key); ok {
+			ttl = max(expireTime.Sub(now).Milliseconds(), 1)
+		}
+		items = append(items, migrateKey{key: key, value: value, ttl: ttl})
+	}
+	return items
+}
+
+// migrateTo 连接目标实例，一次性发送 AUTH、SELECT 和全部 RESTORE 后依次读取回复。
+// 返回还原失败的 key；连接出错时所有 key 都视为失败
+func migrateTo(addr string, auth [][]byte, destDB int, items []migrateKey, replace bool, timeout time.Duration) (map[string]bool, resp.Reply) {
+	failed := make(map[string]bool)
+	markAllFailed := func() {
+		for _, item := range items {
+			failed[item.key] = true
+		}
+	}
+	conn, err := dialMigrateTarget(addr, timeout)
+	if err != nil {
+		markAllFailed()
+		return failed, reply.MakeErrReply("IOERR error or timeout connecting to the client")
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	restoreCmd := "restore"
+	if config.ClusterEnabled() {
+		restoreCmd = "restore-asking"
+	}
+	var buf []byte
+	if auth != nil {
+		buf = append(buf, reply.MakeMultiBulkReply(auth).ToBytes()...)
+	}
+	buf = append(buf, reply.MakeMultiBulkReply(utils.ToCmdLine("select", strconv.Itoa(destDB))).ToBytes()...)
+	for _, item := range items {
+		line := utils.ToCmdLine(restoreCmd, item.key, strconv.FormatInt(item.ttl, 10))
+		line = append(line, rdb.DumpString(item.value))
+		if replace {
+			line = append(line, []byte("REPLACE"))
+		}
+		buf = append(buf, reply.MakeMultiBulkReply(line).ToBytes()...)
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(buf); err != nil {
+		markAllFailed()
+		return failed, reply.MakeErrReply("IOERR error or timeout writing to target instance")
+	}
+
+	br := bufio.NewReader(conn)
+	readReply := func() (string, resp.Reply) {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		line, err := readStatusLine(br)
+		if err != nil {
+			return "", reply.MakeErrReply("IOERR error or timeout reading to target instance")
+		}
+		return line, nil
+	}
+	if auth != nil {
+		line, errReply := readReply()
+		if errReply == nil && strings.HasPrefix(line, "-") {
+			errReply = reply.MakeErrReply("ERR Target instance replied with error: " + line[1:])
+		}
+		if errReply != nil {
+			markAllFailed()
+			return failed, errReply
+		}
+	}
+	line, errReply := readReply()
+	if errReply == nil && strings.HasPrefix(line, "-") {
+		errReply = reply.MakeErrReply("ERR Target instance replied with error: " + line[1:])
+	}
+	if errReply != nil {
+		markAllFailed()
+		return failed, errReply
+	}
+	var firstErr resp.Reply
+	for i, item := range items {
+		line, errReply := readReply()
+		if errReply != nil {
+			for _, rest := range items[i:] {
+				failed[rest.key] = true
+			}
+			return failed, errReply
+		}
+		if strings.HasPrefix(line, "-") {
+			failed[item.key] = true
+			if firstErr == nil {
+				firstErr = reply.MakeErrReply("ERR Target instance replied with error: " + line[1:])
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// dialMigrateTarget 集群节点之间使用 TLS 时 MIGRATE 也使用 TLS，与 redis 相同
+func dialMigrateTarget(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !config.Properties().TlsCluster {
+		return dialer.Dial("tcp", addr)
+	}
+	tlsConfig, err := config.ClientTLSConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+}
+
+// CountKeysInSlot 所有 DB 中属于该槽位的 key 个数，只在集群模式下可用
+func (d *StandaloneDatabase) CountKeysInSlot(s int) int {
+	count := 0
+	for _, db := range d.dbSet {
+		if db.slots != nil {
+			count += db.slots.count(s)
+		}
+	}
+	return count
+}
+
+func init() {
+	registerServerCommand("migrate", -6).
+		attachCommandExtra([]string{"write"}, 3, 3, 1).
+		attachDocs("generic", "Atomically transfers a key from one Redis instance to another.")
+}
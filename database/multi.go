@@ -0,0 +1,152 @@
+package database
+
+import (
+	"errors"
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/rdb"
+	"go_redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// MULTI / EXEC / DISCARD
+// 事务中的命令先放在连接的队列里，EXEC 时持有 writeMu 写锁依次执行，其他连接的写命令不会穿插其中。
+// 入队时命令不存在或参数个数不对，EXEC 放弃整个事务；执行时某条命令出错不影响其他命令，与 redis 相同。
+// 读命令不加锁，可能读到执行到一半的事务
+
+// MULTI
+func execMulti(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("multi")
+	}
+	if c.InMultiState() {
+		return reply.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	c.SetMultiState(true)
+	return reply.MakeOkReply()
+}
+
+// DISCARD
+func execDiscard(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("discard")
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	c.SetMultiState(false)
+	return reply.MakeOkReply()
+}
+
+// EXEC
+func (d *StandaloneDatabase) execExec(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("exec")
+	}
+	if !c.InMultiState() {
+		return reply.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer c.SetMultiState(false)
+	if len(c.GetTxErrors()) > 0 {
+		return reply.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return d.ExecMulti(c, c.GetQueuedCmdLine())
+}
+
+// EnqueueCmd 检查命令能否在事务中执行，可以时放入连接的队列并返回 QUEUED，否则记录错误
+func EnqueueCmd(c resp.Connection, cmdLine CmdLine) resp.Reply {
+	name := strings.ToLower(string(cmdLine[0]))
+	cmd := lookupCommand(name)
+	var errReply resp.Reply
+	switch {
+	case cmd == nil:
+		errReply = reply.MakeErrReply("ERR unknown command '" + name + "'")
+	case !validateArity(cmd.arity, cmdLine):
+		errReply = reply.MakeArgNumErrReply(name)
+	case !allowedInMulti(name, cmd):
+		errReply = reply.MakeErrReply("ERR Command not allowed inside a transaction")
+	}
+	if errReply != nil {
+		c.AddTxError(errors.New(strings.TrimSpace(string(errReply.ToBytes()))))
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return reply.MakeQueuedReply()
+}
+
+// allowedInMulti 数据命令以及 SELECT、FLUSHALL 可以在事务中执行，其他服务器命令不可以
+func allowedInMulti(name string, cmd *command) bool {
+	return cmd.exector != nil || name == "select" || name == "flushall"
+}
+
+// ExecMulti 持有 writeMu 写锁依次执行 cmdLines，返回每条命令的结果
+func (d *StandaloneDatabase) ExecMulti(c resp.Connection, cmdLines []CmdLine) resp.Reply {
+	if d.repl.isReplica() && config.Properties().ReplicaReadOnly {
+		for _, line := range cmdLines {
+			if cmd := lookupCommand(string(line[0])); cmd != nil && cmd.hasFlag(flagWrite) {
+				return reply.MakeErrReply("READONLY You can't write against a read only replica.")
+			}
+		}
+	}
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	if d.repl.active.Load() {
+		defer func() {
+			c.SetWriteOffset(d.repl.currentOffset())
+		}()
+	}
+	replies := make([]resp.Reply, len(cmdLines))
+	for i, line := range cmdLines {
+		replies[i] = d.execQueued(c, line)
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// execQueued 执行事务中的一条命令，调用方已经持有 writeMu
+func (d *StandaloneDatabase) execQueued(c resp.Connection, line CmdLine) resp.Reply {
+	switch strings.ToLower(string(line[0])) {
+	case "select":
+		return execSelect(c, d, line[1:])
+	case "flushall":
+		return d.execFlushAll()
+	}
+	return d.dbSet[c.GetDBIndex()].Exec(c, line)
+}
+
+// UndoLogs 返回把 keys 恢复到当前状态的命令：存在的 key 用 RESTORE ... ABSTTL REPLACE 还原，不存在的 key 删除
+func (d *StandaloneDatabase) UndoLogs(dbIndex int, keys []string) []CmdLine {
+	db := d.dbSet[dbIndex]
+	logs := make([]CmdLine, 0, len(keys))
+	for _, key := range keys {
+		entity, exists := db.GetEntity(key)
+		if !exists {
+			logs = append(logs, utils.ToCmdLine("del", key))
+			continue
+		}
+		value, ok := entity.Data.([]byte)
+		if !ok {
+			continue
+		}
+		var expireAt int64 // 0 表示没有过期时间
+		if expireTime, ok := db.ttlOf(key); ok {
+			expireAt = expireTime.UnixMilli()
+		}
+		logs = append(logs, utils.ToCmdLine3("restore", []byte(key), []byte(strconv.FormatInt(expireAt, 10)),
+			rdb.DumpString(value), []byte("REPLACE"), []byte("ABSTTL")))
+	}
+	return logs
+}
+
+func init() {
+	registerServerCommand("multi", 1).
+		attachCommandExtra([]string{"loading", "stale", "fast"}, 0, 0, 0).
+		attachDocs("transactions", "Starts a transaction.")
+	registerServerCommand("exec", 1).
+		attachCommandExtra([]string{"loading", "stale"}, 0, 0, 0).
+		attachDocs("transactions", "Executes all commands in a transaction.")
+	registerServerCommand("discard", 1).
+		attachCommandExtra([]string{"loading", "stale", "fast"}, 0, 0, 0).
+		attachDocs("transactions", "Discards a transaction.")
+}
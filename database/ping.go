@@ -12,5 +12,7 @@ func Ping(db *DB, args [][]byte) resp.Reply {
 func init() {
 	// 注册PING命令，在包初始化的时候会调用init函数
 	// 这样可以确保PING命令在数据库启动时就可用
-	RegisterCommand("ping", Ping, 1)
+	RegisterCommand("ping", Ping, 1).
+		attachCommandExtra([]string{"fast"}, 0, 0, 0).
+		attachDocs("connection", "Returns the server's liveliness response.")
 }
@@ -0,0 +1,63 @@
+package database
+
+// replBacklog 复制积压缓冲区：保存最近写入复制流的数据，
+// 从节点断线重连后可以从中补发缺失的部分，而不必重新全量同步
+
+type replBacklog struct {
+	buf         []byte
+	idx         int   // 下一个字节写入的位置
+	histLen     int   // 缓冲区中有效数据的长度
+	firstOffset int64 // 缓冲区中第一个字节在复制流中的偏移量
+}
+
+// makeReplBacklog offset 为复制流当前的偏移量，下一个写入的字节偏移量为 offset+1
+func makeReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{
+		buf:         make([]byte, size),
+		firstOffset: offset + 1,
+	}
+}
+
+// write 写入数据，缓冲区满后覆盖最早的数据
+func (b *replBacklog) write(p []byte) {
+	size := len(b.buf)
+	if len(p) > size {
+		// 超过缓冲区大小的部分不可能被读到，只保留末尾
+		b.firstOffset += int64(b.histLen + len(p) - size)
+		p = p[len(p)-size:]
+		b.histLen = 0
+	}
+	for len(p) > 0 {
+		n := copy(b.buf[b.idx:], p)
+		b.idx = (b.idx + n) % size
+		p = p[n:]
+		b.histLen += n
+	}
+	if b.histLen > size {
+		b.firstOffset += int64(b.histLen - size)
+		b.histLen = size
+	}
+}
+
+// endOffset 最后一个字节的偏移量
+func (b *replBacklog) endOffset() int64 {
+	return b.firstOffset + int64(b.histLen) - 1
+}
+
+// contains 判断从 offset 开始的数据是否都还在缓冲区中，offset 为 endOffset+1 表示没有缺失
+func (b *replBacklog) contains(offset int64) bool {
+	return offset >= b.firstOffset && offset <= b.endOffset()+1
+}
+
+// readFrom 复制从 offset 开始到末尾的数据，调用前需要用 contains 检查
+func (b *replBacklog) readFrom(offset int64) []byte {
+	n := int(b.endOffset() - offset + 1)
+	result := make([]byte, n)
+	size := len(b.buf)
+	start := (b.idx - n + size) % size
+	copied := copy(result, b.buf[start:])
+	if copied < n {
+		copy(result[copied:], b.buf[:n-copied])
+	}
+	return result
+}
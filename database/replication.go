@@ -0,0 +1,259 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 主从复制的公共状态：复制 ID、偏移量、积压缓冲区和从节点列表。
+// 主节点把写命令追加到复制流，从节点原样转发从主节点收到的复制流，
+// 因此从节点同样可以有自己的从节点
+
+type replication struct {
+	mu sync.Mutex // 保护以下字段
+
+	replID       string // 当前复制流的 ID
+	replID2      string // 上一个复制流的 ID，从节点晋升后用于接受旧主节点的从节点部分重同步
+	secondOffset int64  // replID2 有效的最大偏移量，-1 表示无效
+	offset       int64  // 复制流当前的偏移量，即 master_repl_offset
+	backlog      *replBacklog
+	streamDB     int // 复制流中最近一次 SELECT 的 DB，-1 表示下一条命令前需要 SELECT
+	replicas     map[resp.Connection]*replicaInfo
+	lastPing     time.Time
+	ackNotify    chan struct{} // 收到 ACK 时关闭并替换，用于唤醒 WAIT
+
+	master *masterLink // 不为 nil 时本节点是从节点
+
+	active atomic.Bool // 已创建积压缓冲区，写命令需要串行执行
+}
+
+// 从节点连接的状态，与 redis INFO 中的取值一致
+const (
+	replicaStateHandshake = "handshake" // 已发送 REPLCONF，还没有 PSYNC
+	replicaStateOnline    = "online"
+)
+
+// replicaInfo 主节点记录的从节点信息
+type replicaInfo struct {
+	conn          resp.Connection
+	state         string
+	ip            string
+	listeningPort int
+	ackOffset     int64 // REPLCONF ACK 上报的偏移量
+	ackTime       time.Time
+}
+
+func makeReplication() *replication {
+	return &replication{
+		replID:       newReplID(),
+		secondOffset: -1,
+		streamDB:     -1,
+		replicas:     make(map[resp.Connection]*replicaInfo),
+		ackNotify:    make(chan struct{}),
+	}
+}
+
+// newReplID 随机生成 40 个十六进制字符的复制 ID
+func newReplID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// isReplica 本节点当前是否为从节点
+func (r *replication) isReplica() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.master != nil
+}
+
+// createBacklogIfNeeded 第一个从节点连接时创建积压缓冲区，调用方持有 mu
+func (r *replication) createBacklogIfNeeded() {
+	if r.backlog != nil {
+		return
+	}
+	size := config.Properties().ReplBacklogSize
+	if size <= 0 {
+		size = 1024 * 1024
+	}
+	r.backlog = makeReplBacklog(size, r.offset)
+	r.active.Store(true)
+}
+
+// feedRaw 把数据追加到复制流并发送给在线的从节点，调用方持有 mu
+func (r *replication) feedRaw(data []byte) {
+	if r.backlog == nil {
+		return
+	}
+	r.backlog.write(data)
+	r.offset += int64(len(data))
+	for conn, info := range r.replicas {
+		if info.state != replicaStateOnline {
+			continue
+		}
+		// 超过输出缓冲区限制时连接已被关闭，连接关闭后会从列表中移除
+		_ = conn.WriteAsync(data)
+	}
+}
+
+// shiftReplID 从节点晋升为主节点时更换复制 ID，旧 ID 记为 replID2，
+// 原主节点的其他从节点仍然可以凭旧 ID 做部分重同步，调用方持有 mu
+func (r *replication) shiftReplID() {
+	r.replID2 = r.replID
+	r.secondOffset = r.offset + 1
+	r.replID = newReplID()
+}
+
+// disconnectReplicas 断开所有从节点，复制 ID 变化后让它们重新同步，调用方持有 mu。
+// 立即移出列表，保证之后的复制流不会再发给它们；关闭连接会等待正在进行的写，异步关闭避免持锁等待
+func (r *replication) disconnectReplicas() {
+	for conn := range r.replicas {
+		delete(r.replicas, conn)
+		go conn.Close()
+	}
+}
+
+// onlineReplicas 返回在线的从节点，调用方持有 mu
+func (r *replication) onlineReplicas() []*replicaInfo {
+	result := make([]*replicaInfo, 0, len(r.replicas))
+	for _, info := range r.replicas {
+		if info.state == replicaStateOnline {
+			result = append(result, info)
+		}
+	}
+	return result
+}
+
+// currentOffset 复制流当前的偏移量
+func (r *replication) currentOffset() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.offset
+}
+
+// ROLE
+func (d *StandaloneDatabase) execRole() resp.Reply {
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if link := r.master; link != nil {
+		return reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("slave")),
+			reply.MakeBulkReply([]byte(link.host)),
+			reply.MakeIntReply(int64(link.port)),
+			reply.MakeBulkReply([]byte(link.getState())),
+			reply.MakeIntReply(r.offset),
+		})
+	}
+	replicas := make([]resp.Reply, 0)
+	for _, info := range r.onlineReplicas() {
+		replicas = append(replicas, reply.MakeMultiBulkReply([][]byte{
+			[]byte(info.ip),
+			[]byte(strconv.Itoa(info.listeningPort)),
+			[]byte(strconv.FormatInt(info.ackOffset, 10)),
+		}))
+	}
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("master")),
+		reply.MakeIntReply(r.offset),
+		reply.MakeMultiRawReply(replicas),
+	})
+}
+
+// replicationInfo INFO replication 的内容
+func (d *StandaloneDatabase) replicationInfo() []string {
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := make([]string, 0)
+	if link := r.master; link != nil {
+		lines = append(lines,
+			"role:slave",
+			"master_host:"+link.host,
+			"master_port:"+strconv.Itoa(link.port))
+		state := link.getState()
+		status := "down"
+		if state == masterLinkConnected {
+			status = "up"
+		}
+		lastIO := int64(-1)
+		if last := link.lastIO.Load(); last > 0 {
+			lastIO = int64(time.Since(time.Unix(0, last)).Seconds())
+		}
+		syncing := "0"
+		if state == masterLinkSync {
+			syncing = "1"
+		}
+		readOnly := "0"
+		if config.Properties().ReplicaReadOnly {
+			readOnly = "1"
+		}
+		lines = append(lines,
+			"master_link_status:"+status,
+			"master_last_io_seconds_ago:"+strconv.FormatInt(lastIO, 10),
+			"master_sync_in_progress:"+syncing,
+			"slave_read_repl_offset:"+strconv.FormatInt(r.offset, 10),
+			"slave_repl_offset:"+strconv.FormatInt(r.offset, 10),
+			"slave_priority:100",
+			"slave_read_only:"+readOnly,
+			"replica_announced:1")
+	} else {
+		lines = append(lines, "role:master")
+	}
+	online := r.onlineReplicas()
+	lines = append(lines, "connected_slaves:"+strconv.Itoa(len(online)))
+	for i, info := range online {
+		lag := int64(time.Since(info.ackTime).Seconds())
+		lines = append(lines, "slave"+strconv.Itoa(i)+":ip="+info.ip+
+			",port="+strconv.Itoa(info.listeningPort)+
+			",state="+info.state+
+			",offset="+strconv.FormatInt(info.ackOffset, 10)+
+			",lag="+strconv.FormatInt(lag, 10))
+	}
+	backlogActive, backlogFirst, backlogHist := "0", int64(0), 0
+	if r.backlog != nil {
+		backlogActive = "1"
+		backlogFirst = r.backlog.firstOffset
+		backlogHist = r.backlog.histLen
+	}
+	lines = append(lines,
+		"master_failover_state:no-failover",
+		"master_replid:"+r.replID,
+		"master_replid2:"+replID2OrZero(r.replID2),
+		"master_repl_offset:"+strconv.FormatInt(r.offset, 10),
+		"second_repl_offset:"+strconv.FormatInt(r.secondOffset, 10),
+		"repl_backlog_active:"+backlogActive,
+		"repl_backlog_size:"+strconv.Itoa(config.Properties().ReplBacklogSize),
+		"repl_backlog_first_byte_offset:"+strconv.FormatInt(backlogFirst, 10),
+		"repl_backlog_histlen:"+strconv.Itoa(backlogHist))
+	return lines
+}
+
+// replID2OrZero 没有 replID2 时与 redis 一样显示 40 个 0
+func replID2OrZero(id string) string {
+	if id == "" {
+		return strings.Repeat("0", 40)
+	}
+	return id
+}
+
+// hostOf 取出地址中的 IP 部分
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
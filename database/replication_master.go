@@ -0,0 +1,324 @@
+package database
+
+import (
+	"bytes"
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/logger"
+	"go_redis/lib/utils"
+	"go_redis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 主节点：响应从节点的 REPLCONF、PSYNC，把写命令追加到复制流
+
+var (
+	pingCmdBytes   = reply.MakeMultiBulkReply(utils.ToCmdLine("ping")).ToBytes()
+	getAckCmdBytes = reply.MakeMultiBulkReply(utils.ToCmdLine("replconf", "getack", "*")).ToBytes()
+)
+
+// lockWrite 写命令执行前加锁，返回解锁函数。
+// 开启复制后写命令串行执行，保证复制流中命令的顺序与执行顺序一致；
+// 未开启时写命令之间可以并发，只与生成快照互斥
+func (d *StandaloneDatabase) lockWrite() func() {
+	for {
+		if d.repl.active.Load() {
+			d.writeMu.Lock()
+			return d.writeMu.Unlock
+		}
+		d.writeMu.RLock()
+		if !d.repl.active.Load() {
+			return d.writeMu.RUnlock
+		}
+		// 加锁期间开启了复制，改为加写锁
+		d.writeMu.RUnlock()
+	}
+}
+
+// feedCommand 主节点把写命令追加到复制流，DB 变化时先追加 SELECT。
+// 从节点原样转发主节点的复制流，这里不做任何事
+func (r *replication) feedCommand(dbIndex int, line CmdLine) {
+	if !r.active.Load() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != nil || r.backlog == nil {
+		return
+	}
+	if dbIndex != r.streamDB {
+		r.feedRaw(reply.MakeMultiBulkReply(utils.ToCmdLine("select", strconv.Itoa(dbIndex))).ToBytes())
+		r.streamDB = dbIndex
+	}
+	r.feedRaw(reply.MakeMultiBulkReply(line).ToBytes())
+}
+
+// replicaInfoOf 返回连接对应的从节点信息，不存在时创建，调用方持有 mu
+func (r *replication) replicaInfoOf(c resp.Connection) *replicaInfo {
+	info, ok := r.replicas[c]
+	if !ok {
+		info = &replicaInfo{
+			conn:  c,
+			state: replicaStateHandshake,
+			ip:    hostOf(c.RemoteAddr()),
+		}
+		r.replicas[c] = info
+	}
+	return info
+}
+
+// removeReplica 连接关闭时移出从节点列表
+func (r *replication) removeReplica(c resp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.replicas, c)
+}
+
+// canPartialResync 判断能否从 offset 开始部分重同步：复制 ID 相同，
+// 或者是晋升前的复制 ID 且 offset 不超过切换时的位置，并且缺失的数据都还在积压缓冲区中。调用方持有 mu
+func (r *replication) canPartialResync(replID string, offset int64) bool {
+	if r.backlog == nil {
+		return false
+	}
+	if replID != r.replID && (replID != r.replID2 || offset > r.secondOffset) {
+		return false
+	}
+	return r.backlog.contains(offset)
+}
+
+// REPLCONF listening-port <port>
+// REPLCONF ip-address <ip>
+// REPLCONF capa eof capa psync2
+// REPLCONF ACK <offset>
+// REPLCONF GETACK *
+func (d *StandaloneDatabase) execReplConf(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(args) >= 2 && strings.EqualFold(string(args[0]), "ack") {
+		// REPLCONF ACK <offset> [FACK <aofoffset>]，从节点每秒上报一次，不需要回复
+		if info, ok := r.replicas[c]; ok && info.state == replicaStateOnline {
+			if offset, err := strconv.ParseInt(string(args[1]), 10, 64); err == nil && offset > info.ackOffset {
+				info.ackOffset = offset
+				close(r.ackNotify)
+				r.ackNotify = make(chan struct{})
+			}
+			info.ackTime = time.Now()
+		}
+		return reply.MakeNoReply()
+	}
+	if len(args) == 2 && strings.EqualFold(string(args[0]), "getack") {
+		// 只有主节点发给从节点的 GETACK 才需要处理，见 applyFromMaster
+		return reply.MakeNoReply()
+	}
+	info := r.replicaInfoOf(c)
+	for i := 0; i < len(args); i += 2 {
+		option := strings.ToLower(string(args[i]))
+		value := string(args[i+1])
+		switch option {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			info.listeningPort = port
+		case "ip-address":
+			info.ip = value
+		case "capa":
+			// eof、psync2 等能力：快照总是以 $<len> 格式发送，PSYNC 总是按 psync2 回复，无需记录
+		default:
+			return reply.MakeErrReply("ERR Unrecognized REPLCONF option: " + string(args[i]))
+		}
+	}
+	return reply.MakeOkReply()
+}
+
+// PSYNC <replid> <offset>
+func (d *StandaloneDatabase) execPSync(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("psync")
+	}
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return d.syncReplica(c, string(args[0]), offset, true)
+}
+
+// SYNC 旧版本的全量同步命令，不支持部分重同步
+func (d *StandaloneDatabase) execSync(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 0 {
+		return reply.MakeArgNumErrReply("sync")
+	}
+	return d.syncReplica(c, "?", -1, false)
+}
+
+// syncReplica 能部分重同步时补发积压缓冲区中的数据，否则生成快照全量同步。
+// 之后复制流通过发送队列异步写给从节点，该连接发来的命令不再回复
+func (d *StandaloneDatabase) syncReplica(c resp.Connection, replID string, offset int64, psync bool) resp.Reply {
+	if c.IsReplica() {
+		return reply.MakeNoReply()
+	}
+	if link := d.masterLinkOf(); link != nil && link.getState() != masterLinkConnected {
+		return reply.MakeErrReply("NOMASTERLINK Can't SYNC while not connected with my master")
+	}
+	// 生成快照期间阻止写命令，保证快照与复制偏移量一致
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := r.replicaInfoOf(c)
+	c.SetReplica(true)
+	c.SetOutputClass(config.ClientClassReplica)
+	if psync && r.canPartialResync(replID, offset) {
+		_ = c.WriteAsync([]byte("+CONTINUE " + r.replID + "\r\n"))
+		_ = c.WriteAsync(r.backlog.readFrom(offset))
+		info.state = replicaStateOnline
+		info.ackOffset = offset - 1
+		info.ackTime = time.Now()
+		logger.Info("partial resynchronization with replica " + info.ip + " accepted, sending " +
+			strconv.FormatInt(r.offset-offset+1, 10) + " bytes of backlog")
+		return reply.MakeNoReply()
+	}
+
+	r.createBacklogIfNeeded()
+	streamDB := r.streamDB
+	if link := r.master; link != nil {
+		// 从节点的复制流所在 DB 由主节点决定
+		streamDB = link.client.GetDBIndex()
+	}
+	var buf bytes.Buffer
+	if err := d.writeSnapshot(&buf, r.replID, r.offset, streamDB); err != nil {
+		logger.Error("generate RDB for replica failed:", err)
+		delete(r.replicas, c)
+		_ = c.Close()
+		return reply.MakeNoReply()
+	}
+	if psync {
+		_ = c.WriteAsync([]byte("+FULLRESYNC " + r.replID + " " + strconv.FormatInt(r.offset, 10) + "\r\n"))
+	}
+	_ = c.WriteBulkAsync([]byte("$" + strconv.Itoa(buf.Len()) + "\r\n"))
+	_ = c.WriteBulkAsync(buf.Bytes())
+	if r.master == nil {
+		// 快照中记录的 DB 不一定是复制流接下来使用的 DB，下一条命令前重新 SELECT
+		r.streamDB = -1
+	}
+	info.state = replicaStateOnline
+	info.ackOffset = r.offset
+	info.ackTime = time.Now()
+	logger.Info("full resynchronization with replica " + info.ip + ", RDB size " + strconv.Itoa(buf.Len()))
+	return reply.MakeNoReply()
+}
+
+// pingReplicas 主节点每隔 repl-ping-replica-period 秒向从节点发送 PING，从节点据此判断连接是否存活
+func (r *replication) pingReplicas() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != nil || len(r.onlineReplicas()) == 0 {
+		return
+	}
+	period := time.Duration(config.Properties().ReplPingReplicaPeriod) * time.Second
+	if time.Since(r.lastPing) < period {
+		return
+	}
+	r.lastPing = time.Now()
+	r.feedRaw(pingCmdBytes)
+}
+
+// countAcked 已确认收到 offset 之前全部数据的从节点个数，调用方持有 mu
+func (r *replication) countAcked(offset int64) int {
+	count := 0
+	for _, info := range r.onlineReplicas() {
+		if info.ackOffset >= offset {
+			count++
+		}
+	}
+	return count
+}
+
+// WAIT numreplicas timeout
+// 阻塞直到至少 numreplicas 个从节点确认收到了该连接之前的所有写命令，或者超时（毫秒，0 表示一直等待），
+// 返回已确认的从节点个数
+func (d *StandaloneDatabase) execWait(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("wait")
+	}
+	if d.repl.isReplica() {
+		return reply.MakeErrReply("ERR WAIT cannot be used with replica instances. Please also note that " +
+			"since Redis 4.0 if a replica is configured to be writable (which is not the default) " +
+			"writes to replicas are just local and are not propagated.")
+	}
+	numReplicas, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return reply.MakeErrReply("ERR timeout is negative")
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	r := d.repl
+	target := c.GetWriteOffset()
+	requested := false
+	var unblock func()
+	for {
+		r.mu.Lock()
+		acked := r.countAcked(target)
+		if acked >= numReplicas {
+			r.mu.Unlock()
+			return reply.MakeIntReply(int64(acked))
+		}
+		if !requested && r.backlog != nil && len(r.onlineReplicas()) > 0 {
+			// 不等从节点每秒一次的 ACK，要求它们立即上报
+			r.feedRaw(getAckCmdBytes)
+			requested = true
+		}
+		notify := r.ackNotify
+		r.mu.Unlock()
+		if unblock == nil {
+			// 需要等待时才继续读取连接，以便发现客户端断开
+			unblock = c.Block()
+			defer unblock()
+		}
+		select {
+		case <-notify:
+		case <-c.Done():
+			// 客户端已断开，回复不会再被读取
+			return reply.MakeIntReply(int64(acked))
+		case <-expired:
+			r.mu.Lock()
+			acked = r.countAcked(target)
+			r.mu.Unlock()
+			return reply.MakeIntReply(int64(acked))
+		}
+	}
+}
+
+func init() {
+	registerServerCommand("wait", 3).
+		attachCommandExtra([]string{"noscript"}, 0, 0, 0).
+		attachDocs("generic", "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.")
+	registerServerCommand("replconf", -1).
+		attachCommandExtra([]string{"admin", "noscript", "loading", "stale"}, 0, 0, 0).
+		attachDocs("server", "An internal command for configuring the replication stream.")
+	registerServerCommand("psync", -3).
+		attachCommandExtra([]string{"admin", "noscript"}, 0, 0, 0).
+		attachDocs("server", "An internal command used in replication.")
+	registerServerCommand("sync", 1).
+		attachCommandExtra([]string{"admin", "noscript"}, 0, 0, 0).
+		attachDocs("server", "An internal command used in replication.")
+}
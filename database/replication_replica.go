@@ -0,0 +1,552 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/logger"
+	"go_redis/lib/utils"
+	"go_redis/resp/connection"
+	"go_redis/resp/parser"
+	"go_redis/resp/reply"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 从节点：连接主节点，握手后通过 PSYNC 全量或部分同步，之后持续执行主节点发来的复制流
+
+// 与主节点连接的状态，与 redis ROLE 中的取值一致
+const (
+	masterLinkConnect    = "connect"    // 等待重连
+	masterLinkConnecting = "connecting" // 正在连接和握手
+	masterLinkSync       = "sync"       // 正在接收快照
+	masterLinkConnected  = "connected"  // 正在接收复制流
+)
+
+var errLinkStopped = errors.New("replication stopped")
+
+type masterLink struct {
+	host   string
+	port   int
+	state  atomic.Value // string
+	lastIO atomic.Int64 // 最近一次收到主节点数据的时间，unix 纳秒
+	client *connection.Connection
+
+	mu      sync.Mutex // 保护 conn 和 stopped
+	conn    net.Conn
+	stopped bool
+	done    chan struct{}
+	writeMu sync.Mutex // 握手和 REPLCONF ACK 可能并发写
+}
+
+func makeMasterLink(host string, port int) *masterLink {
+	link := &masterLink{
+		host:   host,
+		port:   port,
+		client: &connection.Connection{},
+		done:   make(chan struct{}),
+	}
+	link.client.SetPassword(config.Properties().RequirePass)
+	link.state.Store(masterLinkConnect)
+	return link
+}
+
+func (link *masterLink) getState() string {
+	return link.state.Load().(string)
+}
+
+func (link *masterLink) setState(state string) {
+	link.state.Store(state)
+}
+
+func (link *masterLink) addr() string {
+	return net.JoinHostPort(link.host, strconv.Itoa(link.port))
+}
+
+// setConn 记录当前连接，已经停止时返回 false
+func (link *masterLink) setConn(conn net.Conn) bool {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	if link.stopped {
+		return false
+	}
+	link.conn = conn
+	return true
+}
+
+// stop 停止复制，关闭连接让同步协程退出
+func (link *masterLink) stop() {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	if link.stopped {
+		return
+	}
+	link.stopped = true
+	close(link.done)
+	if link.conn != nil {
+		_ = link.conn.Close()
+	}
+}
+
+func (link *masterLink) isStopped() bool {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	return link.stopped
+}
+
+// send 向主节点发送一条命令
+func (link *masterLink) send(conn net.Conn, args ...string) error {
+	link.writeMu.Lock()
+	defer link.writeMu.Unlock()
+	timeout := time.Duration(config.Properties().ReplTimeout) * time.Second
+	_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes())
+	return err
+}
+
+// deadlineReader 每次读取前刷新读超时，主节点 repl-timeout 秒内没有发来任何数据时断开
+type deadlineReader struct {
+	conn net.Conn
+	link *masterLink
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	timeout := time.Duration(config.Properties().ReplTimeout) * time.Second
+	_ = r.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := r.conn.Read(p)
+	if n > 0 {
+		r.link.lastIO.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// readStatusLine 读取一行回复，跳过主节点生成快照期间发来的 \n 保活
+func readStatusLine(br *bufio.Reader) (string, error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return line, nil
+		}
+	}
+}
+
+// masterLinkOf 返回与主节点的连接，本节点是主节点时返回 nil
+func (d *StandaloneDatabase) masterLinkOf() *masterLink {
+	d.repl.mu.Lock()
+	defer d.repl.mu.Unlock()
+	return d.repl.master
+}
+
+// MasterAddr 本节点是从节点时返回主节点地址 host:port，否则返回空串
+func (d *StandaloneDatabase) MasterAddr() string {
+	link := d.masterLinkOf()
+	if link == nil {
+		return ""
+	}
+	return link.addr()
+}
+
+// REPLICAOF host port
+// REPLICAOF NO ONE
+func (d *StandaloneDatabase) execReplicaOf(args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("replicaof")
+	}
+	if strings.EqualFold(string(args[0]), "no") && strings.EqualFold(string(args[1]), "one") {
+		d.replicaOfNoOne()
+		return reply.MakeOkReply()
+	}
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid master port")
+	}
+	if link := d.masterLinkOf(); link != nil && link.host == host && link.port == port {
+		return reply.MakeStatusReply("OK Already connected to specified master")
+	}
+	d.replicaOf(host, port)
+	return reply.MakeOkReply()
+}
+
+// replicaOf 成为 host:port 的从节点，原有的从节点被断开，重连后从本节点继续同步
+func (d *StandaloneDatabase) replicaOf(host string, port int) {
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old := r.master; old != nil {
+		old.stop()
+	}
+	link := makeMasterLink(host, port)
+	if r.streamDB >= 0 {
+		link.client.SelectDB(r.streamDB)
+	}
+	r.master = link
+	r.disconnectReplicas()
+	logger.Info("connecting to master " + link.addr())
+	go d.runMasterLink(link)
+}
+
+// replicaOfNoOne 晋升为主节点，保留数据和积压缓冲区，更换复制 ID
+func (d *StandaloneDatabase) replicaOfNoOne() {
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master == nil {
+		return
+	}
+	r.master.stop()
+	r.master = nil
+	r.shiftReplID()
+	r.streamDB = -1
+	r.disconnectReplicas()
+	logger.Info("master mode enabled, new replication id " + r.replID)
+}
+
+// runMasterLink 与主节点同步，连接断开后每秒重试，直到不再是该主节点的从节点
+func (d *StandaloneDatabase) runMasterLink(link *masterLink) {
+	for {
+		err := d.syncWithMaster(link)
+		if link.isStopped() {
+			return
+		}
+		logger.Warn("replication with master " + link.addr() + " broken: " + err.Error())
+		link.setState(masterLinkConnect)
+		select {
+		case <-link.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func dialMaster(addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: time.Duration(config.Properties().ReplTimeout) * time.Second}
+	if config.Properties().TlsReplication {
+		tlsConfig, err := config.ClientTLSConfig(addr)
+		if err != nil {
+			return nil, err
+		}
+		return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	}
+	return dialer.Dial("tcp", addr)
+}
+
+// syncWithMaster 连接主节点并同步，返回时连接已断开
+func (d *StandaloneDatabase) syncWithMaster(link *masterLink) error {
+	link.setState(masterLinkConnecting)
+	conn, err := dialMaster(link.addr())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !link.setConn(conn) {
+		return errLinkStopped
+	}
+	br := bufio.NewReader(&deadlineReader{conn: conn, link: link})
+	if err := link.handshake(conn, br); err != nil {
+		return err
+	}
+
+	r := d.repl
+	r.mu.Lock()
+	replID, offset := r.replID, r.offset+1
+	r.mu.Unlock()
+	if err := link.send(conn, "psync", replID, strconv.FormatInt(offset, 10)); err != nil {
+		return err
+	}
+	line, err := readStatusLine(br)
+	if err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(line, "+FULLRESYNC"):
+		err = d.fullSyncFromMaster(link, br, line)
+	case strings.HasPrefix(line, "+CONTINUE"):
+		err = d.partialSyncFromMaster(link, line)
+	default:
+		err = errors.New("unexpected reply to PSYNC: " + line)
+	}
+	if err != nil {
+		return err
+	}
+
+	link.setState(masterLinkConnected)
+	stopAck := make(chan struct{})
+	defer close(stopAck)
+	go d.sendAcks(link, stopAck)
+	return d.streamFromMaster(link, br)
+}
+
+// handshake PING、AUTH 和 REPLCONF
+func (link *masterLink) handshake(conn net.Conn, br *bufio.Reader) error {
+	if err := link.send(conn, "ping"); err != nil {
+		return err
+	}
+	line, err := readStatusLine(br)
+	if err != nil {
+		return err
+	}
+	// 主节点设置了密码时 PING 返回 NOAUTH，之后通过 AUTH 认证
+	if strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "-NOAUTH") {
+		return errors.New("error reply to PING from master: " + line)
+	}
+	if auth := config.Properties().MasterAuth; auth != "" {
+		if err := link.send(conn, "auth", auth); err != nil {
+			return err
+		}
+		line, err := readStatusLine(br)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "-") {
+			return errors.New("unable to AUTH to master: " + line)
+		}
+	}
+	port := config.Properties().Port
+	if config.Properties().TlsReplication && config.Properties().TlsPort != 0 {
+		port = config.Properties().TlsPort
+	}
+	// REPLCONF 失败不影响同步，只记录日志
+	if err := link.send(conn, "replconf", "listening-port", strconv.Itoa(port)); err != nil {
+		return err
+	}
+	if line, err = readStatusLine(br); err != nil {
+		return err
+	} else if strings.HasPrefix(line, "-") {
+		logger.Warn("master does not understand REPLCONF listening-port: " + line)
+	}
+	if err := link.send(conn, "replconf", "capa", "eof", "capa", "psync2"); err != nil {
+		return err
+	}
+	if line, err = readStatusLine(br); err != nil {
+		return err
+	} else if strings.HasPrefix(line, "-") {
+		logger.Warn("master does not understand REPLCONF capa: " + line)
+	}
+	return nil
+}
+
+// fullSyncFromMaster +FULLRESYNC <replid> <offset>，接收并加载快照后采用主节点的复制 ID 和偏移量
+func (d *StandaloneDatabase) fullSyncFromMaster(link *masterLink, br *bufio.Reader, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return errors.New("bad FULLRESYNC reply: " + line)
+	}
+	masterOffset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return errors.New("bad FULLRESYNC reply: " + line)
+	}
+	link.setState(masterLinkSync)
+	payload, err := readSnapshotPayload(br)
+	if err != nil {
+		return err
+	}
+	logger.Info("received " + strconv.Itoa(len(payload)) + " bytes of RDB from master")
+
+	d.loading.Store(true)
+	defer d.loading.Store(false)
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	streamDB, err := d.loadSnapshot(bytes.NewReader(payload))
+	if err != nil {
+		return errors.New("load RDB from master failed: " + err.Error())
+	}
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != link {
+		return errLinkStopped
+	}
+	// 历史数据已经被替换，本节点的从节点需要重新全量同步
+	r.replID = fields[1]
+	r.replID2 = ""
+	r.secondOffset = -1
+	r.offset = masterOffset
+	r.backlog = nil
+	r.createBacklogIfNeeded()
+	r.streamDB = streamDB
+	r.disconnectReplicas()
+	link.client.SelectDB(streamDB)
+	return nil
+}
+
+// partialSyncFromMaster +CONTINUE [<replid>]，主节点的复制 ID 变化时记下旧 ID，保证本节点的从节点也能部分重同步
+func (d *StandaloneDatabase) partialSyncFromMaster(link *masterLink, line string) error {
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != link {
+		return errLinkStopped
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 2 && fields[1] != r.replID {
+		r.replID2 = r.replID
+		r.secondOffset = r.offset + 1
+		r.replID = fields[1]
+		r.disconnectReplicas()
+	}
+	r.createBacklogIfNeeded()
+	logger.Info("partial resynchronization with master " + link.addr() + " succeeded")
+	return nil
+}
+
+// readSnapshotPayload 读取 $<len> 或者无盘同步使用的 $EOF:<40 字节结束标记> 格式的快照
+func readSnapshotPayload(br *bufio.Reader) ([]byte, error) {
+	line, err := readStatusLine(br)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(line, "-") {
+		return nil, errors.New("master aborted sync: " + line)
+	}
+	if !strings.HasPrefix(line, "$") {
+		return nil, errors.New("bad protocol from master, expected RDB payload: " + line)
+	}
+	if mark, ok := strings.CutPrefix(line, "$EOF:"); ok {
+		if len(mark) != 40 {
+			return nil, errors.New("bad EOF mark from master: " + line)
+		}
+		return readUntilMark(br, []byte(mark))
+	}
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return nil, errors.New("bad RDB payload length from master: " + line)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// readUntilMark 读取数据直到结束标记，返回不含标记的部分。
+// 只消费到标记为止，标记之后的复制流留在 br 中
+func readUntilMark(br *bufio.Reader, mark []byte) ([]byte, error) {
+	var buf []byte
+	for {
+		if _, err := br.Peek(1); err != nil {
+			return nil, err
+		}
+		chunk, _ := br.Peek(br.Buffered())
+		// 标记可能跨越两次读取
+		searchFrom := max(0, len(buf)-len(mark)+1)
+		consumed := len(buf)
+		buf = append(buf, chunk...)
+		if i := bytes.Index(buf[searchFrom:], mark); i >= 0 {
+			end := searchFrom + i + len(mark)
+			_, _ = br.Discard(end - consumed)
+			return buf[:searchFrom+i], nil
+		}
+		_, _ = br.Discard(len(chunk))
+	}
+}
+
+// streamFromMaster 持续读取并执行复制流，直到连接断开
+func (d *StandaloneDatabase) streamFromMaster(link *masterLink, br *bufio.Reader) error {
+	reader := parser.NewReader(br)
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			if parser.IsFatal(err) {
+				return err
+			}
+			logger.Warn("bad command from master: " + err.Error())
+			continue
+		}
+		if err := d.applyFromMaster(link, args); err != nil {
+			return err
+		}
+	}
+}
+
+// applyFromMaster 执行主节点发来的一条命令，并原样追加到本节点的复制流。
+// 主节点发送的命令总是标准的 RESP 数组，重新编码后与收到的字节相同，偏移量与主节点一致
+func (d *StandaloneDatabase) applyFromMaster(link *masterLink, args [][]byte) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	cmd := strings.ToLower(string(args[0]))
+	switch cmd {
+	case "select":
+		if len(args) == 2 {
+			if index, err := strconv.Atoi(string(args[1])); err == nil && index >= 0 && index < len(d.dbSet) {
+				link.client.SelectDB(index)
+			}
+		}
+	case "replconf":
+		// REPLCONF GETACK * 要求立即上报偏移量，上报的是执行这条命令之前的偏移量，与 redis 一致
+		if len(args) >= 2 && strings.EqualFold(string(args[1]), "getack") {
+			if err := link.sendAck(d.repl.currentOffset()); err != nil {
+				return err
+			}
+		}
+	case "ping":
+		// 主节点的心跳，只计入偏移量
+	case "flushall":
+		d.execFlushAll()
+	default:
+		result := d.dbSet[link.client.GetDBIndex()].Exec(link.client, args)
+		if reply.IsErrReply(result) {
+			logger.Warn("command from master failed: " + strings.TrimSpace(string(result.ToBytes())))
+		}
+	}
+	r := d.repl
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != link {
+		return errLinkStopped
+	}
+	r.feedRaw(reply.MakeMultiBulkReply(args).ToBytes())
+	r.streamDB = link.client.GetDBIndex()
+	return nil
+}
+
+// sendAck 向主节点上报已处理的偏移量
+func (link *masterLink) sendAck(offset int64) error {
+	link.mu.Lock()
+	conn := link.conn
+	link.mu.Unlock()
+	if conn == nil {
+		return errLinkStopped
+	}
+	return link.send(conn, "replconf", "ack", strconv.FormatInt(offset, 10))
+}
+
+// sendAcks 每秒向主节点上报已处理的偏移量
+func (d *StandaloneDatabase) sendAcks(link *masterLink, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := link.sendAck(d.repl.currentOffset()); err != nil {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func init() {
+	registerServerCommand("replicaof", 3).
+		attachCommandExtra([]string{"admin", "noscript", "stale"}, 0, 0, 0).
+		attachDocs("server", "Configures a server as replica of another, or promotes it to a master.")
+	registerServerCommand("slaveof", 3).
+		attachCommandExtra([]string{"admin", "noscript", "stale"}, 0, 0, 0).
+		attachDocs("server", "Sets a Redis server as a replica of another, or promotes it to being a master.")
+	registerServerCommand("role", 1).
+		attachCommandExtra([]string{"noscript", "loading", "stale", "fast"}, 0, 0, 0).
+		attachDocs("server", "Returns the replication role.")
+}
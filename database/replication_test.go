@@ -0,0 +1,173 @@
+package database
+
+import (
+	"bytes"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/resp/connection"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamRecorder 读取管道另一端，记录发给从节点的全部数据
+type streamRecorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *streamRecorder) contains(sub string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return bytes.Contains(s.buf.Bytes(), []byte(sub))
+}
+
+// waitFor 等待记录的数据中出现 sub
+func (s *streamRecorder) waitFor(t *testing.T, sub string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !s.contains(sub) {
+		if time.Now().After(deadline) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			t.Fatalf("replication stream %q does not contain %q", s.buf.String(), sub)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// attachReplica 用 PSYNC 连上一个从节点，返回从节点连接和它收到的数据
+func attachReplica(t *testing.T, d *StandaloneDatabase) (*connection.Connection, *streamRecorder) {
+	t.Helper()
+	server, client := net.Pipe()
+	recorder := &streamRecorder{}
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := client.Read(buf)
+			if err != nil {
+				return
+			}
+			recorder.mu.Lock()
+			recorder.buf.Write(buf[:n])
+			recorder.mu.Unlock()
+		}
+	}()
+	conn := connection.NewConnection(server)
+	t.Cleanup(func() {
+		d.repl.removeReplica(conn)
+		_ = conn.Close()
+		_ = client.Close()
+	})
+	d.Exec(conn, utils.ToCmdLine("psync", "?", "-1"))
+	recorder.waitFor(t, "+FULLRESYNC")
+	return conn, recorder
+}
+
+func execWith(d *StandaloneDatabase, c resp.Connection, args ...string) resp.Reply {
+	return d.Exec(c, utils.ToCmdLine(args...))
+}
+
+// waitAsync 在新协程中执行 WAIT
+func waitAsync(d *StandaloneDatabase, c resp.Connection, args ...string) <-chan resp.Reply {
+	result := make(chan resp.Reply, 1)
+	go func() {
+		result <- execWith(d, c, append([]string{"wait"}, args...)...)
+	}()
+	return result
+}
+
+func mustReturn(t *testing.T, result <-chan resp.Reply, want string) {
+	t.Helper()
+	select {
+	case r := <-result:
+		assertReply(t, r, want)
+	case <-time.After(time.Second):
+		t.Fatal("WAIT is still blocked")
+	}
+}
+
+func mustWait(t *testing.T, result <-chan resp.Reply) {
+	t.Helper()
+	select {
+	case r := <-result:
+		t.Fatalf("WAIT returned %q", r.ToBytes())
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWaitWithoutReplicas(t *testing.T) {
+	d := makeTestDatabase(t)
+	c := &connection.Connection{}
+	assertReply(t, execWith(d, c, "wait", "0", "0"), ":0\r\n")
+	start := time.Now()
+	assertReply(t, execWith(d, c, "wait", "1", "20"), ":0\r\n")
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("WAIT returned before the timeout")
+	}
+	assertReply(t, execWith(d, c, "wait", "1", "-1"), "-ERR timeout is negative\r\n")
+	assertReply(t, execWith(d, c, "wait", "x", "0"), "-ERR value is not an integer or out of range\r\n")
+}
+
+func TestReplicationStream(t *testing.T) {
+	d := makeTestDatabase(t)
+	_, stream := attachReplica(t, d)
+	c := &connection.Connection{}
+	execWith(d, c, "set", "k", "v")
+	stream.waitFor(t, "*2\r\n$6\r\nselect\r\n$1\r\n0\r\n*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n")
+	// 读命令不进入复制流，写命令记录执行后的偏移量
+	offset := d.repl.currentOffset()
+	if c.GetWriteOffset() != offset {
+		t.Fatalf("write offset %d, want %d", c.GetWriteOffset(), offset)
+	}
+	execWith(d, c, "get", "k")
+	if d.repl.currentOffset() != offset {
+		t.Fatal("read command is propagated")
+	}
+}
+
+func TestWaitAck(t *testing.T) {
+	d := makeTestDatabase(t)
+	replica, stream := attachReplica(t, d)
+	c := &connection.Connection{}
+	execWith(d, c, "set", "k", "v")
+	target := c.GetWriteOffset()
+
+	result := waitAsync(d, c, "1", "0")
+	mustWait(t, result)
+	// 第一次未满足时要求从节点立即上报
+	stream.waitFor(t, "$6\r\ngetack\r\n")
+	execWith(d, replica, "replconf", "ack", strconv.FormatInt(target-1, 10))
+	mustWait(t, result)
+	execWith(d, replica, "replconf", "ack", strconv.FormatInt(target, 10))
+	mustReturn(t, result, ":1\r\n")
+
+	// 已确认的写命令不再等待
+	assertReply(t, execWith(d, c, "wait", "1", "0"), ":1\r\n")
+	assertReply(t, execWith(d, c, "wait", "2", "10"), ":1\r\n")
+}
+
+func TestWaitClientDisconnect(t *testing.T) {
+	d := makeTestDatabase(t)
+	attachReplica(t, d)
+	server, client := net.Pipe()
+	defer client.Close()
+	c := connection.NewConnection(server)
+	execWith(d, c, "set", "k", "v")
+	result := waitAsync(d, c, "1", "0")
+	mustWait(t, result)
+	// 客户端断开后立即解除阻塞
+	_ = c.Close()
+	mustReturn(t, result, ":0\r\n")
+}
+
+func TestWaitOnReplica(t *testing.T) {
+	d := makeTestDatabase(t)
+	d.repl.master = &masterLink{}
+	r := execWith(d, &connection.Connection{}, "wait", "1", "0")
+	if !isErr(r) {
+		t.Fatalf("WAIT on replica: %q", r.ToBytes())
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"go_redis/lib/slot"
+	"sync"
+)
+
+// slotLockCount 槽位索引的锁分段数
+const slotLockCount = 256
+
+// slotIndex 集群模式下按槽位记录每个 DB 中的 key，CLUSTER COUNTKEYSINSLOT / GETKEYSINSLOT 不需要遍历整个 DB。
+// dict 的写入和索引的更新在同一把锁内完成，并发增删同一个 key 时两者不会不一致
+type slotIndex struct {
+	locks [slotLockCount]sync.Mutex
+	keys  [slot.Count]map[string]struct{}
+}
+
+func makeSlotIndex() *slotIndex {
+	return &slotIndex{}
+}
+
+// update 执行对 key 的插入（added 为 true）或删除，op 返回值大于 0 时更新索引
+func (idx *slotIndex) update(key string, added bool, op func() int) int {
+	s := slot.KeySlot(key)
+	mu := &idx.locks[s%slotLockCount]
+	mu.Lock()
+	defer mu.Unlock()
+	result := op()
+	if result <= 0 {
+		return result
+	}
+	if added {
+		if idx.keys[s] == nil {
+			idx.keys[s] = make(map[string]struct{})
+		}
+		idx.keys[s][key] = struct{}{}
+	} else {
+		delete(idx.keys[s], key)
+	}
+	return result
+}
+
+// count 槽位中的 key 个数
+func (idx *slotIndex) count(s int) int {
+	mu := &idx.locks[s%slotLockCount]
+	mu.Lock()
+	defer mu.Unlock()
+	return len(idx.keys[s])
+}
+
+// keysIn 返回槽位中至多 limit 个 key
+func (idx *slotIndex) keysIn(s int, limit int) []string {
+	mu := &idx.locks[s%slotLockCount]
+	mu.Lock()
+	defer mu.Unlock()
+	keys := make([]string, 0, min(limit, len(idx.keys[s])))
+	for key := range idx.keys[s] {
+		if len(keys) >= limit {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// clear 清空索引，与 dict 的 Clear 一起调用
+func (idx *slotIndex) clear() {
+	for i := range idx.locks {
+		idx.locks[i].Lock()
+	}
+	for s := range idx.keys {
+		idx.keys[s] = nil
+	}
+	for i := range idx.locks {
+		idx.locks[i].Unlock()
+	}
+}
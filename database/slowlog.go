@@ -0,0 +1,213 @@
+package database
+
+import (
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 慢日志：记录执行时间超过 slowlog-log-slower-than 微秒的命令
+
+const (
+	slowlogMaxArgc   = 32  // 每条记录最多保留的参数个数
+	slowlogMaxString = 128 // 每个参数最多保留的字节数
+)
+
+type slowlogEntry struct {
+	id        int64
+	timestamp int64 // 秒级时间戳
+	duration  int64 // 微秒
+	args      [][]byte
+	addr      string
+	name      string
+}
+
+// slowlogRing 定长环形缓冲区，写满后覆盖最旧的记录
+type slowlogRing struct {
+	mu      sync.Mutex
+	entries []*slowlogEntry
+	head    int // 下一条记录写入的位置
+	size    int
+	nextID  int64
+}
+
+var slowlog = &slowlogRing{}
+
+// push 写入一条记录，容量跟随 slowlog-max-len 变化
+func (s *slowlogRing) push(entry *slowlogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resize(config.Properties().SlowlogMaxLen)
+	if len(s.entries) == 0 {
+		return
+	}
+	entry.id = s.nextID
+	s.nextID++
+	s.entries[s.head] = entry
+	s.head = (s.head + 1) % len(s.entries)
+	if s.size < len(s.entries) {
+		s.size++
+	}
+}
+
+// resize 调整容量，保留最新的记录
+func (s *slowlogRing) resize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	if capacity == len(s.entries) {
+		return
+	}
+	latest := s.latest(capacity)
+	s.entries = make([]*slowlogEntry, capacity)
+	// latest 按从新到旧排列，倒序写回
+	for i := len(latest) - 1; i >= 0; i-- {
+		s.entries[len(latest)-1-i] = latest[i]
+	}
+	s.size = len(latest)
+	if capacity > 0 {
+		s.head = s.size % capacity
+	} else {
+		s.head = 0
+	}
+}
+
+// latest 返回最新的 n 条记录，从新到旧排列，n 为负数时返回全部
+func (s *slowlogRing) latest(n int) []*slowlogEntry {
+	if n < 0 || n > s.size {
+		n = s.size
+	}
+	result := make([]*slowlogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (s.head - i + len(s.entries)) % len(s.entries)
+		result = append(result, s.entries[idx])
+	}
+	return result
+}
+
+func (s *slowlogRing) get(n int) []*slowlogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest(n)
+}
+
+func (s *slowlogRing) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *slowlogRing) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.entries {
+		s.entries[i] = nil
+	}
+	s.head = 0
+	s.size = 0
+}
+
+// recordSlowlog 命令执行时间超过阈值时写入慢日志
+func recordSlowlog(c resp.Connection, args [][]byte, cost time.Duration) {
+	threshold := config.Properties().SlowlogLogSlowerThan
+	if threshold < 0 || cost.Microseconds() < int64(threshold) {
+		return
+	}
+	entry := &slowlogEntry{
+		timestamp: time.Now().Unix(),
+		duration:  cost.Microseconds(),
+		args:      truncateSlowlogArgs(args),
+		name:      c.GetName(),
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		entry.addr = addr.String()
+	}
+	slowlog.push(entry)
+}
+
+// truncateSlowlogArgs 截断过多或过长的参数，避免慢日志占用过多内存
+func truncateSlowlogArgs(args [][]byte) [][]byte {
+	argc := len(args)
+	if argc > slowlogMaxArgc {
+		argc = slowlogMaxArgc
+	}
+	result := make([][]byte, argc)
+	for i := 0; i < argc; i++ {
+		if i == slowlogMaxArgc-1 && len(args) > slowlogMaxArgc {
+			more := len(args) - slowlogMaxArgc + 1
+			result[i] = []byte("... (" + strconv.Itoa(more) + " more arguments)")
+			break
+		}
+		arg := args[i]
+		if len(arg) > slowlogMaxString {
+			more := len(arg) - slowlogMaxString
+			truncated := make([]byte, 0, slowlogMaxString+32)
+			truncated = append(truncated, arg[:slowlogMaxString]...)
+			truncated = append(truncated, "... ("+strconv.Itoa(more)+" more bytes)"...)
+			result[i] = truncated
+		} else {
+			// 复制一份，避免引用调用方可能复用的缓冲区
+			result[i] = append([]byte{}, arg...)
+		}
+	}
+	return result
+}
+
+// SLOWLOG GET [count]
+// SLOWLOG LEN
+// SLOWLOG RESET
+func execSlowlog(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeArgNumErrReply("slowlog")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "get":
+		if len(args) > 2 {
+			return reply.MakeArgNumErrReply("slowlog|get")
+		}
+		count := 10
+		if len(args) == 2 {
+			n, err := strconv.Atoi(string(args[1]))
+			if err != nil || n < -1 {
+				return reply.MakeErrReply("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		entries := slowlog.get(count)
+		result := make([]resp.Reply, 0, len(entries))
+		for _, entry := range entries {
+			result = append(result, reply.MakeMultiRawReply([]resp.Reply{
+				reply.MakeIntReply(entry.id),
+				reply.MakeIntReply(entry.timestamp),
+				reply.MakeIntReply(entry.duration),
+				reply.MakeMultiBulkReply(entry.args),
+				reply.MakeBulkReply([]byte(entry.addr)),
+				reply.MakeBulkReply([]byte(entry.name)),
+			}))
+		}
+		return reply.MakeMultiRawReply(result)
+	case "len":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("slowlog|len")
+		}
+		return reply.MakeIntReply(int64(slowlog.len()))
+	case "reset":
+		if len(args) != 1 {
+			return reply.MakeArgNumErrReply("slowlog|reset")
+		}
+		slowlog.reset()
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try SLOWLOG HELP.")
+}
+
+func init() {
+	registerServerCommand("slowlog", -2).
+		attachCommandExtra([]string{"admin", "loading", "stale"}, 0, 0, 0).
+		attachDocs("server", "A container for slow log commands.")
+}
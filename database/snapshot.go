@@ -0,0 +1,117 @@
+package database
+
+import (
+	"errors"
+	"go_redis/config"
+	"go_redis/interface/database"
+	"go_redis/lib/logger"
+	"go_redis/lib/utils"
+	"go_redis/rdb"
+	"io"
+	"strconv"
+	"time"
+)
+
+// RDB 快照：主节点全量同步时生成，从节点收到后加载
+
+// writeSnapshot 把所有 DB 写成 RDB 快照，调用方需要阻止写命令执行，保证快照与复制偏移量一致。
+// streamDB 为复制流当前所在的 DB，从节点加载后从该 DB 继续执行复制流
+func (d *StandaloneDatabase) writeSnapshot(w io.Writer, replID string, offset int64, streamDB int) error {
+	enc := rdb.NewEncoder(w)
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	if streamDB < 0 {
+		streamDB = 0
+	}
+	aux := [][2]string{
+		{"redis-ver", serverVersion},
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+		{"used-mem", strconv.FormatInt(d.usedMemory(), 10)},
+		{"repl-stream-db", strconv.Itoa(streamDB)},
+		{"repl-id", replID},
+		{"repl-offset", strconv.FormatInt(offset, 10)},
+		{"aof-base", "0"},
+	}
+	for _, field := range aux {
+		if err := enc.WriteAux(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	for i, db := range d.dbSet {
+		size := db.data.Len()
+		if size == 0 {
+			continue
+		}
+		if err := enc.WriteDBHeader(i, uint64(size), uint64(db.ttlMap.Len())); err != nil {
+			return err
+		}
+		var err error
+		db.data.ForEach(func(key string, raw interface{}) bool {
+			entity, _ := raw.(*database.DataEntity)
+			value, ok := entity.Data.([]byte)
+			if !ok {
+				return true
+			}
+			var expireAt int64
+			if expireTime, ok := db.ttlOf(key); ok {
+				if !now.Before(expireTime) {
+					return true
+				}
+				expireAt = expireTime.UnixMilli()
+			}
+			err = enc.WriteString(key, value, expireAt)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return enc.WriteEnd()
+}
+
+// loadSnapshot 清空所有 DB 后加载 RDB 快照，返回快照中记录的复制流所在 DB。
+// 开启 AOF 时加载的数据同样写入 AOF，保证重启后数据与主节点一致
+func (d *StandaloneDatabase) loadSnapshot(rd io.Reader) (int, error) {
+	for _, db := range d.dbSet {
+		db.Flush()
+		db.addAof(utils.ToCmdLine("flushdb"))
+	}
+	dec := rdb.NewDecoder(rd)
+	now := time.Now()
+	loaded, skipped := 0, 0
+	err := dec.Parse(func(entry *rdb.Entry) error {
+		if entry.DB >= len(d.dbSet) {
+			return errors.New("DB index " + strconv.Itoa(entry.DB) + " out of range, databases is " +
+				strconv.Itoa(config.Properties().Databases))
+		}
+		if entry.Type != rdb.TypeString {
+			skipped++
+			return nil
+		}
+		expireTime := time.UnixMilli(entry.ExpireAt)
+		if entry.ExpireAt > 0 && !now.Before(expireTime) {
+			return nil
+		}
+		db := d.dbSet[entry.DB]
+		db.PutEntity(entry.Key, &database.DataEntity{Data: entry.Value})
+		db.addAof(utils.ToCmdLine3("set", []byte(entry.Key), entry.Value))
+		if entry.ExpireAt > 0 {
+			db.Expire(entry.Key, expireTime)
+			db.addAof(utils.ToCmdLine("pexpireat", entry.Key, strconv.FormatInt(entry.ExpireAt, 10)))
+		}
+		loaded++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if skipped > 0 {
+		logger.Warn("skipped " + strconv.Itoa(skipped) + " keys of unsupported types while loading RDB")
+	}
+	logger.Info("loaded " + strconv.Itoa(loaded) + " keys from RDB")
+	streamDB, _ := strconv.Atoi(dec.Aux["repl-stream-db"])
+	return streamDB, nil
+}
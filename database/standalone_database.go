@@ -5,44 +5,131 @@ import (
 	"go_redis/config"
 	"go_redis/interface/resp"
 	"go_redis/lib/logger"
+	"go_redis/lib/utils"
 	"go_redis/resp/reply"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type StandaloneDatabase struct {
 	dbSet      []*DB
 	aofHandler *aof.AofHandler // AOF处理器
+	evictMu    sync.Mutex      // 同一时刻只允许一个协程做内存淘汰
+	closeChan  chan struct{}
+	closeOnce  sync.Once
+
+	repl        *replication
+	writeMu     sync.RWMutex // 见 lockWrite
+	loading     atomic.Bool  // 从节点正在加载主节点的快照
+	expiredKeys chan expiredKey
+}
+
+// expiredKey 命令访问到的过期 key，等待 serverCron 删除
+type expiredKey struct {
+	db  *DB
+	key string
 }
 
+// expiredQueueSize 等待删除的过期 key 的上限，超出的留给抽样清理
+const expiredQueueSize = 1024
+
 func NewStandaloneDatabase() *StandaloneDatabase {
-	database := &StandaloneDatabase{}
-	if config.Properties.Databases == 0 {
-		config.Properties.Databases = 16 // 默认16个数据库
+	database := &StandaloneDatabase{
+		closeChan:   make(chan struct{}),
+		repl:        makeReplication(),
+		expiredKeys: make(chan expiredKey, expiredQueueSize),
+	}
+	databases := config.Properties().Databases
+	if databases == 0 {
+		databases = 16 // 默认16个数据库
 	}
-	database.dbSet = make([]*DB, config.Properties.Databases)
-	for i := 0; i < config.Properties.Databases; i++ {
+	database.dbSet = make([]*DB, databases)
+	for i := 0; i < databases; i++ {
 		db := makeDB()
 		db.index = i
+		db.onExpired = database.queueExpired
 		database.dbSet[i] = db
 	}
-	if config.Properties.AppendOnly == true {
+	if config.Properties().AppendOnly == true {
 		aofHandler, err := aof.NewAofHandler(database)
 		if err != nil {
 			logger.Error("Failed to create AOF handler:", err)
 			return nil
 		}
 		database.aofHandler = aofHandler
-		for i, db := range database.dbSet {
-			dbIndex := i
-			db.addAof = func(line CmdLine) {
+	}
+	// 写命令同时写入 AOF 和复制流
+	for i, db := range database.dbSet {
+		dbIndex := i
+		db.addAof = func(line CmdLine) {
+			if database.aofHandler != nil {
 				database.aofHandler.AddAof(dbIndex, line)
 			}
+			database.repl.feedCommand(dbIndex, line)
 		}
 	}
+	if replicaOf := config.Properties().ReplicaOf; replicaOf != "" {
+		fields := strings.Fields(replicaOf)
+		port := 0
+		if len(fields) == 2 {
+			port, _ = strconv.Atoi(fields[1])
+		}
+		if port <= 0 {
+			logger.Error("invalid replicaof " + replicaOf)
+		} else {
+			database.replicaOf(fields[0], port)
+		}
+	}
+	go database.serverCron()
 	return database
 }
 
+// serverCron 后台定期清理过期 key，主节点定期向从节点发送 PING。
+// 从节点不主动清理过期 key，等待主节点同步过来的 DEL
+func (d *StandaloneDatabase) serverCron() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !d.repl.isReplica() {
+				d.activeExpireCycle()
+			}
+			d.repl.pingReplicas()
+		case <-d.closeChan:
+			return
+		}
+	}
+}
+
+// queueExpired 记下命令访问到的过期 key，由 serverCron 删除。从节点只隐藏过期的 key，等待主节点的 DEL
+func (d *StandaloneDatabase) queueExpired(db *DB, key string) {
+	if d.repl.isReplica() {
+		return
+	}
+	select {
+	case d.expiredKeys <- expiredKey{db: db, key: key}:
+	default:
+	}
+}
+
+// activeExpireCycle 删除命令访问到的过期 key，再抽样清理。持有 writeMu 写锁，不与任何写命令并发：
+// 删除前再次确认 key 已过期，不会删掉刚写入的值，DEL 在 AOF 和复制流中的顺序也与执行顺序一致
+func (d *StandaloneDatabase) activeExpireCycle() {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	for pending := len(d.expiredKeys); pending > 0; pending-- {
+		e := <-d.expiredKeys
+		e.db.deleteExpired(e.key)
+	}
+	for _, db := range d.dbSet {
+		db.activeExpireCycle()
+	}
+}
+
 // set k v
 // get k
 // del k1 k2 ...
@@ -56,22 +143,135 @@ func (d *StandaloneDatabase) Exec(client resp.Connection, args [][]byte) resp.Re
 		}
 	}()
 	cmd := strings.ToLower(string(args[0]))
-	if cmd == "select" {
+	switch cmd {
+	case "auth":
+		return execAuth(client, args[1:])
+	case "hello":
+		return execHello(client, args[1:], d.role())
+	}
+	if !IsAuthenticated(client) {
+		return reply.MakeErrReply("NOAUTH Authentication required.")
+	}
+	command := lookupCommand(cmd)
+	if d.loading.Load() && (command == nil || !command.hasFlag(flagLoading)) {
+		return reply.MakeErrReply("LOADING Redis is loading the dataset in memory")
+	}
+	if client.InMultiState() && cmd != "exec" && cmd != "discard" && cmd != "multi" {
+		return EnqueueCmd(client, args)
+	}
+	start := time.Now()
+	defer func() {
+		if _, ok := cmdTable[cmd]; !ok {
+			// 只统计已知命令，避免任意输入撑大统计表
+			return
+		}
+		cost := time.Since(start)
+		stats.record(cmd, cost)
+		recordSlowlog(client, args, cost)
+	}()
+	replica := d.repl.isReplica()
+	// 从节点的数据由主节点决定，不做内存淘汰
+	if config.Properties().MaxMemory > 0 && !replica && !d.freeMemoryIfNeeded() {
+		if command != nil && command.hasFlag(flagDenyOOM) {
+			return reply.MakeErrReply("OOM command not allowed when used memory > 'maxmemory'.")
+		}
+	}
+	switch cmd {
+	case "multi":
+		return execMulti(client, args[1:])
+	case "exec":
+		return d.execExec(client, args[1:])
+	case "discard":
+		return execDiscard(client, args[1:])
+	case "select":
 		if len(args) != 2 {
 			return reply.MakeArgNumErrReply("select")
 		}
 		return execSelect(client, d, args[1:])
-	} else {
-		result := d.dbSet[client.GetDBIndex()].Exec(client, args)
-		return result
+	case "config":
+		return execConfig(args[1:])
+	case "client":
+		return execClient(client, args[1:])
+	case "slowlog":
+		return execSlowlog(args[1:])
+	case "command":
+		return execCommand(args[1:])
+	case "info":
+		return d.execInfo(args[1:])
+	case "role":
+		return d.execRole()
+	case "replicaof", "slaveof":
+		return d.execReplicaOf(args[1:])
+	case "replconf":
+		return d.execReplConf(client, args[1:])
+	case "psync":
+		return d.execPSync(client, args[1:])
+	case "sync":
+		return d.execSync(client, args[1:])
+	case "wait":
+		return d.execWait(client, args[1:])
+	case "cluster":
+		return d.execCluster(client, args[1:])
+	case "migrate":
+		return d.execMigrate(client, args[1:])
+	case "asking":
+		return execAsking(client, args[1:])
+	case "readonly":
+		return execReadOnly(client, args[1:], true)
+	case "readwrite":
+		return execReadOnly(client, args[1:], false)
+	}
+	if command != nil && command.hasFlag(flagWrite) {
+		if replica && config.Properties().ReplicaReadOnly {
+			return reply.MakeErrReply("READONLY You can't write against a read only replica.")
+		}
+		unlock := d.lockWrite()
+		defer unlock()
+		if d.repl.active.Load() {
+			defer func() {
+				// 先于解锁执行，此时偏移量包含这条命令
+				client.SetWriteOffset(d.repl.currentOffset())
+			}()
+		}
 	}
+	if cmd == "flushall" {
+		return d.execFlushAll()
+	}
+	return d.dbSet[client.GetDBIndex()].Exec(client, args)
 }
 
-func (d StandaloneDatabase) Close() {
+// FLUSHALL，AOF 和复制流中只记录一条 flushall
+func (d *StandaloneDatabase) execFlushAll() resp.Reply {
+	d.FlushAll()
+	d.dbSet[0].addAof(utils.ToCmdLine("flushall"))
+	return reply.MakeOkReply()
 }
 
-func (d StandaloneDatabase) AfterClientClose(client resp.Connection) {
+// role HELLO 中返回的角色
+func (d *StandaloneDatabase) role() string {
+	if d.repl.isReplica() {
+		return "replica"
+	}
+	return "master"
+}
 
+func (d *StandaloneDatabase) Close() {
+	d.closeOnce.Do(func() {
+		close(d.closeChan)
+	})
+	if link := d.masterLinkOf(); link != nil {
+		link.stop()
+	}
+	if d.aofHandler != nil {
+		if err := d.aofHandler.Close(); err != nil {
+			logger.Error("close aof handler error:", err)
+		}
+	}
+}
+
+func (d *StandaloneDatabase) AfterClientClose(client resp.Connection) {
+	// 从节点连接，以及发送过 REPLCONF 但还没有 PSYNC 的连接
+	d.repl.removeReplica(client)
 }
 
 // select 2
@@ -86,3 +286,12 @@ func execSelect(c resp.Connection, database *StandaloneDatabase, args [][]byte)
 	c.SelectDB(dbIndex)
 	return reply.MakeOkReply()
 }
+
+func init() {
+	registerServerCommand("flushall", -1).
+		attachCommandExtra([]string{"write"}, 0, 0, 0).
+		attachDocs("server", "Removes all keys from all databases.")
+	registerServerCommand("select", 2).
+		attachCommandExtra([]string{"loading", "stale", "fast"}, 0, 0, 0).
+		attachDocs("connection", "Changes the selected database.")
+}
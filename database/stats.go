@@ -0,0 +1,44 @@
+package database
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 服务器运行统计，CONFIG RESETSTAT 会将其清零
+
+type cmdStat struct {
+	calls atomic.Int64 // 调用次数
+	usec  atomic.Int64 // 累计耗时，微秒
+}
+
+type serverStats struct {
+	totalCommands atomic.Int64 // 处理过的命令总数
+	cmdStats      sync.Map     // 命令名 -> *cmdStat，只增不删，清零时逐个归零
+}
+
+var stats = &serverStats{}
+
+// record 记录一次命令执行，每条命令都会调用，不加锁
+func (s *serverStats) record(cmdName string, cost time.Duration) {
+	s.totalCommands.Add(1)
+	raw, ok := s.cmdStats.Load(cmdName)
+	if !ok {
+		raw, _ = s.cmdStats.LoadOrStore(cmdName, &cmdStat{})
+	}
+	stat := raw.(*cmdStat)
+	stat.calls.Add(1)
+	stat.usec.Add(cost.Microseconds())
+}
+
+// reset 清空所有统计
+func (s *serverStats) reset() {
+	s.totalCommands.Store(0)
+	s.cmdStats.Range(func(_, raw any) bool {
+		stat := raw.(*cmdStat)
+		stat.calls.Store(0)
+		stat.usec.Store(0)
+		return true
+	})
+}
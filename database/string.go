@@ -30,6 +30,7 @@ func execSet(db *DB, args [][]byte) resp.Reply {
 		Data: value,
 	}
 	db.PutEntity(key, entity)
+	db.Persist(key) // SET 会清除原有的过期时间
 	db.addAof(utils.ToCmdLine3("set", args...))
 	return reply.MakeOkReply()
 }
@@ -55,6 +56,7 @@ func execGetset(db *DB, args [][]byte) resp.Reply {
 	}
 	oldEntity, exists := db.GetEntity(key)
 	db.PutEntity(key, newEntity)
+	db.Persist(key)
 	db.addAof(utils.ToCmdLine3("getset", args...))
 	if !exists {
 		return reply.MakeNullBulkReply()
@@ -81,10 +83,56 @@ func execStrlen(db *DB, args [][]byte) resp.Reply {
 	}
 	return reply.MakeIntReply(int64(len(val)))
 }
+
+// MGET, MGET K1 K2 ...
+func execMGet(db *DB, args [][]byte) resp.Reply {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		entity, exists := db.GetEntity(string(arg))
+		if !exists {
+			continue // nil
+		}
+		// 非字符串类型返回 nil
+		if val, ok := entity.Data.([]byte); ok {
+			result[i] = val
+		}
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// MSET, MSET K1 V1 K2 V2 ...
+func execMSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	for i := 0; i < len(args); i += 2 {
+		db.PutEntity(string(args[i]), &database.DataEntity{Data: args[i+1]})
+		db.Persist(string(args[i]))
+	}
+	db.addAof(utils.ToCmdLine3("mset", args...))
+	return reply.MakeOkReply()
+}
+
 func init() {
-	RegisterCommand("SET", execSet, 3)
-	RegisterCommand("GET", execGet, 2)
-	RegisterCommand("SETNX", execSetnx, 3)
-	RegisterCommand("GETSET", execGetset, 3)
-	RegisterCommand("STRLEN", execStrlen, 2)
+	RegisterCommand("SET", execSet, 3).
+		attachCommandExtra([]string{"write", "denyoom"}, 1, 1, 1).
+		attachDocs("string", "Sets the string value of a key.")
+	RegisterCommand("GET", execGet, 2).
+		attachCommandExtra([]string{"readonly", "fast"}, 1, 1, 1).
+		attachDocs("string", "Returns the string value of a key.")
+	RegisterCommand("SETNX", execSetnx, 3).
+		attachCommandExtra([]string{"write", "denyoom", "fast"}, 1, 1, 1).
+		attachDocs("string", "Set the string value of a key only when the key doesn't exist.")
+	RegisterCommand("GETSET", execGetset, 3).
+		attachCommandExtra([]string{"write", "denyoom", "fast"}, 1, 1, 1).
+		attachDocs("string", "Returns the previous string value of a key after setting it to a new value.")
+	RegisterCommand("STRLEN", execStrlen, 2).
+		attachCommandExtra([]string{"readonly", "fast"}, 1, 1, 1).
+		attachDocs("string", "Returns the length of a string value.")
+	RegisterCommand("MGET", execMGet, -2).
+		attachCommandExtra([]string{"readonly", "fast"}, 1, -1, 1).
+		attachDocs("string", "Atomically returns the string values of one or more keys.")
+	RegisterCommand("MSET", execMSet, -3).
+		attachCommandExtra([]string{"write", "denyoom"}, 1, -1, 2).
+		attachDocs("string", "Atomically creates or modifies the string values of one or more keys.")
 }
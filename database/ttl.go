@@ -0,0 +1,202 @@
+package database
+
+import (
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/resp/reply"
+	"strconv"
+	"time"
+)
+
+// 过期时间：访问时把过期的 key 当作不存在，记下来交给后台协程持有写锁时删除；后台协程另外定期抽样清理
+
+const (
+	activeExpireSamples = 20 // 每轮抽样的 key 数量
+	activeExpireRounds  = 16 // 每次最多连续抽样的轮数
+)
+
+// Expire 设置 key 的过期时间
+func (db *DB) Expire(key string, expireTime time.Time) {
+	db.ttlMap.Put(key, expireTime)
+}
+
+// Persist 移除 key 的过期时间，返回是否移除成功
+func (db *DB) Persist(key string) bool {
+	return db.ttlMap.Remove(key) > 0
+}
+
+// ttlOf 返回 key 的过期时间
+func (db *DB) ttlOf(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return raw.(time.Time), true
+}
+
+// IsExpired 判断 key 是否已过期，不做删除
+func (db *DB) IsExpired(key string) bool {
+	expireTime, ok := db.ttlOf(key)
+	return ok && !time.Now().Before(expireTime)
+}
+
+// expired 判断 key 是否已过期，过期时交给 onExpired 稍后删除，调用方把它当作不存在。
+// 这里没有持有写锁，不能直接删除：并发的 SET 可能刚写入新值还没清除过期时间，DEL 也会与其他写命令乱序
+func (db *DB) expired(key string) bool {
+	if !db.IsExpired(key) {
+		return false
+	}
+	db.onExpired(db, key)
+	return true
+}
+
+// deleteExpired 调用方持有 writeMu 写锁，再次确认 key 已过期后删除并记录 DEL，返回是否删除
+func (db *DB) deleteExpired(key string) bool {
+	if !db.IsExpired(key) {
+		return false
+	}
+	if db.remove(key) > 0 {
+		db.addAof(utils.ToCmdLine("del", key))
+	}
+	return true
+}
+
+// activeExpireCycle 抽样检查带过期时间的 key，过期比例较高时继续下一轮，调用方持有 writeMu 写锁
+func (db *DB) activeExpireCycle() {
+	for round := 0; round < activeExpireRounds; round++ {
+		keys := db.ttlMap.RandomKeys(activeExpireSamples)
+		if len(keys) == 0 {
+			return
+		}
+		expired := 0
+		for _, key := range keys {
+			if db.deleteExpired(key) {
+				expired++
+			}
+		}
+		if expired*4 <= len(keys) {
+			return
+		}
+	}
+}
+
+// expireAt 统一处理 EXPIRE 系列命令，AOF 中统一记录为 PEXPIREAT
+func expireAt(db *DB, key string, expireTime time.Time) resp.Reply {
+	if _, exists := db.GetEntity(key); !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !time.Now().Before(expireTime) {
+		// 过期时间已过，直接删除
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("del", key))
+		return reply.MakeIntReply(1)
+	}
+	db.Expire(key, expireTime)
+	db.addAof(utils.ToCmdLine("pexpireat", key, strconv.FormatInt(expireTime.UnixMilli(), 10)))
+	return reply.MakeIntReply(1)
+}
+
+func parseExpireArg(arg []byte) (int64, resp.Reply) {
+	num, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	return num, nil
+}
+
+// EXPIRE key seconds
+func execExpire(db *DB, args [][]byte) resp.Reply {
+	seconds, errReply := parseExpireArg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	return expireAt(db, string(args[0]), time.Now().Add(time.Duration(seconds)*time.Second))
+}
+
+// PEXPIRE key milliseconds
+func execPExpire(db *DB, args [][]byte) resp.Reply {
+	ms, errReply := parseExpireArg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	return expireAt(db, string(args[0]), time.Now().Add(time.Duration(ms)*time.Millisecond))
+}
+
+// EXPIREAT key unix-time-seconds
+func execExpireAt(db *DB, args [][]byte) resp.Reply {
+	ts, errReply := parseExpireArg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	return expireAt(db, string(args[0]), time.Unix(ts, 0))
+}
+
+// PEXPIREAT key unix-time-milliseconds
+func execPExpireAt(db *DB, args [][]byte) resp.Reply {
+	ts, errReply := parseExpireArg(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	return expireAt(db, string(args[0]), time.UnixMilli(ts))
+}
+
+// ttlReply -2 表示 key 不存在，-1 表示没有过期时间
+func ttlReply(db *DB, key string, unit time.Duration) resp.Reply {
+	if _, exists := db.GetEntity(key); !exists {
+		return reply.MakeIntReply(-2)
+	}
+	expireTime, ok := db.ttlOf(key)
+	if !ok {
+		return reply.MakeIntReply(-1)
+	}
+	remain := time.Until(expireTime)
+	// 与 redis 一致，按四舍五入换算
+	return reply.MakeIntReply(int64((remain + unit/2) / unit))
+}
+
+// TTL key
+func execTTL(db *DB, args [][]byte) resp.Reply {
+	return ttlReply(db, string(args[0]), time.Second)
+}
+
+// PTTL key
+func execPTTL(db *DB, args [][]byte) resp.Reply {
+	return ttlReply(db, string(args[0]), time.Millisecond)
+}
+
+// PERSIST key
+func execPersist(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); !exists {
+		return reply.MakeIntReply(0)
+	}
+	if !db.Persist(key) {
+		return reply.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine3("persist", args...))
+	return reply.MakeIntReply(1)
+}
+
+func init() {
+	RegisterCommand("expire", execExpire, 3).
+		attachCommandExtra([]string{"write", "fast"}, 1, 1, 1).
+		attachDocs("generic", "Sets the expiration time of a key in seconds.")
+	RegisterCommand("pexpire", execPExpire, 3).
+		attachCommandExtra([]string{"write", "fast"}, 1, 1, 1).
+		attachDocs("generic", "Sets the expiration time of a key in milliseconds.")
+	RegisterCommand("expireat", execExpireAt, 3).
+		attachCommandExtra([]string{"write", "fast"}, 1, 1, 1).
+		attachDocs("generic", "Sets the expiration time of a key to a Unix timestamp.")
+	RegisterCommand("pexpireat", execPExpireAt, 3).
+		attachCommandExtra([]string{"write", "fast"}, 1, 1, 1).
+		attachDocs("generic", "Sets the expiration time of a key to a Unix milliseconds timestamp.")
+	RegisterCommand("ttl", execTTL, 2).
+		attachCommandExtra([]string{"readonly", "fast"}, 1, 1, 1).
+		attachDocs("generic", "Returns the expiration time in seconds of a key.")
+	RegisterCommand("pttl", execPTTL, 2).
+		attachCommandExtra([]string{"readonly", "fast"}, 1, 1, 1).
+		attachDocs("generic", "Returns the expiration time in milliseconds of a key.")
+	RegisterCommand("persist", execPersist, 2).
+		attachCommandExtra([]string{"write", "fast"}, 1, 1, 1).
+		attachDocs("generic", "Removes the expiration time of a key.")
+}
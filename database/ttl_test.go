@@ -0,0 +1,145 @@
+package database
+
+import (
+	"bytes"
+	"go_redis/config"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/resp/connection"
+	"testing"
+	"time"
+)
+
+// makeTestDatabase 停止了 serverCron，过期 key 只在测试调用 activeExpireCycle 时删除
+func makeTestDatabase(t *testing.T) *StandaloneDatabase {
+	t.Helper()
+	d := NewStandaloneDatabase()
+	d.Close()
+	return d
+}
+
+// recordAof 记录 DB 0 写入 AOF 和复制流的命令
+func recordAof(d *StandaloneDatabase) *[]string {
+	logged := &[]string{}
+	d.dbSet[0].addAof = func(line CmdLine) {
+		*logged = append(*logged, string(bytes.Join(line, []byte(" "))))
+	}
+	return logged
+}
+
+func execLine(d *StandaloneDatabase, args ...string) resp.Reply {
+	return d.Exec(&connection.Connection{}, utils.ToCmdLine(args...))
+}
+
+func assertReply(t *testing.T, r resp.Reply, want string) {
+	t.Helper()
+	if got := string(r.ToBytes()); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func setConfig(t *testing.T, change func(p *config.ServerProperties)) {
+	t.Helper()
+	old := config.Properties()
+	props := *old
+	change(&props)
+	config.SetProperties(&props)
+	t.Cleanup(func() { config.SetProperties(old) })
+}
+
+func TestExpiredKeyDeletedUnderLock(t *testing.T) {
+	d := makeTestDatabase(t)
+	execLine(d, "set", "k", "v")
+	d.dbSet[0].Expire("k", time.Now().Add(-time.Second))
+	logged := recordAof(d)
+	assertReply(t, execLine(d, "get", "k"), "$-1\r\n")
+	assertReply(t, execLine(d, "exists", "k"), ":0\r\n")
+	// 读命令只隐藏，不删除
+	if _, ok := d.dbSet[0].data.Get("k"); !ok {
+		t.Fatal("expired key is deleted outside the write lock")
+	}
+	if len(*logged) != 0 {
+		t.Fatalf("read command logged %v", *logged)
+	}
+	d.activeExpireCycle()
+	if _, ok := d.dbSet[0].data.Get("k"); ok {
+		t.Fatal("expired key is not deleted by activeExpireCycle")
+	}
+	if len(*logged) != 1 || (*logged)[0] != "del k" {
+		t.Fatalf("logged %v, want [del k]", *logged)
+	}
+}
+
+func TestExpiredKeyRewrittenBeforeDelete(t *testing.T) {
+	d := makeTestDatabase(t)
+	execLine(d, "set", "k", "old")
+	d.dbSet[0].Expire("k", time.Now().Add(-time.Second))
+	assertReply(t, execLine(d, "get", "k"), "$-1\r\n")
+	// GET 记下了 k，删除前 SET 写入了新值
+	assertReply(t, execLine(d, "set", "k", "new"), "+OK\r\n")
+	logged := recordAof(d)
+	d.activeExpireCycle()
+	assertReply(t, execLine(d, "get", "k"), "$3\r\nnew\r\n")
+	if len(*logged) != 0 {
+		t.Fatalf("logged %v after rewrite", *logged)
+	}
+}
+
+func TestExpiredKeyOverwrite(t *testing.T) {
+	d := makeTestDatabase(t)
+	execLine(d, "set", "a", "old")
+	d.dbSet[0].Expire("a", time.Now().Add(-time.Second))
+	assertReply(t, execLine(d, "setnx", "a", "new"), ":1\r\n")
+	assertReply(t, execLine(d, "get", "a"), "$3\r\nnew\r\n")
+	assertReply(t, execLine(d, "ttl", "a"), ":-1\r\n")
+	execLine(d, "set", "b", "old")
+	d.dbSet[0].Expire("b", time.Now().Add(-time.Second))
+	assertReply(t, execLine(d, "del", "b"), ":0\r\n")
+}
+
+func TestExpiredKeyOnReplica(t *testing.T) {
+	d := makeTestDatabase(t)
+	execLine(d, "set", "k", "v")
+	d.dbSet[0].Expire("k", time.Now().Add(-time.Second))
+	d.repl.mu.Lock()
+	d.repl.master = &masterLink{}
+	d.repl.mu.Unlock()
+	defer func() {
+		d.repl.mu.Lock()
+		d.repl.master = nil
+		d.repl.mu.Unlock()
+	}()
+	logged := recordAof(d)
+	assertReply(t, execLine(d, "get", "k"), "$-1\r\n")
+	for pending := len(d.expiredKeys); pending > 0; pending-- {
+		<-d.expiredKeys
+		t.Fatal("replica queued an expired key")
+	}
+	if _, ok := d.dbSet[0].data.Get("k"); !ok || len(*logged) != 0 {
+		t.Fatal("replica deleted an expired key by itself")
+	}
+}
+
+func TestExpireCommands(t *testing.T) {
+	d := makeTestDatabase(t)
+	logged := recordAof(d)
+	assertReply(t, execLine(d, "expire", "missing", "10"), ":0\r\n")
+	assertReply(t, execLine(d, "ttl", "missing"), ":-2\r\n")
+	execLine(d, "set", "k", "v")
+	assertReply(t, execLine(d, "ttl", "k"), ":-1\r\n")
+	assertReply(t, execLine(d, "expire", "k", "100"), ":1\r\n")
+	assertReply(t, execLine(d, "ttl", "k"), ":100\r\n")
+	assertReply(t, execLine(d, "persist", "k"), ":1\r\n")
+	assertReply(t, execLine(d, "persist", "k"), ":0\r\n")
+	assertReply(t, execLine(d, "pexpire", "k", "-1"), ":1\r\n")
+	assertReply(t, execLine(d, "exists", "k"), ":0\r\n")
+	want := []string{"set k v", "pexpireat k", "persist k", "del k"}
+	if len(*logged) != len(want) {
+		t.Fatalf("logged %v", *logged)
+	}
+	for i, line := range want {
+		if !bytes.HasPrefix([]byte((*logged)[i]), []byte(line)) {
+			t.Fatalf("logged %v, want %v", *logged, want)
+		}
+	}
+}
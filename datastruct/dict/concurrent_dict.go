@@ -0,0 +1,246 @@
+package dict
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentDict 分段加锁的并发安全字典。
+// 与 SyncDict 相比，Len 为 O(1)，RandomKeys 能真正随机抽样，供内存淘汰和过期清理使用。
+type ConcurrentDict struct {
+	shards []*shard
+	count  atomic.Int64
+}
+
+type shard struct {
+	m  map[string]interface{}
+	mu sync.RWMutex
+}
+
+const (
+	defaultShardCount = 16
+	maxShardCount     = 1 << 16
+)
+
+// computeCapacity 把分段数调整为不小于 param 的 2 的幂
+func computeCapacity(param int) int {
+	if param <= defaultShardCount {
+		return defaultShardCount
+	}
+	n := 1
+	for n < param && n < maxShardCount {
+		n <<= 1
+	}
+	return n
+}
+
+// MakeConcurrent creates ConcurrentDict with the given number of shards
+func MakeConcurrent(shardCount int) *ConcurrentDict {
+	shardCount = computeCapacity(shardCount)
+	shards := make([]*shard, shardCount)
+	for i := range shards {
+		shards[i] = &shard{m: make(map[string]interface{})}
+	}
+	return &ConcurrentDict{shards: shards}
+}
+
+const prime32 = uint32(16777619)
+
+// fnv32 FNV-1a 哈希，用于定位分段
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return hash
+}
+
+func (dict *ConcurrentDict) getShard(key string) *shard {
+	return dict.shards[fnv32(key)&uint32(len(dict.shards)-1)]
+}
+
+func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
+	s := dict.getShard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, exists = s.m[key]
+	return val, exists
+}
+
+func (dict *ConcurrentDict) Len() int {
+	return int(dict.count.Load())
+}
+
+func (dict *ConcurrentDict) Put(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 0 // 更新操作
+	}
+	s.m[key] = val
+	dict.count.Add(1)
+	return 1 // 新增操作
+}
+
+func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		return 0
+	}
+	s.m[key] = val
+	dict.count.Add(1)
+	return 1
+}
+
+func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+func (dict *ConcurrentDict) Remove(key string) (result int) {
+	s := dict.getShard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		delete(s.m, key)
+		dict.count.Add(-1)
+		return 1
+	}
+	return 0
+}
+
+// ForEach 逐个分段复制后再回调，回调中可以安全地读写字典
+func (dict *ConcurrentDict) ForEach(consumer Consumer) {
+	for _, s := range dict.shards {
+		s.mu.RLock()
+		keys := make([]string, 0, len(s.m))
+		values := make([]interface{}, 0, len(s.m))
+		for key, value := range s.m {
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		s.mu.RUnlock()
+		for i := range keys {
+			if !consumer(keys[i], values[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Scan 以分段下标作为游标，每次遍历完整的分段，直到访问的键不少于 count 个。
+// 遍历期间增删的键可能被漏掉或重复返回，始终存在的键一定会返回
+func (dict *ConcurrentDict) Scan(cursor int, count int, consumer Consumer) int {
+	if cursor < 0 || cursor >= len(dict.shards) {
+		return 0
+	}
+	visited := 0
+	for ; cursor < len(dict.shards) && visited < count; cursor++ {
+		s := dict.shards[cursor]
+		s.mu.RLock()
+		keys := make([]string, 0, len(s.m))
+		values := make([]interface{}, 0, len(s.m))
+		for key, value := range s.m {
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		s.mu.RUnlock()
+		for i := range keys {
+			if !consumer(keys[i], values[i]) {
+				return 0
+			}
+		}
+		visited += len(keys)
+	}
+	if cursor >= len(dict.shards) {
+		return 0
+	}
+	return cursor
+}
+
+func (dict *ConcurrentDict) Keys() []string {
+	keys := make([]string, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// randomKey 随机选择一个分段，借助 map 遍历起点随机的特性取出一个 key
+func (s *shard) randomKey() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key := range s.m {
+		return key, true
+	}
+	return "", false
+}
+
+// RandomKeys 随机获取 n 个键，可能重复；字典为空时返回空切片
+func (dict *ConcurrentDict) RandomKeys(n int) []string {
+	size := dict.Len()
+	if size == 0 || n <= 0 {
+		return []string{}
+	}
+	result := make([]string, 0, n)
+	shardCount := len(dict.shards)
+	for len(result) < n {
+		// 从随机的分段开始依次向后找非空的分段，key 很少时也不会因为只抽中空分段而失败
+		start := rand.Intn(shardCount)
+		found := false
+		for i := 0; i < shardCount && !found; i++ {
+			var key string
+			if key, found = dict.shards[(start+i)%shardCount].randomKey(); found {
+				result = append(result, key)
+			}
+		}
+		if !found {
+			// 字典在抽样过程中被清空
+			break
+		}
+	}
+	return result
+}
+
+// RandomDistinctKeys 随机获取最多 n 个不同的键
+func (dict *ConcurrentDict) RandomDistinctKeys(n int) []string {
+	size := dict.Len()
+	if n > size {
+		n = size
+	}
+	seen := make(map[string]struct{}, n)
+	shardCount := len(dict.shards)
+	for attempts := 0; len(seen) < n && attempts < n*shardCount*4; attempts++ {
+		s := dict.shards[rand.Intn(shardCount)]
+		if key, ok := s.randomKey(); ok {
+			seen[key] = struct{}{}
+		}
+	}
+	result := make([]string, 0, len(seen))
+	for key := range seen {
+		result = append(result, key)
+	}
+	return result
+}
+
+func (dict *ConcurrentDict) Clear() {
+	for _, s := range dict.shards {
+		s.mu.Lock()
+		dict.count.Add(-int64(len(s.m)))
+		s.m = make(map[string]interface{})
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,40 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRandomKeysSparse(t *testing.T) {
+	dict := MakeConcurrent(1024)
+	if keys := dict.RandomKeys(1); len(keys) != 0 {
+		t.Fatalf("RandomKeys on an empty dict: %v", keys)
+	}
+	// 只有一个 key 时每次都能抽到
+	dict.Put("k", 1)
+	for i := 0; i < 100; i++ {
+		if keys := dict.RandomKeys(1); len(keys) != 1 || keys[0] != "k" {
+			t.Fatalf("RandomKeys = %v, want [k]", keys)
+		}
+	}
+	if keys := dict.RandomKeys(3); len(keys) != 3 {
+		t.Fatalf("RandomKeys(3) = %v", keys)
+	}
+}
+
+func TestRandomKeysDistribution(t *testing.T) {
+	dict := MakeConcurrent(16)
+	for i := 0; i < 100; i++ {
+		dict.Put("key:"+strconv.Itoa(i), i)
+	}
+	seen := make(map[string]bool)
+	for _, key := range dict.RandomKeys(2000) {
+		seen[key] = true
+	}
+	if len(seen) < 90 {
+		t.Fatalf("only %d of 100 keys sampled", len(seen))
+	}
+	if keys := dict.RandomDistinctKeys(10); len(keys) != 10 {
+		t.Fatalf("RandomDistinctKeys(10) = %v", keys)
+	}
+}
@@ -12,6 +12,7 @@ type Dict interface {
 	PutIfExists(key string, val interface{}) (result int)
 	Remove(key string) (result int)
 	ForEach(consumer Consumer)
+	Scan(cursor int, count int, consumer Consumer) int // 从 cursor 开始遍历至少 count 个键，返回下一个游标，0 表示遍历结束
 	Keys() []string
 	RandomKeys(n int) []string         // 随机获取n个键
 	RandomDistinctKeys(n int) []string // 随机获取n个不同的键
@@ -63,6 +63,14 @@ func (dict *SyncDict) ForEach(consumer Consumer) {
 	})
 }
 
+// Scan sync.Map 无法按位置续传，一次遍历全部键
+func (dict *SyncDict) Scan(cursor int, count int, consumer Consumer) int {
+	dict.m.Range(func(key, value interface{}) bool {
+		return consumer(key.(string), value)
+	})
+	return 0
+}
+
 func (dict *SyncDict) Keys() []string {
 	keys := make([]string, dict.Len())
 	index := 0
@@ -75,10 +83,11 @@ func (dict *SyncDict) Keys() []string {
 }
 
 func (dict *SyncDict) RandomKeys(n int) []string {
-	result := make([]string, n)
+	// 每次 Range 的起点是随机的，取第一个即可；字典为空时返回空切片
+	result := make([]string, 0, n)
 	for i := 0; i < n; i++ {
 		dict.m.Range(func(key, value interface{}) bool {
-			result[i] = key.(string)
+			result = append(result, key.(string))
 			return false
 		})
 	}
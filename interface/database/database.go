@@ -1,6 +1,9 @@
 package database
 
-import "go_redis/interface/resp"
+import (
+	"go_redis/interface/resp"
+	"sync/atomic"
+)
 
 // 代表redis的业务核心
 
@@ -8,6 +11,10 @@ type CmdLine = [][]byte
 
 type DataEntity struct {
 	Data interface{}
+
+	// 内存淘汰使用的访问信息，由 database 包维护
+	LRU atomic.Uint32 // 最近一次访问的时钟，秒
+	LFU atomic.Uint32 // 高 24 位为上次衰减时的分钟时钟，低 8 位为对数访问计数
 }
 
 type Database interface {
@@ -1,7 +1,41 @@
 package resp
 
+import "net"
+
 type Connection interface {
-	Write([]byte) error // 写入数据
-	GetDBIndex() int    // 得到DB索引
-	SelectDB(int)       // 切换DB
+	Write([]byte) error   // 写入数据
+	GetDBIndex() int      // 得到DB索引
+	SelectDB(int)         // 切换DB
+	SetPassword(string)   // 记录AUTH通过的密码
+	GetPassword() string  // 得到AUTH通过的密码
+	SetName(string)       // CLIENT SETNAME
+	GetName() string      // CLIENT GETNAME
+	RemoteAddr() net.Addr // 客户端地址，内部伪造的连接返回 nil
+	GetID() int64         // 连接 ID
+	SetProtocol(int)      // HELLO 切换 RESP 版本
+	GetProtocol() int     // 当前 RESP 版本，2 或 3
+	Close() error         // 关闭连接
+
+	Done() <-chan struct{} // 连接关闭时关闭
+	Block() func()         // 命令开始阻塞等待，期间客户端断开会关闭连接，结束等待时调用返回的函数
+
+	WriteAsync([]byte) error     // 放入发送队列后立即返回，计入输出缓冲区
+	WriteBulkAsync([]byte) error // 同 WriteAsync，但不计入输出缓冲区，用于全量同步的快照
+	SetOutputClass(string)       // 设置 client-output-buffer-limit 中的类别
+	SetReplica(bool)             // 标记为从节点连接，之后不再回复该连接发来的命令
+	IsReplica() bool
+	SetWriteOffset(int64) // 记录最近一次写命令执行后的复制偏移量，WAIT 使用
+	GetWriteOffset() int64
+	SetAsking(bool) // 集群 ASKING，只对下一条命令有效
+	IsAsking() bool
+	SetReadOnly(bool) // 集群 READONLY / READWRITE，是否允许在从节点上读
+	IsReadOnly() bool
+	SetPeer(bool) // 集群中来自其他节点的连接，KEYS DBSIZE 等需要汇总的命令只在本节点执行
+	IsPeer() bool
+	SetMultiState(bool) // MULTI 开始事务，EXEC / DISCARD 结束事务时清空队列
+	InMultiState() bool
+	EnqueueCmd([][]byte) // 事务中的命令先入队，EXEC 时一起执行
+	GetQueuedCmdLine() [][][]byte
+	AddTxError(error) // 入队时出错，EXEC 返回 EXECABORT
+	GetTxErrors() []error
 }
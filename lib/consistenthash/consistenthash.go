@@ -3,29 +3,52 @@ package consistenthash
 import (
 	"hash/crc32"
 	"sort"
+	"strconv"
 )
 
 type HashFunc func(data []byte) uint32
 
+// DefaultReplicas 每个物理节点（权重为 1 时）在环上放置的虚拟节点个数
+const DefaultReplicas = 160
+
 type NodeMap struct {
 	// 节点映射
 	hashFunc    HashFunc
+	replicas    int            // 权重为 1 的节点对应的虚拟节点个数
+	weights     map[string]int // 物理节点及其权重
 	nodeHashs   []int
 	nodeHashMap map[int]string
 }
 
-func NewNodeMap(hashFunc HashFunc) *NodeMap {
+// NewNodeMap 创建哈希环，replicas 小于 1 时使用 DefaultReplicas，hashFunc 为 nil 时使用 CRC32
+func NewNodeMap(replicas int, hashFunc HashFunc) *NodeMap {
 	m := &NodeMap{
 		hashFunc:    hashFunc,
+		replicas:    replicas,
+		weights:     make(map[string]int),
 		nodeHashs:   make([]int, 0),
 		nodeHashMap: make(map[int]string),
 	}
 	if m.hashFunc == nil {
 		m.hashFunc = crc32.ChecksumIEEE
 	}
+	if m.replicas < 1 {
+		m.replicas = DefaultReplicas
+	}
 	return m
 }
 
+// GetHashFunc 按名称返回哈希函数，支持 crc32 和 murmur3
+func GetHashFunc(name string) (HashFunc, bool) {
+	switch name {
+	case "crc32":
+		return crc32.ChecksumIEEE, true
+	case "murmur3":
+		return Murmur3, true
+	}
+	return nil, false
+}
+
 func (m *NodeMap) IsEmpty() bool {
 	return len(m.nodeHashs) == 0
 }
@@ -36,9 +59,54 @@ func (m *NodeMap) AddNodes(keys ...string) {
 		if key == "" {
 			continue
 		}
-		hash := int(m.hashFunc([]byte(key)))
-		m.nodeHashs = append(m.nodeHashs, hash)
-		m.nodeHashMap[hash] = key
+		m.weights[key] = 1
+	}
+	m.rebuild()
+}
+
+// AddNode 以指定权重加入节点，虚拟节点个数为 replicas * weight；节点已存在时修改其权重
+func (m *NodeMap) AddNode(key string, weight int) {
+	if key == "" || weight < 1 {
+		return
+	}
+	m.weights[key] = weight
+	m.rebuild()
+}
+
+// RemoveNode 从环上移除节点及其全部虚拟节点，原来属于它的 key 落到环上的下一个节点
+func (m *NodeMap) RemoveNode(key string) {
+	if _, ok := m.weights[key]; !ok {
+		return
+	}
+	delete(m.weights, key)
+	m.rebuild()
+}
+
+// Nodes 返回环上的全部物理节点
+func (m *NodeMap) Nodes() []string {
+	nodes := make([]string, 0, len(m.weights))
+	for node := range m.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// rebuild 重新生成整个环。按节点名顺序放置虚拟节点，哈希冲突时保留先放置的，
+// 因此节点加入的顺序不影响结果，集群中所有节点算出的环都相同
+func (m *NodeMap) rebuild() {
+	m.nodeHashs = m.nodeHashs[:0]
+	m.nodeHashMap = make(map[int]string)
+	for _, node := range m.Nodes() {
+		count := m.replicas * m.weights[node]
+		for i := 0; i < count; i++ {
+			hash := int(m.hashFunc([]byte(node + "#" + strconv.Itoa(i))))
+			if _, ok := m.nodeHashMap[hash]; ok {
+				continue
+			}
+			m.nodeHashs = append(m.nodeHashs, hash)
+			m.nodeHashMap[hash] = node
+		}
 	}
 	sort.Ints(m.nodeHashs)
 }
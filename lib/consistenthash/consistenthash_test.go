@@ -0,0 +1,124 @@
+package consistenthash
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// keyDistribution 把 n 个 key 分配到节点上，返回每个节点的 key 数
+func keyDistribution(m *NodeMap, n int) map[string]int {
+	counts := make(map[string]int)
+	for _, node := range m.Nodes() {
+		counts[node] = 0
+	}
+	for i := 0; i < n; i++ {
+		counts[m.PickNode("key:"+strconv.Itoa(i))]++
+	}
+	return counts
+}
+
+// relativeStddev 每个节点 key 数的标准差占平均值的比例
+func relativeStddev(counts map[string]int) float64 {
+	var sum float64
+	for _, c := range counts {
+		sum += float64(c)
+	}
+	mean := sum / float64(len(counts))
+	var variance float64
+	for _, c := range counts {
+		variance += (float64(c) - mean) * (float64(c) - mean)
+	}
+	return math.Sqrt(variance/float64(len(counts))) / mean
+}
+
+func makeNodes(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = "127.0.0.1:" + strconv.Itoa(7000+i)
+	}
+	return nodes
+}
+
+func TestDistribution(t *testing.T) {
+	const keys = 200000
+	for _, name := range []string{"crc32", "murmur3"} {
+		hashFunc, _ := GetHashFunc(name)
+		for _, replicas := range []int{1, 40, 160} {
+			for _, nodeCount := range []int{2, 3, 4, 8} {
+				m := NewNodeMap(replicas, hashFunc)
+				m.AddNodes(makeNodes(nodeCount)...)
+				stddev := relativeStddev(keyDistribution(m, keys))
+				t.Logf("%-7s replicas=%-3d nodes=%d stddev=%.1f%%", name, replicas, nodeCount, stddev*100)
+				if name == "murmur3" && replicas == DefaultReplicas && stddev > 0.2 {
+					t.Errorf("murmur3 with %d virtual nodes: stddev %.1f%% is too high", replicas, stddev*100)
+				}
+			}
+		}
+	}
+}
+
+func TestWeight(t *testing.T) {
+	m := NewNodeMap(0, Murmur3)
+	nodes := makeNodes(3)
+	m.AddNode(nodes[0], 1)
+	m.AddNode(nodes[1], 1)
+	m.AddNode(nodes[2], 2)
+	counts := keyDistribution(m, 100000)
+	ratio := float64(counts[nodes[2]]) / float64(counts[nodes[0]]+counts[nodes[1]])
+	if ratio < 0.8 || ratio > 1.25 {
+		t.Errorf("weight 2 node got %d keys, others %d and %d", counts[nodes[2]], counts[nodes[0]], counts[nodes[1]])
+	}
+}
+
+func TestRemoveNode(t *testing.T) {
+	m := NewNodeMap(0, Murmur3)
+	nodes := makeNodes(4)
+	m.AddNodes(nodes...)
+	before := make([]string, 10000)
+	for i := range before {
+		before[i] = m.PickNode("key:" + strconv.Itoa(i))
+	}
+	m.RemoveNode(nodes[1])
+	for i, owner := range before {
+		after := m.PickNode("key:" + strconv.Itoa(i))
+		if after == nodes[1] {
+			t.Fatalf("key:%d still on removed node", i)
+		}
+		if owner != nodes[1] && after != owner {
+			t.Fatalf("key:%d moved from %s to %s", i, owner, after)
+		}
+	}
+}
+
+func TestAddOrder(t *testing.T) {
+	nodes := makeNodes(5)
+	a := NewNodeMap(0, nil)
+	a.AddNodes(nodes...)
+	b := NewNodeMap(0, nil)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		b.AddNodes(nodes[i])
+	}
+	for i := 0; i < 10000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if a.PickNode(key) != b.PickNode(key) {
+			t.Fatalf("%s: order of AddNodes changed the owner", key)
+		}
+	}
+}
+
+func TestMurmur3(t *testing.T) {
+	// murmur3 x86_32，种子为 0 的标准测试向量
+	cases := map[string]uint32{
+		"":              0,
+		"a":             0x3c2569b2,
+		"abc":           0xb3dd93fa,
+		"hello":         0x248bfa47,
+		"Hello, world!": 0xc0363e43,
+	}
+	for in, want := range cases {
+		if got := Murmur3([]byte(in)); got != want {
+			t.Errorf("Murmur3(%q) = %#x, want %#x", in, got, want)
+		}
+	}
+}
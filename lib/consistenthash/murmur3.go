@@ -0,0 +1,52 @@
+package consistenthash
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// murmur3 的 32 位版本（x86_32），种子为 0。
+// crc32 是线性的，"node#1"、"node#2" 这类只差几个字节的虚拟节点名算出的哈希值分布很差，murmur3 没有这个问题
+
+const (
+	murmurC1 = 0xcc9e2d51
+	murmurC2 = 0x1b873593
+)
+
+// Murmur3 计算 data 的 32 位 murmur3 哈希
+func Murmur3(data []byte) uint32 {
+	var h uint32
+	length := len(data)
+	for ; len(data) >= 4; data = data[4:] {
+		k := binary.LittleEndian.Uint32(data)
+		k *= murmurC1
+		k = bits.RotateLeft32(k, 15)
+		k *= murmurC2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+	// 剩余不足 4 字节的部分
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		k *= murmurC1
+		k = bits.RotateLeft32(k, 15)
+		k *= murmurC2
+		h ^= k
+	}
+	h ^= uint32(length)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,53 @@
+package slot
+
+import "strings"
+
+// Count redis cluster 的哈希槽个数
+const Count = 16384
+
+// crc16Table CRC16-CCITT（XMODEM）查找表，多项式 0x1021，初始值 0，与 redis 的 crc16.c 相同
+var crc16Table = makeCRC16Table()
+
+func makeCRC16Table() [256]uint16 {
+	var table [256]uint16
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// CRC16 计算 data 的 CRC16
+func CRC16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// HashTag 返回 key 中参与计算槽位的部分：第一个 { 与其后第一个 } 之间的内容，
+// 没有 {、没有匹配的 } 或者 {} 之间为空时使用整个 key
+func HashTag(key string) string {
+	begin := strings.IndexByte(key, '{')
+	if begin < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[begin+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[begin+1 : begin+1+end]
+}
+
+// KeySlot 计算 key 所在的槽位
+func KeySlot(key string) int {
+	return int(CRC16(HashTag(key)) & (Count - 1))
+}
@@ -0,0 +1,60 @@
+package slot
+
+import "testing"
+
+func TestCRC16(t *testing.T) {
+	cases := map[string]uint16{
+		"":          0,
+		"123456789": 0x31c3, // redis crc16.c 中的测试向量
+		"a":         0x7c87,
+	}
+	for in, want := range cases {
+		if got := CRC16(in); got != want {
+			t.Errorf("CRC16(%q) = %#x, want %#x", in, got, want)
+		}
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	cases := map[string]string{
+		"foo":                  "foo",
+		"{user1000}.following": "user1000",
+		"{user1000}.followers": "user1000",
+		"foo{bar}{zap}":        "bar",
+		"foo{}{bar}":           "foo{}{bar}",
+		"foo{{bar}}zap":        "{bar",
+		"foo{bar":              "foo{bar",
+		"foo}bar{":             "foo}bar{",
+		"}{a}":                 "a",
+		"{}":                   "{}",
+	}
+	for key, want := range cases {
+		if got := HashTag(key); got != want {
+			t.Errorf("HashTag(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	// 与 redis CLUSTER KEYSLOT 的结果相同
+	cases := map[string]int{
+		"foo":           12182,
+		"bar":           5061,
+		"hello":         866,
+		"somekey":       11058,
+		"foo{hash_tag}": 2515,
+	}
+	for key, want := range cases {
+		if got := KeySlot(key); got != want {
+			t.Errorf("KeySlot(%q) = %d, want %d", key, got, want)
+		}
+	}
+	if KeySlot("{user1000}.following") != KeySlot("{user1000}.followers") {
+		t.Error("keys with the same hash tag are in different slots")
+	}
+	for _, key := range []string{"", "a", "\xff\xfe", "a very long key with spaces and {tags}"} {
+		if s := KeySlot(key); s < 0 || s >= Count {
+			t.Errorf("KeySlot(%q) = %d out of range", key, s)
+		}
+	}
+}
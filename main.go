@@ -7,14 +7,16 @@ import (
 	"go_redis/resp/handler"
 	"go_redis/tcp"
 	"os"
+	"strconv"
 )
 
 const configFile string = "redis.conf"
 
-var defaultProperties = &config.ServerProperties{
-	Bind: "0.0.0.0",
-	Port: 6379,
-}
+var defaultProperties = func() *config.ServerProperties {
+	properties := config.NewServerProperties()
+	properties.Bind = "0.0.0.0"
+	return properties
+}()
 
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
@@ -32,16 +34,34 @@ func main() {
 	if fileExists(configFile) {
 		config.SetupConfig(configFile)
 	} else {
-		config.Properties = defaultProperties
+		config.SetProperties(defaultProperties)
 	}
 
-	err := tcp.ListenAndServeWithSignal(
-		&tcp.Config{
-			Address: fmt.Sprintf("%s:%d",
-				config.Properties.Bind,
-				config.Properties.Port),
-		},
-		handler.MakeRespHandler())
+	tcpConfig := &tcp.Config{}
+	if config.Properties().Port != 0 {
+		tcpConfig.Address = fmt.Sprintf("%s:%d", config.Properties().Bind, config.Properties().Port)
+	}
+	if config.Properties().TlsPort != 0 {
+		tlsConfig, err := config.ServerTLSConfig()
+		if err != nil {
+			logger.Error("load tls config failed:", err)
+			return
+		}
+		tcpConfig.TLSAddress = fmt.Sprintf("%s:%d", config.Properties().Bind, config.Properties().TlsPort)
+		tcpConfig.TLSConfig = tlsConfig
+	}
+	if config.Properties().UnixSocket != "" {
+		tcpConfig.UnixSocket = config.Properties().UnixSocket
+		if perm := config.Properties().UnixSocketPerm; perm != "" {
+			mode, err := strconv.ParseUint(perm, 8, 32)
+			if err != nil {
+				logger.Error("invalid unixsocketperm " + perm)
+				return
+			}
+			tcpConfig.UnixSocketPerm = os.FileMode(mode)
+		}
+	}
+	err := tcp.ListenAndServeWithSignal(tcpConfig, handler.MakeRespHandler())
 	if err != nil {
 		logger.Error(err)
 	}
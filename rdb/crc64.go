@@ -0,0 +1,13 @@
+package rdb
+
+import "hash/crc64"
+
+// RDB 文件末尾的校验和使用 Jones 多项式的 CRC64（反射输入输出，初值 0，结果不取反），
+// 与 redis 的 crc64.c 一致。标准库的实现会对初值和结果取反，这里取反抵消
+
+var jonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5) // 0xad93d23594c935a9 按位反转
+
+// crc64Update 在 crc 的基础上继续计算 p 的校验和
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, jonesTable, p)
+}
@@ -0,0 +1,426 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Decoder 读取 RDB 快照
+type Decoder struct {
+	Aux map[string]string // 解析过程中读到的辅助字段，如 redis-ver、repl-stream-db
+
+	r       *bufio.Reader
+	crc     uint64
+	version int
+	buf     [8]byte
+}
+
+func NewDecoder(rd io.Reader) *Decoder {
+	return &Decoder{
+		Aux: make(map[string]string),
+		r:   bufio.NewReader(rd),
+	}
+}
+
+var errUnexpectedType = errors.New("rdb: unsupported value type")
+
+// readFull 读取 p 长度的数据并累计校验和
+func (dec *Decoder) readFull(p []byte) error {
+	if _, err := io.ReadFull(dec.r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	dec.crc = crc64Update(dec.crc, p)
+	return nil
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	if err := dec.readFull(dec.buf[:1]); err != nil {
+		return 0, err
+	}
+	return dec.buf[0], nil
+}
+
+func (dec *Decoder) readUint64() (uint64, error) {
+	if err := dec.readFull(dec.buf[:8]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(dec.buf[:8]), nil
+}
+
+// readLength 读取长度，encoded 为 true 时 n 表示字符串的特殊编码方式
+func (dec *Decoder) readLength() (n uint64, encoded bool, err error) {
+	first, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit:
+		next, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case lenEncVal:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case len32Bit:
+		if err := dec.readFull(dec.buf[:4]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(dec.buf[:4])), false, nil
+	case len64Bit:
+		if err := dec.readFull(dec.buf[:8]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(dec.buf[:8]), false, nil
+	}
+	return 0, false, fmt.Errorf("rdb: unknown length encoding 0x%x", first)
+}
+
+func (dec *Decoder) readLen() (uint64, error) {
+	n, encoded, err := dec.readLength()
+	if err == nil && encoded {
+		err = errors.New("rdb: unexpected encoded length")
+	}
+	return n, err
+}
+
+// readString 读取字符串，整数编码和 LZF 压缩的字符串会被还原
+func (dec *Decoder) readString() ([]byte, error) {
+	n, encoded, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		buf := make([]byte, n)
+		if err := dec.readFull(buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	switch n {
+	case encInt8:
+		b, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatInt(int64(int8(b)), 10)), nil
+	case encInt16:
+		if err := dec.readFull(dec.buf[:2]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(dec.buf[:2]))), 10)), nil
+	case encInt32:
+		if err := dec.readFull(dec.buf[:4]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(dec.buf[:4]))), 10)), nil
+	case encLzf:
+		compressedLen, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		compressed := make([]byte, compressedLen)
+		if err := dec.readFull(compressed); err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(rawLen))
+	}
+	return nil, fmt.Errorf("rdb: unknown string encoding %d", n)
+}
+
+func (dec *Decoder) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := dec.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse 读取整个快照，每读到一个 key 调用一次 fn，fn 返回错误时停止读取
+func (dec *Decoder) Parse(fn func(entry *Entry) error) error {
+	header := make([]byte, 9)
+	if err := dec.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:5]) != magic {
+		return errors.New("rdb: wrong signature")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > maxVersion {
+		return fmt.Errorf("rdb: can't handle RDB format version %s", header[5:])
+	}
+	dec.version = version
+	dbIndex := 0
+	var expireAt int64
+	for {
+		op, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opEOF:
+			return dec.verifyChecksum()
+		case opSelectDB:
+			n, err := dec.readLen()
+			if err != nil {
+				return err
+			}
+			dbIndex = int(n)
+		case opResizeDB:
+			if _, err := dec.readLen(); err != nil {
+				return err
+			}
+			if _, err := dec.readLen(); err != nil {
+				return err
+			}
+		case opAux:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			value, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			dec.Aux[string(key)] = string(value)
+		case opFunction2:
+			if err := dec.skipStrings(1); err != nil {
+				return err
+			}
+		case opSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := dec.readLen(); err != nil {
+					return err
+				}
+			}
+		case opIdle:
+			if _, err := dec.readLen(); err != nil {
+				return err
+			}
+		case opFreq:
+			if _, err := dec.readByte(); err != nil {
+				return err
+			}
+		case opExpireTimeMs:
+			ms, err := dec.readUint64()
+			if err != nil {
+				return err
+			}
+			expireAt = int64(ms)
+		case opExpireTime:
+			if err := dec.readFull(dec.buf[:4]); err != nil {
+				return err
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(dec.buf[:4])) * 1000
+		case opModuleAux, opFunctionPreGA:
+			return fmt.Errorf("rdb: unsupported opcode 0x%x", op)
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			entry := &Entry{DB: dbIndex, Key: string(key), Type: op, ExpireAt: expireAt}
+			if op == TypeString {
+				entry.Value, err = dec.readString()
+			} else {
+				err = dec.skipValue(op)
+			}
+			if err != nil {
+				return err
+			}
+			expireAt = 0
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// verifyChecksum 版本 5 开始文件末尾有 8 字节校验和，为 0 表示生成时关闭了校验
+func (dec *Decoder) verifyChecksum() error {
+	if dec.version < 5 {
+		return nil
+	}
+	expected := dec.crc
+	if _, err := io.ReadFull(dec.r, dec.buf[:8]); err != nil {
+		return err
+	}
+	checksum := binary.LittleEndian.Uint64(dec.buf[:8])
+	if checksum != 0 && checksum != expected {
+		return errors.New("rdb: wrong checksum")
+	}
+	return nil
+}
+
+// skipValue 跳过暂不支持的类型的值
+func (dec *Decoder) skipValue(valueType byte) error {
+	switch valueType {
+	case TypeHashZipmap, TypeListZiplist, TypeSetIntset, TypeZSetZiplist, TypeHashZiplist,
+		TypeHashListpack, TypeZSetListpack, TypeSetListpack:
+		// 整个值编码为一个字符串
+		return dec.skipStrings(1)
+	case TypeList, TypeSet, TypeListQuicklist:
+		n, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		return dec.skipStrings(n)
+	case TypeHash:
+		n, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		return dec.skipStrings(2 * n)
+	case TypeListQuicklist2:
+		n, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := dec.readLen(); err != nil { // 节点类型
+				return err
+			}
+			if err := dec.skipStrings(1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case TypeZSet, TypeZSet2:
+		n, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := dec.skipStrings(1); err != nil {
+				return err
+			}
+			if err := dec.skipScore(valueType); err != nil {
+				return err
+			}
+		}
+		return nil
+	case TypeStreamListpacks, TypeStreamListpack2, TypeStreamListpack3:
+		return dec.skipStream(valueType)
+	}
+	return errUnexpectedType
+}
+
+// skipScore 跳过有序集合的分数，TypeZSet 以字符串存放，TypeZSet2 为 8 字节二进制
+func (dec *Decoder) skipScore(valueType byte) error {
+	if valueType == TypeZSet2 {
+		_, err := dec.readUint64()
+		return err
+	}
+	n, err := dec.readByte()
+	if err != nil {
+		return err
+	}
+	if n >= 253 {
+		// 253、254、255 分别表示 nan、+inf、-inf
+		return nil
+	}
+	return dec.readFull(make([]byte, n))
+}
+
+func (dec *Decoder) skipLens(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := dec.readLen(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipStream 跳过 stream，格式见 redis rdb.c 的 rdbSaveObject
+func (dec *Decoder) skipStream(valueType byte) error {
+	n, err := dec.readLen()
+	if err != nil {
+		return err
+	}
+	// listpack 节点：主 ID 和 listpack
+	if err := dec.skipStrings(2 * n); err != nil {
+		return err
+	}
+	// 元素个数、最后一个 ID
+	if err := dec.skipLens(3); err != nil {
+		return err
+	}
+	if valueType >= TypeStreamListpack2 {
+		// 第一个 ID、最大删除 ID、写入总数
+		if err := dec.skipLens(5); err != nil {
+			return err
+		}
+	}
+	groups, err := dec.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if err := dec.skipStrings(1); err != nil {
+			return err
+		}
+		if err := dec.skipLens(2); err != nil {
+			return err
+		}
+		if valueType >= TypeStreamListpack2 {
+			if err := dec.skipLens(1); err != nil {
+				return err
+			}
+		}
+		// 组的 PEL：16 字节 ID、8 字节投递时间、投递次数
+		pending, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			if err := dec.readFull(make([]byte, 24)); err != nil {
+				return err
+			}
+			if _, err := dec.readLen(); err != nil {
+				return err
+			}
+		}
+		consumers, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if err := dec.skipStrings(1); err != nil {
+				return err
+			}
+			// seen-time，版本 3 还有 active-time
+			skip := 8
+			if valueType >= TypeStreamListpack3 {
+				skip = 16
+			}
+			if err := dec.readFull(make([]byte, skip)); err != nil {
+				return err
+			}
+			// 消费者的 PEL 只有 16 字节 ID
+			pending, err := dec.readLen()
+			if err != nil {
+				return err
+			}
+			if err := dec.readFull(make([]byte, 16*pending)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
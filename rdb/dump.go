@@ -0,0 +1,56 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// DUMP / RESTORE 使用的序列化格式与 redis 相同：
+// <类型 1 字节> <RDB 编码的值> <RDB 版本 2 字节，小端> <前面所有内容的 CRC64，8 字节，小端>
+
+// ErrBadDumpPayload 版本过高或校验和错误
+var ErrBadDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// DumpString 序列化一个字符串值
+func DumpString(value []byte) []byte {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.writeByte(TypeString)
+	enc.writeString(value)
+	binary.LittleEndian.PutUint16(enc.buf[:2], Version)
+	enc.write(enc.buf[:2])
+	_ = enc.w.Flush()
+	binary.LittleEndian.PutUint64(enc.buf[:8], enc.crc)
+	buf.Write(enc.buf[:8])
+	return buf.Bytes()
+}
+
+// LoadDump 反序列化 DUMP 的结果，返回值类型和字符串值；不是字符串类型时只返回类型，由调用方决定如何处理
+func LoadDump(payload []byte) (byte, []byte, error) {
+	if len(payload) < 10 {
+		return 0, nil, ErrBadDumpPayload
+	}
+	footer := payload[len(payload)-10:]
+	version := binary.LittleEndian.Uint16(footer[:2])
+	if version > maxVersion {
+		return 0, nil, ErrBadDumpPayload
+	}
+	checksum := binary.LittleEndian.Uint64(footer[2:])
+	if crc64Update(0, payload[:len(payload)-8]) != checksum {
+		return 0, nil, ErrBadDumpPayload
+	}
+	dec := NewDecoder(bytes.NewReader(payload[:len(payload)-10]))
+	valueType, err := dec.readByte()
+	if err != nil {
+		return 0, nil, ErrBadDumpPayload
+	}
+	if valueType != TypeString {
+		return valueType, nil, nil
+	}
+	value, err := dec.readString()
+	if err != nil {
+		return 0, nil, ErrBadDumpPayload
+	}
+	return valueType, value, nil
+}
@@ -0,0 +1,32 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	for _, value := range [][]byte{{}, []byte("bar"), bytes.Repeat([]byte("z"), 70000)} {
+		payload := DumpString(value)
+		valueType, got, err := LoadDump(payload)
+		if err != nil || valueType != TypeString || !bytes.Equal(got, value) {
+			t.Fatalf("LoadDump: type %d len %d err %v", valueType, len(got), err)
+		}
+		bad := append([]byte(nil), payload...)
+		bad[1] ^= 0xff
+		if _, _, err := LoadDump(bad); err != ErrBadDumpPayload {
+			t.Errorf("corrupted payload: err %v", err)
+		}
+	}
+	// redis 7 生成的格式，RDB 版本为 11
+	payload := []byte("\x00\x03bar\x0b\x00")
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, crc64Update(0, payload))
+	if _, got, err := LoadDump(append(payload, checksum...)); err != nil || string(got) != "bar" {
+		t.Errorf("LoadDump redis payload: %q %v", got, err)
+	}
+	if _, _, err := LoadDump([]byte("\x00\x03bar\x0d\x00\x00\x00\x00\x00\x00\x00\x00\x00")); err != ErrBadDumpPayload {
+		t.Errorf("future version: err %v", err)
+	}
+}
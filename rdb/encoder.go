@@ -0,0 +1,113 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Encoder 按 RDB 格式写出快照，写完后需要调用 WriteEnd 写入校验和并刷出缓冲区
+//
+//	enc := rdb.NewEncoder(w)
+//	enc.WriteHeader()
+//	enc.WriteDBHeader(0, size, expires)
+//	enc.WriteString(key, value, expireAt)
+//	enc.WriteEnd()
+type Encoder struct {
+	w   *bufio.Writer
+	crc uint64
+	err error // 第一次写入出错后后续写入都直接返回该错误
+	buf [9]byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+func (enc *Encoder) write(p []byte) {
+	if enc.err != nil {
+		return
+	}
+	enc.crc = crc64Update(enc.crc, p)
+	_, enc.err = enc.w.Write(p)
+}
+
+func (enc *Encoder) writeByte(b byte) {
+	enc.buf[0] = b
+	enc.write(enc.buf[:1])
+}
+
+func (enc *Encoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		enc.writeByte(byte(n))
+	case n < 1<<14:
+		enc.buf[0] = byte(n>>8) | len14Bit<<6
+		enc.buf[1] = byte(n)
+		enc.write(enc.buf[:2])
+	case n <= 0xffffffff:
+		enc.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(enc.buf[1:], uint32(n))
+		enc.write(enc.buf[:5])
+	default:
+		enc.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(enc.buf[1:], n)
+		enc.write(enc.buf[:9])
+	}
+}
+
+func (enc *Encoder) writeString(s []byte) {
+	enc.writeLength(uint64(len(s)))
+	enc.write(s)
+}
+
+// WriteHeader 写入魔数和版本号，如 REDIS0009
+func (enc *Encoder) WriteHeader() error {
+	enc.write([]byte(fmt.Sprintf("%s%04d", magic, Version)))
+	return enc.err
+}
+
+// WriteAux 写入一个辅助字段
+func (enc *Encoder) WriteAux(key, value string) error {
+	enc.writeByte(opAux)
+	enc.writeString([]byte(key))
+	enc.writeString([]byte(value))
+	return enc.err
+}
+
+// WriteDBHeader 开始写入一个 DB，size 和 expireSize 只是给加载方预分配空间的提示
+func (enc *Encoder) WriteDBHeader(dbIndex int, size, expireSize uint64) error {
+	enc.writeByte(opSelectDB)
+	enc.writeLength(uint64(dbIndex))
+	enc.writeByte(opResizeDB)
+	enc.writeLength(size)
+	enc.writeLength(expireSize)
+	return enc.err
+}
+
+// WriteString 写入一个字符串类型的 key，expireAt 为 unix 毫秒，0 表示没有过期时间
+func (enc *Encoder) WriteString(key string, value []byte, expireAt int64) error {
+	if expireAt > 0 {
+		enc.writeByte(opExpireTimeMs)
+		binary.LittleEndian.PutUint64(enc.buf[:8], uint64(expireAt))
+		enc.write(enc.buf[:8])
+	}
+	enc.writeByte(TypeString)
+	enc.writeString([]byte(key))
+	enc.writeString(value)
+	return enc.err
+}
+
+// WriteEnd 写入结束标记和校验和
+func (enc *Encoder) WriteEnd() error {
+	enc.writeByte(opEOF)
+	if enc.err != nil {
+		return enc.err
+	}
+	binary.LittleEndian.PutUint64(enc.buf[:8], enc.crc)
+	if _, err := enc.w.Write(enc.buf[:8]); err != nil {
+		return err
+	}
+	return enc.w.Flush()
+}
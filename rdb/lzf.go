@@ -0,0 +1,53 @@
+package rdb
+
+import "errors"
+
+var errLzfCorrupt = errors.New("rdb: corrupt lzf compressed string")
+
+// lzfDecompress 解压 redis 使用的 LZF 压缩字符串，outLen 为解压后的长度
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, outLen)
+	i, o := 0, 0
+	for i < len(in) {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// 字面量，长度为 ctrl+1
+			n := ctrl + 1
+			if i+n > len(in) || o+n > outLen {
+				return nil, errLzfCorrupt
+			}
+			copy(out[o:], in[i:i+n])
+			i += n
+			o += n
+			continue
+		}
+		// 回溯引用，高 3 位为长度，7 表示长度存放在下一个字节
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errLzfCorrupt
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLzfCorrupt
+		}
+		ref := o - ((ctrl & 0x1f) << 8) - int(in[i]) - 1
+		i++
+		length += 2
+		if ref < 0 || o+length > outLen {
+			return nil, errLzfCorrupt
+		}
+		// 引用区间可能与输出区间重叠，逐字节复制
+		for j := 0; j < length; j++ {
+			out[o] = out[ref+j]
+			o++
+		}
+	}
+	if o != outLen {
+		return nil, errLzfCorrupt
+	}
+	return out, nil
+}
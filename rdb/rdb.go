@@ -0,0 +1,75 @@
+package rdb
+
+// RDB 快照格式，用于主从复制的全量同步。
+// 写入时只使用字符串类型；读取时兼容 redis 7 生成的文件，非字符串类型的 key 会被跳过
+
+const (
+	magic   = "REDIS"
+	Version = 9 // 写入的版本号，redis 5.0 及以上都能读取
+
+	maxVersion = 12 // 能读取的最高版本
+)
+
+// 值类型
+const (
+	TypeString          = 0
+	TypeList            = 1
+	TypeSet             = 2
+	TypeZSet            = 3
+	TypeHash            = 4
+	TypeZSet2           = 5
+	TypeModule          = 6
+	TypeModule2         = 7
+	TypeHashZipmap      = 9
+	TypeListZiplist     = 10
+	TypeSetIntset       = 11
+	TypeZSetZiplist     = 12
+	TypeHashZiplist     = 13
+	TypeListQuicklist   = 14
+	TypeStreamListpacks = 15
+	TypeHashListpack    = 16
+	TypeZSetListpack    = 17
+	TypeListQuicklist2  = 18
+	TypeStreamListpack2 = 19
+	TypeSetListpack     = 20
+	TypeStreamListpack3 = 21
+)
+
+// 特殊操作码
+const (
+	opSlotInfo      = 0xF4
+	opFunction2     = 0xF5
+	opFunctionPreGA = 0xF6
+	opModuleAux     = 0xF7
+	opIdle          = 0xF8
+	opFreq          = 0xF9
+	opAux           = 0xFA
+	opResizeDB      = 0xFB
+	opExpireTimeMs  = 0xFC
+	opExpireTime    = 0xFD
+	opSelectDB      = 0xFE
+	opEOF           = 0xFF
+)
+
+// 长度编码，高 2 位区分
+const (
+	len6Bit   = 0
+	len14Bit  = 1
+	len32Bit  = 0x80
+	len64Bit  = 0x81
+	lenEncVal = 3 // 特殊编码的字符串
+
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLzf   = 3
+)
+
+// Entry 快照中的一个 key
+type Entry struct {
+	DB       int
+	Key      string
+	Type     byte
+	Value    []byte // 只有字符串类型有值，其他类型为 nil
+	ExpireAt int64  // 过期时间，unix 毫秒，0 表示没有过期时间
+}
@@ -0,0 +1,154 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestCRC64(t *testing.T) {
+	// redis crc64.c 中的测试向量
+	if got := crc64Update(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("crc64 = %#x, want 0xe9c6d914c4b8d9ca", got)
+	}
+	data := []byte("This is a test of the emergency broadcast system.")
+	if got := crc64Update(crc64Update(0, data[:10]), data[10:]); got != crc64Update(0, data) {
+		t.Fatal("incremental crc64 differs from one shot")
+	}
+}
+
+type testEntry struct {
+	db       int
+	key      string
+	value    []byte
+	expireAt int64
+}
+
+func TestRoundTrip(t *testing.T) {
+	entries := []testEntry{
+		{0, "empty", []byte{}, 0},
+		{0, "small", []byte("hello"), 0},
+		{0, "binary", []byte("a\r\nb\x00c\xff"), 1700000000123},
+		{0, "len14", bytes.Repeat([]byte("x"), 1000), 0},
+		{3, "len32", bytes.Repeat([]byte("y"), 20000), 0},
+		{3, "number", []byte("12345"), 4102444800000},
+		{15, "", []byte("empty key"), 0},
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteAux("redis-ver", "7.2.0"); err != nil {
+		t.Fatal(err)
+	}
+	db := -1
+	for _, e := range entries {
+		if e.db != db {
+			db = e.db
+			if err := enc.WriteDBHeader(db, 3, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.WriteString(e.key, e.value, e.expireAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "REDIS0009") {
+		t.Fatalf("header %q", buf.Bytes()[:9])
+	}
+
+	data := buf.Bytes()
+	dec := NewDecoder(bytes.NewReader(data))
+	var got []*Entry
+	if err := dec.Parse(func(entry *Entry) error {
+		got = append(got, entry)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if dec.Aux["redis-ver"] != "7.2.0" {
+		t.Errorf("aux redis-ver = %q", dec.Aux["redis-ver"])
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("got %d entries, want %d", len(got), len(entries))
+	}
+	for i, e := range entries {
+		g := got[i]
+		if g.DB != e.db || g.Key != e.key || g.Type != TypeString || !bytes.Equal(g.Value, e.value) || g.ExpireAt != e.expireAt {
+			t.Errorf("entry %d: got db=%d key=%q value len %d expire=%d", i, g.DB, g.Key, len(g.Value), g.ExpireAt)
+		}
+	}
+
+	// 改动任意一个字节后校验失败
+	corrupt := append([]byte(nil), data...)
+	corrupt[20] ^= 0x01
+	if err := NewDecoder(bytes.NewReader(corrupt)).Parse(func(*Entry) error { return nil }); err == nil {
+		t.Error("corrupted snapshot parsed without error")
+	}
+	// 校验和为 0 表示生成时关闭了校验
+	binary.LittleEndian.PutUint64(corrupt[len(corrupt)-8:], 0)
+	copy(corrupt, data[:len(data)-8])
+	if err := NewDecoder(bytes.NewReader(corrupt)).Parse(func(*Entry) error { return nil }); err != nil {
+		t.Errorf("zero checksum: %v", err)
+	}
+}
+
+// TestDecodeEncodings 读取 redis 生成的整数编码、LZF 压缩的字符串，并跳过非字符串类型
+func TestDecodeEncodings(t *testing.T) {
+	var body bytes.Buffer
+	body.WriteString("REDIS0011")
+	body.Write([]byte{opSelectDB, 0})
+	// 整数编码：int8 -5、int16 1000、int32 100000
+	body.Write([]byte{TypeString, 2, 'i', '8', 0xC0 | encInt8, 0xfb})
+	body.Write([]byte{TypeString, 3, 'i', '1', '6', 0xC0 | encInt16, 0xe8, 0x03})
+	body.Write([]byte{TypeString, 3, 'i', '3', '2', 0xC0 | encInt32, 0xa0, 0x86, 0x01, 0x00})
+	// LZF："abc" 字面量后回溯 3 字节复制 6 字节，得到 abcabcabc
+	body.Write([]byte{TypeString, 3, 'l', 'z', 'f', 0xC0 | encLzf, 6, 9, 2, 'a', 'b', 'c', 4 << 5, 2})
+	// 列表类型被跳过
+	body.Write([]byte{TypeList, 4, 'l', 'i', 's', 't', 2, 1, 'a', 1, 'b'})
+	// 秒级过期时间
+	body.Write([]byte{opExpireTime, 0x00, 0x5e, 0xd0, 0xb2})
+	body.Write([]byte{TypeString, 3, 'e', 'x', 'p', 1, 'v'})
+	body.WriteByte(opEOF)
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, crc64Update(0, body.Bytes()))
+	body.Write(checksum)
+
+	want := map[string]string{"i8": "-5", "i16": "1000", "i32": "100000", "lzf": "abcabcabc", "list": "", "exp": "v"}
+	seen := 0
+	err := NewDecoder(&body).Parse(func(entry *Entry) error {
+		seen++
+		value, ok := want[entry.Key]
+		if !ok {
+			t.Errorf("unexpected key %q", entry.Key)
+			return nil
+		}
+		if entry.Key == "list" {
+			if entry.Type != TypeList || entry.Value != nil {
+				t.Errorf("list: type %d value %q", entry.Type, entry.Value)
+			}
+			return nil
+		}
+		if string(entry.Value) != value {
+			t.Errorf("%s = %q, want %q", entry.Key, entry.Value, value)
+		}
+		if entry.Key == "exp" && entry.ExpireAt != 3000000000*1000 {
+			t.Errorf("exp expireAt = %d", entry.ExpireAt)
+		}
+		if entry.Key != "exp" && entry.ExpireAt != 0 {
+			t.Errorf("%s: unexpected expireAt %d", entry.Key, entry.ExpireAt)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != len(want) {
+		t.Fatalf("parsed %d keys, want %d", seen, len(want))
+	}
+}
@@ -1,14 +1,19 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"go_redis/interface/resp"
 	"go_redis/lib/logger"
 	"go_redis/lib/sync/wait"
 	"go_redis/resp/parser"
 	"go_redis/resp/reply"
+	"io"
 	"net"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +24,11 @@ type Client struct {
 	waitingReqs chan *request // waiting response
 	ticker      *time.Ticker  // 心跳帧
 	addr        string
+	tlsConfig   *tls.Config // 不为 nil 时使用 TLS 连接
+	broken      atomic.Bool // 连接断开或重连过，连接上的状态（AUTH、SELECT 等）已经丢失
+	connMu      sync.Mutex  // 保护重连和 Close 对 conn 的修改
+	closing     chan struct{}
+	closeOnce   sync.Once
 
 	working *sync.WaitGroup // its counter presents unfinished requests(pending and waiting)
 }
@@ -40,17 +50,34 @@ const (
 
 // MakeClient creates a new client
 func MakeClient(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	return &Client{
+	return MakeTLSClient(addr, nil)
+}
+
+// MakeTLSClient creates a new client connected with TLS, plain TCP if tlsConfig is nil
+func MakeTLSClient(addr string, tlsConfig *tls.Config) (*Client, error) {
+	client := &Client{
 		addr:        addr,
-		conn:        conn,
+		tlsConfig:   tlsConfig,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
-	}, nil
+		closing:     make(chan struct{}),
+	}
+	conn, err := client.dial()
+	if err != nil {
+		return nil, err
+	}
+	client.conn = conn
+	return client, nil
+}
+
+// dial 建立到服务器的连接，TLS 连接会先完成握手
+func (client *Client) dial() (net.Conn, error) {
+	if client.tlsConfig == nil {
+		return net.DialTimeout("tcp", client.addr, maxWait)
+	}
+	dialer := &net.Dialer{Timeout: maxWait}
+	return tls.DialWithDialer(dialer, "tcp", client.addr, client.tlsConfig)
 }
 
 // Start starts asynchronous goroutines
@@ -68,19 +95,42 @@ func (client *Client) Start() {
 
 // Close stops asynchronous goroutines and close connection
 func (client *Client) Close() {
-	client.ticker.Stop()
-	// stop new request
-	close(client.pendingReqs)
+	client.closeOnce.Do(func() {
+		client.ticker.Stop()
+		// 等待正在发送的请求，它们最多等待 maxWait
+		client.working.Wait()
+		// 请求队列不关闭，超时后仍留在队列中的请求和心跳由 closing 结束
+		close(client.closing)
+		client.connMu.Lock()
+		_ = client.conn.Close()
+		client.connMu.Unlock()
+	})
+}
 
-	// wait stop process
-	client.working.Wait()
+// ErrClosed 客户端已经关闭
+var ErrClosed = errors.New("client closed")
+
+func (client *Client) isClosing() bool {
+	select {
+	case <-client.closing:
+		return true
+	default:
+		return false
+	}
+}
 
-	// clean
-	_ = client.conn.Close()
-	close(client.waitingReqs)
+// Broken 连接断开过，之后即使重连成功，连接上的 AUTH、SELECT 等状态也已经丢失
+func (client *Client) Broken() bool {
+	return client.broken.Load()
 }
 
 func (client *Client) handleConnectionError(err error) error {
+	client.broken.Store(true)
+	if client.isClosing() {
+		return ErrClosed
+	}
+	client.connMu.Lock()
+	defer client.connMu.Unlock()
 	err1 := client.conn.Close()
 	if err1 != nil {
 		if opErr, ok := err1.(*net.OpError); ok {
@@ -91,7 +141,7 @@ func (client *Client) handleConnectionError(err error) error {
 			return err1
 		}
 	}
-	conn, err1 := net.Dial("tcp", client.addr)
+	conn, err1 := client.dial()
 	if err1 != nil {
 		logger.Error(err1)
 		return err1
@@ -104,19 +154,52 @@ func (client *Client) handleConnectionError(err error) error {
 }
 
 func (client *Client) heartbeat() {
-	for range client.ticker.C {
-		client.doHeartbeat()
+	for {
+		select {
+		case <-client.ticker.C:
+			client.doHeartbeat()
+		case <-client.closing:
+			return
+		}
 	}
 }
 
 func (client *Client) handleWrite() {
-	for req := range client.pendingReqs {
-		client.doRequest(req)
+	for {
+		select {
+		case req := <-client.pendingReqs:
+			client.doRequest(req)
+		case <-client.closing:
+			return
+		}
 	}
 }
 
+// ErrTimeout 超过 maxWait 或 ctx 的截止时间没有收到回复
+var ErrTimeout = errors.New("server time out")
+
 // Send sends a request to redis server
 func (client *Client) Send(args [][]byte) resp.Reply {
+	r, err := client.SendContext(context.Background(), args)
+	if errors.Is(err, ErrTimeout) {
+		return reply.MakeErrReply("server time out")
+	}
+	if err != nil {
+		return reply.MakeErrReply("request failed")
+	}
+	return r
+}
+
+// SendContext 与 Send 相同，最多等待到 ctx 的截止时间，超时或连接出错时返回 error。
+// 超时的请求仍在管道中，之后收到的回复会被丢弃，不影响后续请求
+func (client *Client) SendContext(ctx context.Context, args [][]byte) (resp.Reply, error) {
+	timeout := maxWait
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+	if timeout <= 0 {
+		return nil, ErrTimeout
+	}
 	request := &request{
 		args:      args,
 		heartbeat: false,
@@ -125,15 +208,18 @@ func (client *Client) Send(args [][]byte) resp.Reply {
 	request.waiting.Add(1)
 	client.working.Add(1)
 	defer client.working.Done()
-	client.pendingReqs <- request
-	timeout := request.waiting.WaitWithTimeout(maxWait)
-	if timeout {
-		return reply.MakeErrReply("server time out")
+	select {
+	case client.pendingReqs <- request:
+	case <-client.closing:
+		return nil, ErrClosed
+	}
+	if request.waiting.WaitWithTimeout(timeout) {
+		return nil, ErrTimeout
 	}
 	if request.err != nil {
-		return reply.MakeErrReply("request failed")
+		return nil, request.err
 	}
-	return request.reply
+	return request.reply, nil
 }
 
 func (client *Client) doHeartbeat() {
@@ -145,7 +231,11 @@ func (client *Client) doHeartbeat() {
 	request.waiting.Add(1)
 	client.working.Add(1)
 	defer client.working.Done()
-	client.pendingReqs <- request
+	select {
+	case client.pendingReqs <- request:
+	case <-client.closing:
+		return
+	}
 	request.waiting.WaitWithTimeout(maxWait)
 }
 
@@ -165,11 +255,15 @@ func (client *Client) doRequest(req *request) {
 		i++
 	}
 	if err == nil {
-		client.waitingReqs <- req
-	} else {
-		req.err = err
-		req.waiting.Done()
+		select {
+		case client.waitingReqs <- req:
+			return
+		case <-client.closing:
+			err = ErrClosed
+		}
 	}
+	req.err = err
+	req.waiting.Done()
 }
 
 func (client *Client) finishRequest(reply resp.Reply) {
@@ -179,13 +273,18 @@ func (client *Client) finishRequest(reply resp.Reply) {
 			logger.Error(err)
 		}
 	}()
-	request := <-client.waitingReqs
-	if request == nil {
+	var req *request
+	select {
+	case req = <-client.waitingReqs:
+	case <-client.closing:
 		return
 	}
-	request.reply = reply
-	if request.waiting != nil {
-		request.waiting.Done()
+	if req == nil {
+		return
+	}
+	req.reply = reply
+	if req.waiting != nil {
+		req.waiting.Done()
 	}
 }
 
@@ -193,10 +292,15 @@ func (client *Client) handleRead() error {
 	ch := parser.ParseStream(client.conn)
 	for payload := range ch {
 		if payload.Err != nil {
+			var opErr *net.OpError
+			if errors.Is(payload.Err, io.EOF) || errors.Is(payload.Err, io.ErrUnexpectedEOF) || errors.As(payload.Err, &opErr) {
+				client.broken.Store(true)
+			}
 			client.finishRequest(reply.MakeErrReply(payload.Err.Error()))
 			continue
 		}
 		client.finishRequest(payload.Data)
 	}
+	client.broken.Store(true)
 	return nil
 }
@@ -4,21 +4,63 @@ import (
 	"go_redis/lib/sync/wait"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var nextID atomic.Int64 // 连接 ID 生成器，从 1 开始递增
+
 type Connection struct {
 	// 连接的唯一描述
 	conn         net.Conn
 	waitingReply wait.Wait // 保证任务完整做完
 	mu           sync.Mutex
-	selectedDB   int // 选择的数据库
+	selectedDB   int    // 选择的数据库
+	password     string // AUTH 通过的密码
+	name         string // CLIENT SETNAME 设置的名称
+	id           int64  // 连接 ID
+	protocol     int    // HELLO 协商的 RESP 版本，0 表示默认的 RESP2
+	replica      bool   // 是否为从节点连接
+	writeOffset  int64  // 最近一次写命令执行后的复制偏移量
+	asking       bool   // 集群 ASKING
+	readOnly     bool   // 集群 READONLY
+	peer         bool   // 集群 CLUSTER PEERAUTH
+	multiState   bool   // 是否在 MULTI 中
+	queue        [][][]byte
+	txErrors     []error
+	output       outputBuffer
+
+	bufMu    sync.Mutex
+	replyBuf []byte // WriteBuffered 攒下的回复，Flush 时一次写出
+
+	done      chan struct{} // 连接关闭时关闭，内部伪造的连接为 nil
+	closeOnce sync.Once
+	blockHook func() func() // 见 SetBlockHook
 }
 
+const (
+	maxReplyBufSize   = 64 * 1024 // 攒下的回复超过该大小时立即写出
+	directWriteSize   = 16 * 1024 // 超过该大小的回复不再复制到 replyBuf，直接写出
+	keptReplyBufBytes = 64 * 1024 // Flush 后保留的缓冲区容量上限
+)
+
+// Write 立即写出，之前 WriteBuffered 攒下的回复先写出，保证顺序
 func (c *Connection) Write(bytes []byte) error {
 	if len(bytes) == 0 {
 		return nil
 	}
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	if err := c.reserveOutput(len(bytes)); err != nil {
+		return err
+	}
+	defer c.releaseOutput(len(bytes))
+	return c.write(bytes)
+}
+
+// write 直接写到 socket
+func (c *Connection) write(bytes []byte) error {
 	c.mu.Lock() // 避免并发写
 	c.waitingReply.Add(1)
 	defer func() {
@@ -31,9 +73,52 @@ func (c *Connection) Write(bytes []byte) error {
 	}
 	return nil
 }
+
+// WriteBuffered 把回复暂存起来，等到 Flush 时和其他回复合并成一次写出。
+// 用于 pipeline：客户端一次发来多条命令时，回复攒在一起写，减少系统调用
+func (c *Connection) WriteBuffered(bytes []byte) error {
+	if len(bytes) == 0 {
+		return nil
+	}
+	if len(bytes) >= directWriteSize {
+		return c.Write(bytes)
+	}
+	if err := c.reserveOutput(len(bytes)); err != nil {
+		return err
+	}
+	c.bufMu.Lock()
+	c.replyBuf = append(c.replyBuf, bytes...)
+	full := len(c.replyBuf) >= maxReplyBufSize
+	c.bufMu.Unlock()
+	if full {
+		return c.Flush()
+	}
+	return nil
+}
+
+// Flush 写出 WriteBuffered 攒下的回复
+func (c *Connection) Flush() error {
+	c.bufMu.Lock()
+	defer c.bufMu.Unlock()
+	if len(c.replyBuf) == 0 {
+		return nil
+	}
+	n := len(c.replyBuf)
+	err := c.write(c.replyBuf)
+	c.releaseOutput(n)
+	if cap(c.replyBuf) > keptReplyBufBytes {
+		c.replyBuf = nil
+	} else {
+		c.replyBuf = c.replyBuf[:0]
+	}
+	return err
+}
+
 func NewConnection(conn net.Conn) *Connection {
 	return &Connection{
 		conn: conn,
+		id:   nextID.Add(1),
+		done: make(chan struct{}),
 	}
 }
 
@@ -44,11 +129,141 @@ func (c *Connection) GetDBIndex() int {
 func (c *Connection) SelectDB(dbNum int) {
 	c.selectedDB = dbNum
 }
+func (c *Connection) SetPassword(password string) {
+	c.password = password
+}
+
+func (c *Connection) GetPassword() string {
+	return c.password
+}
+
+func (c *Connection) SetName(name string) {
+	c.name = name
+}
+
+func (c *Connection) GetName() string {
+	return c.name
+}
+
+func (c *Connection) GetID() int64 {
+	return c.id
+}
+
+func (c *Connection) SetProtocol(protocol int) {
+	c.protocol = protocol
+}
+
+func (c *Connection) GetProtocol() int {
+	if c.protocol == 0 {
+		return 2
+	}
+	return c.protocol
+}
+
+func (c *Connection) SetReplica(replica bool) {
+	c.replica = replica
+}
+
+func (c *Connection) IsReplica() bool {
+	return c.replica
+}
+
+func (c *Connection) SetAsking(asking bool) {
+	c.asking = asking
+}
+
+func (c *Connection) IsAsking() bool {
+	return c.asking
+}
+
+func (c *Connection) SetReadOnly(readOnly bool) {
+	c.readOnly = readOnly
+}
+
+func (c *Connection) IsReadOnly() bool {
+	return c.readOnly
+}
+
+func (c *Connection) SetPeer(peer bool) {
+	c.peer = peer
+}
+
+func (c *Connection) IsPeer() bool {
+	return c.peer
+}
+
+func (c *Connection) SetMultiState(state bool) {
+	if !state {
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+func (c *Connection) SetWriteOffset(offset int64) {
+	c.writeOffset = offset
+}
+
+func (c *Connection) GetWriteOffset() int64 {
+	return c.writeOffset
+}
+
+// Close 关闭连接，可以重复调用
 func (c *Connection) Close() error {
+	c.closeOnce.Do(func() {
+		if c.done != nil {
+			close(c.done)
+		}
+	})
+	_ = c.Flush()
 	c.waitingReply.WaitWithTimeout(time.Second * 10)
 	_ = c.conn.Close()
 	return nil
 }
+
+// Done 连接关闭后返回的 channel 被关闭，阻塞的命令据此提前结束
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+// SetBlockHook 由读取连接的协程设置。命令阻塞期间读取协程不再读连接，
+// hook 负责在阻塞期间继续读取，发现客户端断开时关闭连接，返回的函数停止读取
+func (c *Connection) SetBlockHook(hook func() func()) {
+	c.blockHook = hook
+}
+
+// Block 命令开始阻塞等待时调用，结束等待时调用返回的函数
+func (c *Connection) Block() func() {
+	if c.blockHook == nil {
+		return func() {}
+	}
+	return c.blockHook()
+}
+
 func (c *Connection) RemoteAddr() net.Addr {
+	if c.conn == nil {
+		// AOF 加载时使用的伪造连接
+		return nil
+	}
 	return c.conn.RemoteAddr()
 }
@@ -0,0 +1,150 @@
+package connection
+
+import (
+	"errors"
+	"go_redis/config"
+	"go_redis/lib/logger"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 输出缓冲区：统计已经提交但还没有写到 socket 的字节数，按 client-output-buffer-limit 断开慢消费者
+
+// ErrOutputBufferLimit 超过输出缓冲区限制，连接已被关闭
+var ErrOutputBufferLimit = errors.New("output buffer limit reached")
+
+type outputBuffer struct {
+	class     string       // config.ClientClassXxx，空表示 normal
+	pending   atomic.Int64 // 尚未写出的字节数
+	softSince atomic.Int64 // 开始超过 soft limit 的时间，unix 纳秒，0 表示没有超过
+	overLimit atomic.Bool  // 已因超过限制被关闭
+
+	queueMu  sync.Mutex
+	queue    []asyncMsg // WriteAsync 待发送的消息
+	flushing bool       // 是否有协程正在发送 queue
+}
+
+// SetOutputClass 设置连接在 client-output-buffer-limit 中的类别
+func (c *Connection) SetOutputClass(class string) {
+	c.output.class = class
+}
+
+func (c *Connection) outputLimit() config.OutputBufferLimit {
+	class := c.output.class
+	if class == "" {
+		class = config.ClientClassNormal
+	}
+	return config.GetOutputBufferLimit(class)
+}
+
+// reserveOutput 把 n 字节计入输出缓冲区，超过限制时关闭连接并返回 ErrOutputBufferLimit
+func (c *Connection) reserveOutput(n int) error {
+	if c.output.overLimit.Load() {
+		return ErrOutputBufferLimit
+	}
+	pending := c.output.pending.Add(int64(n))
+	if !c.exceedsLimit(pending) {
+		return nil
+	}
+	c.output.pending.Add(-int64(n))
+	if c.output.overLimit.CompareAndSwap(false, true) {
+		addr := ""
+		if c.conn != nil {
+			addr = c.conn.RemoteAddr().String()
+			// 直接关闭 socket，阻塞中的写立即返回，读协程随后完成清理
+			_ = c.conn.Close()
+		}
+		logger.Warn("client " + addr + " closed for overcoming of output buffer limits")
+	}
+	return ErrOutputBufferLimit
+}
+
+func (c *Connection) releaseOutput(n int) {
+	pending := c.output.pending.Add(-int64(n))
+	if limit := c.outputLimit(); limit.Soft == 0 || pending <= limit.Soft {
+		c.output.softSince.Store(0)
+	}
+}
+
+// exceedsLimit 超过 hard limit，或者超过 soft limit 的时间达到 soft seconds
+func (c *Connection) exceedsLimit(pending int64) bool {
+	limit := c.outputLimit()
+	if limit.Hard > 0 && pending > limit.Hard {
+		return true
+	}
+	if limit.Soft > 0 && pending > limit.Soft {
+		now := time.Now().UnixNano()
+		c.output.softSince.CompareAndSwap(0, now)
+		return now-c.output.softSince.Load() >= limit.SoftSeconds*int64(time.Second)
+	}
+	c.output.softSince.Store(0)
+	return false
+}
+
+type asyncMsg struct {
+	data    []byte
+	counted bool // 是否计入了输出缓冲区
+}
+
+// OutputPending 返回尚未写出的字节数
+func (c *Connection) OutputPending() int64 {
+	return c.output.pending.Load()
+}
+
+// WriteAsync 把消息放入发送队列后立即返回，由单独的协程按顺序写出。
+// 用于 MONITOR 这类推送，写得慢的客户端不会拖慢命令执行，积压超过限制时连接被关闭
+func (c *Connection) WriteAsync(msg []byte) error {
+	if len(msg) == 0 {
+		return nil
+	}
+	if err := c.reserveOutput(len(msg)); err != nil {
+		return err
+	}
+	c.enqueue(asyncMsg{data: msg, counted: true})
+	return nil
+}
+
+// WriteBulkAsync 与 WriteAsync 使用同一个发送队列，但不计入输出缓冲区。
+// 用于全量同步时发送快照，与 redis 一样快照大小不受 client-output-buffer-limit 限制
+func (c *Connection) WriteBulkAsync(msg []byte) error {
+	if len(msg) == 0 {
+		return nil
+	}
+	if c.output.overLimit.Load() {
+		return ErrOutputBufferLimit
+	}
+	c.enqueue(asyncMsg{data: msg})
+	return nil
+}
+
+func (c *Connection) enqueue(msg asyncMsg) {
+	c.output.queueMu.Lock()
+	c.output.queue = append(c.output.queue, msg)
+	if !c.output.flushing {
+		c.output.flushing = true
+		go c.flushQueue()
+	}
+	c.output.queueMu.Unlock()
+}
+
+func (c *Connection) flushQueue() {
+	for {
+		c.output.queueMu.Lock()
+		queue := c.output.queue
+		c.output.queue = nil
+		if len(queue) == 0 {
+			c.output.flushing = false
+			c.output.queueMu.Unlock()
+			return
+		}
+		c.output.queueMu.Unlock()
+		for _, msg := range queue {
+			// 连接关闭后写会立即失败，这里只需要继续释放计数
+			_ = c.write(msg.data)
+			if msg.counted {
+				c.releaseOutput(len(msg.data))
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package connection
+
+import (
+	"go_redis/config"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setOutputLimits 修改 client-output-buffer-limit，测试结束后恢复
+func setOutputLimits(t *testing.T, limits string) {
+	t.Helper()
+	old := config.Properties()
+	props := *old
+	props.ClientOutputBufferLimit = limits
+	config.SetProperties(&props)
+	t.Cleanup(func() { config.SetProperties(old) })
+}
+
+// makeStalledConn 对端不读取数据的连接，写出的数据全部积压在输出缓冲区
+func makeStalledConn(t *testing.T) (*Connection, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	return NewConnection(server), client
+}
+
+// assertClosed 连接已被关闭，对端读取立即返回
+func assertClosed(t *testing.T, peer net.Conn) {
+	t.Helper()
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, err := peer.Read(make([]byte, 1024))
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("connection is not closed: %v", err)
+		}
+	}
+}
+
+func TestOutputBufferHardLimit(t *testing.T) {
+	setOutputLimits(t, "normal 0 0 0 replica 0 0 0 pubsub 100 0 0")
+	c, peer := makeStalledConn(t)
+	c.SetOutputClass(config.ClientClassPubSub)
+	msg := []byte(strings.Repeat("x", 60))
+	if err := c.WriteAsync(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteAsync(msg); err != ErrOutputBufferLimit {
+		t.Fatalf("got %v, want ErrOutputBufferLimit", err)
+	}
+	assertClosed(t, peer)
+	// 关闭后的写入都失败
+	if err := c.WriteAsync([]byte("x")); err != ErrOutputBufferLimit {
+		t.Fatalf("got %v after close", err)
+	}
+	if err := c.WriteBulkAsync([]byte("x")); err != ErrOutputBufferLimit {
+		t.Fatalf("WriteBulkAsync got %v after close", err)
+	}
+}
+
+func TestOutputBufferSoftLimit(t *testing.T) {
+	setOutputLimits(t, "normal 0 0 0 replica 0 0 0 pubsub 0 50 10")
+	c, peer := makeStalledConn(t)
+	c.SetOutputClass(config.ClientClassPubSub)
+	msg := []byte(strings.Repeat("x", 40))
+	for i := 0; i < 3; i++ {
+		// 超过 soft limit 不到 soft seconds 时不断开
+		if err := c.WriteAsync(msg); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	// 超过 soft limit 已经 10 秒
+	c.output.softSince.Store(time.Now().Add(-10 * time.Second).UnixNano())
+	if err := c.WriteAsync(msg); err != ErrOutputBufferLimit {
+		t.Fatalf("got %v, want ErrOutputBufferLimit", err)
+	}
+	assertClosed(t, peer)
+}
+
+func TestOutputBufferBufferedReplies(t *testing.T) {
+	setOutputLimits(t, "normal 100 0 0 replica 0 0 0 pubsub 0 0 0")
+	c, peer := makeStalledConn(t)
+	msg := []byte(strings.Repeat("x", 60))
+	// 攒下未写出的回复同样计入输出缓冲区
+	if err := c.WriteBuffered(msg); err != nil {
+		t.Fatal(err)
+	}
+	if c.OutputPending() != 60 {
+		t.Fatalf("pending %d, want 60", c.OutputPending())
+	}
+	if err := c.WriteBuffered(msg); err != ErrOutputBufferLimit {
+		t.Fatalf("got %v, want ErrOutputBufferLimit", err)
+	}
+	assertClosed(t, peer)
+}
+
+func TestOutputBufferReleased(t *testing.T) {
+	setOutputLimits(t, "normal 100 0 0 replica 0 0 0 pubsub 0 0 0")
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewConnection(server)
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+	msg := []byte(strings.Repeat("x", 60))
+	// 写出后释放计数，累计写出的数据可以超过限制
+	for i := 0; i < 10; i++ {
+		if err := c.WriteBuffered(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.OutputPending() != 0 {
+		t.Fatalf("pending %d after flush", c.OutputPending())
+	}
+}
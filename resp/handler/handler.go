@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"go_redis/cluster"
@@ -16,36 +17,143 @@ import (
 	"net"
 	"strings"
 	"sync"
+	stdatomic "sync/atomic"
+	"time"
 )
 
 var (
-	unknownErrReplyBytes = reply.MakeErrReply("ERR unknown").ToBytes()
+	unknownErrReplyBytes    = reply.MakeErrReply("ERR unknown").ToBytes()
+	maxClientsErrReplyBytes = reply.MakeErrReply("ERR max number of clients reached").ToBytes()
+	noAuthErrReplyBytes     = reply.MakeErrReply("NOAUTH Authentication required.").ToBytes()
+	monitorCmd              = []byte("monitor")
 )
 
 type RespHandler struct {
 	// 处理RESP协议的逻辑
 	activeConn sync.Map
+	connCount  stdatomic.Int32 // 当前连接数，用于 maxclients 限制
 	db         databaseface.Database
 	closing    atomic.Boolean
+	monitors   *monitorHub
 }
 
 func (r *RespHandler) closeClient(client *connection.Connection) {
-	// 关闭一个客户端连接
+	// 关闭一个客户端连接，可能被多次调用
+	if _, loaded := r.activeConn.LoadAndDelete(client); !loaded {
+		return
+	}
+	r.connCount.Add(-1)
+	r.monitors.remove(client)
 	_ = client.Close()
 	r.db.AfterClientClose(client)
-	r.activeConn.Delete(client)
+}
+
+// idleReader 每次读取前按 timeout 配置刷新读超时，空闲过久的连接会被断开，
+// 从节点连接每秒发送 REPLCONF ACK，按 repl-timeout 判断。
+// 解析器只有在缓冲区中的命令都处理完后才会调用 Read，
+// 此时先把攒下的回复写出，pipeline 中的多条回复合并为一次写
+type idleReader struct {
+	conn    net.Conn
+	client  *connection.Connection
+	blocked bool // 命令阻塞期间由 watchBlocked 读取，不设置读超时
+}
+
+func (i *idleReader) Read(p []byte) (int, error) {
+	if err := i.client.Flush(); err != nil {
+		return 0, err
+	}
+	if i.blocked {
+		return i.conn.Read(p)
+	}
+	timeout := config.Properties().Timeout
+	if i.client.IsReplica() {
+		timeout = config.Properties().ReplTimeout
+	}
+	if timeout > 0 {
+		_ = i.conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+	} else {
+		_ = i.conn.SetReadDeadline(time.Time{})
+	}
+	return i.conn.Read(p)
+}
+
+// watchBlocked 命令阻塞期间继续读取连接，客户端断开时关闭连接，阻塞的命令随之结束。
+// 读到的后续命令留在 reader 的缓冲区中，阻塞结束后照常处理。返回的函数停止读取并等待读取协程退出
+func watchBlocked(idle *idleReader, reader *parser.Reader) func() {
+	idle.blocked = true
+	// 阻塞的命令不受 timeout 配置限制
+	_ = idle.conn.SetReadDeadline(time.Time{})
+	var stopping stdatomic.Bool
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			err := reader.ReadAhead()
+			if err == nil {
+				continue
+			}
+			if !stopping.Load() && err != parser.ErrBufferFull {
+				logger.Info("blocked client closed " + idle.client.RemoteAddr().String())
+				_ = idle.client.Close()
+			}
+			return
+		}
+	}()
+	return func() {
+		stopping.Store(true)
+		// 中断正在进行的读取，之后 idleReader 会重新设置读超时
+		_ = idle.conn.SetReadDeadline(time.Now())
+		<-stopped
+		idle.blocked = false
+	}
 }
 
 func MakeRespHandler() *RespHandler {
 	var db databaseface.Database
-	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
+	if config.ClusterEnabled() {
 		db = cluster.MakeClusterDatabase()
 	} else {
 		db = database.NewStandaloneDatabase() // 使用EchoDatabase作为示例
 	}
 	return &RespHandler{
-		db: db,
+		db:       db,
+		monitors: makeMonitorHub(),
+	}
+}
+
+// exec 执行一条命令，MONITOR 需要访问 handler 的状态，在这里处理
+func (r *RespHandler) exec(client *connection.Connection, args [][]byte) []byte {
+	if bytes.EqualFold(args[0], monitorCmd) && len(args) == 1 {
+		if !database.IsAuthenticated(client) {
+			return noAuthErrReplyBytes
+		}
+		// 先回复 OK 再加入监视列表，保证 OK 在推送的命令之前
+		_ = client.Write(reply.MakeOkReply().ToBytes())
+		r.monitors.add(client)
+		return nil
+	}
+	if client.IsReplica() {
+		// 从节点连接只会发来 REPLCONF ACK，复制流通过发送队列写出，这里不能再回复
+		r.db.Exec(client, args)
+		return nil
+	}
+	// 未通过认证的命令不推送给监视连接，AUTH、HELLO 认证成功后再推送
+	authenticated := database.IsAuthenticated(client)
+	if authenticated {
+		r.monitors.feed(client, args)
+	}
+	result := r.db.Exec(client, args)
+	if !authenticated && database.IsAuthenticated(client) {
+		r.monitors.feed(client, args)
 	}
+	if result == nil {
+		return unknownErrReplyBytes
+	}
+	if client.IsReplica() {
+		// PSYNC 之后由复制流接管该连接
+		return nil
+	}
+	return reply.ToBytes(result, client.GetProtocol())
 }
 
 func (r *RespHandler) Handler(ctx context.Context, conn net.Conn) {
@@ -53,66 +161,52 @@ func (r *RespHandler) Handler(ctx context.Context, conn net.Conn) {
 		_ = conn.Close()
 		return
 	}
+	if maxClients := config.Properties().MaxClients; maxClients > 0 && int(r.connCount.Load()) >= maxClients {
+		_, _ = conn.Write(maxClientsErrReplyBytes)
+		_ = conn.Close()
+		return
+	}
 	client := connection.NewConnection(conn)
 	r.activeConn.Store(client, struct{}{})
-	ch := parser.ParseStream(conn) // 解析RESP协议
-	for payload := range ch {
-		// 如果ch不关闭，会一直循环
-		if payload.Err != nil {
-			if payload.Err == io.EOF ||
-				errors.Is(payload.Err, io.ErrUnexpectedEOF) ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
+	r.connCount.Add(1)
+	defer r.closeClient(client)
+	idle := &idleReader{conn: conn, client: client}
+	reader := parser.NewRequestReader(idle) // 解析RESP协议，限制请求大小
+	client.SetBlockHook(func() func() {
+		return watchBlocked(idle, reader)
+	})
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				logger.Info("client idle timeout " + client.RemoteAddr().String())
+				return
+			}
+			if !parser.IsProtocolError(err) {
 				// 客户端连接关闭
+				if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) &&
+					!strings.Contains(err.Error(), "use of closed network connection") {
+					logger.Error("read err:", err)
+				}
 				logger.Info("client closed" + client.RemoteAddr().String())
-				r.closeClient(client)
 				return
 			}
 			// 协议错误
-			logger.Error("protocol error:", payload.Err)
-			errReply := reply.MakeErrReply(payload.Err.Error())
-			err := client.Write(errReply.ToBytes())
-			if err != nil {
+			logger.Error("protocol error:", err)
+			errReply := reply.MakeErrReply("ERR " + err.Error())
+			if err := client.Write(errReply.ToBytes()); err != nil {
 				// 回写出错，关闭连接
-				r.closeClient(client)
 				logger.Error("write err:" + client.RemoteAddr().String())
 				return
 			}
-			continue
-		}
-		// Exec
-		if payload.Data == nil {
-			// 没有数据，可能是心跳包
-			continue
-		}
-		//fmt.Printf("payload.Data type: %T\n", payload.Data)
-		switch data := payload.Data.(type) {
-		case *reply.MultiBulkReply:
-			exec := r.db.Exec(client, data.Args)
-			if exec != nil {
-				_ = client.Write(exec.ToBytes())
-			} else {
-				_ = client.Write(unknownErrReplyBytes)
-			}
-		case *reply.StatusReply:
-			// 处理状态命令 (如 +PING\r\n)
-			args := [][]byte{[]byte(data.Status)}
-			exec := r.db.Exec(client, args)
-			if exec != nil {
-				_ = client.Write(exec.ToBytes())
-			} else {
-				_ = client.Write(unknownErrReplyBytes)
+			if parser.IsFatal(err) {
+				// 无法定位下一条命令，断开连接
+				return
 			}
-		case *reply.IntReply:
-			// 处理整数命令 (如 :123\r\n)
-			// 整数类型通常是响应，不应该作为命令处理
-			logger.Warn("received unexpected integer reply from client:", data.Code)
-			errReply := reply.MakeErrReply("ERR unexpected integer from client")
-			_ = client.Write(errReply.ToBytes())
-		default:
-			logger.Error("invalid data:", payload.Data)
 			continue
 		}
-
+		_ = client.WriteBuffered(r.exec(client, args))
 	}
 }
 
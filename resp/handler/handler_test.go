@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"go_redis/interface/resp"
+	"go_redis/lib/utils"
+	"go_redis/resp/reply"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoDatabase 把最后一个参数原样返回
+type echoDatabase struct{}
+
+func (e *echoDatabase) Exec(client resp.Connection, args [][]byte) resp.Reply {
+	return reply.MakeBulkReply(args[len(args)-1])
+}
+func (e *echoDatabase) Close()                                  {}
+func (e *echoDatabase) AfterClientClose(client resp.Connection) {}
+
+// countingConn 记录写 socket 的次数
+type countingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+// serve 用 echoDatabase 处理一个连接，返回客户端一端
+func serve(t *testing.T) (net.Conn, *countingConn) {
+	t.Helper()
+	server, client := net.Pipe()
+	counting := &countingConn{Conn: server}
+	h := &RespHandler{db: &echoDatabase{}, monitors: makeMonitorHub()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Handler(context.Background(), counting)
+	}()
+	t.Cleanup(func() {
+		_ = client.Close()
+		<-done
+	})
+	return client, counting
+}
+
+func readReplies(t *testing.T, conn net.Conn, want []byte) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestPipelineRepliesCoalesced(t *testing.T) {
+	client, counting := serve(t)
+	var request, want bytes.Buffer
+	for _, arg := range []string{"a", "b", "c"} {
+		request.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("echo", arg)).ToBytes())
+		want.Write(reply.MakeBulkReply([]byte(arg)).ToBytes())
+	}
+	// 三条命令一次发送，回复在解析器再次读取前合并写出
+	if _, err := client.Write(request.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	readReplies(t, client, want.Bytes())
+	if n := counting.writes.Load(); n != 1 {
+		t.Fatalf("%d writes for a pipeline of 3 commands, want 1", n)
+	}
+
+	// 分开发送的命令各自写出
+	for _, arg := range []string{"d", "e"} {
+		if _, err := client.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("echo", arg)).ToBytes()); err != nil {
+			t.Fatal(err)
+		}
+		readReplies(t, client, reply.MakeBulkReply([]byte(arg)).ToBytes())
+	}
+	if n := counting.writes.Load(); n != 3 {
+		t.Fatalf("%d writes, want 3", n)
+	}
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"go_redis/config"
+	"go_redis/lib/logger"
+	"go_redis/resp/connection"
+	"strconv"
+	"strings"
+	"sync"
+	stdatomic "sync/atomic"
+	"time"
+)
+
+// MONITOR：把每条执行的命令推送给所有监视连接
+// 推送通过 WriteAsync 异步写出，监视连接按 replica 类受 client-output-buffer-limit 限制，
+// 积压过多时连接被断开
+
+type monitorHub struct {
+	count    stdatomic.Int32 // 监视连接数，为 0 时 feed 直接返回
+	mu       sync.RWMutex
+	monitors map[*connection.Connection]struct{}
+}
+
+func makeMonitorHub() *monitorHub {
+	return &monitorHub{
+		monitors: make(map[*connection.Connection]struct{}),
+	}
+}
+
+// add 把连接加入监视列表
+func (h *monitorHub) add(client *connection.Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.monitors[client]; ok {
+		return
+	}
+	client.SetOutputClass(config.ClientClassReplica)
+	h.monitors[client] = struct{}{}
+	h.count.Add(1)
+}
+
+// remove 连接关闭时移出监视列表
+func (h *monitorHub) remove(client *connection.Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.monitors[client]; !ok {
+		return
+	}
+	delete(h.monitors, client)
+	h.count.Add(-1)
+}
+
+// feed 把命令推送给所有监视连接，没有监视连接时不做任何事
+func (h *monitorHub) feed(client *connection.Connection, args [][]byte) {
+	if h.count.Load() == 0 {
+		return
+	}
+	msg := formatMonitorLine(client, args)
+	h.mu.RLock()
+	slow := make([]*connection.Connection, 0)
+	for m := range h.monitors {
+		if err := m.WriteAsync(msg); err != nil {
+			slow = append(slow, m)
+		}
+	}
+	h.mu.RUnlock()
+	for _, m := range slow {
+		// 超过输出缓冲区限制的连接已被关闭，这里只需移出列表
+		logger.Warn("monitor client too slow, closing " + addrOf(m))
+		h.remove(m)
+	}
+}
+
+// formatMonitorLine +1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func formatMonitorLine(client *connection.Connection, args [][]byte) []byte {
+	now := time.Now()
+	var sb strings.Builder
+	sb.WriteString("+")
+	sb.WriteString(strconv.FormatInt(now.Unix(), 10))
+	sb.WriteString(".")
+	usec := strconv.FormatInt(int64(now.Nanosecond()/1000), 10)
+	sb.WriteString(strings.Repeat("0", 6-len(usec)) + usec)
+	sb.WriteString(" [")
+	sb.WriteString(strconv.Itoa(client.GetDBIndex()))
+	sb.WriteString(" ")
+	sb.WriteString(addrOf(client))
+	sb.WriteString("]")
+	redact := redactedArgs(args)
+	for i, arg := range args {
+		sb.WriteString(" ")
+		if redact[i] {
+			sb.WriteString("\"(redacted)\"")
+		} else {
+			sb.WriteString(quoteArg(arg))
+		}
+	}
+	sb.WriteString("\r\n")
+	return []byte(sb.String())
+}
+
+// redactedArgs 标记需要隐藏的参数，避免密码和节点令牌出现在监视输出中
+func redactedArgs(args [][]byte) []bool {
+	redact := make([]bool, len(args))
+	cmd := strings.ToLower(string(args[0]))
+	switch cmd {
+	case "auth":
+		for i := 1; i < len(args); i++ {
+			redact[i] = true
+		}
+	case "hello":
+		// HELLO [protover [AUTH username password] [SETNAME clientname]]
+		for i := 2; i+2 < len(args); i++ {
+			if strings.EqualFold(string(args[i]), "auth") {
+				redact[i+1] = true
+				redact[i+2] = true
+				i += 2
+			}
+		}
+	case "migrate":
+		// MIGRATE host port key db timeout [COPY] [REPLACE] [AUTH password | AUTH2 username password] [KEYS key ...]
+		for i := 6; i < len(args); i++ {
+			option := strings.ToLower(string(args[i]))
+			if option == "keys" {
+				break
+			}
+			if option == "auth" && i+1 < len(args) {
+				redact[i+1] = true
+				i++
+			} else if option == "auth2" && i+2 < len(args) {
+				redact[i+1] = true
+				redact[i+2] = true
+				i += 2
+			}
+		}
+	case "config":
+		// CONFIG SET requirepass xxx masterauth xxx
+		if len(args) >= 3 && strings.EqualFold(string(args[1]), "set") {
+			for i := 2; i+1 < len(args); i += 2 {
+				if isSecretConfig(string(args[i])) {
+					redact[i+1] = true
+				}
+			}
+		}
+	case "cluster":
+		// CLUSTER PEERAUTH token
+		if len(args) >= 3 && strings.EqualFold(string(args[1]), "peerauth") {
+			redact[2] = true
+		}
+	}
+	return redact
+}
+
+// isSecretConfig 值为密码的配置项
+func isSecretConfig(name string) bool {
+	return strings.EqualFold(name, "requirepass") || strings.EqualFold(name, "masterauth")
+}
+
+// quoteArg 与 redis 的 sdscatrepr 相同的转义规则
+func quoteArg(arg []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range arg {
+		switch c {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if c >= 0x20 && c < 0x7f {
+				sb.WriteByte(c)
+			} else {
+				sb.WriteString("\\x")
+				sb.WriteString(strconv.FormatInt(int64(c)>>4, 16))
+				sb.WriteString(strconv.FormatInt(int64(c)&0xf, 16))
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// addrOf unix socket 连接的对端地址为空，与 redis 一样显示为 unix:<socket 路径>
+func addrOf(client *connection.Connection) string {
+	addr := client.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	if addr.Network() == "unix" {
+		return "unix:" + config.Properties().UnixSocket
+	}
+	return addr.String()
+}
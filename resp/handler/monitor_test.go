@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"go_redis/lib/utils"
+	"testing"
+)
+
+func TestRedactedArgs(t *testing.T) {
+	cases := []struct {
+		args   []string
+		redact []int
+	}{
+		{[]string{"AUTH", "pw"}, []int{1}},
+		{[]string{"auth", "default", "pw"}, []int{1, 2}},
+		{[]string{"HELLO", "3", "AUTH", "default", "pw", "SETNAME", "c"}, []int{3, 4}},
+		{[]string{"HELLO", "3", "SETNAME", "auth"}, nil},
+		{[]string{"MIGRATE", "h", "1", "", "0", "10", "COPY", "AUTH", "pw", "KEYS", "auth", "k"}, []int{8}},
+		{[]string{"MIGRATE", "h", "1", "k", "0", "10", "AUTH2", "u", "pw"}, []int{7, 8}},
+		{[]string{"CONFIG", "SET", "requirepass", "a", "timeout", "0", "MASTERAUTH", "b"}, []int{3, 7}},
+		{[]string{"CONFIG", "GET", "requirepass"}, nil},
+		{[]string{"CLUSTER", "PEERAUTH", "token"}, []int{2}},
+		{[]string{"CLUSTER", "NODES"}, nil},
+		{[]string{"SET", "auth", "pw"}, nil},
+	}
+	for _, c := range cases {
+		redact := redactedArgs(utils.ToCmdLine(c.args...))
+		want := make([]bool, len(c.args))
+		for _, i := range c.redact {
+			want[i] = true
+		}
+		for i := range want {
+			if redact[i] != want[i] {
+				t.Errorf("%v: arg %d redacted=%v, want %v", c.args, i, redact[i], want[i])
+			}
+		}
+	}
+}
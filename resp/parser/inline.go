@@ -0,0 +1,115 @@
+package parser
+
+// inline 命令：不以 RESP 类型前缀开头的一行文本，按空白切分为参数
+// 便于用 telnet、nc 等工具直接发送命令，引号规则与 redis-cli 一致。
+// 只有服务端读取命令的 Reader.ReadCommand 接受 inline 格式
+// SET foo "hello world"\r\n
+// SET foo 'it\'s'\n
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\v' || b == '\f'
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+func hexDigitToInt(b byte) byte {
+	switch {
+	case b >= '0' && b <= '9':
+		return b - '0'
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10
+	default:
+		return b - 'A' + 10
+	}
+}
+
+// splitArgs 按 redis-cli (sdssplitargs) 的规则切分参数：
+// 双引号内支持 \xHH、\n、\r、\t、\b、\a 等转义；单引号内只支持 \' 转义；
+// 闭合引号后必须是空白或行尾，引号不配对时返回 false
+func splitArgs(line []byte) ([][]byte, bool) {
+	args := make([][]byte, 0)
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, true
+		}
+		inDoubleQuotes := false
+		inSingleQuotes := false
+		current := make([]byte, 0)
+		done := false
+		for !done {
+			if inDoubleQuotes {
+				if i >= len(line) {
+					return nil, false // 缺少闭合的双引号
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' &&
+					isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					current = append(current, hexDigitToInt(line[i+2])*16+hexDigitToInt(line[i+3]))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				} else if line[i] == '"' {
+					// 闭合引号后必须是空白或行尾
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					done = true
+				} else {
+					current = append(current, line[i])
+				}
+			} else if inSingleQuotes {
+				if i >= len(line) {
+					return nil, false // 缺少闭合的单引号
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current = append(current, '\'')
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					done = true
+				} else {
+					current = append(current, line[i])
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', '\v', '\f':
+					done = true
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					current = append(current, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, current)
+	}
+}
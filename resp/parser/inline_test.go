@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		line string
+		args []string
+	}{
+		{"", []string{}},
+		{"  \t ", []string{}},
+		{"SET foo bar", []string{"SET", "foo", "bar"}},
+		{"  SET   foo\tbar  ", []string{"SET", "foo", "bar"}},
+		{`SET foo "hello world"`, []string{"SET", "foo", "hello world"}},
+		{`SET foo ""`, []string{"SET", "foo", ""}},
+		{`SET foo "a\nb\r\t\b\a"`, []string{"SET", "foo", "a\nb\r\t\b\a"}},
+		{`SET foo "\x41\x4a\x7a"`, []string{"SET", "foo", "AJz"}},
+		{`SET foo "\xZZ"`, []string{"SET", "foo", "xZZ"}},
+		{`SET foo "say \"hi\""`, []string{"SET", "foo", `say "hi"`}},
+		{`SET foo 'it\'s'`, []string{"SET", "foo", "it's"}},
+		{`SET foo 'a\nb'`, []string{"SET", "foo", `a\nb`}},
+		{`SET foo '"x"'`, []string{"SET", "foo", `"x"`}},
+		{`SET foo "'x'"`, []string{"SET", "foo", "'x'"}},
+		{`SET foo"bar" x`, []string{"SET", "foobar", "x"}},
+	}
+	for _, c := range cases {
+		args, ok := splitArgs([]byte(c.line))
+		if !ok {
+			t.Errorf("%q: unexpected unbalanced quotes", c.line)
+			continue
+		}
+		if len(args) != len(c.args) {
+			t.Errorf("%q: got %q, want %q", c.line, args, c.args)
+			continue
+		}
+		for i := range args {
+			if string(args[i]) != c.args[i] {
+				t.Errorf("%q: got %q, want %q", c.line, args, c.args)
+				break
+			}
+		}
+	}
+}
+
+func TestSplitArgsUnbalanced(t *testing.T) {
+	for _, line := range []string{
+		`SET foo "bar`,
+		`SET foo 'bar`,
+		`SET foo "bar"baz`,
+		`SET foo 'bar'baz`,
+		`SET foo "bar\"`,
+	} {
+		if _, ok := splitArgs([]byte(line)); ok {
+			t.Errorf("%q: expected unbalanced quotes", line)
+		}
+	}
+}
+
+func TestReadCommandInline(t *testing.T) {
+	input := "SET foo \"hello world\"\r\n\r\nGET foo\n*2\r\n$3\r\nGET\r\n$3\r\nbar\r\nSET a 'b\r\nPING\r\n"
+	reader := NewReader(strings.NewReader(input))
+	want := [][]string{{"SET", "foo", "hello world"}, {"GET", "foo"}, {"GET", "bar"}}
+	for _, w := range want {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bytes.Join(args, []byte(" "))) != strings.Join(w, " ") {
+			t.Fatalf("got %q, want %q", args, w)
+		}
+	}
+	if _, err := reader.ReadCommand(); !IsProtocolError(err) || IsFatal(err) {
+		t.Fatalf("expected non fatal protocol error, got %v", err)
+	}
+	args, err := reader.ReadCommand()
+	if err != nil || len(args) != 1 || string(args[0]) != "PING" {
+		t.Fatalf("got %q %v, want PING", args, err)
+	}
+}
+
+func TestParseStreamRejectsInline(t *testing.T) {
+	ch := ParseStream(strings.NewReader("PING\r\n+OK\r\n"))
+	payload := <-ch
+	if !IsProtocolError(payload.Err) {
+		t.Fatalf("expected protocol error, got %v %v", payload.Data, payload.Err)
+	}
+	payload = <-ch
+	if payload.Err != nil || string(payload.Data.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("got %v %v, want +OK", payload.Data, payload.Err)
+	}
+}
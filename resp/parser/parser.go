@@ -2,241 +2,337 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"go_redis/interface/resp"
 	"go_redis/lib/logger"
 	"go_redis/resp/reply"
 	"io"
+	"math"
 	"runtime/debug"
 	"strconv"
-	"strings"
 )
 
-// 解析器，解析接收到的请求
+// 解析器，解析接收到的请求和回复，支持 RESP2 和 RESP3
 
 type Payload struct {
 	Data resp.Reply // 接收和发送的数据都叫Reply
 	Err  error
 }
 
-type readState struct {
-	// 解析器的状态
-	readingMutiLine   bool     // 是否正在读取多行
-	expectedArgsCount int      // 预期的参数个数
-	msgType           byte     // 消息类型
-	args              [][]byte // 参数列表
-	bulkLen           int64
+// protocolError 协议格式错误，解析器跳过出错的数据继续解析；
+// fatal 为 true 时无法再定位下一个请求，解析结束
+type protocolError struct {
+	msg   string
+	fatal bool
 }
 
-func (r *readState) finished() bool {
-	// 判断解析器是否完成
-	return r.expectedArgsCount > 0 && len(r.args) == r.expectedArgsCount
+func (e *protocolError) Error() string {
+	return "Protocol error: " + e.msg
 }
 
 func ParseStream(reader io.Reader) <-chan *Payload {
-	// 解析RESP协议流
+	// 解析RESP协议流，用于客户端读取回复，不支持 inline 格式
 	// 通过管道输出
 	ch := make(chan *Payload)
-	go parse0(reader, ch)
+	go parse0(newStreamReader(reader), ch)
 	return ch
 }
 
-func parse0(reader io.Reader, ch chan<- *Payload) {
+func parse0(rd *streamReader, ch chan<- *Payload) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error(string(debug.Stack()))
 		}
 	}()
-	bufReader := bufio.NewReader(reader)
-	var state readState
-	var err error
-	var msg []byte
 	for {
-		var ioErr bool
-		msg, ioErr, err = readLine(bufReader, &state)
+		result, err := rd.readReply()
 		if err != nil {
-			if ioErr {
+			var protoErr *protocolError
+			if errors.As(err, &protoErr) && !protoErr.fatal {
 				ch <- &Payload{Err: err}
-				close(ch)
-				return
-			}
-			ch <- &Payload{
-				Err: err,
-			}
-			state = readState{}
-			continue
-		}
-		if !state.readingMutiLine {
-			// 判断不是多行解析
-			if msg[0] == '*' { // *3\r\n
-				err := parseMutiBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{Err: err}
-					state = readState{}
-					continue
-				}
-				if state.expectedArgsCount == 0 {
-					ch <- &Payload{Data: &reply.EmptyMutiBulkReply{}}
-					state = readState{}
-					continue
-				}
-			} else if msg[0] == '$' { //$3\r\n
-				err := parseBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{Err: errors.New("protocol err:" + string(msg))}
-					state = readState{}
-					continue
-				}
-				if state.bulkLen == -1 {
-					ch <- &Payload{Data: &reply.NullBulkReply{}}
-					state = readState{}
-					continue
-				}
-			} else {
-				lineReply, err := parseSingleLineReply(msg)
-				ch <- &Payload{Data: lineReply, Err: err}
-				state = readState{}
-				continue
-			}
-		} else {
-			err := readBody(msg, &state)
-			if err != nil {
-				ch <- &Payload{Err: errors.New("protocol err:" + string(msg))}
-				state = readState{}
 				continue
 			}
-			if state.finished() {
-				var result resp.Reply
-				if state.msgType == '*' {
-					result = reply.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					result = reply.MakeMultiBulkReply(state.args) // 这里直接用多行回复包装
-				}
-				ch <- &Payload{Data: result, Err: nil}
-				state = readState{}
+			// io 错误或超出限制，无法继续解析，结束
+			ch <- &Payload{Err: err}
+			close(ch)
+			return
+		}
+		ch <- &Payload{Data: result}
+	}
+}
+
+const (
+	maxPrealloc     = 1024      // 数组按声明长度预分配的上限，避免只发头部就占用大量内存
+	maxBlobPrealloc = 64 * 1024 // bulk 按声明长度预分配的上限，超过时随读取增长
+	maxNestingDepth = 128       // 聚合类型的最大嵌套层数，避免恶意的深层嵌套耗尽协程栈
+)
+
+// streamReader ParseStream 使用的解析器，除命令外还能解析 RESP2/RESP3 的各种回复
+type streamReader struct {
+	br    *bufio.Reader
+	depth int // 当前正在读取的聚合类型嵌套层数
+}
+
+func newStreamReader(rd io.Reader) *streamReader {
+	return &streamReader{
+		br: bufio.NewReader(rd),
+	}
+}
+
+// readLine 读取一行，返回去掉行尾的内容，crlf 表示是否以 \r\n 结尾
+func (rd *streamReader) readLine() (line []byte, crlf bool, err error) {
+	msg, err := rd.br.ReadBytes('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	if len(msg) >= 2 && msg[len(msg)-2] == '\r' {
+		return msg[:len(msg)-2], true, nil
+	}
+	return msg[:len(msg)-1], false, nil
+}
+
+// readBlob 严格按长度读取 n 字节内容和结尾的 \r\n，内容中可以包含 \r\n
+func (rd *streamReader) readBlob(n int64) ([]byte, error) {
+	var msg []byte
+	if n+2 <= maxBlobPrealloc {
+		msg = make([]byte, n+2) // +2是为了包含\r\n
+		if _, err := io.ReadFull(rd.br, msg); err != nil {
+			return nil, err
+		}
+	} else {
+		// 声明的长度很大时不一次性分配，随实际收到的数据增长
+		buf := bytes.NewBuffer(make([]byte, 0, maxBlobPrealloc))
+		if _, err := io.CopyN(buf, rd.br, n+2); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
 			}
+			return nil, err
 		}
+		msg = buf.Bytes()
+	}
+	if msg[n] != '\r' || msg[n+1] != '\n' {
+		return nil, &protocolError{msg: "invalid bulk format, must end with \\r\\n"}
 	}
-	// 解析器的核心函数
-	// 读取数据并解析成RESP协议格式
-	// 这里可以实现一个简单的RESP解析逻辑
-	// 例如读取字节，判断类型，处理多行等
+	return msg[:n], nil
 }
 
-// $5\r\n\r\n1\r\n
+// parseLength 解析 $3 *3 %3 这类头部中的长度，允许 -1 表示 null
+func parseLength(line []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || n < -1 {
+		return 0, &protocolError{msg: "invalid length " + strconv.Quote(string(line))}
+	}
+	return n, nil
+}
+
+// readReply 读取一个完整的回复，聚合类型递归读取其中的元素。
+// 这里解析的是对端发来的回复，不接受 inline 格式，inline 命令只由 Reader.ReadCommand 处理
 // *3\r\n$3\r\nSET\r\n$4\r\nlzzy\r\n$7\r\nwelcome\r\n
-// *2\r\n$3\r\nGET\r\n$4\r\nlzzy\r\n
-// *2\r\n$4\r\nTYPE\r\n$4\r\nlzzy\r\n
-// *2\r\n$6\r\nselect\r\n$1\r\n1\r\n
-func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
-	// 读取一行数据
-	// 1. \r\n切分
-	// 2. 读取到$+number，严格读取\r\n后面的数据
-	// 返回：读取的信息，是否是io错误，错误类型
-	var msg []byte
-	var err error
-	if state.bulkLen == 0 {
-		// 没有预设的个数，按照\r\n区分
-		msg, err = bufReader.ReadBytes('\n')
+// %1\r\n+key\r\n:1\r\n
+func (rd *streamReader) readReply() (resp.Reply, error) {
+	line, crlf, err := rd.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		// 空行，可能是心跳包
+		return nil, nil
+	}
+	if !crlf {
+		return nil, &protocolError{msg: "must end with \\r\\n"}
+	}
+	switch line[0] {
+	case '+':
+		return reply.MakeStatusReply(string(line[1:])), nil
+	case '-':
+		return reply.MakeErrReply(string(line[1:])), nil
+	case ':':
+		val, err := strconv.ParseInt(string(line[1:]), 10, 64)
 		if err != nil {
-			return nil, true, err // 其他错误直接返回
+			return nil, &protocolError{msg: "invalid integer " + strconv.Quote(string(line))}
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' || msg[len(msg)-1] != '\n' {
-			return nil, false, errors.New("protocol error:must end with \\r\\n")
+		return reply.MakeIntReply(val), nil
+	case '$', '=', '!':
+		return rd.readBulk(line)
+	case '*':
+		return rd.readArray(line)
+	case '%', '|':
+		n, err := parseLength(line)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		msg = make([]byte, state.bulkLen+2) // +2是为了包含\r\n
-		_, err := io.ReadFull(bufReader, msg)
+		m := reply.MakeMapReply()
+		for i := int64(0); i < n; i++ {
+			key, err := rd.readElement()
+			if err != nil {
+				return nil, err
+			}
+			value, err := rd.readElement()
+			if err != nil {
+				return nil, err
+			}
+			m.Add(key, value)
+		}
+		if line[0] == '%' {
+			return m, nil
+		}
+		// 属性之后紧跟真正的回复
+		r, err := rd.readElement()
 		if err != nil {
-			return nil, true, err // 其他错误直接返回
+			return nil, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' || msg[len(msg)-1] != '\n' {
-			return nil, false, errors.New("protocol error:invalid bulk format, must end with \\r\\n")
+		return reply.MakeAttributeReply(m, r), nil
+	case '~', '>':
+		n, err := parseLength(line)
+		if err != nil {
+			return nil, err
+		}
+		replies, err := rd.readElements(n)
+		if err != nil {
+			return nil, err
 		}
-		state.bulkLen = 0 // 读取完毕后重置bulkLen
+		if line[0] == '~' {
+			return reply.MakeSetReply(replies), nil
+		}
+		return reply.MakePushReply(replies), nil
+	case '_':
+		return reply.MakeNullReply(), nil
+	case '#':
+		switch string(line[1:]) {
+		case "t":
+			return reply.MakeBooleanReply(true), nil
+		case "f":
+			return reply.MakeBooleanReply(false), nil
+		}
+		return nil, &protocolError{msg: "invalid boolean " + strconv.Quote(string(line))}
+	case ',':
+		return parseDouble(line)
+	case '(':
+		if !isBigNumber(line[1:]) {
+			return nil, &protocolError{msg: "invalid big number " + strconv.Quote(string(line))}
+		}
+		return reply.MakeBigNumberReply(string(line[1:])), nil
 	}
-	return msg, false, nil
+	return nil, &protocolError{msg: "unknown reply type " + strconv.Quote(string(line))}
 }
 
-// *3\r\n$5\r\nhello\r\n$4\r\nlzzy\r\n$7\r\nwelcome\r\n
-func parseMutiBulkHeader(msg []byte, state *readState) error {
-	// 根据msg的信息，修改readState状态
-	var err error
-	var expectedLine uint64
-	expectedLine, err = strconv.ParseUint(string(msg[1:len(msg)-2]), 10, 32)
+// readBulk 读取 bulk 字符串、verbatim 字符串和 blob error
+// $5\r\nhello\r\n =15\r\ntxt:Some string\r\n !21\r\nSYNTAX invalid syntax\r\n
+func (rd *streamReader) readBulk(line []byte) (resp.Reply, error) {
+	n, err := parseLength(line)
 	if err != nil {
-		return errors.New("parseMutiBulkHeader ParseUint error:" + string(msg))
-	}
-	if expectedLine == 0 {
-		state.expectedArgsCount = 0
-		return nil
-	} else if expectedLine > 0 {
-		state.msgType = msg[0]
-		state.readingMutiLine = true
-		state.expectedArgsCount = int(expectedLine)
-		state.args = make([][]byte, 0, expectedLine)
-		return nil
-	} else {
-		return errors.New("parseMutiBulkHeader expectedLine error:" + string(msg))
+		return nil, err
+	}
+	if n == -1 {
+		return reply.MakeNullBulkReply(), nil
 	}
+	body, err := rd.readBlob(n)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '=':
+		if len(body) < 4 || body[3] != ':' {
+			return nil, &protocolError{msg: "invalid verbatim string"}
+		}
+		return reply.MakeVerbatimReply(string(body[:3]), body[4:]), nil
+	case '!':
+		return reply.MakeErrReply(string(body)), nil
+	}
+	return reply.MakeBulkReply(body), nil
 }
 
-// $4\r\nPING\r\n
-func parseBulkHeader(msg []byte, state *readState) error {
-	var err error
-	state.bulkLen, err = strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
+// readArray 读取数组，元素全部是 bulk 字符串时返回 MultiBulkReply，否则返回 MultiRawReply
+func (rd *streamReader) readArray(line []byte) (resp.Reply, error) {
+	n, err := parseLength(line)
 	if err != nil {
-		return errors.New("parseBulkHeader ParseInt error:" + string(msg))
-	}
-	if state.bulkLen == -1 {
-		return nil
-	} else if state.bulkLen > 0 {
-		state.readingMutiLine = true
-		state.msgType = msg[0]
-		state.expectedArgsCount = 1
-		state.args = make([][]byte, 0, 1)
-		return nil
-	} else {
-		return errors.New("parseBulkHeader bulkLen error:" + string(msg))
+		return nil, err
 	}
+	if n == -1 {
+		return reply.MakeNullReply(), nil
+	}
+	if n == 0 {
+		return &reply.EmptyMutiBulkReply{}, nil
+	}
+	replies, err := rd.readElements(n)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, 0, len(replies))
+	for _, r := range replies {
+		switch element := r.(type) {
+		case *reply.BulkReply:
+			args = append(args, element.Arg)
+		case *reply.NullBulkReply, *reply.NullReply:
+			args = append(args, nil)
+		default:
+			return reply.MakeMultiRawReply(replies), nil
+		}
+	}
+	return reply.MakeMultiBulkReply(args), nil
 }
 
-// +OK -err
-func parseSingleLineReply(msg []byte) (resp.Reply, error) {
-	str := strings.TrimSuffix(string(msg), "\r\n")
-	var result resp.Reply
-	switch str[0] {
-	case '+':
-		result = reply.MakeStatusReply(str[1:])
-	case '-':
-		result = reply.MakeErrReply(str[1:])
-	case ':':
-		val, err := strconv.ParseInt(str[1:], 10, 64)
+func (rd *streamReader) readElements(n int64) ([]resp.Reply, error) {
+	replies := make([]resp.Reply, 0, min(n, maxPrealloc))
+	for i := int64(0); i < n; i++ {
+		r, err := rd.readElement()
 		if err != nil {
-			return nil, errors.New("parseSingleLineReply error:" + string(msg))
+			return nil, err
 		}
-		result = reply.MakeIntReply(val)
+		replies = append(replies, r)
 	}
-	return result, nil
+	return replies, nil
 }
 
-// PING\r\n
-// $5\r\n\r\n1\r\n
-// $5\r\n\r\n1\r\n$5\r\n\r\n1\r\n$5\r\n\r\n1\r\n
-func readBody(msg []byte, state *readState) error {
-	var err error
-	line := msg[0 : len(msg)-2]
-	if line[0] == '$' {
-		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
-			return errors.New("readBody error:" + string(msg))
-		}
-		if state.bulkLen <= 0 {
-			state.args = append(state.args, []byte{})
-			state.bulkLen = 0
+// readElement 读取聚合类型中的一个元素，元素不能为空行。
+// 嵌套超过 maxNestingDepth 层时无法再定位下一个回复，解析结束
+func (rd *streamReader) readElement() (resp.Reply, error) {
+	if rd.depth >= maxNestingDepth {
+		return nil, &protocolError{msg: "too many nested aggregates", fatal: true}
+	}
+	rd.depth++
+	r, err := rd.readReply()
+	rd.depth--
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, &protocolError{msg: "unexpected empty line"}
+	}
+	return r, nil
+}
+
+// parseDouble ,3.14\r\n ,inf\r\n ,-inf\r\n ,nan\r\n
+func parseDouble(line []byte) (resp.Reply, error) {
+	str := string(line[1:])
+	switch str {
+	case "inf":
+		return reply.MakeDoubleReply(math.Inf(1)), nil
+	case "-inf":
+		return reply.MakeDoubleReply(math.Inf(-1)), nil
+	case "nan":
+		return reply.MakeDoubleReply(math.NaN()), nil
+	}
+	val, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return nil, &protocolError{msg: "invalid double " + strconv.Quote(string(line))}
+	}
+	return reply.MakeDoubleReply(val), nil
+}
+
+// isBigNumber 判断是否为可带符号的十进制整数
+func isBigNumber(num []byte) bool {
+	if len(num) > 0 && (num[0] == '-' || num[0] == '+') {
+		num = num[1:]
+	}
+	if len(num) == 0 {
+		return false
+	}
+	for _, b := range num {
+		if b < '0' || b > '9' {
+			return false
 		}
-	} else {
-		state.args = append(state.args, line)
 	}
-	return nil
+	return true
 }
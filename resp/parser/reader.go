@@ -0,0 +1,299 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"go_redis/config"
+	"io"
+	"strconv"
+)
+
+// Reader 拉取式的命令解析器，由调用方在自己的协程中循环调用 ReadCommand。
+// 与 ParseStream 相比：不额外启动协程，不经过 channel；
+// 读缓冲区在整个连接生命周期内复用，一次 Read 可以读到多条 pipeline 命令，
+// 解析时直接在缓冲区上扫描，不为每一行分配内存。
+//
+//	reader := parser.NewRequestReader(conn)
+//	for {
+//		args, err := reader.ReadCommand()
+//		...
+//	}
+type Reader struct {
+	rd      io.Reader
+	buf     []byte
+	r, w    int   // buf[r:w] 为已读入但尚未解析的数据
+	start   int   // 当前命令在 buf 中的起始位置，扩容和整理缓冲区时以它为基准
+	limited bool  // 是否按配置限制请求大小
+	offsets []int // readMultiBulk 记录参数位置，复用以减少分配
+}
+
+// ErrBufferFull ReadAhead 时缓冲区已满
+var ErrBufferFull = errors.New("read ahead buffer is full")
+
+const (
+	defaultReaderBufSize = 16 * 1024
+	maxLineLen           = 64 * 1024 // 请求中单行的最大长度，包括 inline 命令和 *3 $3 这类头部
+)
+
+// NewReader 创建不限制请求大小的 Reader，用于加载 AOF 等可信的数据
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{
+		rd:  rd,
+		buf: make([]byte, defaultReaderBufSize),
+	}
+}
+
+// NewRequestReader 创建按 proto-max-bulk-len 等配置限制请求大小的 Reader，用于客户端连接
+func NewRequestReader(rd io.Reader) *Reader {
+	reader := NewReader(rd)
+	reader.limited = true
+	return reader
+}
+
+// Buffered 返回已读入缓冲区、尚未解析的字节数。
+// 不为 0 时说明客户端以 pipeline 方式发送了更多命令，调用方可以据此合并回复
+func (reader *Reader) Buffered() int {
+	return reader.w - reader.r
+}
+
+// IsProtocolError 判断 ReadCommand 返回的错误是否为协议错误，其余错误为 io 错误
+func IsProtocolError(err error) bool {
+	var protoErr *protocolError
+	return errors.As(err, &protoErr)
+}
+
+// IsFatal 判断出错后能否继续调用 ReadCommand，io 错误和无法定位下一条命令的协议错误为 true
+func IsFatal(err error) bool {
+	var protoErr *protocolError
+	if errors.As(err, &protoErr) {
+		return protoErr.fatal
+	}
+	return true
+}
+
+// ReadCommand 读取一条命令，支持 RESP 数组和 inline 命令，空行和空数组会被跳过。
+// 每条命令的参数共用一块新分配的内存，调用方可以放心地保留参数，不受后续读取影响
+func (reader *Reader) ReadCommand() ([][]byte, error) {
+	reader.shrink()
+	for {
+		reader.start = reader.r
+		line, err := reader.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			args, ok := splitArgs(line)
+			if !ok {
+				return nil, &protocolError{msg: "unbalanced quotes in request"}
+			}
+			if len(args) == 0 {
+				continue
+			}
+			return args, nil
+		}
+		n, err := parseIntBytes(line[1:])
+		if err != nil {
+			return nil, &protocolError{msg: "invalid multibulk length", fatal: true}
+		}
+		if err := reader.checkLength('*', n); err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			continue
+		}
+		return reader.readMultiBulk(int(n))
+	}
+}
+
+// readMultiBulk 读取 n 个 bulk 参数，先记录各参数相对命令起点的位置，
+// 全部读完后一次性复制到新分配的内存中
+func (reader *Reader) readMultiBulk(n int) ([][]byte, error) {
+	offsets := reader.offsets[:0]
+	defer func() {
+		if cap(offsets) <= 2*maxPrealloc {
+			reader.offsets = offsets[:0]
+		}
+	}()
+	total := 0
+	for i := 0; i < n; i++ {
+		line, err := reader.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 2 || line[len(line)-1] != '\r' {
+			return nil, &protocolError{msg: "must end with \\r\\n", fatal: true}
+		}
+		if line[0] != '$' {
+			return nil, &protocolError{msg: "expected '$', got '" + string(line[0]) + "'", fatal: true}
+		}
+		size, err := parseIntBytes(line[1 : len(line)-1])
+		if err != nil || size < 0 {
+			return nil, &protocolError{msg: "invalid bulk length", fatal: true}
+		}
+		if err := reader.checkLength('$', size); err != nil {
+			return nil, err
+		}
+		if err := reader.ensure(int(size) + 2); err != nil {
+			return nil, err
+		}
+		if reader.buf[reader.r+int(size)] != '\r' || reader.buf[reader.r+int(size)+1] != '\n' {
+			return nil, &protocolError{msg: "invalid bulk format, must end with \\r\\n", fatal: true}
+		}
+		offsets = append(offsets, reader.r-reader.start, int(size))
+		total += int(size)
+		reader.r += int(size) + 2
+	}
+	block := make([]byte, total)
+	args := make([][]byte, n)
+	pos := 0
+	for i := 0; i < n; i++ {
+		offset, size := offsets[2*i], offsets[2*i+1]
+		copy(block[pos:], reader.buf[reader.start+offset:reader.start+offset+size])
+		args[i] = block[pos : pos+size : pos+size]
+		pos += size
+	}
+	return args, nil
+}
+
+// readLine 返回以 \n 结尾的一行，不含 \n，返回值指向内部缓冲区，下一次读取前有效
+func (reader *Reader) readLine() ([]byte, error) {
+	scanned := 0
+	for {
+		if i := bytes.IndexByte(reader.buf[reader.r+scanned:reader.w], '\n'); i >= 0 {
+			end := reader.r + scanned + i
+			line := reader.buf[reader.r:end]
+			reader.r = end + 1
+			return line, nil
+		}
+		scanned = reader.w - reader.r
+		if reader.limited && scanned > maxLineLen {
+			return nil, &protocolError{msg: "too big inline request", fatal: true}
+		}
+		if err := reader.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ensure 保证缓冲区中至少有 n 字节未解析的数据
+func (reader *Reader) ensure(n int) error {
+	for reader.w-reader.r < n {
+		if err := reader.fill(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// fill 从底层读取更多数据。当前命令之前已解析的数据会被丢弃，
+// 缓冲区不够用时扩容，只在命令或参数超过缓冲区大小时发生
+func (reader *Reader) fill() error {
+	reader.compact()
+	if reader.w == len(reader.buf) {
+		grown := make([]byte, len(reader.buf)*2)
+		copy(grown, reader.buf[:reader.w])
+		reader.buf = grown
+	}
+	n, err := reader.rd.Read(reader.buf[reader.w:])
+	reader.w += n
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+// compact 丢弃当前命令之前已解析的数据
+func (reader *Reader) compact() {
+	if reader.start > 0 {
+		copy(reader.buf, reader.buf[reader.start:reader.w])
+		reader.r -= reader.start
+		reader.w -= reader.start
+		reader.start = 0
+	}
+}
+
+// ReadAhead 调用方阻塞在一条命令上时继续读取之后的数据，读到的数据留给之后的 ReadCommand。
+// 只使用缓冲区中剩余的空间，不扩容，缓冲区已满时返回 ErrBufferFull。
+// 不能与 ReadCommand 并发调用
+func (reader *Reader) ReadAhead() error {
+	reader.compact()
+	if reader.w == len(reader.buf) {
+		return ErrBufferFull
+	}
+	n, err := reader.rd.Read(reader.buf[reader.w:])
+	reader.w += n
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+// checkLength 检查客户端声明的 bulk 和数组长度是否超过配置的上限
+func (reader *Reader) checkLength(prefix byte, n int64) error {
+	if !reader.limited {
+		return nil
+	}
+	switch prefix {
+	case '$':
+		if maxLen := config.Properties().ProtoMaxBulkLen; maxLen > 0 && n > int64(maxLen) {
+			return &protocolError{msg: "invalid bulk length", fatal: true}
+		}
+	case '*':
+		if maxLen := config.Properties().ProtoMaxMultiBulkLen; maxLen > 0 && n > int64(maxLen) {
+			return &protocolError{msg: "invalid multibulk length", fatal: true}
+		}
+	}
+	return nil
+}
+
+// shrink 处理过大的命令后缓冲区会扩容，空闲时恢复到默认大小，避免长期占用内存
+func (reader *Reader) shrink() {
+	if len(reader.buf) <= 4*defaultReaderBufSize || reader.w-reader.r > defaultReaderBufSize {
+		return
+	}
+	buf := make([]byte, defaultReaderBufSize)
+	reader.w = copy(buf, reader.buf[reader.r:reader.w])
+	reader.r = 0
+	reader.buf = buf
+}
+
+// parseIntBytes 直接解析字节中的十进制整数，避免转换为 string
+func parseIntBytes(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 19 {
+		return 0, strconv.ErrSyntax
+	}
+	neg := false
+	if b[0] == '-' {
+		neg = true
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, strconv.ErrSyntax
+		}
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, strconv.ErrSyntax
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"bytes"
+	"go_redis/config"
+	"go_redis/lib/utils"
+	"go_redis/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pipelinedInput 模拟客户端一次发送的 pipeline 请求
+func pipelinedInput(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		key := "key:" + strconv.Itoa(i)
+		buf.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("SET", key, "value-"+key)).ToBytes())
+	}
+	return buf.Bytes()
+}
+
+const benchPipeline = 1000
+
+func TestReaderReadCommandPipeline(t *testing.T) {
+	reader := NewReader(bytes.NewReader(pipelinedInput(benchPipeline)))
+	for i := 0; i < benchPipeline; i++ {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := "key:" + strconv.Itoa(i)
+		if len(args) != 3 || string(args[1]) != key || string(args[2]) != "value-"+key {
+			t.Fatalf("command %d: got %q", i, args)
+		}
+	}
+}
+
+// setProtoLimits 修改请求大小限制，测试结束后恢复
+func setProtoLimits(t *testing.T, maxBulkLen, maxMultiBulkLen int) {
+	t.Helper()
+	old := config.Properties()
+	props := *old
+	props.ProtoMaxBulkLen = maxBulkLen
+	props.ProtoMaxMultiBulkLen = maxMultiBulkLen
+	config.SetProperties(&props)
+	t.Cleanup(func() { config.SetProperties(old) })
+}
+
+func TestReaderLimits(t *testing.T) {
+	setProtoLimits(t, 16, 4)
+	cases := []struct {
+		name    string
+		input   string
+		err     string
+		trusted bool // 不限制大小时能否读取
+	}{
+		{"bulk length", "*2\r\n$3\r\nGET\r\n$17\r\n", "invalid bulk length", true},
+		{"multibulk count", "*5\r\n", "invalid multibulk length", true},
+		{"inline line length", "SET k " + strings.Repeat("v", 2*maxLineLen) + "\r\n", "too big inline request", true},
+		{"header line length", "*" + strings.Repeat("1", 2*maxLineLen) + "\r\n", "too big inline request", false},
+	}
+	for _, c := range cases {
+		_, err := NewRequestReader(strings.NewReader(c.input)).ReadCommand()
+		if !IsProtocolError(err) || !IsFatal(err) || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got %v, want fatal %q", c.name, err, c.err)
+		}
+		if !c.trusted {
+			continue
+		}
+		// 可信数据不受限制，只因数据不完整出错
+		_, err = NewReader(strings.NewReader(c.input)).ReadCommand()
+		if IsProtocolError(err) {
+			t.Errorf("%s: unlimited reader got %v", c.name, err)
+		}
+	}
+
+	// 正好等于上限时允许
+	input := reply.MakeMultiBulkReply(utils.ToCmdLine("SET", "k", strings.Repeat("v", 16), "x")).ToBytes()
+	args, err := NewRequestReader(bytes.NewReader(input)).ReadCommand()
+	if err != nil || len(args) != 4 {
+		t.Fatalf("got %q %v", args, err)
+	}
+}
+
+func TestParseStreamNestingDepth(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat("*1\r\n", depth) + ":1\r\n"
+	}
+	ch := ParseStream(strings.NewReader(nested(maxNestingDepth) + "+OK\r\n"))
+	if payload := <-ch; payload.Err != nil {
+		t.Fatalf("depth %d: %v", maxNestingDepth, payload.Err)
+	}
+	if payload := <-ch; payload.Err != nil || string(payload.Data.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("got %v %v, want +OK", payload.Data, payload.Err)
+	}
+
+	ch = ParseStream(strings.NewReader(nested(1024*1024) + "+OK\r\n"))
+	payload := <-ch
+	if !IsProtocolError(payload.Err) || !IsFatal(payload.Err) {
+		t.Fatalf("got %v %v, want fatal protocol error", payload.Data, payload.Err)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("ParseStream continues after a fatal error")
+	}
+}
+
+func BenchmarkParseStream(b *testing.B) {
+	input := pipelinedInput(benchPipeline)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for payload := range ParseStream(bytes.NewReader(input)) {
+			if payload.Err != nil {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkReaderReadCommand(b *testing.B) {
+	input := pipelinedInput(benchPipeline)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader := NewReader(bytes.NewReader(input))
+		for {
+			if _, err := reader.ReadCommand(); err != nil {
+				break
+			}
+		}
+	}
+}
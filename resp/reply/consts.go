@@ -52,7 +52,7 @@ func MakeNullBulkReply() *NullBulkReply {
 type EmptyMutiBulkReply struct {
 }
 
-var emptyMutiBulkBytes = []byte("$*0\r\n")
+var emptyMutiBulkBytes = []byte("*0\r\n")
 
 func (n EmptyMutiBulkReply) ToBytes() []byte {
 	return emptyMutiBulkBytes
@@ -74,3 +74,19 @@ func (n NoReply) ToBytes() []byte {
 func MakeNoReply() *NoReply {
 	return &NoReply{}
 }
+
+// QueuedReply MULTI 之后命令入队的回复
+type QueuedReply struct {
+}
+
+var queuedBytes = []byte("+QUEUED\r\n")
+
+func (r QueuedReply) ToBytes() []byte {
+	return queuedBytes
+}
+
+var theQueuedReply = new(QueuedReply)
+
+func MakeQueuedReply() *QueuedReply {
+	return theQueuedReply
+}
@@ -7,8 +7,8 @@ import (
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1") // 空字符串
-	CRLF               = "\r\n"        // 空字符串
+	emptyBulkReplyBytes = []byte("$0\r\n\r\n") // 空字符串
+	CRLF                = "\r\n"
 )
 
 type BulkReply struct {
@@ -17,7 +17,7 @@ type BulkReply struct {
 
 func (b BulkReply) ToBytes() []byte {
 	if len(b.Arg) == 0 {
-		return nullBulkReplyBytes
+		return emptyBulkReplyBytes
 	}
 	return []byte("$" + strconv.Itoa(len(b.Arg)) + CRLF + string(b.Arg) + CRLF)
 }
@@ -34,8 +34,8 @@ func (m MultiBulkReply) ToBytes() []byte {
 	var buf bytes.Buffer
 	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
 	for _, arg := range m.Args {
-		if len(arg) == 0 {
-			buf.WriteString(string(nullBulkBytes) + CRLF)
+		if arg == nil {
+			buf.Write(nullBulkBytes)
 		} else {
 			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
 		}
@@ -46,6 +46,23 @@ func MakeMultiBulkReply(arg [][]byte) *MultiBulkReply {
 	return &MultiBulkReply{Args: arg}
 }
 
+// MultiRawReply 由任意回复组成的数组，用于嵌套数组或混合类型的数组
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+func (m *MultiRawReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(m.Replies)) + CRLF)
+	for _, r := range m.Replies {
+		buf.Write(r.ToBytes())
+	}
+	return buf.Bytes()
+}
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{Replies: replies}
+}
+
 type StatusReply struct {
 	Status string
 }
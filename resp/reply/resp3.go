@@ -0,0 +1,281 @@
+package reply
+
+import (
+	"bytes"
+	"go_redis/interface/resp"
+	"math"
+	"strconv"
+)
+
+// RESP 协议版本
+const (
+	Protocol2 = 2
+	Protocol3 = 3
+)
+
+// Resp3Reply 在 RESP3 下编码与 RESP2 不同的回复
+// ToBytes 始终返回 RESP2 编码，ToBytes3 返回 RESP3 编码
+type Resp3Reply interface {
+	resp.Reply
+	ToBytes3() []byte
+}
+
+// ToBytes 按协议版本编码回复，protocol 为 3 时优先使用 RESP3 编码
+func ToBytes(r resp.Reply, protocol int) []byte {
+	if protocol == Protocol3 {
+		if r3, ok := r.(Resp3Reply); ok {
+			return r3.ToBytes3()
+		}
+	}
+	return r.ToBytes()
+}
+
+// writeAggregate 写入聚合类型：头部 + 按协议编码的各个元素
+func writeAggregate(buf *bytes.Buffer, prefix byte, n int, replies []resp.Reply, protocol int) {
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(n) + CRLF)
+	for _, r := range replies {
+		buf.Write(ToBytes(r, protocol))
+	}
+}
+
+var nullBytes = []byte("_\r\n")
+
+// NullReply RESP3 的 null，RESP2 下编码为空 bulk
+type NullReply struct{}
+
+func (n *NullReply) ToBytes() []byte {
+	return nullBulkBytes
+}
+func (n *NullReply) ToBytes3() []byte {
+	return nullBytes
+}
+func MakeNullReply() *NullReply {
+	return &NullReply{}
+}
+
+// RESP3 下 null bulk 统一编码为 null
+func (n NullBulkReply) ToBytes3() []byte {
+	return nullBytes
+}
+
+// ToBytes3 数组中的 nil 元素编码为 RESP3 null
+func (m MultiBulkReply) ToBytes3() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(m.Args)) + CRLF)
+	for _, arg := range m.Args {
+		if arg == nil {
+			buf.Write(nullBytes)
+		} else {
+			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (m *MultiRawReply) ToBytes3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(m.Replies), m.Replies, Protocol3)
+	return buf.Bytes()
+}
+
+// MapReply 键值对，RESP2 下编码为 key value 交替的数组
+// %2\r\n+first\r\n:1\r\n+second\r\n:2\r\n
+type MapReply struct {
+	Keys   []resp.Reply
+	Values []resp.Reply
+}
+
+func (m *MapReply) pairs() []resp.Reply {
+	flat := make([]resp.Reply, 0, len(m.Keys)*2)
+	for i := range m.Keys {
+		flat = append(flat, m.Keys[i], m.Values[i])
+	}
+	return flat
+}
+
+func (m *MapReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(m.Keys)*2, m.pairs(), Protocol2)
+	return buf.Bytes()
+}
+func (m *MapReply) ToBytes3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '%', len(m.Keys), m.pairs(), Protocol3)
+	return buf.Bytes()
+}
+
+// Add 追加一个键值对，返回自身便于链式调用
+func (m *MapReply) Add(key resp.Reply, value resp.Reply) *MapReply {
+	m.Keys = append(m.Keys, key)
+	m.Values = append(m.Values, value)
+	return m
+}
+
+func MakeMapReply() *MapReply {
+	return &MapReply{}
+}
+
+// MakeBulkMapReply 由 key value 交替的字符串列表创建 MapReply
+func MakeBulkMapReply(pairs [][]byte) *MapReply {
+	m := &MapReply{
+		Keys:   make([]resp.Reply, 0, len(pairs)/2),
+		Values: make([]resp.Reply, 0, len(pairs)/2),
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		m.Add(MakeBulkReply(pairs[i]), MakeBulkReply(pairs[i+1]))
+	}
+	return m
+}
+
+// SetReply 无序集合，RESP2 下编码为数组
+// ~2\r\n+a\r\n+b\r\n
+type SetReply struct {
+	Members []resp.Reply
+}
+
+func (s *SetReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(s.Members), s.Members, Protocol2)
+	return buf.Bytes()
+}
+func (s *SetReply) ToBytes3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '~', len(s.Members), s.Members, Protocol3)
+	return buf.Bytes()
+}
+func MakeSetReply(members []resp.Reply) *SetReply {
+	return &SetReply{Members: members}
+}
+
+// PushReply 服务端主动推送的消息，如 pub/sub 消息，RESP2 下编码为数组
+// >3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n
+type PushReply struct {
+	Replies []resp.Reply
+}
+
+func (p *PushReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '*', len(p.Replies), p.Replies, Protocol2)
+	return buf.Bytes()
+}
+func (p *PushReply) ToBytes3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '>', len(p.Replies), p.Replies, Protocol3)
+	return buf.Bytes()
+}
+func MakePushReply(replies []resp.Reply) *PushReply {
+	return &PushReply{Replies: replies}
+}
+
+// AttributeReply 附带属性的回复，属性在前，随后是真正的回复。
+// RESP2 不支持属性，只编码 Reply 本身
+// |1\r\n+ttl\r\n:3600\r\n$5\r\nvalue\r\n
+type AttributeReply struct {
+	Attributes *MapReply
+	Reply      resp.Reply
+}
+
+func (a *AttributeReply) ToBytes() []byte {
+	return a.Reply.ToBytes()
+}
+func (a *AttributeReply) ToBytes3() []byte {
+	var buf bytes.Buffer
+	writeAggregate(&buf, '|', len(a.Attributes.Keys), a.Attributes.pairs(), Protocol3)
+	buf.Write(ToBytes(a.Reply, Protocol3))
+	return buf.Bytes()
+}
+func MakeAttributeReply(attributes *MapReply, r resp.Reply) *AttributeReply {
+	return &AttributeReply{Attributes: attributes, Reply: r}
+}
+
+// DoubleReply 浮点数，RESP2 下编码为 bulk 字符串
+// ,3.14\r\n ,inf\r\n ,-inf\r\n ,nan\r\n
+type DoubleReply struct {
+	Value float64
+}
+
+func (d *DoubleReply) format() string {
+	switch {
+	case math.IsInf(d.Value, 1):
+		return "inf"
+	case math.IsInf(d.Value, -1):
+		return "-inf"
+	case math.IsNaN(d.Value):
+		return "nan"
+	}
+	return strconv.FormatFloat(d.Value, 'g', 17, 64)
+}
+
+func (d *DoubleReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(d.format())).ToBytes()
+}
+func (d *DoubleReply) ToBytes3() []byte {
+	return []byte("," + d.format() + CRLF)
+}
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{Value: value}
+}
+
+// BooleanReply 布尔值，RESP2 下编码为整数 1 或 0
+// #t\r\n #f\r\n
+type BooleanReply struct {
+	Value bool
+}
+
+var (
+	trueBytes  = []byte("#t\r\n")
+	falseBytes = []byte("#f\r\n")
+	oneBytes   = []byte(":1\r\n")
+	zeroBytes  = []byte(":0\r\n")
+)
+
+func (b *BooleanReply) ToBytes() []byte {
+	if b.Value {
+		return oneBytes
+	}
+	return zeroBytes
+}
+func (b *BooleanReply) ToBytes3() []byte {
+	if b.Value {
+		return trueBytes
+	}
+	return falseBytes
+}
+func MakeBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{Value: value}
+}
+
+// BigNumberReply 大整数，以十进制字符串保存，RESP2 下编码为 bulk 字符串
+// (3492890328409238509324850943850943825024385\r\n
+type BigNumberReply struct {
+	Value string
+}
+
+func (b *BigNumberReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(b.Value)).ToBytes()
+}
+func (b *BigNumberReply) ToBytes3() []byte {
+	return []byte("(" + b.Value + CRLF)
+}
+func MakeBigNumberReply(value string) *BigNumberReply {
+	return &BigNumberReply{Value: value}
+}
+
+// VerbatimReply 带格式的文本，Format 为 3 个字符，如 txt、mkd，RESP2 下编码为 bulk 字符串
+// =15\r\ntxt:Some string\r\n
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+func (v *VerbatimReply) ToBytes() []byte {
+	return MakeBulkReply(v.Text).ToBytes()
+}
+func (v *VerbatimReply) ToBytes3() []byte {
+	body := v.Format + ":" + string(v.Text)
+	return []byte("=" + strconv.Itoa(len(body)) + CRLF + body + CRLF)
+}
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{Format: format, Text: text}
+}
@@ -0,0 +1,62 @@
+package reply
+
+import (
+	"go_redis/interface/resp"
+	"math"
+	"testing"
+)
+
+func TestResp3Encoding(t *testing.T) {
+	bulk := func(s string) resp.Reply {
+		return MakeBulkReply([]byte(s))
+	}
+	cases := []struct {
+		name  string
+		reply resp.Reply
+		resp2 string
+		resp3 string
+	}{
+		{"null", MakeNullReply(), "$-1\r\n", "_\r\n"},
+		{"null bulk", MakeNullBulkReply(), "$-1\r\n", "_\r\n"},
+		{"array with nil", MakeMultiBulkReply([][]byte{[]byte("a"), nil}),
+			"*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+		{"map", MakeMapReply().Add(bulk("a"), MakeIntReply(1)).Add(bulk("b"), MakeNullReply()),
+			"*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n$-1\r\n", "%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n_\r\n"},
+		{"empty map", MakeMapReply(), "*0\r\n", "%0\r\n"},
+		{"bulk map", MakeBulkMapReply([][]byte{[]byte("k"), []byte("v")}),
+			"*2\r\n$1\r\nk\r\n$1\r\nv\r\n", "%1\r\n$1\r\nk\r\n$1\r\nv\r\n"},
+		{"set", MakeSetReply([]resp.Reply{bulk("a"), bulk("b")}),
+			"*2\r\n$1\r\na\r\n$1\r\nb\r\n", "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"push", MakePushReply([]resp.Reply{bulk("message"), bulk("ch"), MakeDoubleReply(1.5)}),
+			"*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$3\r\n1.5\r\n", ">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n,1.5\r\n"},
+		{"nested", MakeMultiRawReply([]resp.Reply{MakeSetReply([]resp.Reply{MakeBooleanReply(true)})}),
+			"*1\r\n*1\r\n:1\r\n", "*1\r\n~1\r\n#t\r\n"},
+		{"double", MakeDoubleReply(3.25), "$4\r\n3.25\r\n", ",3.25\r\n"},
+		// 与 redis 7.0 一样按 %.17g 输出
+		{"double precision", MakeDoubleReply(0.1), "$19\r\n0.10000000000000001\r\n", ",0.10000000000000001\r\n"},
+		{"double integer", MakeDoubleReply(10), "$2\r\n10\r\n", ",10\r\n"},
+		{"inf", MakeDoubleReply(math.Inf(1)), "$3\r\ninf\r\n", ",inf\r\n"},
+		{"-inf", MakeDoubleReply(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{"nan", MakeDoubleReply(math.NaN()), "$3\r\nnan\r\n", ",nan\r\n"},
+		{"boolean", MakeBooleanReply(false), ":0\r\n", "#f\r\n"},
+		{"big number", MakeBigNumberReply("12345678901234567890"),
+			"$20\r\n12345678901234567890\r\n", "(12345678901234567890\r\n"},
+		{"verbatim", MakeVerbatimReply("txt", []byte("hi")), "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{"attribute", MakeAttributeReply(MakeMapReply().Add(bulk("ttl"), MakeIntReply(10)), bulk("v")),
+			"$1\r\nv\r\n", "|1\r\n$3\r\nttl\r\n:10\r\n$1\r\nv\r\n"},
+		// 没有 RESP3 编码的回复两种协议相同
+		{"int", MakeIntReply(7), ":7\r\n", ":7\r\n"},
+		{"status", MakeOkReply(), "+OK\r\n", "+OK\r\n"},
+	}
+	for _, c := range cases {
+		if got := string(ToBytes(c.reply, Protocol2)); got != c.resp2 {
+			t.Errorf("%s: RESP2 %q, want %q", c.name, got, c.resp2)
+		}
+		if got := string(c.reply.ToBytes()); got != c.resp2 {
+			t.Errorf("%s: ToBytes %q, want %q", c.name, got, c.resp2)
+		}
+		if got := string(ToBytes(c.reply, Protocol3)); got != c.resp3 {
+			t.Errorf("%s: RESP3 %q, want %q", c.name, got, c.resp3)
+		}
+	}
+}
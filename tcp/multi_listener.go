@@ -0,0 +1,74 @@
+package tcp
+
+import (
+	"net"
+	"sync"
+)
+
+// multiListener 把多个 listener 合并为一个，用于同时监听明文端口和 TLS 端口
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan net.Conn
+	errs      chan error
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		conns:     make(chan net.Conn),
+		errs:      make(chan error, len(listeners)),
+		closed:    make(chan struct{}),
+	}
+	for _, listener := range listeners {
+		go m.acceptLoop(listener)
+	}
+	return m
+}
+
+func (m *multiListener) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			m.errs <- err
+			return
+		}
+		select {
+		case m.conns <- conn:
+		case <-m.closed:
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// Accept 返回任意一个 listener 接收到的连接，任意一个 listener 出错时返回该错误
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-m.conns:
+		return conn, nil
+	case err := <-m.errs:
+		return nil, err
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		for _, listener := range m.listeners {
+			if e := listener.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// Addr 返回第一个 listener 的地址
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
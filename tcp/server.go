@@ -2,30 +2,85 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"go_redis/config"
 	"go_redis/interface/tcp"
 	"go_redis/lib/logger"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 )
 
 type Config struct {
-	Address string // TCP server address:port
+	Address    string      // TCP server address:port，为空时不监听明文端口
+	TLSAddress string      // TLS 监听地址，为空时不启用 TLS
+	TLSConfig  *tls.Config // TLS 证书等配置
+
+	UnixSocket     string      // unix socket 路径，为空时不监听
+	UnixSocketPerm os.FileMode // socket 文件权限，0 表示使用默认权限
+}
+
+// listen 按配置创建明文、TLS 和 unix socket 监听，启用多个时合并为一个 listener
+func listen(cfg *Config) (net.Listener, error) {
+	listeners := make([]net.Listener, 0, 2)
+	if cfg.Address != "" {
+		listener, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	if cfg.TLSAddress != "" {
+		listener, err := tls.Listen("tcp", cfg.TLSAddress, cfg.TLSConfig)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		logger.Info("tls listen on " + cfg.TLSAddress)
+		listeners = append(listeners, listener)
+	}
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		logger.Info("unix socket listen on " + cfg.UnixSocket)
+		listeners = append(listeners, listener)
+	}
+	switch len(listeners) {
+	case 0:
+		return nil, errors.New("none of port, tls-port and unixsocket is configured")
+	case 1:
+		return listeners[0], nil
+	}
+	return newMultiListener(listeners), nil
 }
 
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 
-	listener, err := net.Listen("tcp", cfg.Address)
+	listener, err := listen(cfg)
 	closeChan := make(chan struct{})
 	sigChan := make(chan os.Signal, 1) // signal.Notify期待带缓冲，否则极端条件会丢失信号
 	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func() {
-		sig := <-sigChan // 阻塞等待信号
-		switch sig {
-		case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
-			closeChan <- struct{}{}
+		for sig := range sigChan { // 阻塞等待信号
+			switch sig {
+			case syscall.SIGHUP:
+				// SIGHUP 只重新加载配置，不断开连接
+				reloadConfig()
+			case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
+				closeChan <- struct{}{}
+				return
+			}
 		}
 	}()
 	if err != nil {
@@ -36,6 +91,19 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	return nil
 }
 
+// reloadConfig 重新读取配置文件并记录生效和需要重启的配置项
+func reloadConfig() {
+	changed, needRestart, err := config.Reload()
+	if err != nil {
+		logger.Error("reload config failed:", err)
+		return
+	}
+	logger.Info("config reloaded, applied: [" + strings.Join(changed, ", ") + "]")
+	if len(needRestart) > 0 {
+		logger.Warn("config changed but requires restart: [" + strings.Join(needRestart, ", ") + "]")
+	}
+}
+
 func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
 	// closeChan 用于优雅关闭，当程序被kill时，业务能够停止
 	go func() {
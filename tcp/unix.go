@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	"errors"
+	"go_redis/lib/logger"
+	"net"
+	"os"
+	"time"
+)
+
+// listenUnix 监听 unix socket。上次异常退出留下的 socket 文件会先被清理，
+// 关闭 listener 时 socket 文件随之删除
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// removeStaleSocket 删除没有进程在监听的 socket 文件；
+// 仍有进程在监听或者路径不是 socket 文件时返回错误，避免误删
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.New(path + " exists and is not a unix socket")
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return errors.New("another server is listening on " + path)
+	}
+	logger.Info("removing stale unix socket " + path)
+	return os.Remove(path)
+}